@@ -2,10 +2,14 @@ package api
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
 
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
 	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
 	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
 	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
@@ -28,6 +32,10 @@ type WorkerAPI interface {
 
 	StorageAddLocal(ctx context.Context, path string) error
 
+	// SetPathWeight changes the weight of a local storage path, which affects
+	// where new sector files are allocated
+	SetPathWeight(ctx context.Context, id stores.ID, weight uint64) error
+
 	// SetEnabled marks the worker as enabled/disabled. Not that this setting
 	// may take a few seconds to propagate to task scheduler
 	SetEnabled(ctx context.Context, enabled bool) error
@@ -43,4 +51,185 @@ type WorkerAPI interface {
 
 	// Like ProcessSession, but returns an error when worker is disabled
 	Session(context.Context) (uuid.UUID, error)
+
+	// Ping is a cheap liveness check, returns an error when the worker is
+	// shutting down
+	Ping(context.Context) error
+
+	// Reservations returns resources taken by calls accepted by the worker
+	// which didn't finish yet, like WorkerInfo.Reserved, without the cost of
+	// Info
+	Reservations(context.Context) (storiface.ReservedResources, error)
+
+	// PurgeUnsealed removes local unsealed copies of finalized sectors matching
+	// the policy, returns the number of bytes freed
+	PurgeUnsealed(ctx context.Context, policy storiface.UnsealedPurgePolicy) (int64, error)
+
+	// RedeclareSectors declares all sector files found in local storage paths
+	// in the sector index, returns the number of declarations made
+	RedeclareSectors(ctx context.Context) (int, error)
+
+	// Evacuate moves all sectors out of local paths not selected by sel into
+	// the selected paths, and makes the worker refuse new tasks, e.g. before
+	// decommissioning it. Interrupted evacuations are resumed by calling
+	// Evacuate again, with an empty selector the last one is used. Cancelling
+	// the call stops the evacuation after the sector being moved.
+	Evacuate(ctx context.Context, sel storiface.EvacuateSelector) (storiface.EvacProgress, error)
+
+	// EvacuateUpdates is Evacuate, reporting each sector it's done with on the
+	// returned channel, and the summary of the evacuation last
+	EvacuateUpdates(ctx context.Context, sel storiface.EvacuateSelector) (<-chan storiface.EvacUpdate, error)
+
+	// EvacuationProgress returns progress of the current, or last evacuation
+	EvacuationProgress(ctx context.Context) (storiface.EvacProgress, error)
+
+	// CancelEvacuation makes an evacuated worker accept new tasks again
+	CancelEvacuation(ctx context.Context) error
+
+	// ResetToUnsealed removes the sealed replica and cache of a sector, keeping
+	// its unsealed data, so that the sector can be sealed again
+	ResetToUnsealed(ctx context.Context, sector abi.SectorID) error
+
+	// CallLogs returns recent log lines of a call, oldest first
+	CallLogs(ctx context.Context, ci storiface.CallID) ([]storiface.LogLine, error)
+
+	// ListSectors returns all sectors with files in local storage, with the
+	// file types present
+	ListSectors(ctx context.Context) ([]storiface.SectorSummary, error)
+	// ListSectorsPage returns up to limit sectors with files in local storage,
+	// starting at the cursor returned with the previous page ("" for the first
+	// page)
+	ListSectorsPage(ctx context.Context, cursor string, limit int) (storiface.SectorListPage, error)
+
+	// FailureRates returns the share of recent calls of each task type which
+	// failed on the worker
+	FailureRates(ctx context.Context) (map[sealtasks.TaskType]storiface.TaskFailureRate, error)
+
+	// GPUTimeStats returns the cumulative wall time tasks of each type spent
+	// running on GPUs since the worker started
+	GPUTimeStats(ctx context.Context) (map[sealtasks.TaskType]storiface.GPUTime, error)
+
+	// TaskTimes returns the cumulative time tasks of each type waited on the
+	// worker before running, and the time they ran, since the worker started
+	TaskTimes(ctx context.Context) (map[sealtasks.TaskType]storiface.TaskTime, error)
+
+	// StoragePathLatencies returns the last latency probe of each local
+	// storage path, slowest first
+	StoragePathLatencies(ctx context.Context) ([]storiface.PathLatency, error)
+
+	// PendingRemovals returns removals of sector files which failed after
+	// finalizing, and are retried periodically, and removals deferred by the
+	// unsealed grace period
+	PendingRemovals(ctx context.Context) ([]storiface.PendingRemoval, error)
+
+	// ListCalls returns calls the worker tracks which weren't returned to the
+	// manager yet
+	ListCalls(ctx context.Context) ([]storiface.DiagnosticCall, error)
+
+	// ReapedCalls returns calls recently cancelled for running longer than the
+	// worker's max call age
+	ReapedCalls(ctx context.Context) ([]storiface.ReapedCall, error)
+
+	// SectorPieces returns pieces added to a sector since the worker started,
+	// with the offsets they were written at
+	SectorPieces(ctx context.Context, sector abi.SectorID) ([]storiface.AddedPiece, error)
+
+	// ComputeUnsealedCID computes the unsealed sector commitment (CommD) from
+	// the sector's piece layout, reading local unsealed data only for pieces
+	// given without a CID
+	ComputeUnsealedCID(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (cid.Cid, error)
+
+	// GCTempFiles removes stale temp files left in local storage paths by
+	// interrupted tasks, and returns the bytes freed
+	GCTempFiles(ctx context.Context) (int64, error)
+
+	// Diagnostics returns a snapshot of the worker's configuration, calls,
+	// recent errors and storage health, for troubleshooting
+	Diagnostics(ctx context.Context) (storiface.DiagnosticReport, error)
+
+	// RepairFile replaces the local copy of a sector file with a copy fetched
+	// from another storage path, checking the fetched copy
+	RepairFile(ctx context.Context, sector storage.SectorRef, fileType storiface.SectorFileType, src storiface.RepairSource) error
+
+	// RebuildCache regenerates the cache files of a sealed sector which can be
+	// computed from its sealed replica, e.g. tree-r-last after losing a cache disk
+	RebuildCache(ctx context.Context, sector storage.SectorRef) error
+
+	// NextTasks infers from the files of a sector which tasks can advance it,
+	// in pipeline order, returning an error for states which don't match a
+	// sealing step
+	NextTasks(ctx context.Context, sector storage.SectorRef) ([]sealtasks.TaskType, error)
+
+	// VerifySector checks the sealed replica and cache of the sector in local
+	// paths for missing or incomplete files
+	VerifySector(ctx context.Context, sector storage.SectorRef) error
+	// VerifySectorUpdates is VerifySector, also reading all data of the
+	// sector files, reporting bytes read so far and an ETA on the returned
+	// channel, then the result
+	VerifySectorUpdates(ctx context.Context, sector storage.SectorRef) (<-chan storiface.VerifyUpdate, error)
+	// ScrubFindings returns recent bad sector files found by the background
+	// scrubber, oldest first
+	ScrubFindings(ctx context.Context) ([]storiface.ScrubFinding, error)
+
+	// IOContention returns how many piece reads and storage moves the worker
+	// has, and how many moves are held back for reads
+	IOContention(ctx context.Context) (storiface.IOContention, error)
+
+	// StagePiece keeps piece data on the worker until CommitStaged adds it to
+	// the sector, after the pieces staged before it
+	StagePiece(ctx context.Context, sector storage.SectorRef, size abi.UnpaddedPieceSize, data storage.Data) error
+	// CommitStaged adds the staged pieces of the sector to it in order, either
+	// all of them or none, returning their piece infos along with the padding
+	// pieces added to align them
+	CommitStaged(ctx context.Context, sector storage.SectorRef) ([]abi.PieceInfo, error)
+	// RemoveStaged removes the staged pieces of the sector
+	RemoveStaged(ctx context.Context, sector abi.SectorID) error
+	// StagingInfo returns the staged pieces and the capacity of the staging
+	// area
+	StagingInfo(ctx context.Context) (storiface.StagingInfo, error)
+
+	// BoostConcurrency raises the concurrency limit of a task type above its
+	// configured limit for the duration
+	BoostConcurrency(ctx context.Context, task sealtasks.TaskType, limit int, d time.Duration) error
+	// ConcurrencyBoosts returns active concurrency boosts
+	ConcurrencyBoosts(ctx context.Context) ([]storiface.ConcurrencyBoost, error)
+
+	// PauseSector stops the worker from starting new calls for the sector,
+	// calls already running keep running
+	PauseSector(ctx context.Context, sector abi.SectorID) error
+	// ResumeSector lets the worker start calls for a paused sector again
+	ResumeSector(ctx context.Context, sector abi.SectorID) error
+	// PausedSectors returns sectors paused with PauseSector
+	PausedSectors(ctx context.Context) ([]abi.SectorID, error)
+
+	// SetCallPriority changes the priority of a call waiting in the worker
+	// call queue, calls which already started are left alone
+	SetCallPriority(ctx context.Context, ci storiface.CallID, priority int) error
+
+	// CheckTaskDeadline returns an ErrTempDeadlineInfeasible call error when
+	// the worker doesn't expect a task started now to be done by the deadline
+	CheckTaskDeadline(ctx context.Context, sector storage.SectorRef, task sealtasks.TaskType, deadline time.Time) (*storiface.CallError, error)
+
+	// RemainingSpaceNeeded estimates how many more bytes of local storage the
+	// sector needs until upToTask completes
+	RemainingSpaceNeeded(ctx context.Context, sector storage.SectorRef, upToTask sealtasks.TaskType) (int64, error)
+
+	// GeneratePieceCommP computes the piece commitment of size bytes of
+	// unpadded piece data, independent of any sector. The result is returned
+	// with ReturnGeneratePieceCommP.
+	GeneratePieceCommP(ctx context.Context, size abi.UnpaddedPieceSize, pieceData storage.Data) (storiface.CallID, error)
+
+	// VerifyCommit2 checks an existing seal proof of the sector without
+	// regenerating it. The result, false for invalid proofs, is returned with
+	// ReturnVerifyCommit2.
+	VerifyCommit2(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, cids storage.SectorCids, proof storage.Proof) (storiface.CallID, error)
+
+	// SupportedProofs returns seal proof types the worker can handle with the
+	// task types it accepts and the proof parameters it has
+	SupportedProofs(ctx context.Context) ([]abi.RegisteredSealProof, error)
+
+	// ProofParams reports the version and files of proof parameters the worker
+	// uses for each proof type, which can be compared across workers to find
+	// ones with stale parameters
+	ProofParams(ctx context.Context) ([]storiface.ProofParams, error)
 }
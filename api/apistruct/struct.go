@@ -314,17 +314,23 @@ type StorageMinerStruct struct {
 		WorkerStats   func(context.Context) (map[uuid.UUID]storiface.WorkerStats, error) `perm:"admin"`
 		WorkerJobs    func(context.Context) (map[uuid.UUID][]storiface.WorkerJob, error) `perm:"admin"`
 
-		ReturnAddPiece        func(ctx context.Context, callID storiface.CallID, pi abi.PieceInfo, err *storiface.CallError) error          `perm:"admin" retry:"true"`
-		ReturnSealPreCommit1  func(ctx context.Context, callID storiface.CallID, p1o storage.PreCommit1Out, err *storiface.CallError) error `perm:"admin" retry:"true"`
-		ReturnSealPreCommit2  func(ctx context.Context, callID storiface.CallID, sealed storage.SectorCids, err *storiface.CallError) error `perm:"admin" retry:"true"`
-		ReturnSealCommit1     func(ctx context.Context, callID storiface.CallID, out storage.Commit1Out, err *storiface.CallError) error    `perm:"admin" retry:"true"`
-		ReturnSealCommit2     func(ctx context.Context, callID storiface.CallID, proof storage.Proof, err *storiface.CallError) error       `perm:"admin" retry:"true"`
-		ReturnFinalizeSector  func(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error                            `perm:"admin" retry:"true"`
-		ReturnReleaseUnsealed func(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error                            `perm:"admin" retry:"true"`
-		ReturnMoveStorage     func(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error                            `perm:"admin" retry:"true"`
-		ReturnUnsealPiece     func(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error                            `perm:"admin" retry:"true"`
-		ReturnReadPiece       func(ctx context.Context, callID storiface.CallID, ok bool, err *storiface.CallError) error                   `perm:"admin" retry:"true"`
-		ReturnFetch           func(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error                            `perm:"admin" retry:"true"`
+		ReturnAddPiece        func(ctx context.Context, callID storiface.CallID, pi abi.PieceInfo, err *storiface.CallError) error                `perm:"admin" retry:"true"`
+		ReturnSealPreCommit1  func(ctx context.Context, callID storiface.CallID, p1o storage.PreCommit1Out, err *storiface.CallError) error       `perm:"admin" retry:"true"`
+		ReturnSealPreCommit2  func(ctx context.Context, callID storiface.CallID, sealed storage.SectorCids, err *storiface.CallError) error       `perm:"admin" retry:"true"`
+		ReturnSealCommit1     func(ctx context.Context, callID storiface.CallID, out storage.Commit1Out, err *storiface.CallError) error          `perm:"admin" retry:"true"`
+		ReturnSealCommit2     func(ctx context.Context, callID storiface.CallID, proof storage.Proof, err *storiface.CallError) error             `perm:"admin" retry:"true"`
+		ReturnFinalizeSector  func(ctx context.Context, callID storiface.CallID, out storiface.FinalizeSectorOut, err *storiface.CallError) error `perm:"admin" retry:"true"`
+		ReturnReleaseUnsealed func(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error                                  `perm:"admin" retry:"true"`
+		ReturnMoveStorage     func(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error                                  `perm:"admin" retry:"true"`
+		ReturnUnsealPiece     func(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error                                  `perm:"admin" retry:"true"`
+		ReturnReadPiece       func(ctx context.Context, callID storiface.CallID, ok bool, err *storiface.CallError) error                         `perm:"admin" retry:"true"`
+		ReturnFetch           func(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error                                  `perm:"admin" retry:"true"`
+
+		ReturnReplicaUpdate       func(ctx context.Context, callID storiface.CallID, out storiface.ReplicaUpdateOut, err *storiface.CallError) error        `perm:"admin" retry:"true"`
+		ReturnProveReplicaUpdate1 func(ctx context.Context, callID storiface.CallID, proofs storiface.ReplicaVanillaProofs, err *storiface.CallError) error `perm:"admin" retry:"true"`
+		ReturnProveReplicaUpdate2 func(ctx context.Context, callID storiface.CallID, proof storiface.ReplicaUpdateProof, err *storiface.CallError) error    `perm:"admin" retry:"true"`
+		ReturnGeneratePieceCommP  func(ctx context.Context, callID storiface.CallID, pi abi.PieceInfo, err *storiface.CallError) error                      `perm:"admin" retry:"true"`
+		ReturnVerifyCommit2       func(ctx context.Context, callID storiface.CallID, ok bool, err *storiface.CallError) error                               `perm:"admin" retry:"true"`
 
 		SealingSchedDiag func(context.Context, bool) (interface{}, error)       `perm:"admin"`
 		SealingAbort     func(ctx context.Context, call storiface.CallID) error `perm:"admin"`
@@ -376,20 +382,26 @@ type WorkerStruct struct {
 		Paths     func(context.Context) ([]stores.StoragePath, error)            `perm:"admin"`
 		Info      func(context.Context) (storiface.WorkerInfo, error)            `perm:"admin"`
 
-		AddPiece        func(ctx context.Context, sector storage.SectorRef, pieceSizes []abi.UnpaddedPieceSize, newPieceSize abi.UnpaddedPieceSize, pieceData storage.Data) (storiface.CallID, error)                 `perm:"admin"`
-		SealPreCommit1  func(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, pieces []abi.PieceInfo) (storiface.CallID, error)                                                              `perm:"admin"`
-		SealPreCommit2  func(ctx context.Context, sector storage.SectorRef, pc1o storage.PreCommit1Out) (storiface.CallID, error)                                                                                     `perm:"admin"`
-		SealCommit1     func(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, pieces []abi.PieceInfo, cids storage.SectorCids) (storiface.CallID, error) `perm:"admin"`
-		SealCommit2     func(ctx context.Context, sector storage.SectorRef, c1o storage.Commit1Out) (storiface.CallID, error)                                                                                         `perm:"admin"`
-		FinalizeSector  func(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range) (storiface.CallID, error)                                                                                   `perm:"admin"`
-		ReleaseUnsealed func(ctx context.Context, sector storage.SectorRef, safeToFree []storage.Range) (storiface.CallID, error)                                                                                     `perm:"admin"`
-		MoveStorage     func(ctx context.Context, sector storage.SectorRef, types storiface.SectorFileType) (storiface.CallID, error)                                                                                 `perm:"admin"`
-		UnsealPiece     func(context.Context, storage.SectorRef, storiface.UnpaddedByteIndex, abi.UnpaddedPieceSize, abi.SealRandomness, cid.Cid) (storiface.CallID, error)                                           `perm:"admin"`
-		ReadPiece       func(context.Context, io.Writer, storage.SectorRef, storiface.UnpaddedByteIndex, abi.UnpaddedPieceSize) (storiface.CallID, error)                                                             `perm:"admin"`
-		Fetch           func(context.Context, storage.SectorRef, storiface.SectorFileType, storiface.PathType, storiface.AcquireMode) (storiface.CallID, error)                                                       `perm:"admin"`
-
-		Remove          func(ctx context.Context, sector abi.SectorID) error `perm:"admin"`
-		StorageAddLocal func(ctx context.Context, path string) error         `perm:"admin"`
+		AddPiece            func(ctx context.Context, sector storage.SectorRef, pieceSizes []abi.UnpaddedPieceSize, newPieceSize abi.UnpaddedPieceSize, pieceData storage.Data) (storiface.CallID, error)                 `perm:"admin"`
+		SealPreCommit1      func(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, pieces []abi.PieceInfo) (storiface.CallID, error)                                                              `perm:"admin"`
+		SealPreCommit2      func(ctx context.Context, sector storage.SectorRef, pc1o storage.PreCommit1Out) (storiface.CallID, error)                                                                                     `perm:"admin"`
+		SealCommit1         func(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, pieces []abi.PieceInfo, cids storage.SectorCids) (storiface.CallID, error) `perm:"admin"`
+		SealCommit2         func(ctx context.Context, sector storage.SectorRef, c1o storage.Commit1Out) (storiface.CallID, error)                                                                                         `perm:"admin"`
+		FinalizeSector      func(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range) (storiface.CallID, error)                                                                                   `perm:"admin"`
+		FinalizeSectorTypes func(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range, types storiface.SectorFileType) (storiface.CallID, error)                                                   `perm:"admin"`
+		ReleaseUnsealed     func(ctx context.Context, sector storage.SectorRef, safeToFree []storage.Range) (storiface.CallID, error)                                                                                     `perm:"admin"`
+		MoveStorage         func(ctx context.Context, sector storage.SectorRef, types storiface.SectorFileType) (storiface.CallID, error)                                                                                 `perm:"admin"`
+		UnsealPiece         func(context.Context, storage.SectorRef, storiface.UnpaddedByteIndex, abi.UnpaddedPieceSize, abi.SealRandomness, cid.Cid) (storiface.CallID, error)                                           `perm:"admin"`
+		ReadPiece           func(context.Context, io.Writer, storage.SectorRef, storiface.UnpaddedByteIndex, abi.UnpaddedPieceSize) (storiface.CallID, error)                                                             `perm:"admin"`
+		Fetch               func(context.Context, storage.SectorRef, storiface.SectorFileType, storiface.PathType, storiface.AcquireMode) (storiface.CallID, error)                                                       `perm:"admin"`
+
+		ReplicaUpdate       func(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (storiface.CallID, error)                                                                  `perm:"admin"`
+		ProveReplicaUpdate1 func(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid) (storiface.CallID, error)                                               `perm:"admin"`
+		ProveReplicaUpdate2 func(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid, vanillaProofs storiface.ReplicaVanillaProofs) (storiface.CallID, error) `perm:"admin"`
+
+		Remove          func(ctx context.Context, sector abi.SectorID) error         `perm:"admin"`
+		StorageAddLocal func(ctx context.Context, path string) error                 `perm:"admin"`
+		SetPathWeight   func(ctx context.Context, id stores.ID, weight uint64) error `perm:"admin"`
 
 		SetEnabled func(ctx context.Context, enabled bool) error `perm:"admin"`
 		Enabled    func(ctx context.Context) (bool, error)       `perm:"admin"`
@@ -398,6 +410,55 @@ type WorkerStruct struct {
 
 		ProcessSession func(context.Context) (uuid.UUID, error) `perm:"admin"`
 		Session        func(context.Context) (uuid.UUID, error) `perm:"admin"`
+		Ping           func(context.Context) error              `perm:"admin"`
+
+		Reservations func(context.Context) (storiface.ReservedResources, error) `perm:"admin"`
+
+		PurgeUnsealed      func(ctx context.Context, policy storiface.UnsealedPurgePolicy) (int64, error)                 `perm:"admin"`
+		Evacuate           func(ctx context.Context, sel storiface.EvacuateSelector) (storiface.EvacProgress, error)      `perm:"admin"`
+		EvacuateUpdates    func(ctx context.Context, sel storiface.EvacuateSelector) (<-chan storiface.EvacUpdate, error) `perm:"admin"`
+		EvacuationProgress func(ctx context.Context) (storiface.EvacProgress, error)                                      `perm:"admin"`
+		CancelEvacuation   func(ctx context.Context) error                                                                `perm:"admin"`
+		ResetToUnsealed    func(ctx context.Context, sector abi.SectorID) error                                           `perm:"admin"`
+		RedeclareSectors   func(ctx context.Context) (int, error)                                                         `perm:"admin"`
+		CallLogs           func(ctx context.Context, ci storiface.CallID) ([]storiface.LogLine, error)                    `perm:"admin"`
+
+		ListSectors          func(ctx context.Context) ([]storiface.SectorSummary, error)                                                                                                                               `perm:"admin"`
+		ListSectorsPage      func(ctx context.Context, cursor string, limit int) (storiface.SectorListPage, error)                                                                                                      `perm:"admin"`
+		FailureRates         func(ctx context.Context) (map[sealtasks.TaskType]storiface.TaskFailureRate, error)                                                                                                        `perm:"admin"`
+		GPUTimeStats         func(ctx context.Context) (map[sealtasks.TaskType]storiface.GPUTime, error)                                                                                                                `perm:"admin"`
+		TaskTimes            func(ctx context.Context) (map[sealtasks.TaskType]storiface.TaskTime, error)                                                                                                               `perm:"admin"`
+		StoragePathLatencies func(ctx context.Context) ([]storiface.PathLatency, error)                                                                                                                                 `perm:"admin"`
+		PendingRemovals      func(ctx context.Context) ([]storiface.PendingRemoval, error)                                                                                                                              `perm:"admin"`
+		ListCalls            func(ctx context.Context) ([]storiface.DiagnosticCall, error)                                                                                                                              `perm:"admin"`
+		ReapedCalls          func(ctx context.Context) ([]storiface.ReapedCall, error)                                                                                                                                  `perm:"admin"`
+		SectorPieces         func(ctx context.Context, sector abi.SectorID) ([]storiface.AddedPiece, error)                                                                                                             `perm:"admin"`
+		ComputeUnsealedCID   func(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (cid.Cid, error)                                                                                               `perm:"admin"`
+		GCTempFiles          func(ctx context.Context) (int64, error)                                                                                                                                                   `perm:"admin"`
+		Diagnostics          func(ctx context.Context) (storiface.DiagnosticReport, error)                                                                                                                              `perm:"admin"`
+		RepairFile           func(ctx context.Context, sector storage.SectorRef, fileType storiface.SectorFileType, src storiface.RepairSource) error                                                                   `perm:"admin"`
+		RebuildCache         func(ctx context.Context, sector storage.SectorRef) error                                                                                                                                  `perm:"admin"`
+		NextTasks            func(ctx context.Context, sector storage.SectorRef) ([]sealtasks.TaskType, error)                                                                                                          `perm:"admin"`
+		VerifySector         func(ctx context.Context, sector storage.SectorRef) error                                                                                                                                  `perm:"admin"`
+		VerifySectorUpdates  func(ctx context.Context, sector storage.SectorRef) (<-chan storiface.VerifyUpdate, error)                                                                                                 `perm:"admin"`
+		ScrubFindings        func(ctx context.Context) ([]storiface.ScrubFinding, error)                                                                                                                                `perm:"admin"`
+		IOContention         func(ctx context.Context) (storiface.IOContention, error)                                                                                                                                  `perm:"admin"`
+		StagePiece           func(ctx context.Context, sector storage.SectorRef, size abi.UnpaddedPieceSize, data storage.Data) error                                                                                   `perm:"admin"`
+		CommitStaged         func(ctx context.Context, sector storage.SectorRef) ([]abi.PieceInfo, error)                                                                                                               `perm:"admin"`
+		RemoveStaged         func(ctx context.Context, sector abi.SectorID) error                                                                                                                                       `perm:"admin"`
+		StagingInfo          func(ctx context.Context) (storiface.StagingInfo, error)                                                                                                                                   `perm:"admin"`
+		BoostConcurrency     func(ctx context.Context, task sealtasks.TaskType, limit int, d time.Duration) error                                                                                                       `perm:"admin"`
+		ConcurrencyBoosts    func(ctx context.Context) ([]storiface.ConcurrencyBoost, error)                                                                                                                            `perm:"admin"`
+		PauseSector          func(ctx context.Context, sector abi.SectorID) error                                                                                                                                       `perm:"admin"`
+		ResumeSector         func(ctx context.Context, sector abi.SectorID) error                                                                                                                                       `perm:"admin"`
+		PausedSectors        func(ctx context.Context) ([]abi.SectorID, error)                                                                                                                                          `perm:"admin"`
+		SetCallPriority      func(ctx context.Context, ci storiface.CallID, priority int) error                                                                                                                         `perm:"admin"`
+		CheckTaskDeadline    func(ctx context.Context, sector storage.SectorRef, task sealtasks.TaskType, deadline time.Time) (*storiface.CallError, error)                                                             `perm:"admin"`
+		RemainingSpaceNeeded func(ctx context.Context, sector storage.SectorRef, upToTask sealtasks.TaskType) (int64, error)                                                                                            `perm:"admin"`
+		GeneratePieceCommP   func(ctx context.Context, size abi.UnpaddedPieceSize, pieceData storage.Data) (storiface.CallID, error)                                                                                    `perm:"admin"`
+		VerifyCommit2        func(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, cids storage.SectorCids, proof storage.Proof) (storiface.CallID, error) `perm:"admin"`
+		SupportedProofs      func(ctx context.Context) ([]abi.RegisteredSealProof, error)                                                                                                                               `perm:"admin"`
+		ProofParams          func(ctx context.Context) ([]storiface.ProofParams, error)                                                                                                                                 `perm:"admin"`
 	}
 }
 
@@ -1306,8 +1367,8 @@ func (c *StorageMinerStruct) ReturnSealCommit2(ctx context.Context, callID stori
 	return c.Internal.ReturnSealCommit2(ctx, callID, proof, err)
 }
 
-func (c *StorageMinerStruct) ReturnFinalizeSector(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
-	return c.Internal.ReturnFinalizeSector(ctx, callID, err)
+func (c *StorageMinerStruct) ReturnFinalizeSector(ctx context.Context, callID storiface.CallID, out storiface.FinalizeSectorOut, err *storiface.CallError) error {
+	return c.Internal.ReturnFinalizeSector(ctx, callID, out, err)
 }
 
 func (c *StorageMinerStruct) ReturnReleaseUnsealed(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
@@ -1330,6 +1391,26 @@ func (c *StorageMinerStruct) ReturnFetch(ctx context.Context, callID storiface.C
 	return c.Internal.ReturnFetch(ctx, callID, err)
 }
 
+func (c *StorageMinerStruct) ReturnReplicaUpdate(ctx context.Context, callID storiface.CallID, out storiface.ReplicaUpdateOut, err *storiface.CallError) error {
+	return c.Internal.ReturnReplicaUpdate(ctx, callID, out, err)
+}
+
+func (c *StorageMinerStruct) ReturnProveReplicaUpdate1(ctx context.Context, callID storiface.CallID, proofs storiface.ReplicaVanillaProofs, err *storiface.CallError) error {
+	return c.Internal.ReturnProveReplicaUpdate1(ctx, callID, proofs, err)
+}
+
+func (c *StorageMinerStruct) ReturnProveReplicaUpdate2(ctx context.Context, callID storiface.CallID, proof storiface.ReplicaUpdateProof, err *storiface.CallError) error {
+	return c.Internal.ReturnProveReplicaUpdate2(ctx, callID, proof, err)
+}
+
+func (c *StorageMinerStruct) ReturnGeneratePieceCommP(ctx context.Context, callID storiface.CallID, pi abi.PieceInfo, err *storiface.CallError) error {
+	return c.Internal.ReturnGeneratePieceCommP(ctx, callID, pi, err)
+}
+
+func (c *StorageMinerStruct) ReturnVerifyCommit2(ctx context.Context, callID storiface.CallID, ok bool, err *storiface.CallError) error {
+	return c.Internal.ReturnVerifyCommit2(ctx, callID, ok, err)
+}
+
 func (c *StorageMinerStruct) SealingSchedDiag(ctx context.Context, doSched bool) (interface{}, error) {
 	return c.Internal.SealingSchedDiag(ctx, doSched)
 }
@@ -1552,6 +1633,10 @@ func (w *WorkerStruct) FinalizeSector(ctx context.Context, sector storage.Sector
 	return w.Internal.FinalizeSector(ctx, sector, keepUnsealed)
 }
 
+func (w *WorkerStruct) FinalizeSectorTypes(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range, types storiface.SectorFileType) (storiface.CallID, error) {
+	return w.Internal.FinalizeSectorTypes(ctx, sector, keepUnsealed, types)
+}
+
 func (w *WorkerStruct) ReleaseUnsealed(ctx context.Context, sector storage.SectorRef, safeToFree []storage.Range) (storiface.CallID, error) {
 	return w.Internal.ReleaseUnsealed(ctx, sector, safeToFree)
 }
@@ -1572,6 +1657,18 @@ func (w *WorkerStruct) Fetch(ctx context.Context, id storage.SectorRef, fileType
 	return w.Internal.Fetch(ctx, id, fileType, ptype, am)
 }
 
+func (w *WorkerStruct) ReplicaUpdate(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (storiface.CallID, error) {
+	return w.Internal.ReplicaUpdate(ctx, sector, pieces)
+}
+
+func (w *WorkerStruct) ProveReplicaUpdate1(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid) (storiface.CallID, error) {
+	return w.Internal.ProveReplicaUpdate1(ctx, sector, sectorKey, newSealed, newUnsealed)
+}
+
+func (w *WorkerStruct) ProveReplicaUpdate2(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid, vanillaProofs storiface.ReplicaVanillaProofs) (storiface.CallID, error) {
+	return w.Internal.ProveReplicaUpdate2(ctx, sector, sectorKey, newSealed, newUnsealed, vanillaProofs)
+}
+
 func (w *WorkerStruct) Remove(ctx context.Context, sector abi.SectorID) error {
 	return w.Internal.Remove(ctx, sector)
 }
@@ -1580,6 +1677,10 @@ func (w *WorkerStruct) StorageAddLocal(ctx context.Context, path string) error {
 	return w.Internal.StorageAddLocal(ctx, path)
 }
 
+func (w *WorkerStruct) SetPathWeight(ctx context.Context, id stores.ID, weight uint64) error {
+	return w.Internal.SetPathWeight(ctx, id, weight)
+}
+
 func (w *WorkerStruct) SetEnabled(ctx context.Context, enabled bool) error {
 	return w.Internal.SetEnabled(ctx, enabled)
 }
@@ -1600,6 +1701,190 @@ func (w *WorkerStruct) Session(ctx context.Context) (uuid.UUID, error) {
 	return w.Internal.Session(ctx)
 }
 
+func (w *WorkerStruct) Ping(ctx context.Context) error {
+	return w.Internal.Ping(ctx)
+}
+
+func (w *WorkerStruct) Reservations(ctx context.Context) (storiface.ReservedResources, error) {
+	return w.Internal.Reservations(ctx)
+}
+
+func (w *WorkerStruct) PurgeUnsealed(ctx context.Context, policy storiface.UnsealedPurgePolicy) (int64, error) {
+	return w.Internal.PurgeUnsealed(ctx, policy)
+}
+
+func (w *WorkerStruct) RedeclareSectors(ctx context.Context) (int, error) {
+	return w.Internal.RedeclareSectors(ctx)
+}
+
+func (w *WorkerStruct) Evacuate(ctx context.Context, sel storiface.EvacuateSelector) (storiface.EvacProgress, error) {
+	return w.Internal.Evacuate(ctx, sel)
+}
+
+func (w *WorkerStruct) EvacuateUpdates(ctx context.Context, sel storiface.EvacuateSelector) (<-chan storiface.EvacUpdate, error) {
+	return w.Internal.EvacuateUpdates(ctx, sel)
+}
+
+func (w *WorkerStruct) EvacuationProgress(ctx context.Context) (storiface.EvacProgress, error) {
+	return w.Internal.EvacuationProgress(ctx)
+}
+
+func (w *WorkerStruct) CancelEvacuation(ctx context.Context) error {
+	return w.Internal.CancelEvacuation(ctx)
+}
+
+func (w *WorkerStruct) ResetToUnsealed(ctx context.Context, sector abi.SectorID) error {
+	return w.Internal.ResetToUnsealed(ctx, sector)
+}
+
+func (w *WorkerStruct) CallLogs(ctx context.Context, ci storiface.CallID) ([]storiface.LogLine, error) {
+	return w.Internal.CallLogs(ctx, ci)
+}
+
+func (w *WorkerStruct) ListSectors(ctx context.Context) ([]storiface.SectorSummary, error) {
+	return w.Internal.ListSectors(ctx)
+}
+
+func (w *WorkerStruct) ListSectorsPage(ctx context.Context, cursor string, limit int) (storiface.SectorListPage, error) {
+	return w.Internal.ListSectorsPage(ctx, cursor, limit)
+}
+
+func (w *WorkerStruct) FailureRates(ctx context.Context) (map[sealtasks.TaskType]storiface.TaskFailureRate, error) {
+	return w.Internal.FailureRates(ctx)
+}
+
+func (w *WorkerStruct) GPUTimeStats(ctx context.Context) (map[sealtasks.TaskType]storiface.GPUTime, error) {
+	return w.Internal.GPUTimeStats(ctx)
+}
+
+func (w *WorkerStruct) TaskTimes(ctx context.Context) (map[sealtasks.TaskType]storiface.TaskTime, error) {
+	return w.Internal.TaskTimes(ctx)
+}
+
+func (w *WorkerStruct) StoragePathLatencies(ctx context.Context) ([]storiface.PathLatency, error) {
+	return w.Internal.StoragePathLatencies(ctx)
+}
+
+func (w *WorkerStruct) PendingRemovals(ctx context.Context) ([]storiface.PendingRemoval, error) {
+	return w.Internal.PendingRemovals(ctx)
+}
+
+func (w *WorkerStruct) ListCalls(ctx context.Context) ([]storiface.DiagnosticCall, error) {
+	return w.Internal.ListCalls(ctx)
+}
+
+func (w *WorkerStruct) ReapedCalls(ctx context.Context) ([]storiface.ReapedCall, error) {
+	return w.Internal.ReapedCalls(ctx)
+}
+
+func (w *WorkerStruct) SectorPieces(ctx context.Context, sector abi.SectorID) ([]storiface.AddedPiece, error) {
+	return w.Internal.SectorPieces(ctx, sector)
+}
+
+func (w *WorkerStruct) ComputeUnsealedCID(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (cid.Cid, error) {
+	return w.Internal.ComputeUnsealedCID(ctx, sector, pieces)
+}
+
+func (w *WorkerStruct) GCTempFiles(ctx context.Context) (int64, error) {
+	return w.Internal.GCTempFiles(ctx)
+}
+
+func (w *WorkerStruct) Diagnostics(ctx context.Context) (storiface.DiagnosticReport, error) {
+	return w.Internal.Diagnostics(ctx)
+}
+
+func (w *WorkerStruct) RepairFile(ctx context.Context, sector storage.SectorRef, fileType storiface.SectorFileType, src storiface.RepairSource) error {
+	return w.Internal.RepairFile(ctx, sector, fileType, src)
+}
+
+func (w *WorkerStruct) RebuildCache(ctx context.Context, sector storage.SectorRef) error {
+	return w.Internal.RebuildCache(ctx, sector)
+}
+
+func (w *WorkerStruct) NextTasks(ctx context.Context, sector storage.SectorRef) ([]sealtasks.TaskType, error) {
+	return w.Internal.NextTasks(ctx, sector)
+}
+
+func (w *WorkerStruct) VerifySector(ctx context.Context, sector storage.SectorRef) error {
+	return w.Internal.VerifySector(ctx, sector)
+}
+
+func (w *WorkerStruct) VerifySectorUpdates(ctx context.Context, sector storage.SectorRef) (<-chan storiface.VerifyUpdate, error) {
+	return w.Internal.VerifySectorUpdates(ctx, sector)
+}
+
+func (w *WorkerStruct) ScrubFindings(ctx context.Context) ([]storiface.ScrubFinding, error) {
+	return w.Internal.ScrubFindings(ctx)
+}
+
+func (w *WorkerStruct) IOContention(ctx context.Context) (storiface.IOContention, error) {
+	return w.Internal.IOContention(ctx)
+}
+
+func (w *WorkerStruct) StagePiece(ctx context.Context, sector storage.SectorRef, size abi.UnpaddedPieceSize, data storage.Data) error {
+	return w.Internal.StagePiece(ctx, sector, size, data)
+}
+
+func (w *WorkerStruct) CommitStaged(ctx context.Context, sector storage.SectorRef) ([]abi.PieceInfo, error) {
+	return w.Internal.CommitStaged(ctx, sector)
+}
+
+func (w *WorkerStruct) RemoveStaged(ctx context.Context, sector abi.SectorID) error {
+	return w.Internal.RemoveStaged(ctx, sector)
+}
+
+func (w *WorkerStruct) StagingInfo(ctx context.Context) (storiface.StagingInfo, error) {
+	return w.Internal.StagingInfo(ctx)
+}
+
+func (w *WorkerStruct) BoostConcurrency(ctx context.Context, task sealtasks.TaskType, limit int, d time.Duration) error {
+	return w.Internal.BoostConcurrency(ctx, task, limit, d)
+}
+
+func (w *WorkerStruct) ConcurrencyBoosts(ctx context.Context) ([]storiface.ConcurrencyBoost, error) {
+	return w.Internal.ConcurrencyBoosts(ctx)
+}
+
+func (w *WorkerStruct) PauseSector(ctx context.Context, sector abi.SectorID) error {
+	return w.Internal.PauseSector(ctx, sector)
+}
+
+func (w *WorkerStruct) ResumeSector(ctx context.Context, sector abi.SectorID) error {
+	return w.Internal.ResumeSector(ctx, sector)
+}
+
+func (w *WorkerStruct) PausedSectors(ctx context.Context) ([]abi.SectorID, error) {
+	return w.Internal.PausedSectors(ctx)
+}
+
+func (w *WorkerStruct) SetCallPriority(ctx context.Context, ci storiface.CallID, priority int) error {
+	return w.Internal.SetCallPriority(ctx, ci, priority)
+}
+
+func (w *WorkerStruct) CheckTaskDeadline(ctx context.Context, sector storage.SectorRef, task sealtasks.TaskType, deadline time.Time) (*storiface.CallError, error) {
+	return w.Internal.CheckTaskDeadline(ctx, sector, task, deadline)
+}
+
+func (w *WorkerStruct) RemainingSpaceNeeded(ctx context.Context, sector storage.SectorRef, upToTask sealtasks.TaskType) (int64, error) {
+	return w.Internal.RemainingSpaceNeeded(ctx, sector, upToTask)
+}
+
+func (w *WorkerStruct) GeneratePieceCommP(ctx context.Context, size abi.UnpaddedPieceSize, pieceData storage.Data) (storiface.CallID, error) {
+	return w.Internal.GeneratePieceCommP(ctx, size, pieceData)
+}
+
+func (w *WorkerStruct) VerifyCommit2(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, cids storage.SectorCids, proof storage.Proof) (storiface.CallID, error) {
+	return w.Internal.VerifyCommit2(ctx, sector, ticket, seed, cids, proof)
+}
+
+func (w *WorkerStruct) SupportedProofs(ctx context.Context) ([]abi.RegisteredSealProof, error) {
+	return w.Internal.SupportedProofs(ctx)
+}
+
+func (w *WorkerStruct) ProofParams(ctx context.Context) ([]storiface.ProofParams, error) {
+	return w.Internal.ProofParams(ctx)
+}
+
 func (g GatewayStruct) ChainGetBlockMessages(ctx context.Context, c cid.Cid) (*api.BlockMessages, error) {
 	return g.Internal.ChainGetBlockMessages(ctx, c)
 }
@@ -225,6 +225,7 @@ func init() {
 					CPUs:        64,
 					GPUs:        []string{"aGPU 1337"},
 				},
+				Load: 12.5,
 			},
 			Enabled:    true,
 			MemUsedMin: 0,
@@ -238,9 +239,34 @@ func init() {
 	// worker specific
 	addExample(storiface.AcquireMove)
 	addExample(storiface.UnpaddedByteIndex(abi.PaddedPieceSize(1 << 20).Unpadded()))
+	addExample(storiface.PaddedByteIndex(1 << 20))
 	addExample(map[sealtasks.TaskType]struct{}{
 		sealtasks.TTPreCommit2: {},
 	})
+	addExample(sealtasks.TTPreCommit2)
+	addExample(storiface.CapPartialUnseal)
+	addExample(storiface.EvacMoved)
+	addExample(map[sealtasks.TaskType]storiface.GPUTime{
+		sealtasks.TTCommit2: {
+			Calls:    10,
+			Duration: 30 * time.Minute,
+		},
+	})
+	addExample(map[sealtasks.TaskType]storiface.TaskFailureRate{
+		sealtasks.TTPreCommit2: {
+			Calls:    50,
+			Failures: 2,
+			Rate:     0.04,
+		},
+	})
+	addExample(map[sealtasks.TaskType]storiface.TaskTime{
+		sealtasks.TTPreCommit1: {
+			Started:   10,
+			QueueWait: 5 * time.Minute,
+			Succeeded: 9,
+			Execution: 30 * time.Hour,
+		},
+	})
 }
 
 func exampleValue(method string, t, parent reflect.Type) interface{} {
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-state-types/abi"
+
 	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
 )
 
 var setCmd = &cli.Command{
@@ -49,3 +59,127 @@ var waitQuietCmd = &cli.Command{
 		return api.WaitQuiet(ctx)
 	},
 }
+
+var callLogsCmd = &cli.Command{
+	Name:      "call-logs",
+	Usage:     "Print recent log lines of a call",
+	ArgsUsage: "[call id, e.g. 1000-1-6d3a2f7b-...]",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+
+		ci, err := parseCallID(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		lines, err := api.CallLogs(ctx, ci)
+		if err != nil {
+			return xerrors.Errorf("CallLogs: %w", err)
+		}
+
+		for _, line := range lines {
+			fmt.Printf("%s\t%s\t%s\n", line.Time.Format(time.RFC3339Nano), line.Level, line.Message)
+		}
+
+		return nil
+	},
+}
+
+var boostCmd = &cli.Command{
+	Name:      "boost",
+	Usage:     "Raise the concurrency limit of a task type for a while",
+	ArgsUsage: "[task type, e.g. seal/v0/precommit/1]",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:     "limit",
+			Usage:    "concurrency limit while boosted, above the configured --task-limit",
+			Required: true,
+		},
+		&cli.DurationFlag{
+			Name:  "duration",
+			Usage: "how long the boost lasts",
+			Value: time.Hour,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		tt := sealtasks.TaskType(cctx.Args().First())
+		if err := api.BoostConcurrency(ctx, tt, cctx.Int("limit"), cctx.Duration("duration")); err != nil {
+			return xerrors.Errorf("BoostConcurrency: %w", err)
+		}
+
+		fmt.Printf("Boosted %s to %d for %s\n", tt, cctx.Int("limit"), cctx.Duration("duration"))
+		return nil
+	},
+}
+
+var boostsCmd = &cli.Command{
+	Name:  "boosts",
+	Usage: "List active concurrency boosts",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		boosts, err := api.ConcurrencyBoosts(ctx)
+		if err != nil {
+			return xerrors.Errorf("ConcurrencyBoosts: %w", err)
+		}
+
+		for _, b := range boosts {
+			fmt.Printf("%s\tlimit %d (configured %d)\t%d running\tuntil %s\n", b.Task, b.Limit, b.Configured, b.Running, b.Until.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+// parseCallID parses the format of storiface.CallID.String
+func parseCallID(s string) (storiface.CallID, error) {
+	parts := strings.SplitN(s, "-", 3)
+	if len(parts) != 3 {
+		return storiface.CallID{}, xerrors.Errorf("malformed call id %q", s)
+	}
+
+	miner, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return storiface.CallID{}, xerrors.Errorf("parsing miner id: %w", err)
+	}
+	number, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return storiface.CallID{}, xerrors.Errorf("parsing sector number: %w", err)
+	}
+	id, err := uuid.Parse(parts[2])
+	if err != nil {
+		return storiface.CallID{}, xerrors.Errorf("parsing call uuid: %w", err)
+	}
+
+	return storiface.CallID{
+		Sector: abi.SectorID{Miner: abi.ActorID(miner), Number: abi.SectorNumber(number)},
+		ID:     id,
+	}, nil
+}
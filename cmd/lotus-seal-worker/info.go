@@ -2,12 +2,14 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/urfave/cli/v2"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/lotus/chain/types"
 	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
 )
 
 var infoCmd = &cli.Command{
@@ -53,8 +55,37 @@ var infoCmd = &cli.Command{
 		fmt.Printf("CPUs: %d; GPUs: %v\n", info.Resources.CPUs, info.Resources.GPUs)
 		fmt.Printf("RAM: %s; Swap: %s\n", types.SizeStr(types.NewInt(info.Resources.MemPhysical)), types.SizeStr(types.NewInt(info.Resources.MemSwap)))
 		fmt.Printf("Reserved memory: %s\n", types.SizeStr(types.NewInt(info.Resources.MemReserved)))
+		printThermal(info.Thermal.CPU)
+		for _, gpu := range info.Thermal.GPUs {
+			printThermal(gpu)
+		}
+
+		ioc, err := api.IOContention(ctx)
+		if err != nil {
+			return xerrors.Errorf("getting I/O contention: %w", err)
+		}
+		fmt.Printf("Piece reads: %d; Moves: %d running, %d waiting (policy: %s)\n", ioc.Reads, ioc.Moves, ioc.MovesWaiting, ioc.Policy)
 		fmt.Println()
 
+		params, err := api.ProofParams(ctx)
+		if err != nil {
+			return xerrors.Errorf("getting proof params: %w", err)
+		}
+
+		if len(params) > 0 {
+			fmt.Println("Proof parameters:")
+			for _, pp := range params {
+				var present int
+				for _, f := range pp.Files {
+					if f.Present {
+						present++
+					}
+				}
+				fmt.Printf("\tProof %d: %s (%d/%d files present)\n", pp.ProofType, pp.Version, present, len(pp.Files))
+			}
+			fmt.Println()
+		}
+
 		paths, err := api.Paths(ctx)
 		if err != nil {
 			return xerrors.Errorf("getting path info: %w", err)
@@ -75,8 +106,29 @@ var infoCmd = &cli.Command{
 				fmt.Print("Use: ReadOnly")
 			}
 			fmt.Printf("\tLocal: %s\n", path.LocalPath)
+			if path.FsType != "" {
+				fmt.Printf("\tFilesystem: %s\n", path.FsType)
+			}
 		}
 
 		return nil
 	},
 }
+
+func printThermal(dt storiface.DeviceThermal) {
+	if dt.Name == "" { // workers which don't report thermal state
+		return
+	}
+
+	temp := "unknown"
+	if dt.TempC > 0 {
+		temp = fmt.Sprintf("%.1f°C", dt.TempC)
+	}
+
+	throttle := dt.Throttle
+	if len(dt.Reasons) > 0 {
+		throttle = fmt.Sprintf("%s (%s)", throttle, strings.Join(dt.Reasons, ", "))
+	}
+
+	fmt.Printf("Thermal %s: %s; Throttle: %s\n", dt.Name, temp, throttle)
+}
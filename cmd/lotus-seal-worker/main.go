@@ -7,24 +7,29 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/ipfs/go-datastore/namespace"
 	logging "github.com/ipfs/go-log/v2"
 	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/urfave/cli/v2"
+	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/go-jsonrpc/auth"
 	paramfetch "github.com/filecoin-project/go-paramfetch"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-statestore"
 
 	"github.com/filecoin-project/lotus/api"
@@ -34,6 +39,7 @@ import (
 	sectorstorage "github.com/filecoin-project/lotus/extern/sector-storage"
 	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
 	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
 	"github.com/filecoin-project/lotus/lib/lotuslog"
 	"github.com/filecoin-project/lotus/lib/rpcenc"
 	"github.com/filecoin-project/lotus/metrics"
@@ -57,8 +63,12 @@ func main() {
 		runCmd,
 		infoCmd,
 		storageCmd,
+		sectorsCmd,
 		setCmd,
 		waitQuietCmd,
+		callLogsCmd,
+		boostCmd,
+		boostsCmd,
 	}
 
 	app := &cli.App{
@@ -120,6 +130,192 @@ var runCmd = &cli.Command{
 			Usage: "don't use swap",
 			Value: false,
 		},
+		&cli.Uint64Flag{
+			Name:  "debug-log-sample",
+			Usage: "only log one in every N debug messages on hot paths (0 logs everything)",
+			Value: 0,
+		},
+		&cli.BoolFlag{
+			Name:  "debug-reservations",
+			Usage: "check that acquired sector paths and their storage reservations are released exactly once, logging violations",
+		},
+		&cli.IntFlag{
+			Name:  "max-sectors",
+			Usage: "maximum number of sectors the worker can hold (0 means no limit)",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "max-concurrent-calls",
+			Usage: "maximum number of calls running on the worker at the same time, queued calls start in priority order (0 means no limit)",
+			Value: 0,
+		},
+		&cli.StringSliceFlag{
+			Name:  "task-limit",
+			Usage: "limit how many calls of a task type run at once, as task=limit, e.g. seal/v0/precommit/1=4 (can be raised for a while with 'lotus-worker boost')",
+		},
+		&cli.IntFlag{
+			Name:  "cleanup-parallelism",
+			Usage: "maximum number of sector copy removals, like the ones done after unsealing, running at the same time (0 means no limit)",
+			Value: 0,
+		},
+		&cli.StringSliceFlag{
+			Name:  "addpiece-paths",
+			Usage: "IDs of local storage paths AddPiece prefers for new unsealed files, in order, when they have enough space",
+		},
+		&cli.BoolFlag{
+			Name:  "addpiece-near-sealed",
+			Usage: "make AddPiece prefer the local storage path holding the sealed replica of the sector for new unsealed files",
+		},
+		&cli.BoolFlag{
+			Name:  "addpiece-verify",
+			Usage: "read pieces back after AddPiece writes them, and check their commitment (doubles AddPiece I/O)",
+		},
+		&cli.BoolFlag{
+			Name:  "sync-writes",
+			Usage: "fsync sector files written by AddPiece and FinalizeSector, and their directories, before the tasks return (slower, but durable on power failure)",
+		},
+		&cli.StringFlag{
+			Name:  "acquire-prefer",
+			Usage: "how to pick among local storage paths qualifying for sector files of a task: most-available, or empty for the store's default order",
+		},
+		&cli.IntFlag{
+			Name:  "move-alternates",
+			Usage: "number of other long-term storage paths moving a sector into long-term storage tries when the destination runs out of space",
+		},
+		&cli.StringSliceFlag{
+			Name:  "move-alternate-paths",
+			Usage: "IDs of local storage paths tried in order when the destination of a move runs out of space, by default any suitable path is tried",
+		},
+		&cli.StringFlag{
+			Name:  "gpu-check",
+			Usage: "what happens to GPU tasks dispatched while the worker sees no GPU: start them anyway, reject them with a retryable error, or reject them and stop accepting GPU task types until enabled again (off, reject, disable)",
+			Value: "off",
+		},
+		&cli.StringFlag{
+			Name:  "params-check",
+			Usage: "check proof parameter files used by accepted tasks at startup, failing it on bad files: don't check, check that they aren't missing or empty, or also check their digests, which reads all of them (off, present, digest)",
+			Value: "off",
+		},
+		&cli.StringFlag{
+			Name:  "move-read-policy",
+			Usage: "how storage moves yield to piece reads: run next to reads, wait while there are reads, or cap moves running while there are reads (off, pause, cap)",
+			Value: "off",
+		},
+		&cli.IntFlag{
+			Name:  "max-moves-during-reads",
+			Usage: "how many storage moves run at once while there are piece reads, with --move-read-policy=cap",
+			Value: 1,
+		},
+		&cli.StringFlag{
+			Name:  "staging-dir",
+			Usage: "directory to keep staged pieces in until they are committed to their sector, piece staging is disabled when not set",
+		},
+		&cli.StringFlag{
+			Name:  "finalize-cache",
+			Usage: "what finalization does with the cleared sector cache: keep it, move it to long-term storage, or drop it when long-term storage already has a copy (keep, move, drop)",
+			Value: "keep",
+		},
+		&cli.IntFlag{
+			Name:  "read-piece-buffer",
+			Usage: "size of the buffer used when streaming piece data, in bytes (0 uses the default)",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  "path-probe-interval",
+			Usage: "how often latency of local storage paths is probed (0 uses the default, negative disables probing)",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "failure-window",
+			Usage: "number of recent calls of each task type failure rates are computed over (0 uses the default)",
+			Value: 0,
+		},
+		&cli.StringFlag{
+			Name:  "soft-memory-limit",
+			Usage: "make tasks wait while the memory estimates of running tasks would exceed this limit, e.g. 256GiB (empty means no limit)",
+		},
+		&cli.BoolFlag{
+			Name:  "reject-over-memory-limit",
+			Usage: "fail tasks which would exceed the soft memory limit instead of making them wait",
+		},
+		&cli.IntFlag{
+			Name:  "precommit2-tree-threads",
+			Usage: "threads to build PreCommit2 trees with, shared by all tasks running at once; leave CPUs for other tasks to avoid oversubscription (0 = one per CPU)",
+		},
+		&cli.DurationFlag{
+			Name:  "max-call-age",
+			Usage: "cancel calls still running this long after the worker accepted them, as a safety net against stuck calls (0 = no limit)",
+		},
+		&cli.DurationFlag{
+			Name:  "scrub-interval",
+			Usage: "check sealed replicas and caches in local paths in the background, one sector per interval while no tasks are running (0 = disabled)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "ffi-error-rule",
+			Usage: "classify task errors containing a string as fatal (not retried) or recoverable (retried), as fatal:<match> or recoverable:<match>; checked before the default rules",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allowed-miner",
+			Usage: "only accept calls for sectors of this miner, e.g. f01234; can be repeated (default: all miners)",
+		},
+		&cli.StringFlag{
+			Name:  "status-listen",
+			Usage: "host address and port to serve read-only JSON worker status on (/info, /calls, /paths, /healthy), without authentication; disabled when empty",
+		},
+		&cli.StringFlag{
+			Name:  "task-webhook",
+			Usage: "URL to post task start, success and failure events to as JSON",
+		},
+		&cli.IntFlag{
+			Name:  "addpiece-replicas",
+			Usage: "number of local paths to write unsealed data added by AddPiece to at once",
+			Value: 1,
+		},
+		&cli.BoolFlag{
+			Name:  "addpiece-replicas-best-effort",
+			Usage: "let AddPiece succeed with fewer unsealed replicas than addpiece-replicas, as long as one is written",
+		},
+		&cli.BoolFlag{
+			Name:  "addpiece-overwrite",
+			Usage: "remove unsealed data of sectors AddPiece adds the first piece to, instead of failing the call (for recovery)",
+		},
+		&cli.StringFlag{
+			Name:  "addpiece-rate-limit",
+			Usage: "limit how fast all AddPiece calls together read piece data, per second, e.g. 200MiB (empty means no limit)",
+		},
+		&cli.BoolFlag{
+			Name:  "commit2-clear-cache",
+			Usage: "clear the local cache of sectors right after commit2, keeping only files needed for proving; commit1 can't be redone for the sector afterwards",
+		},
+		&cli.BoolFlag{
+			Name:  "commit1-cpu-only",
+			Usage: "make sure commit1 never uses GPUs, keeping them free for commit2 and PoSt; fails commit1 of proof types for which proofs may use GPUs",
+		},
+		&cli.StringSliceFlag{
+			Name:  "storage-group",
+			Usage: "allocate sector files of a task type only in local paths of a storage group, as task=group, e.g. seal/v0/addpiece=nvme",
+		},
+		&cli.BoolFlag{
+			Name:  "dedup-pieces",
+			Usage: "share unsealed files of sectors filled by the same piece with hard links, instead of keeping a copy for each sector",
+		},
+		&cli.BoolFlag{
+			Name:  "force-remove",
+			Usage: "remove sector files when the miner asks for it also while calls for the sector are in progress",
+		},
+		&cli.StringSliceFlag{
+			Name:  "capabilities",
+			Usage: "capabilities declared to the scheduler (snap-deals, partial-unseal, window-post), by default the ones supported by the proofs backend",
+		},
+		&cli.IntFlag{
+			Name:  "finalize-remove-retries",
+			Usage: "number of times removing unsealed files after finalizing is retried before it's deferred (0 uses the default, negative disables retries)",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  "unsealed-grace-period",
+			Usage: "keep unsealed data not kept by finalization for this long before removing it, e.g. to serve retrievals (0 removes it right away)",
+		},
 		&cli.BoolFlag{
 			Name:  "addpiece",
 			Usage: "enable addpiece",
@@ -145,11 +341,35 @@ var runCmd = &cli.Command{
 			Usage: "enable commit (32G sectors: all cores or GPUs, 128GiB Memory + 64GiB swap)",
 			Value: true,
 		},
+		&cli.BoolFlag{
+			Name:  "replica-update",
+			Usage: "enable replica update and prove replica update 1 (snap-deals)",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "prove-replica-update2",
+			Usage: "enable prove replica update 2 (snap-deals)",
+			Value: false,
+		},
 		&cli.IntFlag{
 			Name:  "parallel-fetch-limit",
 			Usage: "maximum fetch operations to run in parallel",
 			Value: 5,
 		},
+		&cli.Int64Flag{
+			Name:  "fetch-chunk-size",
+			Usage: "size of ranges requested when fetching sector files, in bytes (0 fetches whole files at once)",
+			Value: 1 << 30,
+		},
+		&cli.IntFlag{
+			Name:  "fetch-retries",
+			Usage: "how many times failed fetches are retried, resuming from the last byte written",
+			Value: 5,
+		},
+		&cli.IntFlag{
+			Name:  "fetch-source-limit",
+			Usage: "maximum number of parallel fetches from one source worker or miner, further fetches wait (0 = only parallel-fetch-limit applies)",
+		},
 		&cli.StringFlag{
 			Name:  "timeout",
 			Usage: "used when 'listen' is unspecified. must be a valid duration recognized by golang's time.ParseDuration function",
@@ -205,6 +425,11 @@ var runCmd = &cli.Command{
 			log.Fatalf("Cannot register the view: %v", err)
 		}
 
+		// Set the metric to one so it is published to the exporter, tagged
+		// with the version like the daemon's
+		ictx, _ := tag.New(ctx, tag.Insert(metrics.Version, build.BuildVersion), tag.Insert(metrics.Commit, build.CurrentCommit))
+		stats.Record(ictx, metrics.LotusInfo.M(1))
+
 		v, err := nodeApi.Version(ctx)
 		if err != nil {
 			return err
@@ -250,6 +475,12 @@ var runCmd = &cli.Command{
 		if cctx.Bool("commit") {
 			taskTypes = append(taskTypes, sealtasks.TTCommit2)
 		}
+		if cctx.Bool("replica-update") {
+			taskTypes = append(taskTypes, sealtasks.TTReplicaUpdate, sealtasks.TTProveReplicaUpdate1)
+		}
+		if cctx.Bool("prove-replica-update2") {
+			taskTypes = append(taskTypes, sealtasks.TTProveReplicaUpdate2)
+		}
 
 		if len(taskTypes) == 0 {
 			return xerrors.Errorf("no task types specified")
@@ -333,6 +564,19 @@ var runCmd = &cli.Command{
 
 		log.Info("Opening local storage; connecting to master")
 		const unspecifiedAddress = "0.0.0.0"
+		var allowedMiners []abi.ActorID
+		for _, s := range cctx.StringSlice("allowed-miner") {
+			maddr, err := address.NewFromString(s)
+			if err != nil {
+				return xerrors.Errorf("parsing allowed miner %q: %w", s, err)
+			}
+			mid, err := address.IDFromAddress(maddr)
+			if err != nil {
+				return xerrors.Errorf("allowed miner %q must be an ID address: %w", s, err)
+			}
+			allowedMiners = append(allowedMiners, abi.ActorID(mid))
+		}
+
 		address := cctx.String("listen")
 		addressSlice := strings.Split(address, ":")
 		if ip := net.ParseIP(addressSlice[0]); ip != nil {
@@ -360,21 +604,196 @@ var runCmd = &cli.Command{
 			return xerrors.Errorf("could not get api info: %w", err)
 		}
 
-		remote := stores.NewRemote(localStore, nodeApi, sminfo.AuthHeader(), cctx.Int("parallel-fetch-limit"))
+		fcfg := stores.DefaultFetchConfig()
+		fcfg.ChunkSize = cctx.Int64("fetch-chunk-size")
+		fcfg.Retries = cctx.Int("fetch-retries")
+		fcfg.SourceLimit = cctx.Int("fetch-source-limit")
+
+		remote := stores.NewRemote(localStore, nodeApi, sminfo.AuthHeader(), cctx.Int("parallel-fetch-limit"), fcfg)
 
 		// Create / expose the worker
 
 		wsts := statestore.New(namespace.Wrap(ds, modules.WorkerCallsPrefix))
 
+		finalizeCache, err := sectorstorage.ParseFinalizeCachePolicy(cctx.String("finalize-cache"))
+		if err != nil {
+			return err
+		}
+
+		gpuCheck, err := sectorstorage.ParseGPUCheckPolicy(cctx.String("gpu-check"))
+		if err != nil {
+			return err
+		}
+
+		moveRead, err := sectorstorage.ParseMoveReadPolicy(cctx.String("move-read-policy"))
+		if err != nil {
+			return err
+		}
+
+		paramsCheck, err := sectorstorage.ParseParamsCheckPolicy(cctx.String("params-check"))
+		if err != nil {
+			return err
+		}
+
+		var memLimit uint64
+		if s := cctx.String("soft-memory-limit"); s != "" {
+			l, err := units.RAMInBytes(s)
+			if err != nil {
+				return xerrors.Errorf("parsing soft memory limit: %w", err)
+			}
+			memLimit = uint64(l)
+		}
+
+		var addPieceRate int64
+		if s := cctx.String("addpiece-rate-limit"); s != "" {
+			addPieceRate, err = units.RAMInBytes(s)
+			if err != nil {
+				return xerrors.Errorf("parsing AddPiece rate limit: %w", err)
+			}
+		}
+
+		addPieceAlloc := stores.AllocPolicy{
+			NearSealed: cctx.Bool("addpiece-near-sealed"),
+		}
+		for _, id := range cctx.StringSlice("addpiece-paths") {
+			addPieceAlloc.Paths = append(addPieceAlloc.Paths, stores.ID(id))
+		}
+
+		moveFull := stores.MoveFullPolicy{
+			Alternates: cctx.Int("move-alternates"),
+		}
+		for _, id := range cctx.StringSlice("move-alternate-paths") {
+			moveFull.Paths = append(moveFull.Paths, stores.ID(id))
+		}
+
+		var acquirePref stores.PathPreference
+		if name := cctx.String("acquire-prefer"); name != "" {
+			acquirePref = stores.PathPreferences[name]
+			if acquirePref == nil {
+				return xerrors.Errorf("unknown acquire preference '%s'", name)
+			}
+		}
+
+		taskLimits, err := sectorstorage.ParseTaskLimits(cctx.StringSlice("task-limit"))
+		if err != nil {
+			return err
+		}
+
+		storageGroups, err := sectorstorage.ParseTaskStorageGroups(cctx.StringSlice("storage-group"))
+		if err != nil {
+			return err
+		}
+		if err := sectorstorage.CheckTaskStorageGroups(localStore, storageGroups); err != nil {
+			return err
+		}
+
+		if err := sectorstorage.CheckTreeThreads(cctx.Int("precommit2-tree-threads")); err != nil {
+			return err
+		}
+
+		if wh := cctx.String("task-webhook"); wh != "" {
+			if u, err := url.Parse(wh); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return xerrors.Errorf("task webhook must be an http(s) URL, got '%s'", wh)
+			}
+		}
+
+		var ffiErrorRules []sectorstorage.FFIErrorRule
+		for _, s := range cctx.StringSlice("ffi-error-rule") {
+			rule, err := sectorstorage.ParseFFIErrorRule(s)
+			if err != nil {
+				return err
+			}
+			ffiErrorRules = append(ffiErrorRules, rule)
+		}
+
+		var capabilities []storiface.WorkerCapability
+		if cctx.IsSet("capabilities") {
+			capabilities = []storiface.WorkerCapability{}
+			for _, name := range cctx.StringSlice("capabilities") {
+				c, err := storiface.ParseWorkerCapability(name)
+				if err != nil {
+					return err
+				}
+				capabilities = append(capabilities, c)
+			}
+		}
+
 		workerApi := &worker{
 			LocalWorker: sectorstorage.NewLocalWorker(sectorstorage.WorkerConfig{
 				TaskTypes: taskTypes,
 				NoSwap:    cctx.Bool("no-swap"),
+
+				ParamsManifest:        build.ParametersJSON(),
+				ParamsCheck:           paramsCheck,
+				ParamsSectorSize:      ssize,
+				MaxSectors:            cctx.Int("max-sectors"),
+				DebugLogSampleRate:    cctx.Uint64("debug-log-sample"),
+				DebugReservations:     cctx.Bool("debug-reservations"),
+				ReadPieceBufferSize:   cctx.Int("read-piece-buffer"),
+				MaxConcurrentCalls:    cctx.Int("max-concurrent-calls"),
+				TaskLimits:            taskLimits,
+				CleanupParallelism:    cctx.Int("cleanup-parallelism"),
+				FinalizeCache:         finalizeCache,
+				AddPieceAlloc:         addPieceAlloc,
+				AddPieceReplicas:      cctx.Int("addpiece-replicas"),
+				TaskWebhook:           cctx.String("task-webhook"),
+				MaxCallAge:            cctx.Duration("max-call-age"),
+				GPUCheck:              gpuCheck,
+				MoveReadPolicy:        moveRead,
+				MaxMovesDuringReads:   cctx.Int("max-moves-during-reads"),
+				StagingDir:            cctx.String("staging-dir"),
+				ScrubInterval:         cctx.Duration("scrub-interval"),
+				FFIErrorRules:         ffiErrorRules,
+				AllowedMiners:         allowedMiners,
+				PreCommit2TreeThreads: cctx.Int("precommit2-tree-threads"),
+				ReplicasBestEffort:    cctx.Bool("addpiece-replicas-best-effort"),
+				AddPieceOverwrite:     cctx.Bool("addpiece-overwrite"),
+				AddPieceRateLimit:     addPieceRate,
+				TaskStorageGroups:     storageGroups,
+				CPUOnlyCommit1:        cctx.Bool("commit1-cpu-only"),
+				Commit2ClearCache:     cctx.Bool("commit2-clear-cache"),
+				VerifyAddPiece:        cctx.Bool("addpiece-verify"),
+				SyncWrites:            cctx.Bool("sync-writes"),
+				MoveFull:              moveFull,
+				AcquirePreference:     acquirePref,
+				FailureWindow:         cctx.Int("failure-window"),
+				PathProbeInterval:     cctx.Duration("path-probe-interval"),
+				FinalizeRemoveRetries: cctx.Int("finalize-remove-retries"),
+				UnsealedGracePeriod:   cctx.Duration("unsealed-grace-period"),
+				Capabilities:          capabilities,
+				ForceRemove:           cctx.Bool("force-remove"),
+				DedupPieces:           cctx.Bool("dedup-pieces"),
+				SoftMemoryLimit:       memLimit,
+				RejectOverMemoryLimit: cctx.Bool("reject-over-memory-limit"),
 			}, remote, localStore, nodeApi, nodeApi, wsts),
 			localStore: localStore,
 			ls:         lr,
 		}
 
+		if err := workerApi.CheckCPUOnlyCommit1(ctx); err != nil {
+			return xerrors.Errorf("commit1-cpu-only: %w", err)
+		}
+
+		if err := workerApi.CheckProofParams(ctx); err != nil {
+			return xerrors.Errorf("params-check: %w", err)
+		}
+
+		// catch misconfigured mounts before accepting tasks
+		checked, err := workerApi.CheckStorage(ctx)
+		if err != nil {
+			return xerrors.Errorf("checking storage: %w", err)
+		}
+		for _, err := range checked {
+			if err != nil {
+				return xerrors.Errorf("storage path unreachable: %w", err)
+			}
+		}
+
+		exporter, err := metrics.Exporter()
+		if err != nil {
+			return err
+		}
+
 		mux := mux.NewRouter()
 
 		log.Info("Setting up control endpoint at " + address)
@@ -386,6 +805,7 @@ var runCmd = &cli.Command{
 		mux.Handle("/rpc/v0", rpcServer)
 		mux.Handle("/rpc/streams/v0/push/{uuid}", readerHandler)
 		mux.PathPrefix("/remote").HandlerFunc((&stores.FetchHandler{Local: localStore}).ServeHTTP)
+		mux.Handle("/debug/metrics", exporter)
 		mux.PathPrefix("/").Handler(http.DefaultServeMux) // pprof
 
 		ah := &auth.Handler{
@@ -401,6 +821,28 @@ var runCmd = &cli.Command{
 			},
 		}
 
+		if sl := cctx.String("status-listen"); sl != "" {
+			snl, err := net.Listen("tcp", sl)
+			if err != nil {
+				return xerrors.Errorf("listening for status requests: %w", err)
+			}
+
+			log.Infof("Serving worker status at %s", sl)
+
+			ssrv := &http.Server{Handler: workerApi.StatusHandler()}
+			go func() {
+				if err := ssrv.Serve(snl); err != nil && err != http.ErrServerClosed {
+					log.Errorf("serving worker status failed: %s", err)
+				}
+			}()
+			go func() {
+				<-ctx.Done()
+				if err := ssrv.Shutdown(context.TODO()); err != nil {
+					log.Errorf("shutting down status server failed: %s", err)
+				}
+			}()
+		}
+
 		go func() {
 			<-ctx.Done()
 			log.Warn("Shutting down...")
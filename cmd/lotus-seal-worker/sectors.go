@@ -0,0 +1,504 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+var sectorsCmd = &cli.Command{
+	Name:  "sectors",
+	Usage: "manage sectors stored on the worker",
+	Subcommands: []*cli.Command{
+		sectorsPurgeUnsealedCmd,
+		sectorsRedeclareCmd,
+		sectorsEvacuateCmd,
+		sectorsResetToUnsealedCmd,
+		sectorsPauseCmd,
+		sectorsResumeCmd,
+		sectorsPausedCmd,
+		sectorsVerifyCmd,
+		sectorsScrubFindingsCmd,
+		sectorsStagedCmd,
+		sectorsRemoveStagedCmd,
+	},
+}
+
+var sectorsPurgeUnsealedCmd = &cli.Command{
+	Name:  "purge-unsealed",
+	Usage: "remove local unsealed copies of finalized sectors",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "min-age",
+			Usage: "only remove unsealed files not modified for at least this long",
+		},
+		&cli.StringSliceFlag{
+			Name:  "pin",
+			Usage: "keep unsealed copy of the sector, e.g. s-t01000-1",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		policy := storiface.UnsealedPurgePolicy{
+			MinAge: cctx.Duration("min-age"),
+		}
+		for _, s := range cctx.StringSlice("pin") {
+			sid, err := storiface.ParseSectorID(s)
+			if err != nil {
+				return xerrors.Errorf("parsing pinned sector: %w", err)
+			}
+			policy.Pinned = append(policy.Pinned, sid)
+		}
+
+		freed, err := api.PurgeUnsealed(ctx, policy)
+		if err != nil {
+			return xerrors.Errorf("PurgeUnsealed: %w", err)
+		}
+
+		fmt.Printf("Freed %s\n", types.SizeStr(types.NewInt(uint64(freed))))
+		return nil
+	},
+}
+
+var sectorsRedeclareCmd = &cli.Command{
+	Name:  "redeclare",
+	Usage: "declare all sector files found in local storage paths in the sector index",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		n, err := api.RedeclareSectors(ctx)
+		if err != nil {
+			return xerrors.Errorf("RedeclareSectors: %w", err)
+		}
+
+		fmt.Printf("Declared %d sector files\n", n)
+		return nil
+	},
+}
+
+var sectorsResetToUnsealedCmd = &cli.Command{
+	Name:      "reset-to-unsealed",
+	Usage:     "remove the sealed replica and cache of a sector, keeping unsealed data to seal it again from",
+	ArgsUsage: "[sector, e.g. s-t01000-1]",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+
+		sid, err := storiface.ParseSectorID(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing sector: %w", err)
+		}
+
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		if err := api.ResetToUnsealed(ctx, sid); err != nil {
+			return xerrors.Errorf("ResetToUnsealed: %w", err)
+		}
+
+		fmt.Printf("Removed sealed replica and cache of %s\n", storiface.SectorName(sid))
+		return nil
+	},
+}
+
+var sectorsPauseCmd = &cli.Command{
+	Name:      "pause",
+	Usage:     "stop starting new tasks for a sector, tasks already running keep running",
+	ArgsUsage: "[sector, e.g. s-t01000-1]",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+
+		sid, err := storiface.ParseSectorID(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing sector: %w", err)
+		}
+
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		if err := api.PauseSector(ctx, sid); err != nil {
+			return xerrors.Errorf("PauseSector: %w", err)
+		}
+
+		fmt.Printf("Paused %s\n", storiface.SectorName(sid))
+		return nil
+	},
+}
+
+var sectorsResumeCmd = &cli.Command{
+	Name:      "resume",
+	Usage:     "start tasks for a paused sector again",
+	ArgsUsage: "[sector, e.g. s-t01000-1]",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+
+		sid, err := storiface.ParseSectorID(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing sector: %w", err)
+		}
+
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		if err := api.ResumeSector(ctx, sid); err != nil {
+			return xerrors.Errorf("ResumeSector: %w", err)
+		}
+
+		fmt.Printf("Resumed %s\n", storiface.SectorName(sid))
+		return nil
+	},
+}
+
+var sectorsPausedCmd = &cli.Command{
+	Name:  "paused",
+	Usage: "list paused sectors",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		paused, err := api.PausedSectors(ctx)
+		if err != nil {
+			return xerrors.Errorf("PausedSectors: %w", err)
+		}
+
+		for _, sid := range paused {
+			fmt.Println(storiface.SectorName(sid))
+		}
+		return nil
+	},
+}
+
+var sectorsVerifyCmd = &cli.Command{
+	Name:      "verify",
+	Usage:     "read the sealed replica and cache of a sector in local paths, reporting progress",
+	ArgsUsage: "[sector, e.g. s-t01000-1]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "sector-size",
+			Usage: "size of the sector",
+			Value: "32GiB",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+
+		sid, err := storiface.ParseSectorID(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing sector: %w", err)
+		}
+
+		ssize, err := units.RAMInBytes(cctx.String("sector-size"))
+		if err != nil {
+			return xerrors.Errorf("parsing sector size: %w", err)
+		}
+		spt, err := miner.SealProofTypeFromSectorSize(abi.SectorSize(ssize), build.NewestNetworkVersion)
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		updates, err := api.VerifySectorUpdates(ctx, storage.SectorRef{ID: sid, ProofType: spt})
+		if err != nil {
+			return xerrors.Errorf("VerifySectorUpdates: %w", err)
+		}
+
+		var result *storiface.VerifyUpdate
+		for u := range updates {
+			if u.Done {
+				u := u
+				result = &u
+				continue
+			}
+
+			fmt.Printf("%s: %s of %s verified, ETA %s\n", storiface.SectorName(u.Sector),
+				types.SizeStr(types.NewInt(uint64(u.Verified))), types.SizeStr(types.NewInt(uint64(u.Total))),
+				u.ETA.Format(time.RFC3339))
+		}
+
+		if result == nil {
+			return xerrors.Errorf("interrupted")
+		}
+		if result.Error != "" {
+			return xerrors.Errorf("VerifySector: %s", result.Error)
+		}
+
+		fmt.Printf("%s: %s verified\n", storiface.SectorName(sid), types.SizeStr(types.NewInt(uint64(result.Verified))))
+		return nil
+	},
+}
+
+var sectorsScrubFindingsCmd = &cli.Command{
+	Name:  "scrub-findings",
+	Usage: "list bad sector files recently found by the background scrubber",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		found, err := api.ScrubFindings(ctx)
+		if err != nil {
+			return xerrors.Errorf("ScrubFindings: %w", err)
+		}
+
+		for _, sf := range found {
+			fmt.Printf("%s\t%s\t%s\t%s\n", sf.Found.Format(time.RFC3339), storiface.SectorName(sf.Sector), sf.Type, sf.Error)
+		}
+		return nil
+	},
+}
+
+var sectorsStagedCmd = &cli.Command{
+	Name:  "staged",
+	Usage: "list pieces staged for sectors, and the capacity of the staging area",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		si, err := api.StagingInfo(ctx)
+		if err != nil {
+			return xerrors.Errorf("StagingInfo: %w", err)
+		}
+
+		fmt.Printf("Staging area: %s\n", si.Dir)
+		fmt.Printf("Used: %s; Available: %s of %s\n", types.SizeStr(types.NewInt(uint64(si.Used))), types.SizeStr(types.NewInt(uint64(si.Available))), types.SizeStr(types.NewInt(uint64(si.Capacity))))
+
+		for _, ss := range si.Sectors {
+			var size abi.UnpaddedPieceSize
+			for _, sp := range ss.Pieces {
+				size += sp.Size
+			}
+			fmt.Printf("%s\t%d pieces\t%s\n", storiface.SectorName(ss.Sector), len(ss.Pieces), types.SizeStr(types.NewInt(uint64(size))))
+		}
+		return nil
+	},
+}
+
+var sectorsRemoveStagedCmd = &cli.Command{
+	Name:      "remove-staged",
+	Usage:     "remove the pieces staged for a sector",
+	ArgsUsage: "[sector, e.g. s-t01000-1]",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+
+		sid, err := storiface.ParseSectorID(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing sector: %w", err)
+		}
+
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		if err := api.RemoveStaged(ctx, sid); err != nil {
+			return xerrors.Errorf("RemoveStaged: %w", err)
+		}
+
+		fmt.Printf("Removed staged pieces of %s\n", storiface.SectorName(sid))
+		return nil
+	},
+}
+
+var sectorsEvacuateCmd = &cli.Command{
+	Name:  "evacuate",
+	Usage: "move all sectors off the worker's other local paths into the given paths, and stop accepting new tasks",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "to",
+			Usage: "ID of a local storage path to move sectors to, tried in order (resumes the last evacuation when not set)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "pin",
+			Usage: "leave the sector in place, e.g. s-t01000-1",
+		},
+	},
+	Subcommands: []*cli.Command{
+		sectorsEvacuateStatusCmd,
+		sectorsEvacuateCancelCmd,
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		sel := storiface.EvacuateSelector{
+			Paths: cctx.StringSlice("to"),
+		}
+		for _, s := range cctx.StringSlice("pin") {
+			sid, err := storiface.ParseSectorID(s)
+			if err != nil {
+				return xerrors.Errorf("parsing pinned sector: %w", err)
+			}
+			sel.Pinned = append(sel.Pinned, sid)
+		}
+
+		updates, err := api.EvacuateUpdates(ctx, sel)
+		if err != nil {
+			return xerrors.Errorf("EvacuateUpdates: %w", err)
+		}
+
+		var summary *storiface.EvacUpdate
+		for u := range updates {
+			if u.Result == "" {
+				u := u
+				summary = &u
+				continue
+			}
+
+			fmt.Printf("%s: %s (%d of %d moved)", storiface.SectorName(u.Sector), u.Result, u.Progress.Moved, u.Progress.Sectors)
+			if u.Error != "" {
+				fmt.Printf(": %s", u.Error)
+			}
+			fmt.Println()
+		}
+
+		if summary == nil {
+			return xerrors.Errorf("interrupted, the worker stops evacuating after the sector being moved, see 'sectors evacuate status'")
+		}
+
+		printEvacProgress(summary.Progress)
+		if summary.Error != "" {
+			return xerrors.Errorf("Evacuate: %s", summary.Error)
+		}
+		return nil
+	},
+}
+
+var sectorsEvacuateStatusCmd = &cli.Command{
+	Name:  "status",
+	Usage: "print progress of the current or last evacuation",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		progress, err := api.EvacuationProgress(lcli.ReqContext(cctx))
+		if err != nil {
+			return xerrors.Errorf("EvacuationProgress: %w", err)
+		}
+
+		printEvacProgress(progress)
+		return nil
+	},
+}
+
+var sectorsEvacuateCancelCmd = &cli.Command{
+	Name:  "cancel",
+	Usage: "make an evacuated worker accept new tasks again",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := api.CancelEvacuation(lcli.ReqContext(cctx)); err != nil {
+			return xerrors.Errorf("CancelEvacuation: %w", err)
+		}
+
+		return nil
+	},
+}
+
+func printEvacProgress(p storiface.EvacProgress) {
+	fmt.Printf("Running: %t; Done: %t\n", p.Running, p.Done)
+	fmt.Printf("Moved %d of %d sectors\n", p.Moved, p.Sectors)
+	for _, l := range []struct {
+		name    string
+		sectors []abi.SectorID
+	}{
+		{"Pinned", p.Pinned},
+		{"Busy", p.Busy},
+		{"Failed", p.Failed},
+		{"Remaining", p.Remaining},
+	} {
+		if len(l.sectors) == 0 {
+			continue
+		}
+
+		fmt.Printf("%s:", l.name)
+		for _, sid := range l.sectors {
+			fmt.Printf(" %s", storiface.SectorName(sid))
+		}
+		fmt.Println()
+	}
+}
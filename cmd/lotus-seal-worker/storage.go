@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/mitchellh/go-homedir"
@@ -22,6 +23,32 @@ var storageCmd = &cli.Command{
 	Usage: "manage sector storage",
 	Subcommands: []*cli.Command{
 		storageAttachCmd,
+		storageSetWeightCmd,
+	},
+}
+
+var storageSetWeightCmd = &cli.Command{
+	Name:      "set-weight",
+	Usage:     "change the weight of a local storage path, new sector files are placed in paths with higher weights first",
+	ArgsUsage: "[storage ID] [weight]",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetWorkerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		if cctx.Args().Len() != 2 {
+			return xerrors.Errorf("expected storage ID and weight")
+		}
+
+		weight, err := strconv.ParseUint(cctx.Args().Get(1), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing weight: %w", err)
+		}
+
+		return nodeApi.SetPathWeight(ctx, stores.ID(cctx.Args().Get(0)), weight)
 	},
 }
 
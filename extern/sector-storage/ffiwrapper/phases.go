@@ -0,0 +1,74 @@
+package ffiwrapper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// Sub-phases of tasks timed by the Sealer. Each FFI call is timed as one phase,
+// what it does internally, e.g. encoding layers vs building trees, can't be
+// told apart.
+const (
+	PhaseAcquire       = "acquire" // acquiring sector paths, including fetching inputs
+	PhasePrepare       = "prepare"
+	PhaseSDR           = "sdr" // PreCommit1 labeling layers
+	PhaseTrees         = "trees"
+	PhaseVanillaProofs = "vanilla-proofs"
+	PhaseSNARK         = "snark"
+)
+
+type phaseTimerKey struct{}
+
+type phaseTimer struct {
+	lk     sync.Mutex
+	phases []storiface.TaskPhase
+}
+
+// WithPhaseTimer returns a context which tasks run with record the time they
+// spent in each sub-phase into, see TaskPhases
+func WithPhaseTimer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, phaseTimerKey{}, &phaseTimer{})
+}
+
+// StartPhase starts timing a sub-phase of the task running with ctx, the
+// returned func ends it. Time spent in a phase started more than once is
+// added up. Does nothing when ctx has no phase timer.
+func StartPhase(ctx context.Context, phase string) func() {
+	pt, ok := ctx.Value(phaseTimerKey{}).(*phaseTimer)
+	if !ok {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		took := time.Since(start)
+
+		pt.lk.Lock()
+		defer pt.lk.Unlock()
+
+		for i := range pt.phases {
+			if pt.phases[i].Phase == phase {
+				pt.phases[i].Took += took
+				return
+			}
+		}
+		pt.phases = append(pt.phases, storiface.TaskPhase{Phase: phase, Took: took})
+	}
+}
+
+// TaskPhases returns the sub-phases timed for the task running with ctx, in
+// the order they started. Empty for tasks which aren't instrumented.
+func TaskPhases(ctx context.Context) []storiface.TaskPhase {
+	pt, ok := ctx.Value(phaseTimerKey{}).(*phaseTimer)
+	if !ok {
+		return nil
+	}
+
+	pt.lk.Lock()
+	defer pt.lk.Unlock()
+
+	return append([]storiface.TaskPhase(nil), pt.phases...)
+}
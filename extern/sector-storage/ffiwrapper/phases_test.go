@@ -0,0 +1,33 @@
+package ffiwrapper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskPhases(t *testing.T) {
+	// not timed without a timer
+	ctx := context.Background()
+	StartPhase(ctx, PhaseAcquire)()
+	require.Nil(t, TaskPhases(ctx))
+
+	ctx = WithPhaseTimer(ctx)
+	end := StartPhase(ctx, PhaseAcquire)
+	time.Sleep(5 * time.Millisecond)
+	end()
+	StartPhase(ctx, PhaseTrees)()
+
+	// repeated phases add up
+	end = StartPhase(ctx, PhaseAcquire)
+	time.Sleep(5 * time.Millisecond)
+	end()
+
+	phases := TaskPhases(ctx)
+	require.Len(t, phases, 2)
+	require.Equal(t, PhaseAcquire, phases[0].Phase)
+	require.GreaterOrEqual(t, int64(phases[0].Took), int64(10*time.Millisecond))
+	require.Equal(t, PhaseTrees, phases[1].Phase)
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"math/bits"
 	"os"
+	"path/filepath"
 	"runtime"
 
 	"github.com/ipfs/go-cid"
@@ -26,6 +27,9 @@ import (
 )
 
 var _ Storage = &Sealer{}
+var _ PartialFinalizer = &Sealer{}
+var _ ReplicaUpdater = &Sealer{}
+var _ CacheRebuilder = &Sealer{}
 
 func New(sectors SectorProvider) (*Sealer, error) {
 	sb := &Sealer{
@@ -413,12 +417,15 @@ func (sb *Sealer) ReadPiece(ctx context.Context, writer io.Writer, sector storag
 }
 
 func (sb *Sealer) SealPreCommit1(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, pieces []abi.PieceInfo) (out storage.PreCommit1Out, err error) {
+	endPhase := StartPhase(ctx, PhaseAcquire)
 	paths, done, err := sb.sectors.AcquireSector(ctx, sector, storiface.FTUnsealed, storiface.FTSealed|storiface.FTCache, storiface.PathSealing)
+	endPhase()
 	if err != nil {
 		return nil, xerrors.Errorf("acquiring sector paths: %w", err)
 	}
 	defer done()
 
+	endPrepare := StartPhase(ctx, PhasePrepare)
 	e, err := os.OpenFile(paths.Sealed, os.O_RDWR|os.O_CREATE, 0644) // nolint:gosec
 	if err != nil {
 		return nil, xerrors.Errorf("ensuring sealed file exists: %w", err)
@@ -455,8 +462,10 @@ func (sb *Sealer) SealPreCommit1(ctx context.Context, sector storage.SectorRef,
 	if sum != ussize {
 		return nil, xerrors.Errorf("aggregated piece sizes don't match sector size: %d != %d (%d)", sum, ussize, int64(ussize-sum))
 	}
+	endPrepare()
 
 	// TODO: context cancellation respect
+	defer StartPhase(ctx, PhaseSDR)()
 	p1o, err := ffi.SealPreCommitPhase1(
 		sector.ProofType,
 		paths.Cache,
@@ -474,12 +483,15 @@ func (sb *Sealer) SealPreCommit1(ctx context.Context, sector storage.SectorRef,
 }
 
 func (sb *Sealer) SealPreCommit2(ctx context.Context, sector storage.SectorRef, phase1Out storage.PreCommit1Out) (storage.SectorCids, error) {
+	endPhase := StartPhase(ctx, PhaseAcquire)
 	paths, done, err := sb.sectors.AcquireSector(ctx, sector, storiface.FTSealed|storiface.FTCache, 0, storiface.PathSealing)
+	endPhase()
 	if err != nil {
 		return storage.SectorCids{}, xerrors.Errorf("acquiring sector paths: %w", err)
 	}
 	defer done()
 
+	defer StartPhase(ctx, PhaseTrees)()
 	sealedCID, unsealedCID, err := ffi.SealPreCommitPhase2(phase1Out, paths.Cache, paths.Sealed)
 	if err != nil {
 		return storage.SectorCids{}, xerrors.Errorf("presealing sector %d (%s): %w", sector.ID.Number, paths.Unsealed, err)
@@ -492,11 +504,15 @@ func (sb *Sealer) SealPreCommit2(ctx context.Context, sector storage.SectorRef,
 }
 
 func (sb *Sealer) SealCommit1(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, pieces []abi.PieceInfo, cids storage.SectorCids) (storage.Commit1Out, error) {
+	endPhase := StartPhase(ctx, PhaseAcquire)
 	paths, done, err := sb.sectors.AcquireSector(ctx, sector, storiface.FTSealed|storiface.FTCache, 0, storiface.PathSealing)
+	endPhase()
 	if err != nil {
 		return nil, xerrors.Errorf("acquire sector paths: %w", err)
 	}
 	defer done()
+
+	defer StartPhase(ctx, PhaseVanillaProofs)()
 	output, err := ffi.SealCommitPhase1(
 		sector.ProofType,
 		cids.Sealed,
@@ -519,10 +535,43 @@ func (sb *Sealer) SealCommit1(ctx context.Context, sector storage.SectorRef, tic
 }
 
 func (sb *Sealer) SealCommit2(ctx context.Context, sector storage.SectorRef, phase1Out storage.Commit1Out) (storage.Proof, error) {
+	defer StartPhase(ctx, PhaseSNARK)()
 	return ffi.SealCommitPhase2(phase1Out, sector.ID.Number, sector.ID.Miner)
 }
 
+func (sb *Sealer) ReplicaUpdate(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (storiface.ReplicaUpdateOut, error) {
+	paths, done, err := sb.sectors.AcquireSector(ctx, sector, storiface.FTSealed|storiface.FTCache, storiface.FTUpdate|storiface.FTUpdateCache, storiface.PathSealing)
+	if err != nil {
+		return storiface.ReplicaUpdateOut{}, xerrors.Errorf("acquiring sector paths: %w", err)
+	}
+	defer done()
+
+	return storiface.ReplicaUpdateOut{}, xerrors.Errorf("encoding replica update of sector %d into %s: %w", sector.ID.Number, paths.Update, ErrReplicaUpdateUnsupported)
+}
+
+func (sb *Sealer) ProveReplicaUpdate1(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid) (storiface.ReplicaVanillaProofs, error) {
+	paths, done, err := sb.sectors.AcquireSector(ctx, sector, storiface.FTSealed|storiface.FTCache|storiface.FTUpdate|storiface.FTUpdateCache, 0, storiface.PathSealing)
+	if err != nil {
+		return nil, xerrors.Errorf("acquiring sector paths: %w", err)
+	}
+	defer done()
+
+	return nil, xerrors.Errorf("generating vanilla update proofs for %s: %w", paths.Update, ErrReplicaUpdateUnsupported)
+}
+
+func (sb *Sealer) ProveReplicaUpdate2(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid, vanillaProofs storiface.ReplicaVanillaProofs) (storiface.ReplicaUpdateProof, error) {
+	return nil, xerrors.Errorf("generating update proof of sector %d: %w", sector.ID.Number, ErrReplicaUpdateUnsupported)
+}
+
 func (sb *Sealer) FinalizeSector(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range) error {
+	if err := sb.FinalizeUnsealed(ctx, sector, keepUnsealed); err != nil {
+		return err
+	}
+
+	return sb.ClearCache(ctx, sector)
+}
+
+func (sb *Sealer) FinalizeUnsealed(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range) error {
 	ssize, err := sector.ProofType.SectorSize()
 	if err != nil {
 		return err
@@ -587,6 +636,15 @@ func (sb *Sealer) FinalizeSector(ctx context.Context, sector storage.SectorRef,
 
 	}
 
+	return nil
+}
+
+func (sb *Sealer) ClearCache(ctx context.Context, sector storage.SectorRef) error {
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return err
+	}
+
 	paths, done, err := sb.sectors.AcquireSector(ctx, sector, storiface.FTCache, 0, storiface.PathStorage)
 	if err != nil {
 		return xerrors.Errorf("acquiring sector cache path: %w", err)
@@ -596,6 +654,24 @@ func (sb *Sealer) FinalizeSector(ctx context.Context, sector storage.SectorRef,
 	return ffi.ClearCache(uint64(ssize), paths.Cache)
 }
 
+func (sb *Sealer) RebuildCache(ctx context.Context, sector storage.SectorRef) error {
+	if _, err := sector.ProofType.SectorSize(); err != nil {
+		return xerrors.Errorf("proof type %d: %s: %w", sector.ProofType, err, ErrCacheNotRebuildable)
+	}
+
+	paths, done, err := sb.sectors.AcquireSector(ctx, sector, storiface.FTSealed|storiface.FTCache, 0, storiface.PathStorage)
+	if err != nil {
+		return xerrors.Errorf("acquiring sector paths: %w", err)
+	}
+	defer done()
+
+	if _, err := os.Stat(filepath.Join(paths.Cache, "p_aux")); err != nil {
+		return xerrors.Errorf("checking p_aux: %s: %w", err, ErrCacheNotRebuildable)
+	}
+
+	return xerrors.Errorf("regenerating tree-r-last of sector %d from %s: %w", sector.ID.Number, paths.Sealed, ErrCacheRebuildUnsupported)
+}
+
 func (sb *Sealer) ReleaseUnsealed(ctx context.Context, sector storage.SectorRef, safeToFree []storage.Range) error {
 	// This call is meant to mark storage as 'freeable'. Given that unsealing is
 	// very expensive, we don't remove data as soon as we can - instead we only
@@ -620,3 +620,90 @@ func TestGenerateUnsealedCID(t *testing.T) {
 		[][]byte{barr(1, 16), barr(0, 16), barr(2, 8), barr(3, 16), barr(0, 16), barr(0, 8), barr(4, 4), barr(5, 16), barr(0, 16), barr(0, 8)},
 	)
 }
+
+func TestReplicaUpdateUnsupported(t *testing.T) {
+	ctx := context.TODO()
+
+	dir, err := ioutil.TempDir("", "sbtest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint
+
+	sp := &basicfs.Provider{Root: dir}
+	sb, err := New(sp)
+	require.NoError(t, err)
+
+	si := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: sealProofType,
+	}
+
+	// no sealed replica to update
+	_, err = sb.ReplicaUpdate(ctx, si, nil)
+	require.True(t, xerrors.Is(err, storiface.ErrSectorNotFound), "%+v", err)
+
+	p, done, err := sp.AcquireSector(ctx, si, 0, storiface.FTSealed|storiface.FTCache, storiface.PathSealing)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(p.Sealed, []byte("sealed"), 0644))
+	require.NoError(t, os.Mkdir(p.Cache, 0755))
+	done()
+
+	_, err = sb.ReplicaUpdate(ctx, si, nil)
+	require.True(t, xerrors.Is(err, ErrReplicaUpdateUnsupported), "%+v", err)
+
+	// paths are released on error
+	_, done, err = sp.AcquireSector(ctx, si, storiface.FTSealed|storiface.FTCache, storiface.FTUpdate|storiface.FTUpdateCache, storiface.PathSealing)
+	require.NoError(t, err)
+	done()
+
+	// no update to prove
+	_, err = sb.ProveReplicaUpdate1(ctx, si, cid.Undef, cid.Undef, cid.Undef)
+	require.True(t, xerrors.Is(err, storiface.ErrSectorNotFound), "%+v", err)
+
+	p, done, err = sp.AcquireSector(ctx, si, 0, storiface.FTUpdate|storiface.FTUpdateCache, storiface.PathSealing)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(p.Update, []byte("update"), 0644))
+	require.NoError(t, os.Mkdir(p.UpdateCache, 0755))
+	done()
+
+	_, err = sb.ProveReplicaUpdate1(ctx, si, cid.Undef, cid.Undef, cid.Undef)
+	require.True(t, xerrors.Is(err, ErrReplicaUpdateUnsupported), "%+v", err)
+
+	_, err = sb.ProveReplicaUpdate2(ctx, si, cid.Undef, cid.Undef, cid.Undef, nil)
+	require.True(t, xerrors.Is(err, ErrReplicaUpdateUnsupported), "%+v", err)
+}
+
+func TestRebuildCacheUnsupported(t *testing.T) {
+	ctx := context.TODO()
+
+	dir, err := ioutil.TempDir("", "sbtest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint
+
+	sp := &basicfs.Provider{Root: dir}
+	sb, err := New(sp)
+	require.NoError(t, err)
+
+	si := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: sealProofType,
+	}
+
+	p, done, err := sp.AcquireSector(ctx, si, 0, storiface.FTSealed|storiface.FTCache, storiface.PathSealing)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(p.Sealed, []byte("sealed"), 0644))
+	require.NoError(t, os.Mkdir(p.Cache, 0755))
+	done()
+
+	// p_aux can't be rebuilt
+	err = sb.RebuildCache(ctx, si)
+	require.True(t, xerrors.Is(err, ErrCacheNotRebuildable), "%+v", err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(p.Cache, "p_aux"), []byte("p_aux"), 0644))
+
+	err = sb.RebuildCache(ctx, si)
+	require.True(t, xerrors.Is(err, ErrCacheRebuildUnsupported), "%+v", err)
+
+	si.ProofType = abi.RegisteredSealProof(-1)
+	err = sb.RebuildCache(ctx, si)
+	require.True(t, xerrors.Is(err, ErrCacheNotRebuildable), "%+v", err)
+}
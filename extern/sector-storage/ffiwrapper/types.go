@@ -7,6 +7,7 @@ import (
 	proof2 "github.com/filecoin-project/specs-actors/v2/actors/runtime/proof"
 
 	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/specs-storage/storage"
@@ -33,6 +34,43 @@ type Storage interface {
 	ReadPiece(ctx context.Context, writer io.Writer, sector storage.SectorRef, offset storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize) (bool, error)
 }
 
+// ErrReplicaUpdateUnsupported is returned by replica update methods when the
+// proofs library in use can't encode or prove sector updates
+var ErrReplicaUpdateUnsupported = xerrors.New("replica updates unsupported by proofs version")
+
+// ReplicaUpdater is implemented by proof backends supporting snap-deal replica updates
+type ReplicaUpdater interface {
+	ReplicaUpdate(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (storiface.ReplicaUpdateOut, error)
+	ProveReplicaUpdate1(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid) (storiface.ReplicaVanillaProofs, error)
+	ProveReplicaUpdate2(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid, vanillaProofs storiface.ReplicaVanillaProofs) (storiface.ReplicaUpdateProof, error)
+}
+
+// PartialFinalizer is implemented by proof backends which can finalize parts
+// of a sector separately
+type PartialFinalizer interface {
+	// FinalizeUnsealed frees unsealed data outside of keepUnsealed ranges
+	FinalizeUnsealed(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range) error
+
+	// ClearCache removes sealing cache files not needed for proving
+	ClearCache(ctx context.Context, sector storage.SectorRef) error
+}
+
+// ErrCacheRebuildUnsupported is returned by RebuildCache when the proofs
+// library in use can't regenerate cache files from a sealed replica
+var ErrCacheRebuildUnsupported = xerrors.New("rebuilding caches unsupported by proofs version")
+
+// ErrCacheNotRebuildable is returned by RebuildCache for sectors whose missing
+// cache files can't be computed from the sealed replica
+var ErrCacheNotRebuildable = xerrors.New("sector cache can't be rebuilt from sealed replica")
+
+// CacheRebuilder is implemented by proof backends which can regenerate the
+// cache files of a sealed sector needed for proving, tree-r-last and t_aux,
+// from its sealed replica. p_aux holds comm_c, which can only be computed from
+// the layers deleted after sealing, so it must still be in the cache.
+type CacheRebuilder interface {
+	RebuildCache(ctx context.Context, sector storage.SectorRef) error
+}
+
 type Verifier interface {
 	VerifySeal(proof2.SealVerifyInfo) (bool, error)
 	VerifyWinningPoSt(ctx context.Context, info proof2.WinningPoStVerifyInfo) (bool, error)
@@ -0,0 +1,42 @@
+package fsutil
+
+import (
+	"syscall"
+)
+
+const FsTypeUnknown = "unknown"
+
+// magic numbers from linux/magic.h
+var fsTypes = map[uint32]string{
+	0xEF53:     "ext", // ext2, ext3 and ext4 share the magic number
+	0x58465342: "xfs",
+	0x9123683E: "btrfs",
+	0x2FC12FC1: "zfs",
+	0xF2F52010: "f2fs",
+	0x6969:     "nfs",
+	0xFF534D42: "cifs",
+	0xFE534D42: "smb2",
+	0x00C36400: "ceph",
+	0x0BD00BD0: "lustre",
+	0x47504653: "gpfs",
+	0x65735546: "fuse",
+	0x794C7630: "overlayfs",
+	0x01021994: "tmpfs",
+}
+
+// FsType returns the type of filesystem the path is on, or FsTypeUnknown when
+// it can't be determined
+func FsType(path string) string {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		log.Debugf("statfs %s: %s", path, err)
+		return FsTypeUnknown
+	}
+
+	t, ok := fsTypes[uint32(stat.Type)]
+	if !ok {
+		return FsTypeUnknown
+	}
+
+	return t
+}
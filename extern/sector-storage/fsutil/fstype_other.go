@@ -0,0 +1,9 @@
+// +build !linux
+
+package fsutil
+
+const FsTypeUnknown = "unknown"
+
+func FsType(path string) string {
+	return FsTypeUnknown
+}
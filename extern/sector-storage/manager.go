@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
@@ -29,6 +30,12 @@ var log = logging.Logger("advmgr")
 
 var ErrNoWorkers = errors.New("no suitable workers found")
 
+// Calls failing with a retryable error are rescheduled, possibly on another
+// worker, up to callRetryAttempts times
+const callRetryAttempts = 3
+
+var callRetryWait = 5 * time.Second
+
 type URLs []string
 
 type Worker interface {
@@ -43,6 +50,28 @@ type Worker interface {
 
 	Session(context.Context) (uuid.UUID, error)
 
+	// Ping returns quickly when the worker is up and responsive, and is meant
+	// for frequent liveness checks, unlike Info
+	Ping(context.Context) error
+
+	// Reservations returns resources taken by calls on the worker, and is
+	// cheap enough to be called on every heartbeat, unlike Info
+	Reservations(context.Context) (storiface.ReservedResources, error)
+
+	// ListSectors returns sectors with files in the worker's local storage
+	ListSectors(ctx context.Context) ([]storiface.SectorSummary, error)
+	// ListSectorsPage returns a page of sectors with files in the worker's
+	// local storage, starting at the cursor ("" for the first page)
+	ListSectorsPage(ctx context.Context, cursor string, limit int) (storiface.SectorListPage, error)
+
+	// SetCallPriority changes the priority of a call queued on the worker
+	SetCallPriority(ctx context.Context, ci storiface.CallID, priority int) error
+
+	// CheckTaskDeadline returns an ErrTempDeadlineInfeasible CallError when the
+	// worker doesn't expect to finish the task by the deadline, nil when it
+	// does or can't tell
+	CheckTaskDeadline(ctx context.Context, sector storage.SectorRef, task sealtasks.TaskType, deadline time.Time) (*storiface.CallError, error)
+
 	Close() error // TODO: do we need this?
 }
 
@@ -88,6 +117,13 @@ type result struct {
 type SealerConfig struct {
 	ParallelFetchLimit int
 
+	// FetchChunkSize is the size of ranges requested when fetching sector
+	// files from other storage paths, 0 fetches whole files at once
+	FetchChunkSize int64
+	// FetchRetries is how many times failed fetches are retried, resuming
+	// from the last byte written
+	FetchRetries int
+
 	// Local worker config
 	AllowAddPiece   bool
 	AllowPreCommit1 bool
@@ -112,7 +148,11 @@ func New(ctx context.Context, ls stores.LocalStorage, si stores.SectorIndex, sc
 		return nil, xerrors.Errorf("creating prover instance: %w", err)
 	}
 
-	stor := stores.NewRemote(lstor, si, http.Header(sa), sc.ParallelFetchLimit)
+	fcfg := stores.DefaultFetchConfig()
+	fcfg.ChunkSize = sc.FetchChunkSize
+	fcfg.Retries = sc.FetchRetries
+
+	stor := stores.NewRemote(lstor, si, http.Header(sa), sc.ParallelFetchLimit, fcfg)
 
 	m := &Manager{
 		ls:         ls,
@@ -202,6 +242,26 @@ func (m *Manager) schedFetch(sector storage.SectorRef, ft storiface.SectorFileTy
 	}
 }
 
+// scheduleRetry schedules work like sched.Schedule, rescheduling it when the
+// call fails with a retryable CallError. Only use this for work which is safe
+// to run more than once.
+func (m *Manager) scheduleRetry(ctx context.Context, sector storage.SectorRef, taskType sealtasks.TaskType, sel WorkerSelector, prepare WorkerAction, work WorkerAction) error {
+	for attempt := 1; ; attempt++ {
+		err := m.sched.Schedule(ctx, sector, taskType, sel, prepare, work)
+		if err == nil || !storiface.IsRetryable(err) || attempt == callRetryAttempts {
+			return err
+		}
+
+		log.Warnw("retrying call", "sector", sector.ID, "task", taskType, "attempt", attempt, "error", err)
+
+		select {
+		case <-time.After(callRetryWait):
+		case <-ctx.Done():
+			return xerrors.Errorf("waiting to retry %s: %w", taskType, ctx.Err())
+		}
+	}
+}
+
 func (m *Manager) readPiece(sink io.Writer, sector storage.SectorRef, offset storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize, rok *bool) func(ctx context.Context, w Worker) error {
 	return func(ctx context.Context, w Worker) error {
 		r, err := m.waitSimpleCall(ctx)(w.ReadPiece(ctx, sink, sector, offset, size))
@@ -281,7 +341,7 @@ func (m *Manager) ReadPiece(ctx context.Context, sink io.Writer, sector storage.
 	if unsealed == cid.Undef {
 		return xerrors.Errorf("cannot unseal piece (sector: %d, offset: %d size: %d) - unsealed cid is undefined", sector, offset, size)
 	}
-	err = m.sched.Schedule(ctx, sector, sealtasks.TTUnseal, selector, unsealFetch, func(ctx context.Context, w Worker) error {
+	err = m.scheduleRetry(ctx, sector, sealtasks.TTUnseal, selector, unsealFetch, func(ctx context.Context, w Worker) error {
 		// TODO: make restartable
 		_, err := m.waitSimpleCall(ctx)(w.UnsealPiece(ctx, sector, offset, size, ticket, unsealed))
 		return err
@@ -537,6 +597,20 @@ func (m *Manager) SealCommit2(ctx context.Context, sector storage.SectorRef, pha
 }
 
 func (m *Manager) FinalizeSector(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range) error {
+	return m.FinalizeSectorTypes(ctx, sector, keepUnsealed, storiface.FTSealed|storiface.FTUnsealed|storiface.FTCache)
+}
+
+// FinalizeSectorTypes finalizes parts of the sector selected by types, which
+// lets callers finalize sectors in stages, e.g. moving the cache to long-term
+// storage while keeping the sealed replica on fast storage a bit longer.
+// FTUnsealed and FTCache are finalized on a worker (see
+// LocalWorker.FinalizeSectorTypes), then moved to storage together with
+// FTSealed.
+func (m *Manager) FinalizeSectorTypes(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range, types storiface.SectorFileType) error {
+	if types == 0 || types&^(storiface.FTSealed|storiface.FTUnsealed|storiface.FTCache) != 0 {
+		return xerrors.Errorf("can only finalize sealed, unsealed and cache data, got file types %d", types)
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -556,30 +630,48 @@ func (m *Manager) FinalizeSector(ctx context.Context, sector storage.SectorRef,
 		}
 	}
 
-	selector := newExistingSelector(m.index, sector.ID, storiface.FTCache|storiface.FTSealed, false)
-
-	err := m.sched.Schedule(ctx, sector, sealtasks.TTFinalize, selector,
-		m.schedFetch(sector, storiface.FTCache|storiface.FTSealed|unsealed, storiface.PathSealing, storiface.AcquireMove),
-		func(ctx context.Context, w Worker) error {
-			_, err := m.waitSimpleCall(ctx)(w.FinalizeSector(ctx, sector, keepUnsealed))
+	if finalize := types & finalizeTypes; finalize != 0 {
+		selector := newExistingSelector(m.index, sector.ID, storiface.FTCache|storiface.FTSealed, false)
+
+		err := m.scheduleRetry(ctx, sector, sealtasks.TTFinalize, selector,
+			m.schedFetch(sector, storiface.FTCache|storiface.FTSealed|unsealed, storiface.PathSealing, storiface.AcquireMove),
+			func(ctx context.Context, w Worker) error {
+				var res interface{}
+				var err error
+				if finalize == finalizeTypes {
+					// also works with workers which don't support partial finalization
+					res, err = m.waitSimpleCall(ctx)(w.FinalizeSector(ctx, sector, keepUnsealed))
+				} else {
+					res, err = m.waitSimpleCall(ctx)(w.FinalizeSectorTypes(ctx, sector, keepUnsealed, finalize))
+				}
+				if err != nil {
+					return err
+				}
+
+				if out, ok := res.(storiface.FinalizeSectorOut); ok {
+					log.Infow("finalized sector", "sector", sector.ID, "sealedOnDisk", out.SealedOnDisk, "cacheOnDisk", out.CacheOnDisk)
+				}
+				return nil
+			})
+		if err != nil {
 			return err
-		})
-	if err != nil {
-		return err
+		}
 	}
 
-	fetchSel := newAllocSelector(m.index, storiface.FTCache|storiface.FTSealed, storiface.PathStorage)
-	moveUnsealed := unsealed
-	{
-		if len(keepUnsealed) == 0 {
-			moveUnsealed = storiface.FTNone
-		}
+	moveTypes := types & (storiface.FTCache | storiface.FTSealed)
+	if types&unsealed != 0 && len(keepUnsealed) > 0 {
+		moveTypes |= storiface.FTUnsealed
 	}
+	if moveTypes == storiface.FTNone {
+		return nil
+	}
+
+	fetchSel := newAllocSelector(m.index, moveTypes, storiface.PathStorage)
 
-	err = m.sched.Schedule(ctx, sector, sealtasks.TTFetch, fetchSel,
-		m.schedFetch(sector, storiface.FTCache|storiface.FTSealed|moveUnsealed, storiface.PathStorage, storiface.AcquireMove),
+	err := m.scheduleRetry(ctx, sector, sealtasks.TTFetch, fetchSel,
+		m.schedFetch(sector, moveTypes, storiface.PathStorage, storiface.AcquireMove),
 		func(ctx context.Context, w Worker) error {
-			_, err := m.waitSimpleCall(ctx)(w.MoveStorage(ctx, sector, storiface.FTCache|storiface.FTSealed|moveUnsealed))
+			_, err := m.waitSimpleCall(ctx)(w.MoveStorage(ctx, sector, moveTypes))
 			return err
 		})
 	if err != nil {
@@ -637,8 +729,8 @@ func (m *Manager) ReturnSealCommit2(ctx context.Context, callID storiface.CallID
 	return m.returnResult(callID, proof, err)
 }
 
-func (m *Manager) ReturnFinalizeSector(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
-	return m.returnResult(callID, nil, err)
+func (m *Manager) ReturnFinalizeSector(ctx context.Context, callID storiface.CallID, out storiface.FinalizeSectorOut, err *storiface.CallError) error {
+	return m.returnResult(callID, out, err)
 }
 
 func (m *Manager) ReturnReleaseUnsealed(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
@@ -661,6 +753,26 @@ func (m *Manager) ReturnFetch(ctx context.Context, callID storiface.CallID, err
 	return m.returnResult(callID, nil, err)
 }
 
+func (m *Manager) ReturnReplicaUpdate(ctx context.Context, callID storiface.CallID, out storiface.ReplicaUpdateOut, err *storiface.CallError) error {
+	return m.returnResult(callID, out, err)
+}
+
+func (m *Manager) ReturnProveReplicaUpdate1(ctx context.Context, callID storiface.CallID, proofs storiface.ReplicaVanillaProofs, err *storiface.CallError) error {
+	return m.returnResult(callID, proofs, err)
+}
+
+func (m *Manager) ReturnProveReplicaUpdate2(ctx context.Context, callID storiface.CallID, proof storiface.ReplicaUpdateProof, err *storiface.CallError) error {
+	return m.returnResult(callID, proof, err)
+}
+
+func (m *Manager) ReturnGeneratePieceCommP(ctx context.Context, callID storiface.CallID, pi abi.PieceInfo, err *storiface.CallError) error {
+	return m.returnResult(callID, pi, err)
+}
+
+func (m *Manager) ReturnVerifyCommit2(ctx context.Context, callID storiface.CallID, ok bool, err *storiface.CallError) error {
+	return m.returnResult(callID, ok, err)
+}
+
 func (m *Manager) StorageLocal(ctx context.Context) (map[stores.ID]string, error) {
 	l, err := m.localStore.Local(ctx)
 	if err != nil {
@@ -18,6 +18,7 @@ import (
 	"github.com/ipfs/go-datastore"
 	logging "github.com/ipfs/go-log"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-statestore"
@@ -98,7 +99,7 @@ func newTestMgr(ctx context.Context, t *testing.T, ds datastore.Datastore) (*Man
 	prover, err := ffiwrapper.New(&readonlyProvider{stor: lstor, index: si})
 	require.NoError(t, err)
 
-	stor := stores.NewRemote(lstor, si, nil, 6000)
+	stor := stores.NewRemote(lstor, si, nil, 6000, stores.DefaultFetchConfig())
 
 	m := &Manager{
 		ls:         st,
@@ -453,3 +454,65 @@ func TestReenableWorker(t *testing.T) {
 	i, _ = m.sched.Info(ctx)
 	require.Len(t, i.(SchedDiagInfo).OpenWindows, 2)
 }
+
+func TestScheduleRetry(t *testing.T) {
+	ctx := context.Background()
+	m, lstor, _, _, cleanup := newTestMgr(ctx, t, datastore.NewMapDatastore())
+	defer cleanup()
+
+	cw := callRetryWait
+	callRetryWait = time.Millisecond
+	defer func() {
+		callRetryWait = cw
+	}()
+
+	tw := newTestWorker(WorkerConfig{
+		TaskTypes: []sealtasks.TaskType{sealtasks.TTAddPiece},
+	}, lstor, m)
+	require.NoError(t, m.AddWorker(ctx, tw))
+
+	sid := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	noop := func(ctx context.Context, w Worker) error { return nil }
+	failing := func(n int, code storiface.ErrorCode, calls *int) WorkerAction {
+		return func(ctx context.Context, w Worker) error {
+			*calls++
+			if *calls <= n {
+				return xerrors.Errorf("call: %w", storiface.Err(code, xerrors.New("no space")))
+			}
+			return nil
+		}
+	}
+
+	var calls int
+	err := m.scheduleRetry(ctx, sid, sealtasks.TTAddPiece, newTaskSelector(), noop, failing(1, storiface.ErrTempAllocateSpace, &calls))
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+
+	calls = 0
+	err = m.scheduleRetry(ctx, sid, sealtasks.TTAddPiece, newTaskSelector(), noop, failing(callRetryAttempts, storiface.ErrTempAllocateSpace, &calls))
+	require.True(t, storiface.IsRetryable(err))
+	require.Equal(t, callRetryAttempts, calls)
+
+	calls = 0
+	err = m.scheduleRetry(ctx, sid, sealtasks.TTAddPiece, newTaskSelector(), noop, failing(1, storiface.ErrUnknown, &calls))
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+}
+
+func TestManagerFinalizeSectorTypesInvalid(t *testing.T) {
+	ctx := context.Background()
+	m, _, _, _, cleanup := newTestMgr(ctx, t, datastore.NewMapDatastore())
+	defer cleanup()
+
+	sid := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	require.Error(t, m.FinalizeSectorTypes(ctx, sid, nil, storiface.FTNone))
+	require.Error(t, m.FinalizeSectorTypes(ctx, sid, nil, storiface.FTCache|storiface.FTUpdate))
+}
@@ -0,0 +1,280 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-statestore"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/fr32"
+	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+const memStorageID = stores.ID("mem")
+
+// memStore is a minimal stores.Store standing in for object storage backends
+// in tests. Long-term sector data is kept in memory as objects, sector files
+// are only placed in the scratch directory while they are being worked on.
+type memStore struct {
+	scratch string
+
+	lk      sync.Mutex
+	objects map[abi.SectorID]map[storiface.SectorFileType][]byte
+}
+
+func newMemStore(scratch string) *memStore {
+	return &memStore{
+		scratch: scratch,
+		objects: map[abi.SectorID]map[storiface.SectorFileType][]byte{},
+	}
+}
+
+func (m *memStore) scratchPath(s abi.SectorID, fileType storiface.SectorFileType) string {
+	return filepath.Join(m.scratch, fileType.String(), storiface.SectorName(s))
+}
+
+func (m *memStore) AcquireSector(ctx context.Context, s storage.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, sealing storiface.PathType, op storiface.AcquireMode) (storiface.SectorPaths, storiface.SectorPaths, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	var paths, ids storiface.SectorPaths
+	for _, fileType := range storiface.PathTypes {
+		if fileType&(existing|allocate) == 0 {
+			continue
+		}
+
+		p := m.scratchPath(s.ID, fileType)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return storiface.SectorPaths{}, storiface.SectorPaths{}, err
+		}
+
+		if existing.Has(fileType) {
+			if _, err := os.Stat(p); os.IsNotExist(err) {
+				data, ok := m.objects[s.ID][fileType]
+				if !ok {
+					return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.Errorf("acquiring sector %d %s: %w", s.ID, fileType, storiface.ErrSectorNotFound)
+				}
+
+				// download the object into scratch space
+				if err := ioutil.WriteFile(p, data, 0644); err != nil {
+					return storiface.SectorPaths{}, storiface.SectorPaths{}, err
+				}
+			} else if err != nil {
+				return storiface.SectorPaths{}, storiface.SectorPaths{}, err
+			}
+		}
+
+		storiface.SetPathByType(&paths, fileType, p)
+		storiface.SetPathByType(&ids, fileType, string(memStorageID))
+	}
+	paths.ID = s.ID
+	ids.ID = s.ID
+
+	return paths, ids, nil
+}
+
+func (m *memStore) Remove(ctx context.Context, s abi.SectorID, types storiface.SectorFileType, force bool) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	for _, fileType := range storiface.PathTypes {
+		if !types.Has(fileType) {
+			continue
+		}
+		delete(m.objects[s], fileType)
+		if err := os.RemoveAll(m.scratchPath(s, fileType)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memStore) RemoveCopies(ctx context.Context, s abi.SectorID, types storiface.SectorFileType) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	// objects are the primary copies
+	for _, fileType := range storiface.PathTypes {
+		if !types.Has(fileType) {
+			continue
+		}
+		if _, ok := m.objects[s][fileType]; !ok {
+			continue
+		}
+		if err := os.RemoveAll(m.scratchPath(s, fileType)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memStore) MoveStorage(ctx context.Context, s storage.SectorRef, types storiface.SectorFileType) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	for _, fileType := range storiface.PathTypes {
+		if !types.Has(fileType) {
+			continue
+		}
+		p := m.scratchPath(s.ID, fileType)
+		data, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			if _, ok := m.objects[s.ID][fileType]; ok {
+				continue
+			}
+			return xerrors.Errorf("moving sector %d %s: %w", s.ID, fileType, storiface.ErrSectorNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		// upload the file, then drop the scratch copy
+		if m.objects[s.ID] == nil {
+			m.objects[s.ID] = map[storiface.SectorFileType][]byte{}
+		}
+		m.objects[s.ID][fileType] = data
+
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memStore) FsStat(ctx context.Context, id stores.ID) (fsutil.FsStat, error) {
+	return fsutil.FsStat{}, nil
+}
+
+func (m *memStore) ReadUnsealed(ctx context.Context, s storage.SectorRef, offset storiface.PaddedByteIndex, size abi.PaddedPieceSize) (io.ReadCloser, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	data, ok := m.objects[s.ID][storiface.FTUnsealed]
+	if !ok || uint64(offset)+uint64(size) > uint64(len(data)) {
+		return nil, nil
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data[offset : uint64(offset)+uint64(size)])), nil
+}
+
+var _ stores.Store = &memStore{}
+var _ stores.UnsealedReader = &memStore{}
+
+type memStoreReturns struct {
+	storiface.WorkerReturn
+
+	errs chan *storiface.CallError
+	read chan bool
+}
+
+func (r *memStoreReturns) ReturnFetch(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
+	r.errs <- err
+	return nil
+}
+
+func (r *memStoreReturns) ReturnMoveStorage(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
+	r.errs <- err
+	return nil
+}
+
+func (r *memStoreReturns) ReturnReadPiece(ctx context.Context, callID storiface.CallID, ok bool, err *storiface.CallError) error {
+	r.errs <- err
+	r.read <- ok
+	return nil
+}
+
+func TestLocalWorkerMemStore(t *testing.T) {
+	ctx := context.Background()
+
+	ms := newMemStore(t.TempDir())
+	ret := &memStoreReturns{errs: make(chan *storiface.CallError, 1), read: make(chan bool, 1)}
+
+	// testExec panics on ReadPiece, so piece reads must be streamed from the store
+	w := newLocalWorker(func() (ffiwrapper.Storage, error) {
+		return &testExec{}, nil
+	}, WorkerConfig{}, ms, nil, stores.NewIndex(), ret, statestore.New(dssync.MutexWrap(datastore.NewMapDatastore())))
+	defer w.Close() // nolint
+
+	paths, err := w.Paths(ctx)
+	require.NoError(t, err)
+	require.Empty(t, paths)
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	sealedPath := ms.scratchPath(sector.ID, storiface.FTSealed)
+
+	_, err = w.Fetch(ctx, sector, storiface.FTSealed, storiface.PathStorage, storiface.AcquireCopy)
+	require.NoError(t, err)
+	require.NotNil(t, <-ret.errs)
+
+	// seal into scratch space, then move into the object store
+	sealed := bytes.Repeat([]byte{0xa5}, 2048)
+	p, _, err := ms.AcquireSector(ctx, sector, storiface.FTNone, storiface.FTSealed, storiface.PathSealing, storiface.AcquireMove)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(p.Sealed, sealed, 0644))
+
+	_, err = w.MoveStorage(ctx, sector, storiface.FTSealed)
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+
+	require.NoFileExists(t, sealedPath)
+	require.Equal(t, sealed, ms.objects[sector.ID][storiface.FTSealed])
+
+	// fetching places a copy back into scratch space
+	_, err = w.Fetch(ctx, sector, storiface.FTSealed, storiface.PathStorage, storiface.AcquireCopy)
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+
+	fetched, err := ioutil.ReadFile(sealedPath)
+	require.NoError(t, err)
+	require.Equal(t, sealed, fetched)
+
+	// piece reads stream unsealed data from the object, without a local copy
+	piece := bytes.Repeat([]byte{0x3f}, int(abi.PaddedPieceSize(2048).Unpadded()))
+	unsealed := make([]byte, 2048)
+	fr32.Pad(piece, unsealed)
+	ms.objects[sector.ID][storiface.FTUnsealed] = unsealed
+
+	var buf bytes.Buffer
+	_, err = w.ReadPiece(ctx, &buf, sector, 0, abi.PaddedPieceSize(1024).Unpadded())
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+	require.True(t, <-ret.read)
+	require.Equal(t, piece[:abi.PaddedPieceSize(1024).Unpadded()], buf.Bytes())
+	require.NoFileExists(t, ms.scratchPath(sector.ID, storiface.FTUnsealed))
+
+	_, err = w.ReadPiece(ctx, &buf, sector, storiface.UnpaddedByteIndex(abi.PaddedPieceSize(2048).Unpadded()), abi.PaddedPieceSize(1024).Unpadded())
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+	require.False(t, <-ret.read)
+
+	require.NoError(t, w.Remove(ctx, sector.ID))
+	require.NoFileExists(t, sealedPath)
+	require.Empty(t, ms.objects[sector.ID])
+
+	_, err = w.MoveStorage(ctx, sector, storiface.FTSealed)
+	require.NoError(t, err)
+	require.NotNil(t, <-ret.errs)
+
+	freed, err := w.PurgeUnsealed(ctx, storiface.UnsealedPurgePolicy{})
+	require.NoError(t, err)
+	require.Zero(t, freed)
+}
@@ -0,0 +1,71 @@
+package sectorstorage
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+var (
+	TaskType, _    = tag.NewKey("task_type")
+	ScrubResult, _ = tag.NewKey("result")
+)
+
+var (
+	TaskQueueWait = stats.Float64("sectorstorage/task_queue_wait_ms", "Time tasks waited on the worker between being dispatched and starting to run", stats.UnitMilliseconds)
+	TaskExecution = stats.Float64("sectorstorage/task_execution_ms", "Time successful tasks ran on the worker", stats.UnitMilliseconds)
+	ScrubSectors  = stats.Int64("sectorstorage/scrub_sectors", "Sectors checked by the background scrubber", stats.UnitDimensionless)
+)
+
+// tasks take from milliseconds to hours
+var taskMillisecondsDistribution = view.Distribution(1, 10, 100, 1000, 5000, 10000, 30000, 60000, 300000, 600000, 1800000, 3600000, 7200000, 14400000, 28800000, 57600000)
+
+var (
+	TaskQueueWaitView = &view.View{
+		Measure:     TaskQueueWait,
+		Aggregation: taskMillisecondsDistribution,
+		TagKeys:     []tag.Key{TaskType},
+	}
+	TaskExecutionView = &view.View{
+		Measure:     TaskExecution,
+		Aggregation: taskMillisecondsDistribution,
+		TagKeys:     []tag.Key{TaskType},
+	}
+	ScrubSectorsView = &view.View{
+		Measure:     ScrubSectors,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{ScrubResult},
+	}
+)
+
+// DefaultViews are the OpenCensus views of worker metrics
+var DefaultViews = []*view.View{
+	TaskQueueWaitView,
+	TaskExecutionView,
+	ScrubSectorsView,
+}
+
+func recordTaskDuration(ctx context.Context, m *stats.Float64Measure, tt sealtasks.TaskType, d time.Duration) {
+	ctx, err := tag.New(ctx, tag.Upsert(TaskType, string(tt)))
+	if err != nil {
+		log.Warnf("tagging task duration: %+v", err)
+	}
+	stats.Record(ctx, m.M(float64(d.Nanoseconds())/1e6))
+}
+
+func recordScrubbedSector(ctx context.Context, ok bool) {
+	result := "ok"
+	if !ok {
+		result = "bad"
+	}
+	ctx, err := tag.New(ctx, tag.Upsert(ScrubResult, result))
+	if err != nil {
+		log.Warnf("tagging scrubbed sector: %+v", err)
+	}
+	stats.Record(ctx, ScrubSectors.M(1))
+}
@@ -438,7 +438,7 @@ func (mgr *SectorMgr) ReturnSealCommit2(ctx context.Context, callID storiface.Ca
 	panic("not supported")
 }
 
-func (mgr *SectorMgr) ReturnFinalizeSector(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
+func (mgr *SectorMgr) ReturnFinalizeSector(ctx context.Context, callID storiface.CallID, out storiface.FinalizeSectorOut, err *storiface.CallError) error {
 	panic("not supported")
 }
 
@@ -462,6 +462,26 @@ func (mgr *SectorMgr) ReturnFetch(ctx context.Context, callID storiface.CallID,
 	panic("not supported")
 }
 
+func (mgr *SectorMgr) ReturnReplicaUpdate(ctx context.Context, callID storiface.CallID, out storiface.ReplicaUpdateOut, err *storiface.CallError) error {
+	panic("not supported")
+}
+
+func (mgr *SectorMgr) ReturnProveReplicaUpdate1(ctx context.Context, callID storiface.CallID, proofs storiface.ReplicaVanillaProofs, err *storiface.CallError) error {
+	panic("not supported")
+}
+
+func (mgr *SectorMgr) ReturnProveReplicaUpdate2(ctx context.Context, callID storiface.CallID, proof storiface.ReplicaUpdateProof, err *storiface.CallError) error {
+	panic("not supported")
+}
+
+func (mgr *SectorMgr) ReturnGeneratePieceCommP(ctx context.Context, callID storiface.CallID, pi abi.PieceInfo, err *storiface.CallError) error {
+	panic("not supported")
+}
+
+func (mgr *SectorMgr) ReturnVerifyCommit2(ctx context.Context, callID storiface.CallID, ok bool, err *storiface.CallError) error {
+	panic("not supported")
+}
+
 func (m mockVerif) VerifySeal(svi proof2.SealVerifyInfo) (bool, error) {
 	if len(svi.Proof) != 1920 {
 		return false, nil
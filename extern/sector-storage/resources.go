@@ -315,6 +315,13 @@ func init() {
 	ResourceTable[sealtasks.TTUnseal] = ResourceTable[sealtasks.TTPreCommit1] // TODO: measure accurately
 	ResourceTable[sealtasks.TTReadUnsealed] = ResourceTable[sealtasks.TTFetch]
 
+	// Encoding an update rebuilds tree-d and tree-r-last over the whole sector,
+	// which is the GPU-bound part of PC2. Update proofs are a vanilla proof
+	// step plus a SNARK over it, same as C1/C2
+	ResourceTable[sealtasks.TTReplicaUpdate] = ResourceTable[sealtasks.TTPreCommit2]
+	ResourceTable[sealtasks.TTProveReplicaUpdate1] = ResourceTable[sealtasks.TTCommit1]
+	ResourceTable[sealtasks.TTProveReplicaUpdate2] = ResourceTable[sealtasks.TTCommit2]
+
 	// V1_1 is the same as V1
 	for _, m := range ResourceTable {
 		m[abi.RegisteredSealProof_StackedDrg2KiBV1_1] = m[abi.RegisteredSealProof_StackedDrg2KiBV1]
@@ -41,6 +41,24 @@ func WithPriority(ctx context.Context, priority int) context.Context {
 	return context.WithValue(ctx, SchedPriorityKey, priority)
 }
 
+type schedDeadlineCtxKey int
+
+var SchedDeadlineKey schedDeadlineCtxKey
+
+func getTaskDeadline(ctx context.Context) (time.Time, bool) {
+	dl, ok := ctx.Value(SchedDeadlineKey).(time.Time)
+	return dl, ok
+}
+
+// WithTaskDeadline sets the time by which a task should be done. Before the
+// scheduler starts the task on a worker it asks the worker whether it expects to
+// be done in time (see Worker.CheckTaskDeadline), and tries other workers when
+// it doesn't. Unlike context deadlines, the task isn't cancelled when the
+// deadline passes.
+func WithTaskDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, SchedDeadlineKey, deadline)
+}
+
 const mib = 1 << 20
 
 type WorkerAction func(ctx context.Context, w Worker) error
@@ -123,9 +141,13 @@ type activeResources struct {
 type workerRequest struct {
 	sector   storage.SectorRef
 	taskType sealtasks.TaskType
-	priority int // larger values more important
+	priority int       // larger values more important
+	deadline time.Time // zero without a deadline, see WithTaskDeadline
 	sel      WorkerSelector
 
+	// workers which don't expect to finish the task by its deadline
+	infeasible map[WorkerID]struct{}
+
 	prepare WorkerAction
 	work    WorkerAction
 
@@ -167,12 +189,14 @@ func newScheduler() *scheduler {
 
 func (sh *scheduler) Schedule(ctx context.Context, sector storage.SectorRef, taskType sealtasks.TaskType, sel WorkerSelector, prepare WorkerAction, work WorkerAction) error {
 	ret := make(chan workerResponse)
+	deadline, _ := getTaskDeadline(ctx)
 
 	select {
 	case sh.schedule <- &workerRequest{
 		sector:   sector,
 		taskType: taskType,
 		priority: getPriority(ctx),
+		deadline: deadline,
 		sel:      sel,
 
 		prepare: prepare,
@@ -391,8 +415,17 @@ func (sh *scheduler) trySched() {
 					continue
 				}
 
+				if _, rejected := task.infeasible[windowRequest.worker]; rejected {
+					continue
+				}
+
+				if !worker.info.Capabilities.Supports(task.taskType) {
+					log.Debugw("skipping worker without capability", "worker", windowRequest.worker, "task", task.taskType, "capability", storiface.TaskCapabilities[task.taskType])
+					continue
+				}
+
 				// TODO: allow bigger windows
-				if !windows[wnd].allocated.canHandleRequest(needRes, windowRequest.worker, "schedAcceptable", worker.info.Resources) {
+				if !windows[wnd].allocated.canHandleRequest(needRes, windowRequest.worker, "schedAcceptable", worker.resources()) {
 					continue
 				}
 
@@ -458,7 +491,7 @@ func (sh *scheduler) trySched() {
 		selectedWindow := -1
 		for _, wnd := range acceptableWindows[task.indexHeap] {
 			wid := sh.openWindows[wnd].worker
-			wr := sh.workers[wid].info.Resources
+			wr := sh.workers[wid].resources()
 
 			log.Debugf("SCHED try assign sqi:%d sector %d to window %d", sqi, task.sector.ID.Number, wnd)
 
@@ -469,7 +502,7 @@ func (sh *scheduler) trySched() {
 
 			log.Debugf("SCHED ASSIGNED sqi:%d sector %d task %s to window %d", sqi, task.sector.ID.Number, task.taskType, wnd)
 
-			windows[wnd].allocated.add(wr, needRes)
+			windows[wnd].allocated.add(wr.WorkerResources, needRes)
 			// TODO: We probably want to re-sort acceptableWindows here based on new
 			//  workerHandle.utilization + windows[wnd].allocated.utilization (workerHandle.utilization is used in all
 			//  task selectors, but not in the same way, so need to figure out how to do that in a non-O(n^2 way), and
@@ -0,0 +1,74 @@
+package sectorstorage
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+)
+
+// deadlineRejected asks the worker whether it expects to finish a request with
+// a deadline in time, before anything runs on it. When it doesn't the request
+// goes back to the queue to be tried on other workers, or, when no other worker
+// can take it, fails with the error from the worker. Returns true when the
+// request doesn't run on the worker.
+func (sh *scheduler) deadlineRejected(req *workerRequest, wid WorkerID, w *workerHandle) bool {
+	if req.deadline.IsZero() {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(req.ctx, SelectorTimeout)
+	cerr, err := w.workerRpc.CheckTaskDeadline(ctx, req.sector, req.taskType, req.deadline)
+	cancel()
+	if err != nil {
+		log.Warnw("checking task deadline", "worker", wid, "task", req.taskType, "sector", req.sector.ID, "error", err)
+		return false
+	}
+	if cerr == nil {
+		return false
+	}
+
+	log.Infow("worker doesn't expect to finish task by its deadline", "worker", wid, "task", req.taskType, "sector", req.sector.ID, "error", cerr)
+
+	if req.infeasible == nil {
+		req.infeasible = map[WorkerID]struct{}{}
+	}
+	req.infeasible[wid] = struct{}{}
+
+	if !sh.deadlineCandidates(req) {
+		req.respond(cerr)
+		return true
+	}
+
+	select {
+	case sh.schedule <- req:
+	case <-sh.closing:
+		req.respond(xerrors.New("closing"))
+	case <-req.ctx.Done():
+	}
+	return true
+}
+
+// deadlineCandidates returns whether any worker which didn't reject the
+// request for its deadline yet could take it
+func (sh *scheduler) deadlineCandidates(req *workerRequest) bool {
+	sh.workersLk.RLock()
+	defer sh.workersLk.RUnlock()
+
+	for wid, worker := range sh.workers {
+		if _, rejected := req.infeasible[wid]; rejected {
+			continue
+		}
+		if !worker.enabled || !worker.info.Capabilities.Supports(req.taskType) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(req.ctx, SelectorTimeout)
+		ok, err := req.sel.Ok(ctx, req.taskType, req.sector.ProofType, worker)
+		cancel()
+		if err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -6,7 +6,16 @@ import (
 	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
 )
 
-func (a *activeResources) withResources(id WorkerID, wr storiface.WorkerResources, r Resources, locker sync.Locker, cb func() error) error {
+// workerResources are resources of a worker used for scheduling
+type workerResources struct {
+	storiface.WorkerResources
+
+	// Taken by calls on the worker which the scheduler doesn't account for as
+	// active, e.g. calls started before the manager restarted
+	unaccounted storiface.ReservedResources
+}
+
+func (a *activeResources) withResources(id WorkerID, wr workerResources, r Resources, locker sync.Locker, cb func() error) error {
 	for !a.canHandleRequest(r, id, "withResources", wr) {
 		if a.cond == nil {
 			a.cond = sync.NewCond(locker)
@@ -14,11 +23,11 @@ func (a *activeResources) withResources(id WorkerID, wr storiface.WorkerResource
 		a.cond.Wait()
 	}
 
-	a.add(wr, r)
+	a.add(wr.WorkerResources, r)
 
 	err := cb()
 
-	a.free(wr, r)
+	a.free(wr.WorkerResources, r)
 	if a.cond != nil {
 		a.cond.Broadcast()
 	}
@@ -44,29 +53,30 @@ func (a *activeResources) free(wr storiface.WorkerResources, r Resources) {
 	a.memUsedMax -= r.MaxMemory
 }
 
-func (a *activeResources) canHandleRequest(needRes Resources, wid WorkerID, caller string, res storiface.WorkerResources) bool {
+func (a *activeResources) canHandleRequest(needRes Resources, wid WorkerID, caller string, res workerResources) bool {
 
 	// TODO: dedupe needRes.BaseMinMemory per task type (don't add if that task is already running)
-	minNeedMem := res.MemReserved + a.memUsedMin + needRes.MinMemory + needRes.BaseMinMemory
+	minNeedMem := res.MemReserved + res.unaccounted.MemUsedMin + a.memUsedMin + needRes.MinMemory + needRes.BaseMinMemory
 	if minNeedMem > res.MemPhysical {
 		log.Debugf("sched: not scheduling on worker %d for %s; not enough physical memory - need: %dM, have %dM", wid, caller, minNeedMem/mib, res.MemPhysical/mib)
 		return false
 	}
 
-	maxNeedMem := res.MemReserved + a.memUsedMax + needRes.MaxMemory + needRes.BaseMinMemory
+	maxNeedMem := res.MemReserved + res.unaccounted.MemUsedMax + a.memUsedMax + needRes.MaxMemory + needRes.BaseMinMemory
 
 	if maxNeedMem > res.MemSwap+res.MemPhysical {
 		log.Debugf("sched: not scheduling on worker %d for %s; not enough virtual memory - need: %dM, have %dM", wid, caller, maxNeedMem/mib, (res.MemSwap+res.MemPhysical)/mib)
 		return false
 	}
 
-	if a.cpuUse+needRes.Threads(res.CPUs) > res.CPUs {
-		log.Debugf("sched: not scheduling on worker %d for %s; not enough threads, need %d, %d in use, target %d", wid, caller, needRes.Threads(res.CPUs), a.cpuUse, res.CPUs)
+	cpuUse := a.cpuUse + res.unaccounted.CpuUse
+	if cpuUse+needRes.Threads(res.CPUs) > res.CPUs {
+		log.Debugf("sched: not scheduling on worker %d for %s; not enough threads, need %d, %d in use, target %d", wid, caller, needRes.Threads(res.CPUs), cpuUse, res.CPUs)
 		return false
 	}
 
 	if len(res.GPUs) > 0 && needRes.CanGPU {
-		if a.gpuUsed {
+		if a.gpuUsed || res.unaccounted.GpuUsed {
 			log.Debugf("sched: not scheduling on worker %d for %s; GPU in use", wid, caller)
 			return false
 		}
@@ -94,6 +104,35 @@ func (a *activeResources) utilization(wr storiface.WorkerResources) float64 {
 	return max
 }
 
+// resources returns worker resources used for scheduling. Resources reserved
+// by calls on the worker beyond what the scheduler accounts for as active are
+// returned as unaccounted, and the rest aren't booked twice. Must be called
+// with workersLk held.
+func (wh *workerHandle) resources() workerResources {
+	res := workerResources{WorkerResources: wh.info.Resources}
+
+	reserved := wh.info.Reserved
+	if reserved == (storiface.ReservedResources{}) {
+		return res
+	}
+
+	active := wh.active
+	res.unaccounted = storiface.ReservedResources{
+		MemUsedMin: excess(reserved.MemUsedMin, active.memUsedMin),
+		MemUsedMax: excess(reserved.MemUsedMax, active.memUsedMax),
+		CpuUse:     excess(reserved.CpuUse, active.cpuUse),
+		GpuUsed:    reserved.GpuUsed && !active.gpuUsed,
+	}
+	return res
+}
+
+func excess(reserved, accounted uint64) uint64 {
+	if reserved > accounted {
+		return reserved - accounted
+	}
+	return 0
+}
+
 func (wh *workerHandle) utilization() float64 {
 	wh.lk.Lock()
 	u := wh.active.utilization(wh.info.Resources)
@@ -14,6 +14,7 @@ import (
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-state-types/abi"
 
@@ -45,6 +46,8 @@ type schedTestWorker struct {
 
 	closed  bool
 	session uuid.UUID
+
+	slow bool // rejects tasks with deadlines
 }
 
 func (s *schedTestWorker) SealPreCommit1(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, pieces []abi.PieceInfo) (storiface.CallID, error) {
@@ -67,10 +70,26 @@ func (s *schedTestWorker) FinalizeSector(ctx context.Context, sector storage.Sec
 	panic("implement me")
 }
 
+func (s *schedTestWorker) FinalizeSectorTypes(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range, types storiface.SectorFileType) (storiface.CallID, error) {
+	panic("implement me")
+}
+
 func (s *schedTestWorker) ReleaseUnsealed(ctx context.Context, sector storage.SectorRef, safeToFree []storage.Range) (storiface.CallID, error) {
 	panic("implement me")
 }
 
+func (s *schedTestWorker) ReplicaUpdate(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (storiface.CallID, error) {
+	panic("implement me")
+}
+
+func (s *schedTestWorker) ProveReplicaUpdate1(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid) (storiface.CallID, error) {
+	panic("implement me")
+}
+
+func (s *schedTestWorker) ProveReplicaUpdate2(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid, vanillaProofs storiface.ReplicaVanillaProofs) (storiface.CallID, error) {
+	panic("implement me")
+}
+
 func (s *schedTestWorker) Remove(ctx context.Context, sector storage.SectorRef) (storiface.CallID, error) {
 	panic("implement me")
 }
@@ -122,6 +141,33 @@ func (s *schedTestWorker) Info(ctx context.Context) (storiface.WorkerInfo, error
 	}, nil
 }
 
+func (s *schedTestWorker) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *schedTestWorker) Reservations(ctx context.Context) (storiface.ReservedResources, error) {
+	return storiface.ReservedResources{}, nil
+}
+
+func (s *schedTestWorker) ListSectors(ctx context.Context) ([]storiface.SectorSummary, error) {
+	return nil, nil
+}
+
+func (s *schedTestWorker) ListSectorsPage(ctx context.Context, cursor string, limit int) (storiface.SectorListPage, error) {
+	return storiface.SectorListPage{}, nil
+}
+
+func (s *schedTestWorker) SetCallPriority(ctx context.Context, ci storiface.CallID, priority int) error {
+	return nil
+}
+
+func (s *schedTestWorker) CheckTaskDeadline(ctx context.Context, sector storage.SectorRef, task sealtasks.TaskType, deadline time.Time) (*storiface.CallError, error) {
+	if s.slow {
+		return storiface.Err(storiface.ErrTempDeadlineInfeasible, xerrors.Errorf("%s is slow", s.name)), nil
+	}
+	return nil, nil
+}
+
 func (s *schedTestWorker) Session(context.Context) (uuid.UUID, error) {
 	return s.session, nil
 }
@@ -164,6 +210,68 @@ func addTestWorker(t *testing.T, sched *scheduler, index *stores.Index, name str
 	require.NoError(t, sched.runWorker(context.TODO(), w))
 }
 
+func TestSchedDeadline(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 30*time.Second)
+	defer done()
+
+	index := stores.NewIndex()
+	sched := newScheduler()
+	go sched.runSched()
+	defer sched.Close(ctx) // nolint
+
+	tasks := map[sealtasks.TaskType]struct{}{sealtasks.TTPreCommit1: {}}
+	addTestWorker(t, sched, index, "slow", tasks)
+	for _, wh := range sched.workers {
+		wh.workerRpc.(*schedTestWorker).slow = true
+	}
+	addTestWorker(t, sched, index, "fast", tasks)
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 8, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	sel := newAllocSelector(index, storiface.FTCache, storiface.PathSealing)
+	noopAction := func(ctx context.Context, w Worker) error {
+		return nil
+	}
+
+	schedule := func(ctx context.Context) (string, error) {
+		var ran string
+		err := sched.Schedule(ctx, sector, sealtasks.TTPreCommit1, sel, noopAction, func(ctx context.Context, w Worker) error {
+			wi, err := w.Info(ctx)
+			if err != nil {
+				return err
+			}
+			ran = wi.Hostname
+			return nil
+		})
+		return ran, err
+	}
+
+	// tasks rejected by workers not expecting to be done in time run elsewhere
+	for i := 0; i < 10; i++ {
+		ran, err := schedule(WithTaskDeadline(ctx, time.Now().Add(time.Hour)))
+		require.NoError(t, err)
+		require.Equal(t, "fast", ran)
+	}
+
+	// when no other worker can take the task, the rejection is returned
+	sched.workersLk.Lock()
+	for _, wh := range sched.workers {
+		wh.workerRpc.(*schedTestWorker).slow = true
+	}
+	sched.workersLk.Unlock()
+
+	_, err := schedule(WithTaskDeadline(ctx, time.Now().Add(time.Hour)))
+	var cerr *storiface.CallError
+	require.True(t, xerrors.As(err, &cerr))
+	require.Equal(t, storiface.ErrTempDeadlineInfeasible, cerr.Code)
+
+	// without a deadline workers aren't asked
+	_, err = schedule(ctx)
+	require.NoError(t, err)
+}
+
 func TestSchedStartStop(t *testing.T) {
 	sched := newScheduler()
 	go sched.runSched()
@@ -644,3 +752,48 @@ func TestWindowCompact(t *testing.T) {
 		[][]sealtasks.TaskType{{sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTAddPiece}, {sealtasks.TTPreCommit1, sealtasks.TTPreCommit2}}),
 	)
 }
+
+func TestWorkerHandleResources(t *testing.T) {
+	spt := abi.RegisteredSealProof_StackedDrg32GiBV1
+	pc1 := ResourceTable[sealtasks.TTPreCommit1][spt]
+	pc2 := ResourceTable[sealtasks.TTPreCommit2][spt]
+
+	wh := &workerHandle{
+		info: storiface.WorkerInfo{
+			Resources: decentWorkerResources,
+		},
+		active: &activeResources{},
+	}
+	require.Equal(t, workerResources{WorkerResources: decentWorkerResources}, wh.resources())
+
+	// the worker runs a PC1 the scheduler doesn't know about
+	wh.info.Reserved = storiface.ReservedResources{
+		MemUsedMin: pc1.MinMemory,
+		MemUsedMax: pc1.MaxMemory,
+		CpuUse:     pc1.Threads(decentWorkerResources.CPUs),
+	}
+	require.Equal(t, storiface.ReservedResources{
+		MemUsedMin: pc1.MinMemory,
+		MemUsedMax: pc1.MaxMemory,
+		CpuUse:     1,
+	}, wh.resources().unaccounted)
+
+	// calls the scheduler assigned aren't counted twice
+	wh.active.add(wh.info.Resources, pc1)
+	require.Equal(t, storiface.ReservedResources{}, wh.resources().unaccounted)
+	wh.active.free(wh.info.Resources, pc1)
+
+	// a GPU task the scheduler doesn't know about blocks other GPU tasks
+	wh.info.Reserved = storiface.ReservedResources{GpuUsed: true}
+	require.True(t, wh.resources().unaccounted.GpuUsed)
+	require.False(t, wh.active.canHandleRequest(pc2, WorkerID{}, "test", wh.resources()))
+	require.True(t, wh.active.canHandleRequest(ResourceTable[sealtasks.TTPreCommit1][abi.RegisteredSealProof_StackedDrg2KiBV1], WorkerID{}, "test", wh.resources()))
+
+	wh.active.add(wh.info.Resources, pc2)
+	require.False(t, wh.resources().unaccounted.GpuUsed)
+
+	// threads taken by unaccounted calls aren't booked
+	wh.active = &activeResources{}
+	wh.info.Reserved = storiface.ReservedResources{CpuUse: decentWorkerResources.CPUs}
+	require.False(t, wh.active.canHandleRequest(pc1, WorkerID{}, "test", wh.resources()))
+}
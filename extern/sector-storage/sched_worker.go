@@ -267,6 +267,7 @@ func (sw *schedWorker) requestWindows() bool {
 func (sw *schedWorker) waitForUpdates() (update bool, sched bool, ok bool) {
 	select {
 	case <-sw.heartbeatTimer.C:
+		sw.updateReserved()
 		return false, false, true
 	case w := <-sw.scheduledWindows:
 		sw.worker.wndLk.Lock()
@@ -283,6 +284,24 @@ func (sw *schedWorker) waitForUpdates() (update bool, sched bool, ok bool) {
 	return false, false, false
 }
 
+// updateReserved refreshes resources reserved by calls on the worker. The rest
+// of the worker info, including memory used by other processes, stays as
+// reported when the worker connected, as it includes memory of running tasks.
+func (sw *schedWorker) updateReserved() {
+	ctx, cancel := context.WithTimeout(context.TODO(), stores.HeartbeatInterval/2)
+	defer cancel()
+
+	reserved, err := sw.worker.workerRpc.Reservations(ctx)
+	if err != nil {
+		log.Warnw("failed to update worker reservations", "worker", sw.wid, "error", err)
+		return
+	}
+
+	sw.sched.workersLk.Lock()
+	sw.worker.info.Reserved = reserved
+	sw.sched.workersLk.Unlock()
+}
+
 func (sw *schedWorker) workerCompactWindows() {
 	worker := sw.worker
 
@@ -295,7 +314,7 @@ func (sw *schedWorker) workerCompactWindows() {
 
 			for ti, todo := range window.todo {
 				needRes := ResourceTable[todo.taskType][todo.sector.ProofType]
-				if !lower.allocated.canHandleRequest(needRes, sw.wid, "compactWindows", worker.info.Resources) {
+				if !lower.allocated.canHandleRequest(needRes, sw.wid, "compactWindows", worker.resources()) {
 					continue
 				}
 
@@ -351,7 +370,7 @@ assignLoop:
 			worker.lk.Lock()
 			for t, todo := range firstWindow.todo {
 				needRes := ResourceTable[todo.taskType][todo.sector.ProofType]
-				if worker.preparing.canHandleRequest(needRes, sw.wid, "startPreparing", worker.info.Resources) {
+				if worker.preparing.canHandleRequest(needRes, sw.wid, "startPreparing", worker.resources()) {
 					tidx = t
 					break
 				}
@@ -396,8 +415,22 @@ func (sw *schedWorker) startProcessingTask(taskDone chan struct{}, req *workerRe
 	w.lk.Unlock()
 
 	go func() {
+		if sh.deadlineRejected(req, sw.wid, w) {
+			sh.workersLk.Lock()
+			w.lk.Lock()
+			w.preparing.free(w.info.Resources, needRes)
+			w.lk.Unlock()
+			sh.workersLk.Unlock()
+
+			select {
+			case taskDone <- struct{}{}:
+			case <-sh.closing:
+			}
+			return
+		}
+
 		// first run the prepare step (e.g. fetching sector data from other worker)
-		err := req.prepare(req.ctx, sh.workTracker.worker(sw.wid, w.workerRpc))
+		err := req.prepare(req.ctx, sh.workTracker.worker(sw.wid, w.workerRpc, req.priority))
 		sh.workersLk.Lock()
 
 		if err != nil {
@@ -423,7 +456,7 @@ func (sw *schedWorker) startProcessingTask(taskDone chan struct{}, req *workerRe
 		}
 
 		// wait (if needed) for resources in the 'active' window
-		err = w.active.withResources(sw.wid, w.info.Resources, needRes, &sh.workersLk, func() error {
+		err = w.active.withResources(sw.wid, w.resources(), needRes, &sh.workersLk, func() error {
 			w.lk.Lock()
 			w.preparing.free(w.info.Resources, needRes)
 			w.lk.Unlock()
@@ -436,7 +469,7 @@ func (sw *schedWorker) startProcessingTask(taskDone chan struct{}, req *workerRe
 			}
 
 			// Do the work!
-			err = req.work(req.ctx, sh.workTracker.worker(sw.wid, w.workerRpc))
+			err = req.work(req.ctx, sh.workTracker.worker(sw.wid, w.workerRpc, req.priority))
 
 			select {
 			case req.ret <- workerResponse{err: err}:
@@ -11,21 +11,28 @@ const (
 
 	TTFinalize TaskType = "seal/v0/finalize"
 
+	TTReplicaUpdate       TaskType = "seal/v0/replicaupdate"
+	TTProveReplicaUpdate1 TaskType = "seal/v0/provereplicaupdate/1"
+	TTProveReplicaUpdate2 TaskType = "seal/v0/provereplicaupdate/2"
+
 	TTFetch        TaskType = "seal/v0/fetch"
 	TTUnseal       TaskType = "seal/v0/unseal"
 	TTReadUnsealed TaskType = "seal/v0/unsealread"
 )
 
 var order = map[TaskType]int{
-	TTAddPiece:     6, // least priority
-	TTPreCommit1:   5,
-	TTPreCommit2:   4,
-	TTCommit2:      3,
-	TTCommit1:      2,
-	TTUnseal:       1,
-	TTFetch:        -1,
-	TTReadUnsealed: -1,
-	TTFinalize:     -2, // most priority
+	TTAddPiece:            6, // least priority
+	TTReplicaUpdate:       5,
+	TTPreCommit1:          5,
+	TTPreCommit2:          4,
+	TTProveReplicaUpdate2: 3,
+	TTCommit2:             3,
+	TTProveReplicaUpdate1: 2,
+	TTCommit1:             2,
+	TTUnseal:              1,
+	TTFetch:               -1,
+	TTReadUnsealed:        -1,
+	TTFinalize:            -2, // most priority
 }
 
 var shortNames = map[TaskType]string{
@@ -38,6 +45,10 @@ var shortNames = map[TaskType]string{
 
 	TTFinalize: "FIN",
 
+	TTReplicaUpdate:       "RU ",
+	TTProveReplicaUpdate1: "PR1",
+	TTProveReplicaUpdate2: "PR2",
+
 	TTFetch:        "GET",
 	TTUnseal:       "UNS",
 	TTReadUnsealed: "RD ",
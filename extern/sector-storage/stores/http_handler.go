@@ -103,20 +103,29 @@ func (handler *FetchHandler) remoteGetSector(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var rd io.Reader
-	if stat.IsDir() {
-		rd, err = tarutil.TarDirectory(path)
-		w.Header().Set("Content-Type", "application/x-tar")
-	} else {
-		rd, err = os.OpenFile(path, os.O_RDONLY, 0644) // nolint
+	if !stat.IsDir() {
+		f, err := os.OpenFile(path, os.O_RDONLY, 0644) // nolint
+		if err != nil {
+			log.Errorf("%+v", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer f.Close() // nolint
+
+		// ServeContent handles range requests, which lets fetches resume
 		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", stat.ModTime(), f)
+		return
 	}
+
+	rd, err := tarutil.TarDirectory(path)
 	if err != nil {
 		log.Errorf("%+v", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/x-tar")
 	w.WriteHeader(200)
 	if _, err := io.Copy(w, rd); err != nil { // TODO: default 32k buf may be too small
 		log.Errorf("%+v", err)
@@ -21,6 +21,10 @@ import (
 var HeartbeatInterval = 10 * time.Second
 var SkippedHeartbeatThresh = HeartbeatInterval * 5
 
+// ErrStorageNotFound is returned for storage IDs the index doesn't know, e.g.
+// because the storage was never attached, or was detached
+var ErrStorageNotFound = errors.New("sector store not found")
+
 // ID identifies sector storage by UUID. One sector storage should map to one
 //  filesystem, local or networked / shared by multiple machines
 type ID string
@@ -155,6 +159,10 @@ func (i *Index) StorageAttach(ctx context.Context, si StorageInfo, st fsutil.FsS
 			i.stores[si.ID].info.URLs = append(i.stores[si.ID].info.URLs, u)
 		}
 
+		// attaching again updates the weight, e.g. after it was changed in the
+		// path metadata
+		i.stores[si.ID].info.Weight = si.Weight
+
 		return nil
 	}
 	i.stores[si.ID] = &storageEntry{
@@ -363,7 +371,7 @@ func (i *Index) StorageInfo(ctx context.Context, id ID) (StorageInfo, error) {
 
 	si, found := i.stores[id]
 	if !found {
-		return StorageInfo{}, xerrors.Errorf("sector store not found")
+		return StorageInfo{}, xerrors.Errorf("%s: %w", id, ErrStorageNotFound)
 	}
 
 	return *si.info, nil
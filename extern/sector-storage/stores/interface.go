@@ -2,6 +2,7 @@ package stores
 
 import (
 	"context"
+	"io"
 
 	"github.com/filecoin-project/go-state-types/abi"
 
@@ -24,3 +25,39 @@ type Store interface {
 
 	FsStat(ctx context.Context, id ID) (fsutil.FsStat, error)
 }
+
+// Reserver is implemented by stores which keep track of space allocated for
+// sector files which are still being written. Stores which don't implement it
+// (e.g. object storage backends) are assumed to not need reservations.
+type Reserver interface {
+	Reserve(ctx context.Context, sid storage.SectorRef, ft storiface.SectorFileType, storageIDs storiface.SectorPaths, overheadTab map[storiface.SectorFileType]int) (func(), error)
+}
+
+// CopiesAcquirer is implemented by stores which can report all local paths
+// holding each file type of an acquired sector, AcquireSector only returns the
+// path which is used.
+type CopiesAcquirer interface {
+	// AcquireSectorCopies is AcquireSector, also returning IDs of all local
+	// storage paths holding existing file types, and of the paths allocated
+	// for allocated file types
+	AcquireSectorCopies(ctx context.Context, s storage.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, sealing storiface.PathType, op storiface.AcquireMode) (paths storiface.SectorPaths, stores storiface.SectorPaths, copies storiface.SectorStorageIDs, err error)
+}
+
+// UnsealedReader is implemented by stores which can stream unsealed sector
+// data directly, without first placing the unsealed file in a local path (e.g.
+// using ranged reads from object storage). When the worker store implements
+// it, piece reads use it instead of acquiring the unsealed file.
+type UnsealedReader interface {
+	// ReadUnsealed returns a reader for size bytes of padded piece data at
+	// offset in the unsealed sector file. When the range isn't available
+	// unsealed the reader is nil, and the error is nil.
+	ReadUnsealed(ctx context.Context, s storage.SectorRef, offset storiface.PaddedByteIndex, size abi.PaddedPieceSize) (io.ReadCloser, error)
+}
+
+// SourceFetcher is implemented by stores which can fetch sector files from a
+// chosen storage path
+type SourceFetcher interface {
+	// FetchFrom fetches a sector file from the source storage path into a
+	// newly allocated local path, and declares it there
+	FetchFrom(ctx context.Context, s storage.SectorRef, fileType storiface.SectorFileType, pathType storiface.PathType, source ID, primary bool) (string, ID, error)
+}
@@ -28,6 +28,10 @@ type StoragePath struct {
 
 	CanSeal  bool
 	CanStore bool
+
+	// FsType is the type of filesystem the path is on (e.g. xfs, nfs), or
+	// "unknown" if it can't be determined
+	FsType string
 }
 
 // LocalStorageMeta [path]/sectorstore.json
@@ -42,6 +46,10 @@ type LocalStorageMeta struct {
 
 	// Finalized sectors that will be proved over time will be stored here
 	CanStore bool
+
+	// Groups the path belongs to, workers can allocate files of some tasks
+	// only in paths of a group
+	Groups []string `json:",omitempty"`
 }
 
 // StorageConfig .lotusstorage/storage.json
@@ -73,6 +81,12 @@ type Local struct {
 
 	paths map[ID]*path
 
+	// incremented when paths are opened
+	pathsGen uint64
+
+	// last ID given to a reservation
+	nextReservation uint64
+
 	localLk sync.RWMutex
 }
 
@@ -81,6 +95,15 @@ type path struct {
 
 	reserved     int64
 	reservations map[abi.SectorID]storiface.SectorFileType
+
+	// reservations persisted in ReservationsFile, included in reserved
+	persisted map[uint64]Reservation
+
+	// filesystem type, detected when the path is opened
+	fsType string
+
+	// storage groups from the path metadata
+	groups []string
 }
 
 func (p *path) stat(ls LocalStorage) (fsutil.FsStat, error) {
@@ -166,6 +189,14 @@ func (st *Local) OpenPath(ctx context.Context, p string) error {
 
 		reserved:     0,
 		reservations: map[abi.SectorID]storiface.SectorFileType{},
+		persisted:    map[uint64]Reservation{},
+
+		fsType: fsutil.FsType(p),
+		groups: meta.Groups,
+	}
+
+	if err := st.loadReservations(meta.ID, out); err != nil {
+		return xerrors.Errorf("loading reservations for %s: %w", p, err)
 	}
 
 	fst, err := out.stat(st.localStorage)
@@ -189,10 +220,20 @@ func (st *Local) OpenPath(ctx context.Context, p string) error {
 	}
 
 	st.paths[meta.ID] = out
+	st.pathsGen++
 
 	return nil
 }
 
+// PathsGeneration returns a number which changes whenever a local path is
+// opened or its weight changes, e.g. for invalidating cached results of Local
+func (st *Local) PathsGeneration() uint64 {
+	st.localLk.RLock()
+	defer st.localLk.RUnlock()
+
+	return st.pathsGen
+}
+
 func (st *Local) open(ctx context.Context) error {
 	cfg, err := st.localStorage.GetStorage()
 	if err != nil {
@@ -255,6 +296,61 @@ func (st *Local) Redeclare(ctx context.Context) error {
 	return nil
 }
 
+// SetWeight changes the weight of a local path, which affects where new
+// sector files are allocated. The weight is persisted in the path metadata,
+// and updated in the index.
+func (st *Local) SetWeight(ctx context.Context, id ID, weight uint64) error {
+	st.localLk.Lock()
+	defer st.localLk.Unlock()
+
+	p, ok := st.paths[id]
+	if !ok || p.local == "" {
+		return xerrors.Errorf("local path %s not found", id)
+	}
+
+	mpath := filepath.Join(p.local, MetaFile)
+	mb, err := ioutil.ReadFile(mpath)
+	if err != nil {
+		return xerrors.Errorf("reading storage metadata for %s: %w", p.local, err)
+	}
+
+	var meta LocalStorageMeta
+	if err := json.Unmarshal(mb, &meta); err != nil {
+		return xerrors.Errorf("unmarshalling storage metadata for %s: %w", p.local, err)
+	}
+	meta.Weight = weight
+
+	mb, err = json.MarshalIndent(&meta, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("marshalling storage metadata: %w", err)
+	}
+	if err := ioutil.WriteFile(mpath+".tmp", mb, 0644); err != nil {
+		return xerrors.Errorf("writing storage metadata for %s: %w", p.local, err)
+	}
+	if err := os.Rename(mpath+".tmp", mpath); err != nil {
+		return xerrors.Errorf("replacing storage metadata for %s: %w", p.local, err)
+	}
+
+	fst, err := p.stat(st.localStorage)
+	if err != nil {
+		return err
+	}
+
+	err = st.index.StorageAttach(ctx, StorageInfo{
+		ID:       id,
+		URLs:     st.urls,
+		Weight:   meta.Weight,
+		CanSeal:  meta.CanSeal,
+		CanStore: meta.CanStore,
+	}, fst)
+	if err != nil {
+		return xerrors.Errorf("updating storage in index: %w", err)
+	}
+
+	st.pathsGen++
+	return nil
+}
+
 func (st *Local) declareSectors(ctx context.Context, p string, id ID, primary bool) error {
 	for _, t := range storiface.PathTypes {
 		ents, err := ioutil.ReadDir(filepath.Join(p, t.String()))
@@ -326,7 +422,12 @@ func (st *Local) reportStorage(ctx context.Context) {
 	}
 }
 
-func (st *Local) Reserve(ctx context.Context, sid storage.SectorRef, ft storiface.SectorFileType, storageIDs storiface.SectorPaths, overheadTab map[storiface.SectorFileType]int) (func(), error) {
+func (st *Local) Reserve(ctx context.Context, sid storage.SectorRef, ft storiface.SectorFileType, storageIDs storiface.SectorPaths, overheadTab map[storiface.SectorFileType]int) (release func(), err error) {
+	reason := ReserveFailOther
+	defer func() {
+		recordReserve(ctx, reason, err)
+	}()
+
 	ssize, err := sid.ProofType.SectorSize()
 	if err != nil {
 		return nil, err
@@ -341,6 +442,8 @@ func (st *Local) Reserve(ctx context.Context, sid storage.SectorRef, ft storifac
 		deferredDone()
 	}()
 
+	touched := map[ID]*path{}
+
 	for _, fileType := range storiface.PathTypes {
 		if fileType&ft == 0 {
 			continue
@@ -350,21 +453,25 @@ func (st *Local) Reserve(ctx context.Context, sid storage.SectorRef, ft storifac
 
 		p, ok := st.paths[id]
 		if !ok {
+			reason = ReserveFailPathOffline
 			return nil, errPathNotFound
 		}
 
 		stat, err := p.stat(st.localStorage)
 		if err != nil {
+			reason = ReserveFailPathOffline
 			return nil, xerrors.Errorf("getting local storage stat: %w", err)
 		}
 
 		overhead := int64(overheadTab[fileType]) * int64(ssize) / storiface.FSOverheadDen
 
 		if stat.Available < overhead {
+			reason = ReserveFailNoSpace
 			return nil, storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("can't reserve %d bytes in '%s' (id:%s), only %d available", overhead, p.local, id, stat.Available))
 		}
 
-		p.reserved += overhead
+		rid := st.addReservation(id, p, sid.ID, fileType, overhead)
+		touched[id] = p
 
 		prevDone := done
 		done = func() {
@@ -373,7 +480,13 @@ func (st *Local) Reserve(ctx context.Context, sid storage.SectorRef, ft storifac
 			st.localLk.Lock()
 			defer st.localLk.Unlock()
 
-			p.reserved -= overhead
+			st.releaseReservation(p, rid)
+		}
+	}
+
+	for _, p := range touched {
+		if err := p.writeReservations(); err != nil {
+			log.Errorf("persisting reservations of %s: %+v", p.local, err)
 		}
 	}
 
@@ -382,13 +495,20 @@ func (st *Local) Reserve(ctx context.Context, sid storage.SectorRef, ft storifac
 }
 
 func (st *Local) AcquireSector(ctx context.Context, sid storage.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, pathType storiface.PathType, op storiface.AcquireMode) (storiface.SectorPaths, storiface.SectorPaths, error) {
+	paths, storageIDs, _, err := st.AcquireSectorCopies(ctx, sid, existing, allocate, pathType, op)
+	return paths, storageIDs, err
+}
+
+// AcquireSectorCopies is AcquireSector, also returning IDs of all local paths
+// holding existing file types, the first of which is the one used
+func (st *Local) AcquireSectorCopies(ctx context.Context, sid storage.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, pathType storiface.PathType, op storiface.AcquireMode) (storiface.SectorPaths, storiface.SectorPaths, storiface.SectorStorageIDs, error) {
 	if existing|allocate != existing^allocate {
-		return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.New("can't both find and allocate a sector")
+		return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, xerrors.New("can't both find and allocate a sector")
 	}
 
 	ssize, err := sid.ProofType.SectorSize()
 	if err != nil {
-		return storiface.SectorPaths{}, storiface.SectorPaths{}, err
+		return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, err
 	}
 
 	st.localLk.RLock()
@@ -396,6 +516,7 @@ func (st *Local) AcquireSector(ctx context.Context, sid storage.SectorRef, exist
 
 	var out storiface.SectorPaths
 	var storageIDs storiface.SectorPaths
+	copies := storiface.SectorStorageIDs{}
 
 	for _, fileType := range storiface.PathTypes {
 		if fileType&existing == 0 {
@@ -407,6 +528,7 @@ func (st *Local) AcquireSector(ctx context.Context, sid storage.SectorRef, exist
 			log.Warnf("finding existing sector %d(t:%d) failed: %+v", sid, fileType, err)
 			continue
 		}
+		si = st.preferExisting(ctx, sid.ID, fileType, si)
 
 		for _, info := range si {
 			p, ok := st.paths[info.ID]
@@ -418,12 +540,18 @@ func (st *Local) AcquireSector(ctx context.Context, sid storage.SectorRef, exist
 				continue
 			}
 
+			copies.Add(fileType, string(info.ID))
+
+			// the first (preferred) local copy is used
+			if existing&fileType == 0 {
+				continue
+			}
+
 			spath := p.sectorPath(sid.ID, fileType)
 			storiface.SetPathByType(&out, fileType, spath)
 			storiface.SetPathByType(&storageIDs, fileType, string(info.ID))
 
 			existing ^= fileType
-			break
 		}
 	}
 
@@ -434,47 +562,56 @@ func (st *Local) AcquireSector(ctx context.Context, sid storage.SectorRef, exist
 
 		sis, err := st.index.StorageBestAlloc(ctx, fileType, ssize, pathType)
 		if err != nil {
-			return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.Errorf("finding best storage for allocating : %w", err)
+			return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, xerrors.Errorf("finding best storage for allocating : %w", err)
 		}
+		sis = st.allocGroup(ctx, sis)
+		sis = st.allocOrder(ctx, sid.ID, ssize, fileType, sis)
+		sis = st.preferOrder(ctx, sid.ID, fileType, false, sis)
 
-		var best string
-		var bestID ID
-
-		for _, si := range sis {
-			p, ok := st.paths[si.ID]
-			if !ok {
-				continue
+		best, bestID := st.allocPath(sid.ID, fileType, pathType, sis)
+		if best == "" {
+			if group, ok := ctx.Value(allocGroupKey{}).(string); ok {
+				return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, xerrors.Errorf("couldn't find a suitable path for a sector in storage group %q", group)
 			}
+			return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, xerrors.Errorf("couldn't find a suitable path for a sector")
+		}
 
-			if p.local == "" { // TODO: can that even be the case?
-				continue
-			}
+		storiface.SetPathByType(&out, fileType, best)
+		storiface.SetPathByType(&storageIDs, fileType, string(bestID))
+		copies.Add(fileType, string(bestID))
+		allocate ^= fileType
+	}
 
-			if (pathType == storiface.PathSealing) && !si.CanSeal {
-				continue
-			}
+	return out, storageIDs, copies, nil
+}
 
-			if (pathType == storiface.PathStorage) && !si.CanStore {
-				continue
-			}
+// allocPath returns the first of the candidate paths suitable for allocating a
+// sector file. Must be called with localLk held.
+func (st *Local) allocPath(sid abi.SectorID, fileType storiface.SectorFileType, pathType storiface.PathType, sis []StorageInfo) (string, ID) {
+	for _, si := range sis {
+		p, ok := st.paths[si.ID]
+		if !ok {
+			continue
+		}
 
-			// TODO: Check free space
+		if p.local == "" { // TODO: can that even be the case?
+			continue
+		}
 
-			best = p.sectorPath(sid.ID, fileType)
-			bestID = si.ID
-			break
+		if (pathType == storiface.PathSealing) && !si.CanSeal {
+			continue
 		}
 
-		if best == "" {
-			return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.Errorf("couldn't find a suitable path for a sector")
+		if (pathType == storiface.PathStorage) && !si.CanStore {
+			continue
 		}
 
-		storiface.SetPathByType(&out, fileType, best)
-		storiface.SetPathByType(&storageIDs, fileType, string(bestID))
-		allocate ^= fileType
+		// TODO: Check free space
+
+		return p.sectorPath(sid, fileType), si.ID
 	}
 
-	return out, storageIDs, nil
+	return "", ""
 }
 
 func (st *Local) Local(ctx context.Context) ([]StoragePath, error) {
@@ -498,6 +635,7 @@ func (st *Local) Local(ctx context.Context) ([]StoragePath, error) {
 			LocalPath: p.local,
 			CanSeal:   si.CanSeal,
 			CanStore:  si.CanStore,
+			FsType:    p.fsType,
 		})
 	}
 
@@ -519,7 +657,7 @@ func (st *Local) Remove(ctx context.Context, sid abi.SectorID, typ storiface.Sec
 	}
 
 	for _, info := range si {
-		if err := st.removeSector(ctx, sid, typ, info.ID); err != nil {
+		if err := st.removeSector(ctx, sid, typ, info.ID, info.Primary); err != nil {
 			return err
 		}
 	}
@@ -555,7 +693,7 @@ func (st *Local) RemoveCopies(ctx context.Context, sid abi.SectorID, typ storifa
 			continue
 		}
 
-		if err := st.removeSector(ctx, sid, typ, info.ID); err != nil {
+		if err := st.removeSector(ctx, sid, typ, info.ID, false); err != nil {
 			return err
 		}
 	}
@@ -563,7 +701,22 @@ func (st *Local) RemoveCopies(ctx context.Context, sid abi.SectorID, typ storifa
 	return nil
 }
 
-func (st *Local) removeSector(ctx context.Context, sid abi.SectorID, typ storiface.SectorFileType, storage ID) error {
+// RemoveFrom removes a sector file from one local path, e.g. a copy which
+// turned out incomplete
+func (st *Local) RemoveFrom(ctx context.Context, sid abi.SectorID, typ storiface.SectorFileType, storage ID) error {
+	if bits.OnesCount(uint(typ)) != 1 {
+		return xerrors.New("delete expects one file type")
+	}
+
+	return st.removeSector(ctx, sid, typ, storage, false)
+}
+
+func (st *Local) removeSector(ctx context.Context, sid abi.SectorID, typ storiface.SectorFileType, storage ID, primary bool) error {
+	rctx := removeContext(ctx)
+	if err := rctx.Err(); err != nil {
+		return xerrors.Errorf("removing sector (%v): %w", sid, err)
+	}
+
 	p, ok := st.paths[storage]
 	if !ok {
 		return nil
@@ -580,7 +733,17 @@ func (st *Local) removeSector(ctx context.Context, sid abi.SectorID, typ storifa
 	spath := p.sectorPath(sid, typ)
 	log.Infof("remove %s", spath)
 
-	if err := os.RemoveAll(spath); err != nil {
+	if err := removeAll(rctx, spath); err != nil {
+		if rctx.Err() != nil {
+			// keep what's left of the files in the index, so that they get
+			// removed the next time
+			if derr := st.index.StorageDeclareSector(context.TODO(), storage, sid, typ, primary); derr != nil {
+				log.Errorf("redeclaring partially removed sector (%v) in %s: %+v", sid, spath, derr)
+			}
+
+			return xerrors.Errorf("removing sector (%v) from %s: %w", sid, spath, err)
+		}
+
 		log.Errorf("removing sector (%v) from %s: %+v", sid, spath, err)
 	}
 
@@ -631,13 +794,13 @@ func (st *Local) MoveStorage(ctx context.Context, s storage.SectorRef, types sto
 			return xerrors.Errorf("dropping source sector from index: %w", err)
 		}
 
-		if err := move(storiface.PathByType(src, fileType), storiface.PathByType(dest, fileType)); err != nil {
-			// TODO: attempt some recovery (check if src is still there, re-declare)
-			return xerrors.Errorf("moving sector %v(%d): %w", s, fileType, err)
+		destID, err := st.moveSectorFile(ctx, s, fileType, storiface.PathByType(src, fileType), sst.ID, storiface.PathByType(dest, fileType), dst.ID)
+		if err != nil {
+			return err
 		}
 
-		if err := st.index.StorageDeclareSector(ctx, ID(storiface.PathByType(destIds, fileType)), s.ID, fileType, true); err != nil {
-			return xerrors.Errorf("declare sector %d(t:%d) -> %s: %w", s, fileType, ID(storiface.PathByType(destIds, fileType)), err)
+		if err := st.index.StorageDeclareSector(ctx, destID, s.ID, fileType, true); err != nil {
+			return xerrors.Errorf("declare sector %d(t:%d) -> %s: %w", s, fileType, destID, err)
 		}
 	}
 
@@ -646,6 +809,130 @@ func (st *Local) MoveStorage(ctx context.Context, s storage.SectorRef, types sto
 	return nil
 }
 
+// MoveSector moves sector files of the given types from the local paths which
+// currently hold them into the dest local path, and updates the index
+func (st *Local) MoveSector(ctx context.Context, sid abi.SectorID, types storiface.SectorFileType, dest ID) error {
+	type sectorMove struct {
+		fileType storiface.SectorFileType
+		src      ID
+		from, to string
+	}
+
+	st.localLk.RLock()
+
+	dp, ok := st.paths[dest]
+	if !ok {
+		st.localLk.RUnlock()
+		return xerrors.Errorf("dest path %s: %w", dest, errPathNotFound)
+	}
+
+	var moves []sectorMove
+	var need int64
+
+	for _, fileType := range storiface.PathTypes {
+		if fileType&types == 0 {
+			continue
+		}
+
+		found := false
+		for id, p := range st.paths {
+			spath := p.sectorPath(sid, fileType)
+			if _, err := os.Stat(spath); err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+
+				st.localLk.RUnlock()
+				return xerrors.Errorf("stat %s: %w", spath, err)
+			}
+
+			found = true
+			if id == dest {
+				break
+			}
+
+			used, err := st.localStorage.DiskUsage(spath)
+			if err != nil {
+				st.localLk.RUnlock()
+				return xerrors.Errorf("getting disk usage of %s: %w", spath, err)
+			}
+
+			need += used
+			moves = append(moves, sectorMove{
+				fileType: fileType,
+				src:      id,
+				from:     spath,
+				to:       dp.sectorPath(sid, fileType),
+			})
+			break
+		}
+
+		if !found {
+			st.localLk.RUnlock()
+			return xerrors.Errorf("sector %d (%s): %w", sid, fileType, storiface.ErrSectorNotFound)
+		}
+	}
+
+	stat, err := dp.stat(st.localStorage)
+	st.localLk.RUnlock()
+	if err != nil {
+		return xerrors.Errorf("getting dest storage stat: %w", err)
+	}
+
+	if stat.Available < need {
+		return storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("can't move %d bytes to '%s' (id:%s), only %d available", need, dp.local, dest, stat.Available))
+	}
+
+	for _, m := range moves {
+		log.Debugf("moving %d(%s) to storage: %s -> %s", sid, m.fileType, m.src, dest)
+
+		// the source stays declared until the file is in place at the
+		// destination, so that a failed or cancelled move doesn't leave the
+		// sector file out of the index
+		if err := move(ctx, m.from, m.to); err != nil {
+			st.recoverMove(sid, m.fileType, m.src, dest, m.from, m.to)
+			return xerrors.Errorf("moving sector %d(%s): %w", sid, m.fileType, err)
+		}
+
+		if err := st.index.StorageDeclareSector(ctx, dest, sid, m.fileType, true); err != nil {
+			return xerrors.Errorf("declare sector %d(t:%s) -> %s: %w", sid, m.fileType, dest, err)
+		}
+
+		if err := st.index.StorageDropSector(ctx, m.src, sid, m.fileType); err != nil {
+			return xerrors.Errorf("dropping source sector from index: %w", err)
+		}
+	}
+
+	st.reportStorage(ctx) // report space use changes
+
+	return nil
+}
+
+// recoverMove fixes up the index after a failed move of a sector file. The
+// source is still declared, but the move may have gotten far enough to remove
+// it after putting the file in place at the destination, in which case the
+// destination is declared instead. The index is updated even if the move was
+// cancelled.
+func (st *Local) recoverMove(sid abi.SectorID, fileType storiface.SectorFileType, src, dest ID, from, to string) {
+	ctx := context.Background()
+
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		return // the source is still there, and declared
+	}
+	if _, err := os.Stat(to); err != nil {
+		log.Errorw("sector file missing at both ends of a failed move", "sector", sid, "type", fileType, "from", from, "to", to)
+		return
+	}
+
+	if err := st.index.StorageDeclareSector(ctx, dest, sid, fileType, true); err != nil {
+		log.Errorw("declaring moved sector file after failed move", "sector", sid, "type", fileType, "storage", dest, "error", err)
+		return
+	}
+	if err := st.index.StorageDropSector(ctx, src, sid, fileType); err != nil {
+		log.Errorw("dropping source of moved sector file after failed move", "sector", sid, "type", fileType, "storage", src, "error", err)
+	}
+}
+
 var errPathNotFound = xerrors.Errorf("fsstat: path not found")
 
 func (st *Local) FsStat(ctx context.Context, id ID) (fsutil.FsStat, error) {
@@ -661,3 +948,4 @@ func (st *Local) FsStat(ctx context.Context, id ID) (fsutil.FsStat, error) {
 }
 
 var _ Store = &Local{}
+var _ Reserver = &Local{}
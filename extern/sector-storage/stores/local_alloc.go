@@ -0,0 +1,197 @@
+package stores
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// AllocPolicy controls which local path AcquireSector allocates new sector
+// files in. Preferred paths are only used when they are suitable for the
+// allocation and have enough free space for the file, otherwise allocation
+// falls back to the path the index picks.
+type AllocPolicy struct {
+	// NearSealed prefers paths which already hold the sealed replica of the
+	// sector
+	NearSealed bool
+
+	// Paths are preferred in order, after paths holding the sealed replica
+	// when NearSealed is set
+	Paths []ID
+}
+
+func (ap AllocPolicy) empty() bool {
+	return !ap.NearSealed && len(ap.Paths) == 0
+}
+
+type allocPolicyKey struct{}
+
+// WithAllocPolicy returns a context with which Local.AcquireSector allocates
+// sector files following the policy
+func WithAllocPolicy(ctx context.Context, ap AllocPolicy) context.Context {
+	if ap.empty() {
+		return ctx
+	}
+	return context.WithValue(ctx, allocPolicyKey{}, ap)
+}
+
+// allocOrder sorts candidate paths for allocating a sector file by the
+// context's allocation policy. Must be called with localLk held.
+func (st *Local) allocOrder(ctx context.Context, sid abi.SectorID, ssize abi.SectorSize, fileType storiface.SectorFileType, sis []StorageInfo) []StorageInfo {
+	ap, ok := ctx.Value(allocPolicyKey{}).(AllocPolicy)
+	if !ok {
+		return sis
+	}
+
+	var preferred []ID
+	if ap.NearSealed {
+		sealed, err := st.index.StorageFindSector(ctx, sid, storiface.FTSealed, 0, false)
+		if err != nil {
+			log.Warnf("finding sealed sector %d for allocation: %+v", sid, err)
+		}
+		for _, info := range sealed {
+			preferred = append(preferred, info.ID)
+		}
+	}
+	preferred = append(preferred, ap.Paths...)
+
+	candidates := map[ID]StorageInfo{}
+	for _, si := range sis {
+		candidates[si.ID] = si
+	}
+
+	need := int64(storiface.FSOverheadSeal[fileType]) * int64(ssize) / storiface.FSOverheadDen
+
+	// preferred paths without enough space are skipped, also as fallbacks
+	out := make([]StorageInfo, 0, len(sis))
+	used := map[ID]bool{}
+	for _, id := range preferred {
+		si, ok := candidates[id]
+		if !ok || used[id] {
+			continue
+		}
+
+		p, ok := st.paths[id]
+		if !ok || p.local == "" {
+			continue
+		}
+		used[id] = true
+
+		stat, err := p.stat(st.localStorage)
+		if err != nil {
+			log.Warnf("not allocating %s of sector %d in preferred path %s: %+v", fileType, sid, id, err)
+			continue
+		}
+		if stat.Available < need {
+			log.Warnf("not allocating %s of sector %d in preferred path %s: need %d bytes, %d available", fileType, sid, id, need, stat.Available)
+			continue
+		}
+
+		out = append(out, si)
+	}
+
+	for _, si := range sis {
+		if !used[si.ID] {
+			out = append(out, si)
+		}
+	}
+
+	return out
+}
+
+type allocGroupKey struct{}
+
+// WithAllocGroup returns a context with which Local.AcquireSector allocates
+// sector files only in local paths of the storage group (see
+// LocalStorageMeta.Groups). Unlike allocation policies, it doesn't fall back
+// to other paths.
+func WithAllocGroup(ctx context.Context, group string) context.Context {
+	if group == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, allocGroupKey{}, group)
+}
+
+// allocGroup filters candidate paths for allocating a sector file to paths
+// of the context's storage group. Must be called with localLk held.
+func (st *Local) allocGroup(ctx context.Context, sis []StorageInfo) []StorageInfo {
+	group, ok := ctx.Value(allocGroupKey{}).(string)
+	if !ok {
+		return sis
+	}
+
+	out := make([]StorageInfo, 0, len(sis))
+	for _, si := range sis {
+		p, ok := st.paths[si.ID]
+		if !ok || !p.inGroup(group) {
+			continue
+		}
+		out = append(out, si)
+	}
+	return out
+}
+
+func (p *path) inGroup(group string) bool {
+	for _, g := range p.groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Groups returns local paths by the storage groups they belong to
+func (st *Local) Groups() map[string][]ID {
+	st.localLk.RLock()
+	defer st.localLk.RUnlock()
+
+	out := map[string][]ID{}
+	for id, p := range st.paths {
+		if p.local == "" {
+			continue
+		}
+		for _, g := range p.groups {
+			out[g] = append(out[g], id)
+		}
+	}
+	return out
+}
+
+// AllocCandidates returns IDs of local paths in which AcquireSector may
+// allocate the sector file with the context's allocation policy and group, in
+// the order they are tried
+func (st *Local) AllocCandidates(ctx context.Context, sid storage.SectorRef, fileType storiface.SectorFileType, pathType storiface.PathType) ([]ID, error) {
+	ssize, err := sid.ProofType.SectorSize()
+	if err != nil {
+		return nil, err
+	}
+
+	st.localLk.RLock()
+	defer st.localLk.RUnlock()
+
+	sis, err := st.index.StorageBestAlloc(ctx, fileType, ssize, pathType)
+	if err != nil {
+		return nil, xerrors.Errorf("finding best storage for allocating: %w", err)
+	}
+	sis = st.allocGroup(ctx, sis)
+	sis = st.allocOrder(ctx, sid.ID, ssize, fileType, sis)
+
+	var out []ID
+	for _, si := range sis {
+		p, ok := st.paths[si.ID]
+		if !ok || p.local == "" {
+			continue
+		}
+		if (pathType == storiface.PathSealing && !si.CanSeal) || (pathType == storiface.PathStorage && !si.CanStore) {
+			continue
+		}
+		out = append(out, si.ID)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,147 @@
+package stores
+
+import (
+	"context"
+	"os"
+	"syscall"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// MoveFullPolicy controls what Local.MoveStorage does when the destination
+// path runs out of space while a sector file is being moved into it. The
+// partial copy is always removed from the destination, and the source is left
+// in place and declared in the index again.
+type MoveFullPolicy struct {
+	// Alternates is the number of other destination paths tried after a
+	// destination fills up, 0 fails the move right away
+	Alternates int
+
+	// Paths limits alternate destinations to the listed paths, tried in
+	// order. When empty, alternates are picked by the index from all storage
+	// paths suitable for the sector.
+	Paths []ID
+}
+
+type moveFullPolicyKey struct{}
+
+// WithMoveFullPolicy returns a context with which Local.MoveStorage handles
+// full destinations following the policy
+func WithMoveFullPolicy(ctx context.Context, mp MoveFullPolicy) context.Context {
+	return context.WithValue(ctx, moveFullPolicyKey{}, mp)
+}
+
+func moveFullPolicy(ctx context.Context) MoveFullPolicy {
+	mp, _ := ctx.Value(moveFullPolicyKey{}).(MoveFullPolicy)
+	return mp
+}
+
+// moveFile is move, overridden in tests
+var moveFile = move
+
+func isNoSpace(err error) bool {
+	return xerrors.Is(err, syscall.ENOSPC)
+}
+
+// moveSectorFile moves a sector file into dest, or into an alternate path
+// when dest fills up. The returned ID is the path the file was moved to.
+func (st *Local) moveSectorFile(ctx context.Context, s storage.SectorRef, fileType storiface.SectorFileType, src string, srcID ID, dest string, destID ID) (ID, error) {
+	mp := moveFullPolicy(ctx)
+
+	// the source was removed from the index before moving, declare it again
+	// when it's still in place, so that the sector isn't lost
+	redeclare := func(merr error) error {
+		if _, err := os.Stat(src); err != nil {
+			log.Errorw("sector source missing after failed move", "sector", s.ID, "type", fileType, "path", src, "error", err)
+			return merr
+		}
+		// the move may have failed because it was aborted
+		dctx := ctx
+		if ctx.Err() != nil {
+			dctx = context.Background()
+		}
+		if err := st.index.StorageDeclareSector(dctx, srcID, s.ID, fileType, true); err != nil {
+			log.Errorw("re-declaring source sector after failed move", "sector", s.ID, "type", fileType, "storage", srcID, "error", err)
+		}
+		return merr
+	}
+
+	tried := map[ID]bool{}
+	for {
+		err := moveFile(ctx, src, dest)
+		if err == nil {
+			return destID, nil
+		}
+
+		if !isNoSpace(err) {
+			return "", redeclare(xerrors.Errorf("moving sector %v(%d): %w", s, fileType, err))
+		}
+
+		tried[destID] = true
+		if len(tried) > mp.Alternates {
+			return "", redeclare(storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("moving sector %v(%d): destination %s full: %w", s, fileType, destID, err)))
+		}
+
+		alt, altID, aerr := st.alternateDest(ctx, s, fileType, mp, tried)
+		if aerr != nil {
+			return "", redeclare(storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("moving sector %v(%d): destination %s full, no alternate: %w", s, fileType, destID, aerr)))
+		}
+
+		log.Warnw("move destination full, trying alternate", "sector", s.ID, "type", fileType, "full", destID, "alternate", altID)
+		dest, destID = alt, altID
+	}
+}
+
+// alternateDest picks the next destination for moving a sector file into long
+// term storage, skipping paths which were tried already
+func (st *Local) alternateDest(ctx context.Context, s storage.SectorRef, fileType storiface.SectorFileType, mp MoveFullPolicy, tried map[ID]bool) (string, ID, error) {
+	ssize, err := s.ProofType.SectorSize()
+	if err != nil {
+		return "", "", err
+	}
+
+	sis, err := st.index.StorageBestAlloc(ctx, fileType, ssize, storiface.PathStorage)
+	if err != nil {
+		return "", "", xerrors.Errorf("finding best storage for allocating : %w", err)
+	}
+
+	st.localLk.RLock()
+	defer st.localLk.RUnlock()
+
+	sis = filterAlternates(sis, mp.Paths, tried)
+
+	dest, destID := st.allocPath(s.ID, fileType, storiface.PathStorage, sis)
+	if dest == "" {
+		return "", "", xerrors.Errorf("couldn't find a suitable path for a sector")
+	}
+
+	return dest, destID, nil
+}
+
+// filterAlternates drops tried paths from the candidates, and when paths are
+// given, keeps only those, in their order
+func filterAlternates(sis []StorageInfo, paths []ID, tried map[ID]bool) []StorageInfo {
+	out := make([]StorageInfo, 0, len(sis))
+	if len(paths) == 0 {
+		for _, si := range sis {
+			if !tried[si.ID] {
+				out = append(out, si)
+			}
+		}
+		return out
+	}
+
+	for _, id := range paths {
+		for _, si := range sis {
+			if si.ID == id && !tried[id] {
+				out = append(out, si)
+				break
+			}
+		}
+	}
+	return out
+}
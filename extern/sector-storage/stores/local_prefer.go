@@ -0,0 +1,139 @@
+package stores
+
+import (
+	"context"
+	"sort"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// PathCandidate is a local path qualifying for a sector file acquired with
+// Local.AcquireSector, either holding an existing copy of the file, or
+// suitable for allocating it
+type PathCandidate struct {
+	ID        ID
+	LocalPath string
+	Weight    uint64
+
+	CanSeal  bool
+	CanStore bool
+
+	// Stat is empty when the path couldn't be stat'ed
+	Stat fsutil.FsStat
+}
+
+// PathPreference orders candidate paths for acquiring a sector file, the first
+// returned candidate is used. Candidates left out aren't used, unless none are
+// returned, in which case the store's default order is kept.
+type PathPreference func(ctx context.Context, sector abi.SectorID, fileType storiface.SectorFileType, existing bool, candidates []PathCandidate) []PathCandidate
+
+// PreferMostAvailable prefers paths with the most available space
+func PreferMostAvailable(ctx context.Context, sector abi.SectorID, fileType storiface.SectorFileType, existing bool, candidates []PathCandidate) []PathCandidate {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Stat.Available > candidates[j].Stat.Available
+	})
+	return candidates
+}
+
+// PathPreferences are named path preferences, e.g. for selecting one in
+// configuration
+var PathPreferences = map[string]PathPreference{
+	"most-available": PreferMostAvailable,
+}
+
+type pathPreferenceKey struct{}
+
+// WithPathPreference returns a context with which Local.AcquireSector consults
+// pref when multiple local paths qualify for a sector file. A nil pref keeps
+// the default order.
+func WithPathPreference(ctx context.Context, pref PathPreference) context.Context {
+	if pref == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, pathPreferenceKey{}, pref)
+}
+
+// preferOrder orders qualifying local paths with the context's path
+// preference. Paths which aren't local are dropped, as AcquireSector can't use
+// them anyway. Must be called with localLk held.
+func (st *Local) preferOrder(ctx context.Context, sid abi.SectorID, fileType storiface.SectorFileType, existing bool, sis []StorageInfo) []StorageInfo {
+	pref, ok := ctx.Value(pathPreferenceKey{}).(PathPreference)
+	if !ok {
+		return sis
+	}
+
+	byID := map[ID]StorageInfo{}
+	var candidates []PathCandidate
+	for _, si := range sis {
+		p, ok := st.paths[si.ID]
+		if !ok || p.local == "" {
+			continue
+		}
+
+		stat, err := p.stat(st.localStorage)
+		if err != nil {
+			log.Warnf("stat of candidate path %s for sector %d: %+v", si.ID, sid, err)
+		}
+
+		byID[si.ID] = si
+		candidates = append(candidates, PathCandidate{
+			ID:        si.ID,
+			LocalPath: p.local,
+			Weight:    si.Weight,
+			CanSeal:   si.CanSeal,
+			CanStore:  si.CanStore,
+			Stat:      stat,
+		})
+	}
+
+	if len(candidates) < 2 {
+		return sis
+	}
+
+	preferred := pref(ctx, sid, fileType, existing, candidates)
+	if len(preferred) == 0 {
+		return sis
+	}
+
+	out := make([]StorageInfo, 0, len(sis))
+	for _, c := range preferred {
+		if si, ok := byID[c.ID]; ok {
+			out = append(out, si)
+			delete(byID, c.ID)
+		}
+	}
+
+	return out
+}
+
+// preferExisting orders paths holding existing copies of a sector file with the
+// context's path preference. Must be called with localLk held.
+func (st *Local) preferExisting(ctx context.Context, sid abi.SectorID, fileType storiface.SectorFileType, si []SectorStorageInfo) []SectorStorageInfo {
+	if _, ok := ctx.Value(pathPreferenceKey{}).(PathPreference); !ok {
+		return si
+	}
+
+	byID := map[ID]SectorStorageInfo{}
+	sis := make([]StorageInfo, 0, len(si))
+	for _, info := range si {
+		byID[info.ID] = info
+		sis = append(sis, StorageInfo{
+			ID:       info.ID,
+			URLs:     info.URLs,
+			Weight:   info.Weight,
+			CanSeal:  info.CanSeal,
+			CanStore: info.CanStore,
+		})
+	}
+
+	sis = st.preferOrder(ctx, sid, fileType, true, sis)
+
+	out := make([]SectorStorageInfo, 0, len(sis))
+	for _, info := range sis {
+		out = append(out, byID[info.ID])
+	}
+	return out
+}
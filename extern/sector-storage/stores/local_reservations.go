@@ -0,0 +1,138 @@
+package stores
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// ReservationsFile [path]/reservations.json
+const ReservationsFile = "reservations.json"
+
+// Reservation is storage space reserved in a local path for a sector file.
+// Reservations are persisted in the storage path, so that reservations left
+// behind by a process which crashed are still accounted for after restarting,
+// until they are released.
+type Reservation struct {
+	ID      uint64 `json:"-"`
+	Storage ID     `json:"-"`
+
+	Sector abi.SectorID
+	Type   storiface.SectorFileType
+	Size   int64
+}
+
+// loadReservations reads reservations persisted in the path, and accounts
+// for them as reserved space. Must be called with localLk held.
+func (st *Local) loadReservations(id ID, p *path) error {
+	rb, err := ioutil.ReadFile(filepath.Join(p.local, ReservationsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("reading reservations: %w", err)
+	}
+
+	var res []Reservation
+	if err := json.Unmarshal(rb, &res); err != nil {
+		return xerrors.Errorf("unmarshalling reservations: %w", err)
+	}
+
+	for _, r := range res {
+		st.addReservation(id, p, r.Sector, r.Type, r.Size)
+	}
+
+	return nil
+}
+
+// addReservation must be called with localLk held
+func (st *Local) addReservation(id ID, p *path, sector abi.SectorID, ft storiface.SectorFileType, size int64) uint64 {
+	st.nextReservation++
+	rid := st.nextReservation
+
+	p.reserved += size
+	p.persisted[rid] = Reservation{
+		ID:      rid,
+		Storage: id,
+		Sector:  sector,
+		Type:    ft,
+		Size:    size,
+	}
+
+	return rid
+}
+
+// releaseReservation frees the reserved space, it's a no-op for reservations
+// which were already released. Must be called with localLk held.
+func (st *Local) releaseReservation(p *path, rid uint64) int64 {
+	r, ok := p.persisted[rid]
+	if !ok {
+		return 0
+	}
+
+	delete(p.persisted, rid)
+	p.reserved -= r.Size
+
+	if err := p.writeReservations(); err != nil {
+		log.Errorf("persisting reservations of %s: %+v", p.local, err)
+	}
+
+	return r.Size
+}
+
+func (p *path) writeReservations() error {
+	res := make([]Reservation, 0, len(p.persisted))
+	for _, r := range p.persisted {
+		res = append(res, r)
+	}
+
+	rb, err := json.Marshal(res)
+	if err != nil {
+		return xerrors.Errorf("marshalling reservations: %w", err)
+	}
+
+	file := filepath.Join(p.local, ReservationsFile)
+	if err := ioutil.WriteFile(file+".tmp", rb, 0644); err != nil {
+		return xerrors.Errorf("writing reservations: %w", err)
+	}
+
+	return os.Rename(file+".tmp", file)
+}
+
+// Reservations returns storage space reservations held in local paths,
+// including reservations restored from a previous run
+func (st *Local) Reservations() []Reservation {
+	st.localLk.RLock()
+	defer st.localLk.RUnlock()
+
+	var out []Reservation
+	for _, p := range st.paths {
+		for _, r := range p.persisted {
+			out = append(out, r)
+		}
+	}
+
+	return out
+}
+
+// ReleaseReservation frees a reservation returned by Reservations, and returns
+// how many bytes were released. Releasing a reservation more than once, also
+// with the func returned by Reserve, is safe.
+func (st *Local) ReleaseReservation(r Reservation) int64 {
+	st.localLk.Lock()
+	defer st.localLk.Unlock()
+
+	p, ok := st.paths[r.Storage]
+	if !ok {
+		return 0
+	}
+
+	return st.releaseReservation(p, r.ID)
+}
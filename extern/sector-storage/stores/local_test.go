@@ -3,15 +3,23 @@ package stores
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
 
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
 	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"golang.org/x/xerrors"
 )
 
 const pathSize = 16 << 20
@@ -91,5 +99,547 @@ func TestLocalStorage(t *testing.T) {
 	err = st.OpenPath(ctx, filepath.Join(tstor.root, p1))
 	require.NoError(t, err)
 
+	paths, err := st.Local(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	require.NotEmpty(t, paths[0].FsType)
+
+	require.Equal(t, fsutil.FsTypeUnknown, fsutil.FsType(filepath.Join(tstor.root, "missing")))
+
 	// TODO: put more things here
 }
+
+func TestLocalReservations(t *testing.T) {
+	ctx := context.TODO()
+
+	root, err := ioutil.TempDir("", "sector-storage-teststorage-")
+	require.NoError(t, err)
+	defer os.RemoveAll(root) // nolint
+
+	tstor := &TestingLocalStorage{
+		root: root,
+	}
+
+	require.NoError(t, tstor.init("1"))
+	tstor.c.StoragePaths = []LocalPath{{Path: filepath.Join(root, "1")}}
+
+	st, err := NewLocal(ctx, tstor, NewIndex(), nil)
+	require.NoError(t, err)
+
+	paths, err := st.Local(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	ids := storiface.SectorPaths{ID: sector.ID, Sealed: string(paths[0].ID), Cache: string(paths[0].ID)}
+
+	_, err = st.Reserve(ctx, sector, storiface.FTSealed|storiface.FTCache, ids, storiface.FSOverheadSeal)
+	require.NoError(t, err)
+	require.Len(t, st.Reservations(), 2)
+
+	fst, err := st.FsStat(ctx, paths[0].ID)
+	require.NoError(t, err)
+	reserved := fst.Reserved
+
+	// reservations are restored when the path is opened again
+	st, err = NewLocal(ctx, tstor, NewIndex(), nil)
+	require.NoError(t, err)
+
+	res := st.Reservations()
+	require.Len(t, res, 2)
+	fst, err = st.FsStat(ctx, paths[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, reserved, fst.Reserved)
+
+	var freed int64
+	for _, r := range res {
+		require.Equal(t, sector.ID, r.Sector)
+		freed += st.ReleaseReservation(r)
+		require.Zero(t, st.ReleaseReservation(r))
+	}
+	require.Equal(t, reserved, freed)
+	require.Empty(t, st.Reservations())
+
+	st, err = NewLocal(ctx, tstor, NewIndex(), nil)
+	require.NoError(t, err)
+	require.Empty(t, st.Reservations())
+
+	// the func returned by Reserve doesn't free space released already
+	release, err := st.Reserve(ctx, sector, storiface.FTSealed, ids, storiface.FSOverheadSeal)
+	require.NoError(t, err)
+	res = st.Reservations()
+	require.Len(t, res, 1)
+	require.Equal(t, res[0].Size, st.ReleaseReservation(res[0]))
+
+	release()
+	fst, err = st.FsStat(ctx, paths[0].ID)
+	require.NoError(t, err)
+	require.Zero(t, fst.Reserved)
+}
+
+func TestRemoveCancel(t *testing.T) {
+	ctx := context.TODO()
+
+	root, err := ioutil.TempDir("", "sector-storage-teststorage-")
+	require.NoError(t, err)
+	defer os.RemoveAll(root) // nolint
+
+	tstor := &TestingLocalStorage{
+		root: root,
+	}
+
+	index := NewIndex()
+
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, tstor.init("1"))
+	require.NoError(t, st.OpenPath(ctx, filepath.Join(tstor.root, "1")))
+
+	paths, err := st.Local(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+
+	sid := abi.SectorID{Miner: 1000, Number: 1}
+	cache := filepath.Join(paths[0].LocalPath, storiface.FTCache.String(), storiface.SectorName(sid))
+	require.NoError(t, os.MkdirAll(cache, 0755))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(cache, fmt.Sprint(i)), []byte("data"), 0644))
+	}
+	require.NoError(t, index.StorageDeclareSector(ctx, paths[0].ID, sid, storiface.FTCache, true))
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	require.True(t, xerrors.Is(st.Remove(WithAbortableRemove(cctx), sid, storiface.FTCache, true), context.Canceled))
+	_, err = os.Stat(cache)
+	require.NoError(t, err)
+
+	si, err := index.StorageFindSector(ctx, sid, storiface.FTCache, 0, false)
+	require.NoError(t, err)
+	require.Len(t, si, 1)
+
+	// cancelled while removing files, what's left stays in the index
+	require.Error(t, st.Remove(WithAbortableRemove(&cancelAfter{Context: ctx, n: 4}), sid, storiface.FTCache, true))
+
+	left, err := ioutil.ReadDir(cache)
+	require.NoError(t, err)
+	require.NotEmpty(t, left)
+	require.Less(t, len(left), 10)
+
+	si, err = index.StorageFindSector(ctx, sid, storiface.FTCache, 0, false)
+	require.NoError(t, err)
+	require.Len(t, si, 1)
+	require.True(t, si[0].Primary)
+
+	// removals which aren't abortable ignore cancellation
+	require.NoError(t, st.Remove(cctx, sid, storiface.FTCache, true))
+	_, err = os.Stat(cache)
+	require.True(t, os.IsNotExist(err))
+
+	si, err = index.StorageFindSector(ctx, sid, storiface.FTCache, 0, false)
+	require.NoError(t, err)
+	require.Empty(t, si)
+}
+
+// cancelAfter is a context which gets cancelled after n calls to Err
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	c.n--
+	if c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestLocalAllocPolicy(t *testing.T) {
+	ctx := context.TODO()
+
+	root := t.TempDir()
+	tstor := &TestingLocalStorage{
+		root: root,
+	}
+
+	for _, sub := range []string{"1", "2", "3"} {
+		require.NoError(t, tstor.init(sub))
+		tstor.c.StoragePaths = append(tstor.c.StoragePaths, LocalPath{Path: filepath.Join(root, sub)})
+	}
+
+	index := NewIndex()
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	paths, err := st.Local(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 3)
+
+	byDir := map[string]ID{}
+	for _, p := range paths {
+		byDir[filepath.Base(p.LocalPath)] = p.ID
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg8MiBV1,
+	}
+	alloc := func(ap AllocPolicy) ID {
+		_, ids, err := st.AcquireSector(WithAllocPolicy(ctx, ap), sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing, storiface.AcquireMove)
+		require.NoError(t, err)
+		return ID(ids.Unsealed)
+	}
+
+	require.Equal(t, byDir["2"], alloc(AllocPolicy{Paths: []ID{byDir["2"], byDir["1"]}}))
+	require.Equal(t, byDir["1"], alloc(AllocPolicy{Paths: []ID{"missing", byDir["1"]}}))
+
+	require.NoError(t, index.StorageDeclareSector(ctx, byDir["3"], sector.ID, storiface.FTSealed, true))
+	require.Equal(t, byDir["3"], alloc(AllocPolicy{NearSealed: true, Paths: []ID{byDir["2"]}}))
+
+	// preferred paths need space for the whole file
+	ids := storiface.SectorPaths{ID: sector.ID, Sealed: string(byDir["3"])}
+	release, err := st.Reserve(ctx, sector, storiface.FTSealed, ids, map[storiface.SectorFileType]int{storiface.FTSealed: storiface.FSOverheadDen * 3 / 2})
+	require.NoError(t, err)
+	require.Equal(t, byDir["2"], alloc(AllocPolicy{NearSealed: true, Paths: []ID{byDir["2"]}}))
+
+	release()
+	require.Equal(t, byDir["3"], alloc(AllocPolicy{NearSealed: true, Paths: []ID{byDir["2"]}}))
+}
+
+func TestLocalAllocGroup(t *testing.T) {
+	ctx := context.TODO()
+
+	root := t.TempDir()
+	tstor := &TestingLocalStorage{
+		root: root,
+	}
+
+	ids := map[string]ID{}
+	for _, dir := range []string{"nvme", "hdd"} {
+		meta := &LocalStorageMeta{
+			ID:       ID(uuid.New().String()),
+			Weight:   1,
+			CanSeal:  true,
+			CanStore: true,
+			Groups:   []string{dir},
+		}
+		if dir == "hdd" {
+			meta.Weight = 10 // picked without a group
+		}
+		ids[dir] = meta.ID
+
+		mb, err := json.Marshal(meta)
+		require.NoError(t, err)
+		require.NoError(t, os.Mkdir(filepath.Join(root, dir), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, dir, MetaFile), mb, 0644))
+		tstor.c.StoragePaths = append(tstor.c.StoragePaths, LocalPath{Path: filepath.Join(root, dir)})
+	}
+
+	index := NewIndex()
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string][]ID{"nvme": {ids["nvme"]}, "hdd": {ids["hdd"]}}, st.Groups())
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg8MiBV1,
+	}
+	alloc := func(ctx context.Context) (ID, error) {
+		_, sids, err := st.AcquireSector(ctx, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing, storiface.AcquireMove)
+		return ID(sids.Unsealed), err
+	}
+
+	id, err := alloc(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ids["hdd"], id)
+
+	id, err = alloc(WithAllocGroup(ctx, "nvme"))
+	require.NoError(t, err)
+	require.Equal(t, ids["nvme"], id)
+
+	// preferences don't lead out of the group
+	id, err = alloc(WithAllocPolicy(WithAllocGroup(ctx, "nvme"), AllocPolicy{Paths: []ID{ids["hdd"]}}))
+	require.NoError(t, err)
+	require.Equal(t, ids["nvme"], id)
+
+	_, err = alloc(WithAllocGroup(ctx, "missing"))
+	require.Error(t, err)
+}
+
+func TestLocalMoveStorageFull(t *testing.T) {
+	ctx := context.TODO()
+
+	root := t.TempDir()
+	tstor := &TestingLocalStorage{
+		root: root,
+	}
+
+	ids := map[string]ID{}
+	for _, dir := range []string{"seal", "a", "b", "c"} {
+		meta := &LocalStorageMeta{
+			ID:       ID(uuid.New().String()),
+			Weight:   1,
+			CanSeal:  dir == "seal",
+			CanStore: dir != "seal",
+		}
+		if dir == "a" {
+			meta.Weight = 10 // picked first
+		}
+		ids[dir] = meta.ID
+
+		mb, err := json.Marshal(meta)
+		require.NoError(t, err)
+		require.NoError(t, os.Mkdir(filepath.Join(root, dir), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, dir, MetaFile), mb, 0644))
+		tstor.c.StoragePaths = append(tstor.c.StoragePaths, LocalPath{Path: filepath.Join(root, dir)})
+	}
+
+	index := NewIndex()
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	full := map[string]bool{}
+	moveFile = func(ctx context.Context, from, to string) error {
+		dir := filepath.Base(filepath.Dir(filepath.Dir(to)))
+		if full[dir] {
+			return xerrors.Errorf("move: %w", &os.PathError{Op: "write", Path: to, Err: syscall.ENOSPC})
+		}
+		return move(ctx, from, to)
+	}
+	defer func() {
+		moveFile = move
+	}()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg8MiBV1,
+	}
+	src := filepath.Join(root, "seal", storiface.FTSealed.String(), storiface.SectorName(sector.ID))
+	require.NoError(t, ioutil.WriteFile(src, []byte("sealed"), 0644))
+	require.NoError(t, index.StorageDeclareSector(ctx, ids["seal"], sector.ID, storiface.FTSealed, true))
+
+	requireIn := func(dir string) {
+		si, err := index.StorageFindSector(ctx, sector.ID, storiface.FTSealed, 0, false)
+		require.NoError(t, err)
+		require.Len(t, si, 1)
+		require.Equal(t, ids[dir], si[0].ID)
+		require.FileExists(t, filepath.Join(root, dir, storiface.FTSealed.String(), storiface.SectorName(sector.ID)))
+	}
+
+	requireFull := func(err error) {
+		var cerr *storiface.CallError
+		require.True(t, xerrors.As(err, &cerr))
+		require.Equal(t, storiface.ErrTempAllocateSpace, cerr.Code)
+	}
+
+	// by default the move fails, leaving the source in place
+	full["a"] = true
+	requireFull(st.MoveStorage(ctx, sector, storiface.FTSealed))
+	requireIn("seal")
+
+	// alternates limited to the configured paths
+	full["c"] = true
+	requireFull(st.MoveStorage(WithMoveFullPolicy(ctx, MoveFullPolicy{Alternates: 2, Paths: []ID{ids["c"]}}), sector, storiface.FTSealed))
+	requireIn("seal")
+
+	// and to the number of alternates
+	full["c"] = false
+	full["b"] = true
+	requireFull(st.MoveStorage(WithMoveFullPolicy(ctx, MoveFullPolicy{Alternates: 1, Paths: []ID{ids["b"], ids["c"]}}), sector, storiface.FTSealed))
+	requireIn("seal")
+
+	require.NoError(t, st.MoveStorage(WithMoveFullPolicy(ctx, MoveFullPolicy{Alternates: 1, Paths: []ID{ids["c"]}}), sector, storiface.FTSealed))
+	requireIn("c")
+	require.NoFileExists(t, src)
+}
+
+func TestReserveMetrics(t *testing.T) {
+	ctx := context.TODO()
+
+	require.NoError(t, view.Register(DefaultViews...))
+	defer view.Unregister(DefaultViews...)
+
+	root := t.TempDir()
+	tstor := &TestingLocalStorage{
+		root: root,
+	}
+	require.NoError(t, tstor.init("1"))
+	tstor.c.StoragePaths = append(tstor.c.StoragePaths, LocalPath{Path: filepath.Join(root, "1")})
+
+	st, err := NewLocal(ctx, tstor, NewIndex(), nil)
+	require.NoError(t, err)
+
+	paths, err := st.Local(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg8MiBV1,
+	}
+	reserve := func(id ID, overhead int) error {
+		ids := storiface.SectorPaths{ID: sector.ID, Sealed: string(id)}
+		release, err := st.Reserve(ctx, sector, storiface.FTSealed, ids, map[storiface.SectorFileType]int{storiface.FTSealed: overhead})
+		if err == nil {
+			release()
+		}
+		return err
+	}
+
+	require.NoError(t, reserve(paths[0].ID, storiface.FSOverheadDen))
+	require.Error(t, reserve(paths[0].ID, storiface.FSOverheadDen*3))
+	require.Error(t, reserve("missing", storiface.FSOverheadDen))
+
+	count := func(v *view.View) map[string]int64 {
+		rows, err := view.RetrieveData(v.Name)
+		require.NoError(t, err)
+
+		out := map[string]int64{}
+		for _, row := range rows {
+			reason := ""
+			for _, tg := range row.Tags {
+				reason = tg.Value
+			}
+			out[reason] = row.Data.(*view.CountData).Value
+		}
+		return out
+	}
+
+	require.Equal(t, map[string]int64{"": 3}, count(ReserveAttemptsView))
+	require.Equal(t, map[string]int64{"": 1}, count(ReserveSuccessView))
+	require.Equal(t, map[string]int64{
+		ReserveFailNoSpace:     1,
+		ReserveFailPathOffline: 1,
+	}, count(ReserveFailureView))
+}
+
+func TestLocalPathPreference(t *testing.T) {
+	ctx := context.TODO()
+
+	root := t.TempDir()
+	tstor := &TestingLocalStorage{
+		root: root,
+	}
+
+	for _, sub := range []string{"1", "2", "3"} {
+		require.NoError(t, tstor.init(sub))
+		tstor.c.StoragePaths = append(tstor.c.StoragePaths, LocalPath{Path: filepath.Join(root, sub)})
+	}
+
+	index := NewIndex()
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	paths, err := st.Local(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 3)
+
+	byDir := map[string]ID{}
+	for _, p := range paths {
+		byDir[filepath.Base(p.LocalPath)] = p.ID
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg8MiBV1,
+	}
+
+	prefer := func(dir string) PathPreference {
+		return func(ctx context.Context, sid abi.SectorID, fileType storiface.SectorFileType, existing bool, candidates []PathCandidate) []PathCandidate {
+			for _, c := range candidates {
+				if c.ID == byDir[dir] {
+					return []PathCandidate{c}
+				}
+			}
+			return nil
+		}
+	}
+
+	alloc := func(pref PathPreference) ID {
+		_, ids, err := st.AcquireSector(WithPathPreference(ctx, pref), sector, storiface.FTNone, storiface.FTSealed, storiface.PathSealing, storiface.AcquireMove)
+		require.NoError(t, err)
+		return ID(ids.Sealed)
+	}
+
+	require.Equal(t, byDir["2"], alloc(prefer("2")))
+	require.Equal(t, byDir["3"], alloc(prefer("3")))
+
+	// no preferred candidates keeps the default order
+	require.NotEmpty(t, alloc(prefer("missing")))
+
+	ids := storiface.SectorPaths{ID: sector.ID, Sealed: string(byDir["1"])}
+	release1, err := st.Reserve(ctx, sector, storiface.FTSealed, ids, map[storiface.SectorFileType]int{storiface.FTSealed: storiface.FSOverheadDen})
+	require.NoError(t, err)
+	defer release1()
+	ids.Sealed = string(byDir["3"])
+	release3, err := st.Reserve(ctx, sector, storiface.FTSealed, ids, map[storiface.SectorFileType]int{storiface.FTSealed: storiface.FSOverheadDen / 2})
+	require.NoError(t, err)
+	defer release3()
+
+	require.Equal(t, byDir["2"], alloc(PreferMostAvailable))
+
+	// the preference is also consulted for existing copies
+	for _, dir := range []string{"1", "3"} {
+		require.NoError(t, index.StorageDeclareSector(ctx, byDir[dir], sector.ID, storiface.FTCache, false))
+	}
+
+	existing := func(pref PathPreference) ID {
+		_, ids, err := st.AcquireSector(WithPathPreference(ctx, pref), sector, storiface.FTCache, storiface.FTNone, storiface.PathSealing, storiface.AcquireMove)
+		require.NoError(t, err)
+		return ID(ids.Cache)
+	}
+
+	require.Equal(t, byDir["1"], existing(prefer("1")))
+	require.Equal(t, byDir["3"], existing(prefer("3")))
+	require.Equal(t, byDir["3"], existing(PreferMostAvailable))
+}
+
+func TestLocalAcquireSectorCopies(t *testing.T) {
+	ctx := context.TODO()
+
+	root := t.TempDir()
+	tstor := &TestingLocalStorage{
+		root: root,
+	}
+
+	for _, sub := range []string{"1", "2", "3"} {
+		require.NoError(t, tstor.init(sub))
+		tstor.c.StoragePaths = append(tstor.c.StoragePaths, LocalPath{Path: filepath.Join(root, sub)})
+	}
+
+	index := NewIndex()
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	paths, err := st.Local(ctx)
+	require.NoError(t, err)
+
+	byDir := map[string]ID{}
+	for _, p := range paths {
+		byDir[filepath.Base(p.LocalPath)] = p.ID
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg8MiBV1,
+	}
+
+	for _, dir := range []string{"1", "3"} {
+		require.NoError(t, index.StorageDeclareSector(ctx, byDir[dir], sector.ID, storiface.FTSealed, true))
+	}
+
+	spaths, ids, copies, err := st.AcquireSectorCopies(ctx, sector, storiface.FTSealed, storiface.FTUnsealed, storiface.PathSealing, storiface.AcquireMove)
+	require.NoError(t, err)
+	require.NotEmpty(t, spaths.Sealed)
+
+	// the used copy is one of all copies
+	require.ElementsMatch(t, []string{string(byDir["1"]), string(byDir["3"])}, copies.ByType(storiface.FTSealed))
+	require.Contains(t, copies.ByType(storiface.FTSealed), ids.Sealed)
+	require.Equal(t, []string{ids.Unsealed}, copies.ByType(storiface.FTUnsealed))
+}
@@ -0,0 +1,65 @@
+package stores
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// Reasons for failed storage reservations, values of the ReserveFailureReason
+// tag
+const (
+	ReserveFailNoSpace     = "no_space"
+	ReserveFailPathOffline = "path_offline"
+	ReserveFailOther       = "other"
+)
+
+var (
+	ReserveFailureReason, _ = tag.NewKey("reason")
+)
+
+var (
+	ReserveAttempts = stats.Int64("stores/reserve_attempts", "Counter for storage space reservation attempts", stats.UnitDimensionless)
+	ReserveSuccess  = stats.Int64("stores/reserve_success", "Counter for successful storage space reservations", stats.UnitDimensionless)
+	ReserveFailure  = stats.Int64("stores/reserve_failure", "Counter for failed storage space reservations", stats.UnitDimensionless)
+)
+
+var (
+	ReserveAttemptsView = &view.View{
+		Measure:     ReserveAttempts,
+		Aggregation: view.Count(),
+	}
+	ReserveSuccessView = &view.View{
+		Measure:     ReserveSuccess,
+		Aggregation: view.Count(),
+	}
+	ReserveFailureView = &view.View{
+		Measure:     ReserveFailure,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{ReserveFailureReason},
+	}
+)
+
+// DefaultViews are the OpenCensus views of storage metrics
+var DefaultViews = []*view.View{
+	ReserveAttemptsView,
+	ReserveSuccessView,
+	ReserveFailureView,
+}
+
+func recordReserve(ctx context.Context, reason string, err error) {
+	stats.Record(ctx, ReserveAttempts.M(1))
+
+	if err == nil {
+		stats.Record(ctx, ReserveSuccess.M(1))
+		return
+	}
+
+	ctx, terr := tag.New(ctx, tag.Upsert(ReserveFailureReason, reason))
+	if terr != nil {
+		log.Warnf("tagging reservation failure: %+v", terr)
+	}
+	stats.Record(ctx, ReserveFailure.M(1))
+}
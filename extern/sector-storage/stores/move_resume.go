@@ -0,0 +1,236 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+)
+
+const moveCopyBufSize = 1 << 20
+
+// copyResumable copies a sector file or directory into a staging path next to
+// `to` (in the FetchTempSubdir, which isn't scanned for sectors), and renames
+// it into place once all data is copied. If a previous copy was interrupted,
+// data already present in the staging path is verified and reused. The copy
+// stops when ctx is cancelled.
+func copyResumable(ctx context.Context, from, to string) error {
+	staging, err := tempFetchDest(to, true)
+	if err != nil {
+		return err
+	}
+
+	st, err := os.Stat(from)
+	if err != nil {
+		return xerrors.Errorf("stat source: %w", err)
+	}
+
+	if st.IsDir() {
+		err = copyDirResumable(ctx, from, staging)
+	} else {
+		if sst, serr := os.Stat(staging); serr == nil && sst.IsDir() {
+			log.Warnw("inconsistent move state, copying from scratch", "staging", staging)
+			if err := os.RemoveAll(staging); err != nil {
+				return xerrors.Errorf("removing staging dir: %w", err)
+			}
+		}
+
+		err = copyFileResumable(ctx, from, staging, st)
+	}
+	if err != nil {
+		return xerrors.Errorf("copying %s to %s: %w", from, staging, err)
+	}
+
+	if err := os.Chtimes(staging, st.ModTime(), st.ModTime()); err != nil {
+		return xerrors.Errorf("setting staged copy times: %w", err)
+	}
+
+	if err := os.Rename(staging, to); err != nil {
+		return xerrors.Errorf("renaming staged copy: %w", err)
+	}
+
+	return nil
+}
+
+func copyDirResumable(ctx context.Context, from, to string) error {
+	if st, err := os.Stat(to); err == nil && !st.IsDir() {
+		log.Warnw("inconsistent move state, copying from scratch", "staging", to)
+		if err := os.Remove(to); err != nil {
+			return xerrors.Errorf("removing staging file: %w", err)
+		}
+	}
+
+	type dirTimes struct {
+		path string
+		info os.FileInfo
+	}
+	var dirs []dirTimes
+
+	err := filepath.Walk(from, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(from, p)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(to, rel)
+
+		if info.IsDir() {
+			dirs = append(dirs, dirTimes{dest, info})
+			return os.MkdirAll(dest, info.Mode().Perm()|0700) // nolint
+		}
+
+		return copyFileResumable(ctx, p, dest, info)
+	})
+	if err != nil {
+		return err
+	}
+
+	// creating entries changes directory mtimes, so set them bottom-up once
+	// everything is copied
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirs[i].path, dirs[i].info.ModTime(), dirs[i].info.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyFileResumable copies `from` into `to`, continuing after the part of `to`
+// which matches the source. The modification time of the source is preserved.
+func copyFileResumable(ctx context.Context, from, to string, srcInfo os.FileInfo) error {
+	src, err := os.Open(from) // nolint
+	if err != nil {
+		return err
+	}
+	defer src.Close() // nolint
+
+	offset, err := resumeOffset(src, srcInfo.Size(), to)
+	if err != nil {
+		return err
+	}
+
+	if offset < srcInfo.Size() || srcInfo.Size() == 0 {
+		if offset > 0 {
+			log.Infow("resuming interrupted move", "file", to, "copied", offset, "size", srcInfo.Size())
+		}
+
+		dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE, srcInfo.Mode().Perm()) // nolint
+		if err != nil {
+			return err
+		}
+
+		if err := copyFrom(ctx, dst, src, offset, srcInfo.Size()); err != nil {
+			_ = dst.Close()
+			return err
+		}
+
+		if err := dst.Close(); err != nil {
+			return err
+		}
+	}
+
+	return os.Chtimes(to, srcInfo.ModTime(), srcInfo.ModTime())
+}
+
+// resumeOffset returns the length of the prefix of `to` matching the source,
+// rounded down to whole copy blocks. All of the copied data is compared, as an
+// interrupted copy may have left garbage anywhere in the file.
+func resumeOffset(src *os.File, size int64, to string) (int64, error) {
+	st, err := os.Stat(to)
+	switch {
+	case os.IsNotExist(err):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	case st.Size() > size:
+		log.Warnw("inconsistent move state, copying from scratch", "file", to, "copied", st.Size(), "size", size)
+		return 0, nil
+	}
+
+	dst, err := os.Open(to) // nolint
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close() // nolint
+
+	sbuf := make([]byte, moveCopyBufSize)
+	dbuf := make([]byte, moveCopyBufSize)
+
+	var pos int64
+	for pos < st.Size() {
+		n := st.Size() - pos
+		if n > moveCopyBufSize {
+			n = moveCopyBufSize
+		}
+
+		if _, err := src.ReadAt(sbuf[:n], pos); err != nil && err != io.EOF {
+			return 0, xerrors.Errorf("reading source: %w", err)
+		}
+		if _, err := dst.ReadAt(dbuf[:n], pos); err != nil && err != io.EOF {
+			return 0, xerrors.Errorf("reading copied data: %w", err)
+		}
+
+		if !bytes.Equal(sbuf[:n], dbuf[:n]) {
+			log.Warnw("copied data doesn't match source, resuming from last matching block", "file", to, "offset", pos, "copied", st.Size())
+			break
+		}
+
+		pos += n
+	}
+
+	return pos, nil
+}
+
+// copyFrom copies src into dst starting at offset. Zeroed blocks are skipped
+// so that sparse files (like unsealed sectors) stay sparse. ctx is checked
+// between blocks.
+func copyFrom(ctx context.Context, dst *os.File, src *os.File, offset, size int64) error {
+	if err := dst.Truncate(offset); err != nil {
+		return err
+	}
+
+	buf := make([]byte, moveCopyBufSize)
+	for pos := offset; pos < size; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := src.ReadAt(buf, pos)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			return xerrors.Errorf("source truncated at %d, expected %d bytes", pos, size)
+		}
+
+		if !isZero(buf[:n]) {
+			if _, err := dst.WriteAt(buf[:n], pos); err != nil {
+				return err
+			}
+		}
+
+		pos += int64(n)
+	}
+
+	if err := dst.Truncate(size); err != nil {
+		return err
+	}
+
+	return dst.Sync()
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package stores
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestCopyResumable(t *testing.T) {
+	root, err := ioutil.TempDir("", "lotus-move-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(root) // nolint
+
+	srcDir := filepath.Join(root, "src", "s-t01000-1")
+	require.NoError(t, os.MkdirAll(srcDir, 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dst"), 0755))
+
+	data := map[string][]byte{}
+	for _, name := range []string{"p_aux", "t_aux", "sc-02-data-tree-r-last.dat", "zeros"} {
+		b := make([]byte, 3*moveCopyBufSize+123)
+		if name != "zeros" {
+			_, _ = rand.New(rand.NewSource(int64(len(name)))).Read(b)
+		}
+
+		data[name] = b
+		require.NoError(t, ioutil.WriteFile(filepath.Join(srcDir, name), b, 0644))
+	}
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for name := range data {
+		require.NoError(t, os.Chtimes(filepath.Join(srcDir, name), mtime, mtime))
+	}
+	require.NoError(t, os.Chtimes(srcDir, mtime, mtime))
+
+	to := filepath.Join(root, "dst", "s-t01000-1")
+	staging, err := tempFetchDest(to, true)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(staging, 0755))
+
+	// a fully copied file, a partially copied one, and broken partial copies,
+	// at the end and at the start
+	require.NoError(t, ioutil.WriteFile(filepath.Join(staging, "p_aux"), data["p_aux"], 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(staging, "t_aux"), data["t_aux"][:moveCopyBufSize+7], 0644))
+
+	broken := append([]byte{}, data["sc-02-data-tree-r-last.dat"][:2*moveCopyBufSize]...)
+	broken[len(broken)-1]++
+	require.NoError(t, ioutil.WriteFile(filepath.Join(staging, "sc-02-data-tree-r-last.dat"), broken, 0644))
+
+	brokenStart := make([]byte, 2*moveCopyBufSize+5)
+	brokenStart[0] = 1
+	require.NoError(t, ioutil.WriteFile(filepath.Join(staging, "zeros"), brokenStart, 0644))
+
+	require.NoError(t, copyResumable(context.TODO(), srcDir, to))
+
+	for name, b := range data {
+		got, err := ioutil.ReadFile(filepath.Join(to, name))
+		require.NoError(t, err)
+		require.Equal(t, b, got, name)
+
+		st, err := os.Stat(filepath.Join(to, name))
+		require.NoError(t, err)
+		require.True(t, mtime.Equal(st.ModTime()), name)
+	}
+
+	st, err := os.Stat(to)
+	require.NoError(t, err)
+	require.True(t, mtime.Equal(st.ModTime()))
+
+	_, err = os.Stat(staging)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestCopyResumableEmpty(t *testing.T) {
+	root, err := ioutil.TempDir("", "lotus-move-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(root) // nolint
+
+	from := filepath.Join(root, "src", "s-t01000-1")
+	require.NoError(t, os.MkdirAll(filepath.Dir(from), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dst"), 0755))
+	require.NoError(t, ioutil.WriteFile(from, nil, 0644))
+
+	to := filepath.Join(root, "dst", "s-t01000-1")
+	require.NoError(t, copyResumable(context.TODO(), from, to))
+
+	st, err := os.Stat(to)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), st.Size())
+}
+
+func TestMoveCopyCancel(t *testing.T) {
+	root := t.TempDir()
+
+	from := filepath.Join(root, "src", "s-t01000-1")
+	to := filepath.Join(root, "dst", "s-t01000-1")
+	require.NoError(t, os.MkdirAll(from, 0755))
+	require.NoError(t, os.MkdirAll(filepath.Dir(to), 0755))
+
+	b := make([]byte, 3*moveCopyBufSize)
+	_, _ = rand.New(rand.NewSource(1)).Read(b)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(from, "p_aux"), b, 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := moveCopy(ctx, from, to)
+	require.True(t, xerrors.Is(err, context.Canceled), err)
+
+	// the partial copy is removed, the source stays in place
+	staging, err := tempFetchDest(to, false)
+	require.NoError(t, err)
+	require.NoDirExists(t, staging)
+	require.NoDirExists(t, to)
+	require.FileExists(t, filepath.Join(from, "p_aux"))
+
+	require.NoError(t, moveCopy(context.Background(), from, to))
+	got, err := ioutil.ReadFile(filepath.Join(to, "p_aux"))
+	require.NoError(t, err)
+	require.Equal(t, b, got)
+	require.NoDirExists(t, from)
+}
@@ -3,6 +3,8 @@ package stores
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/bits"
 	"mime"
@@ -12,7 +14,9 @@ import (
 	gopath "path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
 	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
@@ -22,21 +26,50 @@ import (
 	"github.com/filecoin-project/specs-storage/storage"
 
 	"github.com/hashicorp/go-multierror"
-	files "github.com/ipfs/go-ipfs-files"
 	"golang.org/x/xerrors"
 )
 
 var FetchTempSubdir = "fetching"
 
+// FetchConfig configures how sector files are fetched from other storage paths
+type FetchConfig struct {
+	// ChunkSize is the size of ranges requested when fetching sector files, 0
+	// fetches whole files with a single request
+	ChunkSize int64
+
+	// Retries is how many times a failed fetch is retried, resuming from the
+	// last byte written
+	Retries int
+
+	RetryWait time.Duration
+
+	// SourceLimit is how many fetches from one source, identified by the
+	// host of its URL, can run at the same time. Further fetches from the
+	// source wait. 0 means only the overall fetch limit applies.
+	SourceLimit int
+}
+
+func DefaultFetchConfig() FetchConfig {
+	return FetchConfig{
+		ChunkSize: 1 << 30,
+		Retries:   5,
+		RetryWait: 5 * time.Second,
+	}
+}
+
 type Remote struct {
 	local *Local
 	index SectorIndex
 	auth  http.Header
 
-	limit chan struct{}
+	limit    chan struct{}
+	fetchCfg FetchConfig
 
 	fetchLk  sync.Mutex
 	fetching map[abi.SectorID]chan struct{}
+
+	sourceLk     sync.Mutex
+	sourceLimits map[string]chan struct{}
 }
 
 func (r *Remote) RemoveCopies(ctx context.Context, s abi.SectorID, types storiface.SectorFileType) error {
@@ -47,53 +80,41 @@ func (r *Remote) RemoveCopies(ctx context.Context, s abi.SectorID, types storifa
 	return r.local.RemoveCopies(ctx, s, types)
 }
 
-func NewRemote(local *Local, index SectorIndex, auth http.Header, fetchLimit int) *Remote {
+func NewRemote(local *Local, index SectorIndex, auth http.Header, fetchLimit int, fcfg FetchConfig) *Remote {
 	return &Remote{
 		local: local,
 		index: index,
 		auth:  auth,
 
-		limit: make(chan struct{}, fetchLimit),
+		limit:    make(chan struct{}, fetchLimit),
+		fetchCfg: fcfg,
 
-		fetching: map[abi.SectorID]chan struct{}{},
+		fetching:     map[abi.SectorID]chan struct{}{},
+		sourceLimits: map[string]chan struct{}{},
 	}
 }
 
 func (r *Remote) AcquireSector(ctx context.Context, s storage.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, pathType storiface.PathType, op storiface.AcquireMode) (storiface.SectorPaths, storiface.SectorPaths, error) {
+	paths, stores, _, err := r.AcquireSectorCopies(ctx, s, existing, allocate, pathType, op)
+	return paths, stores, err
+}
+
+// AcquireSectorCopies is AcquireSector, also returning all local paths holding
+// the acquired file types, including the ones files were fetched into
+func (r *Remote) AcquireSectorCopies(ctx context.Context, s storage.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, pathType storiface.PathType, op storiface.AcquireMode) (storiface.SectorPaths, storiface.SectorPaths, storiface.SectorStorageIDs, error) {
 	if existing|allocate != existing^allocate {
-		return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.New("can't both find and allocate a sector")
+		return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, xerrors.New("can't both find and allocate a sector")
 	}
 
-	for {
-		r.fetchLk.Lock()
-
-		c, locked := r.fetching[s.ID]
-		if !locked {
-			r.fetching[s.ID] = make(chan struct{})
-			r.fetchLk.Unlock()
-			break
-		}
-
-		r.fetchLk.Unlock()
-
-		select {
-		case <-c:
-			continue
-		case <-ctx.Done():
-			return storiface.SectorPaths{}, storiface.SectorPaths{}, ctx.Err()
-		}
+	unlock, err := r.lockFetch(ctx, s.ID)
+	if err != nil {
+		return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, err
 	}
+	defer unlock()
 
-	defer func() {
-		r.fetchLk.Lock()
-		close(r.fetching[s.ID])
-		delete(r.fetching, s.ID)
-		r.fetchLk.Unlock()
-	}()
-
-	paths, stores, err := r.local.AcquireSector(ctx, s, existing, allocate, pathType, op)
+	paths, stores, copies, err := r.local.AcquireSectorCopies(ctx, s, existing, allocate, pathType, op)
 	if err != nil {
-		return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.Errorf("local acquire error: %w", err)
+		return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, xerrors.Errorf("local acquire error: %w", err)
 	}
 
 	var toFetch storiface.SectorFileType
@@ -109,7 +130,7 @@ func (r *Remote) AcquireSector(ctx context.Context, s storage.SectorRef, existin
 
 	apaths, ids, err := r.local.AcquireSector(ctx, s, storiface.FTNone, toFetch, pathType, op)
 	if err != nil {
-		return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.Errorf("allocate local sector for fetching: %w", err)
+		return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, xerrors.Errorf("allocate local sector for fetching: %w", err)
 	}
 
 	odt := storiface.FSOverheadSeal
@@ -119,7 +140,7 @@ func (r *Remote) AcquireSector(ctx context.Context, s storage.SectorRef, existin
 
 	releaseStorage, err := r.local.Reserve(ctx, s, toFetch, ids, odt)
 	if err != nil {
-		return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.Errorf("reserving storage space: %w", err)
+		return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, xerrors.Errorf("reserving storage space: %w", err)
 	}
 	defer releaseStorage()
 
@@ -135,13 +156,14 @@ func (r *Remote) AcquireSector(ctx context.Context, s storage.SectorRef, existin
 		dest := storiface.PathByType(apaths, fileType)
 		storageID := storiface.PathByType(ids, fileType)
 
-		url, err := r.acquireFromRemote(ctx, s.ID, fileType, dest)
+		url, err := r.acquireFromRemote(ctx, s.ID, fileType, dest, nil)
 		if err != nil {
-			return storiface.SectorPaths{}, storiface.SectorPaths{}, err
+			return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, err
 		}
 
 		storiface.SetPathByType(&paths, fileType, dest)
 		storiface.SetPathByType(&stores, fileType, storageID)
+		copies.Add(fileType, storageID)
 
 		if err := r.index.StorageDeclareSector(ctx, ID(storageID), s.ID, fileType, op == storiface.AcquireMove); err != nil {
 			log.Warnf("declaring sector %v in %s failed: %+v", s, storageID, err)
@@ -155,7 +177,79 @@ func (r *Remote) AcquireSector(ctx context.Context, s storage.SectorRef, existin
 		}
 	}
 
-	return paths, stores, nil
+	return paths, stores, copies, nil
+}
+
+// lockFetch waits until no other files of the sector are being fetched, the
+// returned func must be called when done fetching
+func (r *Remote) lockFetch(ctx context.Context, sid abi.SectorID) (func(), error) {
+	for {
+		r.fetchLk.Lock()
+
+		c, locked := r.fetching[sid]
+		if !locked {
+			r.fetching[sid] = make(chan struct{})
+			r.fetchLk.Unlock()
+			break
+		}
+
+		r.fetchLk.Unlock()
+
+		select {
+		case <-c:
+			continue
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		r.fetchLk.Lock()
+		close(r.fetching[sid])
+		delete(r.fetching, sid)
+		r.fetchLk.Unlock()
+	}, nil
+}
+
+// FetchFrom fetches a sector file from the storage path source into a newly
+// allocated local path, and declares it there. Unlike AcquireSector, it
+// fetches the file from the given path only, and also when the file was
+// declared in local paths, e.g. when replacing a corrupted local copy.
+func (r *Remote) FetchFrom(ctx context.Context, s storage.SectorRef, fileType storiface.SectorFileType, pathType storiface.PathType, source ID, primary bool) (string, ID, error) {
+	unlock, err := r.lockFetch(ctx, s.ID)
+	if err != nil {
+		return "", "", err
+	}
+	defer unlock()
+
+	apaths, ids, err := r.local.AcquireSector(ctx, s, storiface.FTNone, fileType, pathType, storiface.AcquireMove)
+	if err != nil {
+		return "", "", xerrors.Errorf("allocate local sector for fetching: %w", err)
+	}
+
+	odt := storiface.FSOverheadSeal
+	if pathType == storiface.PathStorage {
+		odt = storiface.FsOverheadFinalized
+	}
+
+	releaseStorage, err := r.local.Reserve(ctx, s, fileType, ids, odt)
+	if err != nil {
+		return "", "", xerrors.Errorf("reserving storage space: %w", err)
+	}
+	defer releaseStorage()
+
+	dest := storiface.PathByType(apaths, fileType)
+	storageID := ID(storiface.PathByType(ids, fileType))
+
+	if _, err := r.acquireFromRemote(ctx, s.ID, fileType, dest, []ID{source}); err != nil {
+		return "", "", err
+	}
+
+	if err := r.index.StorageDeclareSector(ctx, storageID, s.ID, fileType, primary); err != nil {
+		return "", "", xerrors.Errorf("declaring fetched sector file: %w", err)
+	}
+
+	return dest, storageID, nil
 }
 
 func tempFetchDest(spath string, create bool) (string, error) {
@@ -170,12 +264,29 @@ func tempFetchDest(spath string, create bool) (string, error) {
 	return filepath.Join(tempdir, b), nil
 }
 
-func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType storiface.SectorFileType, dest string) (string, error) {
+// acquireFromRemote fetches a sector file from a path holding it, only from
+// the given paths when sources isn't empty
+func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType storiface.SectorFileType, dest string, sources []ID) (string, error) {
 	si, err := r.index.StorageFindSector(ctx, s, fileType, 0, false)
 	if err != nil {
 		return "", err
 	}
 
+	if len(sources) > 0 {
+		allowed := map[ID]bool{}
+		for _, id := range sources {
+			allowed[id] = true
+		}
+
+		filtered := si[:0]
+		for _, info := range si {
+			if allowed[info.ID] {
+				filtered = append(filtered, info)
+			}
+		}
+		si = filtered
+	}
+
 	if len(si) == 0 {
 		return "", xerrors.Errorf("failed to acquire sector %v from remote(%d): %w", s, fileType, storiface.ErrSectorNotFound)
 	}
@@ -204,7 +315,7 @@ func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType
 				continue
 			}
 
-			if err := move(tempDest, dest); err != nil {
+			if err := move(ctx, tempDest, dest); err != nil {
 				return "", xerrors.Errorf("fetch move error (storage %s) %s -> %s: %w", info.ID, tempDest, dest, err)
 			}
 
@@ -218,6 +329,39 @@ func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType
 	return "", xerrors.Errorf("failed to acquire sector %v from remote (tried %v): %w", s, si, merr)
 }
 
+// waitSource waits until fewer than FetchConfig.SourceLimit fetches from the
+// source of the URL are running, the returned func must be called when the
+// fetch is done
+func (r *Remote) waitSource(ctx context.Context, u string) (func(), error) {
+	if r.fetchCfg.SourceLimit <= 0 {
+		return func() {}, nil
+	}
+
+	source := u
+	if pu, err := url.Parse(u); err == nil && pu.Host != "" {
+		source = pu.Host
+	}
+
+	r.sourceLk.Lock()
+	limit, ok := r.sourceLimits[source]
+	if !ok {
+		limit = make(chan struct{}, r.fetchCfg.SourceLimit)
+		r.sourceLimits[source] = limit
+	}
+	r.sourceLk.Unlock()
+
+	if len(limit) >= cap(limit) {
+		log.Infof("Throttling fetch from %s, %d already running", source, len(limit))
+	}
+
+	select {
+	case limit <- struct{}{}:
+		return func() { <-limit }, nil
+	case <-ctx.Done():
+		return nil, xerrors.Errorf("context error while waiting for fetch limiter of %s: %w", source, ctx.Err())
+	}
+}
+
 func (r *Remote) fetch(ctx context.Context, url, outname string) error {
 	log.Infof("Fetch %s -> %s", url, outname)
 
@@ -225,6 +369,14 @@ func (r *Remote) fetch(ctx context.Context, url, outname string) error {
 		log.Infof("Throttling fetch, %d already running", len(r.limit))
 	}
 
+	// the source limit is waited for first, so that fetches waiting for a busy
+	// source don't hold slots of the overall limit
+	release, err := r.waitSource(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// TODO: Smarter throttling
 	//  * Priority (just going sequentially is still pretty good)
 	//  * Per interface
@@ -236,50 +388,257 @@ func (r *Remote) fetch(ctx context.Context, url, outname string) error {
 		return xerrors.Errorf("context error while waiting for fetch limiter: %w", ctx.Err())
 	}
 
+	// continue from data left by an interrupted fetch, file mtimes are set to
+	// the Last-Modified time of the source, which is used to check that the
+	// source didn't change since
+	st := fetchState{size: -1}
+	if fi, err := os.Stat(outname); err == nil && fi.Mode().IsRegular() {
+		st.offset = fi.Size()
+		st.validator = fi.ModTime().UTC().Format(http.TimeFormat)
+		log.Infow("resuming fetch", "url", url, "dest", outname, "offset", st.offset)
+	} else if err := os.RemoveAll(outname); err != nil {
+		return xerrors.Errorf("removing dest: %w", err)
+	}
+
+	var retries int
+	for {
+		prev := st.offset
+		done, err := r.fetchRange(ctx, url, outname, &st)
+		if err == nil {
+			if done {
+				return nil
+			}
+
+			retries = 0
+			continue
+		}
+		if st.offset > prev {
+			// made progress, so this is a new failure
+			retries = 0
+		}
+
+		if ctx.Err() != nil || retries >= r.fetchCfg.Retries {
+			return err
+		}
+		retries++
+
+		log.Warnw("fetch failed, retrying", "url", url, "dest", outname, "offset", st.offset, "retry", retries, "error", err)
+
+		select {
+		case <-time.After(r.fetchCfg.RetryWait):
+		case <-ctx.Done():
+			return xerrors.Errorf("context error while waiting to retry fetch: %w", ctx.Err())
+		}
+	}
+}
+
+// fetchState tracks the progress of a fetch across range requests
+type fetchState struct {
+	offset    int64  // bytes written to the output file
+	size      int64  // total size reported by the source, -1 until known
+	validator string // If-Range value identifying the source version
+}
+
+// restart makes the next request fetch the whole file again
+func (st *fetchState) restart() {
+	*st = fetchState{size: -1}
+}
+
+// update records validators of the source version sent with resp, and sets
+// the output file mtime to Last-Modified of the source
+func (st *fetchState) update(resp *http.Response, outname string) error {
+	etag := resp.Header.Get("ETag")
+	lastMod := resp.Header.Get("Last-Modified")
+
+	switch {
+	case etag != "" && !strings.HasPrefix(etag, "W/"): // If-Range needs strong validators
+		st.validator = etag
+	case lastMod != "":
+		st.validator = lastMod
+	default:
+		st.validator = ""
+	}
+
+	if lastMod == "" {
+		return nil
+	}
+
+	mtime, err := http.ParseTime(lastMod)
+	if err != nil {
+		return xerrors.Errorf("parsing Last-Modified: %w", err)
+	}
+
+	if err := os.Chtimes(outname, mtime, mtime); err != nil {
+		return xerrors.Errorf("setting dest mtime: %w", err)
+	}
+	return nil
+}
+
+// fetchRange fetches the next chunk of data at st.offset, and returns whether
+// the transfer is complete. Sources not supporting range requests, or which
+// changed since the previous request, send the whole file, which is then
+// written from the start.
+func (r *Remote) fetchRange(ctx context.Context, url, outname string, st *fetchState) (bool, error) {
+	if st.offset > 0 && st.validator == "" && st.size < 0 {
+		// no way to tell whether the data we have is from the current version
+		// of the source
+		log.Warnw("can't validate partially fetched data, restarting fetch", "url", url, "dest", outname)
+		st.restart()
+	}
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return xerrors.Errorf("request: %w", err)
+		return false, xerrors.Errorf("request: %w", err)
+	}
+	req.Header = r.auth.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	switch {
+	case r.fetchCfg.ChunkSize > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", st.offset, st.offset+r.fetchCfg.ChunkSize-1))
+	case st.offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", st.offset))
+	}
+	if req.Header.Get("Range") != "" && st.validator != "" {
+		req.Header.Set("If-Range", st.validator)
 	}
-	req.Header = r.auth
 	req = req.WithContext(ctx)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return xerrors.Errorf("do request: %w", err)
+		return false, xerrors.Errorf("do request: %w", err)
 	}
 	defer resp.Body.Close() // nolint
 
-	if resp.StatusCode != 200 {
-		return xerrors.Errorf("non-200 code: %d", resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// full transfer, either ranges aren't supported, the source changed,
+		// or this is a directory
+		mediatype, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil {
+			return false, xerrors.Errorf("parse media type: %w", err)
+		}
+
+		if st.offset > 0 {
+			log.Infow("source sent the whole file, restarting fetch", "url", url, "dest", outname)
+		}
+		st.restart()
+
+		if err := os.RemoveAll(outname); err != nil {
+			return false, xerrors.Errorf("removing dest: %w", err)
+		}
+
+		switch mediatype {
+		case "application/x-tar":
+			return true, tarutil.ExtractTar(resp.Body, outname)
+		case "application/octet-stream":
+			n, err := writeAt(outname, 0, resp.Body)
+			st.offset = n
+			if err != nil {
+				return false, err
+			}
+			if resp.ContentLength >= 0 && n != resp.ContentLength {
+				return false, xerrors.Errorf("short response: got %d bytes, expected %d", n, resp.ContentLength)
+			}
+			return true, st.update(resp, outname)
+		default:
+			return false, xerrors.Errorf("unknown content type: '%s'", mediatype)
+		}
+	case http.StatusPartialContent:
+		start, end, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return false, err
+		}
+		if start != st.offset {
+			return false, xerrors.Errorf("requested range at %d, got %d", st.offset, start)
+		}
+		if end < start || end >= size {
+			return false, xerrors.Errorf("invalid content range %d-%d/%d", start, end, size)
+		}
+		if st.size >= 0 && size != st.size {
+			st.restart()
+			return false, xerrors.Errorf("source size changed from %d to %d, restarting", st.size, size)
+		}
+		st.size = size
+
+		n, err := writeAt(outname, st.offset, resp.Body)
+		st.offset += n
+		if uerr := st.update(resp, outname); err == nil {
+			err = uerr
+		}
+		if err != nil {
+			return false, err
+		}
+		if n != end-start+1 {
+			return false, xerrors.Errorf("short range response: got %d bytes, expected %d", n, end-start+1)
+		}
+
+		return st.offset >= size, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// we may already have the whole file
+		_, _, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && size == st.offset && (st.size < 0 || st.size == size) {
+			// make sure the file exists, the source may be empty
+			if _, err := writeAt(outname, st.offset, http.NoBody); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+
+		prev := st.offset
+		st.restart()
+		return false, xerrors.Errorf("range at %d not satisfiable (size %d), restarting", prev, size)
+	default:
+		return false, xerrors.Errorf("non-200 code: %d", resp.StatusCode)
 	}
+}
 
-	/*bar := pb.New64(w.sizeForType(typ))
-	bar.ShowPercent = true
-	bar.ShowSpeed = true
-	bar.Units = pb.U_BYTES
+// writeAt writes data from r into the file truncated at offset
+func writeAt(outname string, offset int64, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(outname, os.O_WRONLY|os.O_CREATE, 0644) // nolint
+	if err != nil {
+		return 0, xerrors.Errorf("opening dest: %w", err)
+	}
 
-	barreader := bar.NewProxyReader(resp.Body)
+	if err := f.Truncate(offset); err != nil {
+		_ = f.Close()
+		return 0, xerrors.Errorf("truncating dest: %w", err)
+	}
 
-	bar.Start()
-	defer bar.Finish()*/
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return 0, xerrors.Errorf("seeking dest: %w", err)
+	}
 
-	mediatype, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		return xerrors.Errorf("parse media type: %w", err)
+		return n, xerrors.Errorf("writing dest: %w", err)
 	}
 
-	if err := os.RemoveAll(outname); err != nil {
-		return xerrors.Errorf("removing dest: %w", err)
+	return n, nil
+}
+
+// parseContentRange parses 'bytes start-end/size' and 'bytes */size' headers
+func parseContentRange(h string) (start, end, size int64, err error) {
+	if _, err := fmt.Sscanf(h, "bytes */%d", &size); err == nil {
+		return 0, -1, size, nil
 	}
 
-	switch mediatype {
-	case "application/x-tar":
-		return tarutil.ExtractTar(resp.Body, outname)
-	case "application/octet-stream":
-		return files.WriteTo(files.NewReaderFile(resp.Body), outname)
-	default:
-		return xerrors.Errorf("unknown content type: '%s'", mediatype)
+	if _, err := fmt.Sscanf(h, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		return 0, 0, 0, xerrors.Errorf("parsing content range '%s': %w", h, err)
 	}
+
+	return start, end, size, nil
+}
+
+// Reserve reserves space in local storage paths, which is where fetched
+// sectors are placed
+func (r *Remote) Reserve(ctx context.Context, sid storage.SectorRef, ft storiface.SectorFileType, storageIDs storiface.SectorPaths, overheadTab map[storiface.SectorFileType]int) (func(), error) {
+	return r.local.Reserve(ctx, sid, ft, storageIDs, overheadTab)
 }
 
 func (r *Remote) MoveStorage(ctx context.Context, s storage.SectorRef, types storiface.SectorFileType) error {
@@ -405,3 +764,4 @@ func (r *Remote) FsStat(ctx context.Context, id ID) (fsutil.FsStat, error) {
 }
 
 var _ Store = &Remote{}
+var _ Reserver = &Remote{}
@@ -0,0 +1,204 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// source file mtime in tests, partially fetched files with the same mtime are
+// resumed
+var testModTime = time.Unix(1600000000, 0)
+
+func testFetch(t *testing.T, chunk int64, handler http.HandlerFunc, partial []byte, partialMtime time.Time) []byte {
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "lotus-fetch-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint
+
+	out := filepath.Join(dir, "s-t01000-1")
+	if partial != nil {
+		require.NoError(t, ioutil.WriteFile(out, partial, 0644))
+		require.NoError(t, os.Chtimes(out, partialMtime, partialMtime))
+	}
+
+	r := NewRemote(nil, nil, http.Header{}, 1, FetchConfig{
+		ChunkSize: chunk,
+		Retries:   5,
+		RetryWait: time.Millisecond,
+	})
+	require.NoError(t, r.fetch(context.Background(), srv.URL, out))
+
+	got, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	return got
+}
+
+func TestFetchRanges(t *testing.T) {
+	data := make([]byte, 10000)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+
+	var ranges []string
+	got := testFetch(t, 3000, func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+
+		if len(ranges) == 2 {
+			// break the connection in the middle of the second chunk
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 3000-5999/%d", len(data)))
+			w.Header().Set("Content-Length", "3000")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(data[3000:4500])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", testModTime, bytes.NewReader(data))
+	}, nil, time.Time{})
+
+	require.Equal(t, data, got)
+	require.Equal(t, []string{
+		"bytes=0-2999",
+		"bytes=3000-5999",
+		"bytes=4500-7499",
+		"bytes=7500-10499",
+	}, ranges)
+}
+
+func TestFetchResume(t *testing.T) {
+	data := make([]byte, 10000)
+	_, _ = rand.New(rand.NewSource(2)).Read(data)
+
+	var ranges []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", testModTime, bytes.NewReader(data))
+	}
+
+	got := testFetch(t, 0, handler, data[:6000], testModTime)
+	require.Equal(t, data, got)
+	require.Equal(t, []string{"bytes=6000-"}, ranges)
+
+	// data fetched from a different version of the source isn't reused
+	stale := make([]byte, 6000)
+	ranges = nil
+
+	got = testFetch(t, 0, handler, stale, testModTime.Add(-time.Hour))
+	require.Equal(t, data, got)
+	require.Equal(t, []string{"bytes=6000-"}, ranges)
+}
+
+func TestFetchSourceSizeChanged(t *testing.T) {
+	data := make([]byte, 10000)
+	_, _ = rand.New(rand.NewSource(4)).Read(data)
+
+	// source without validators, which changes after the first chunk
+	var reqs int
+	got := testFetch(t, 3000, func(w http.ResponseWriter, r *http.Request) {
+		reqs++
+
+		src := data
+		if reqs == 1 {
+			src = data[:8000]
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(src))
+	}, nil, time.Time{})
+
+	require.Equal(t, data, got)
+	// first chunk of the old version, second chunk detecting the change, then
+	// the whole file from the start
+	require.Equal(t, 2+4, reqs)
+}
+
+func TestFetchEmpty(t *testing.T) {
+	got := testFetch(t, 3000, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", testModTime, bytes.NewReader(nil))
+	}, nil, time.Time{})
+
+	require.Empty(t, got)
+}
+
+func TestFetchNoRangeSupport(t *testing.T) {
+	data := make([]byte, 10000)
+	_, _ = rand.New(rand.NewSource(3)).Read(data)
+
+	got := testFetch(t, 3000, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}, data[:1000], testModTime)
+
+	require.Equal(t, data, got)
+}
+
+func TestFetchSourceLimit(t *testing.T) {
+	data := make([]byte, 1000)
+
+	var lk sync.Mutex
+	running := map[string]int{}
+	maxRunning := map[string]int{}
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			lk.Lock()
+			running[name]++
+			if running[name] > maxRunning[name] {
+				maxRunning[name] = running[name]
+			}
+			lk.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+			http.ServeContent(w, r, "", testModTime, bytes.NewReader(data))
+
+			lk.Lock()
+			running[name]--
+			lk.Unlock()
+		}
+	}
+
+	busy := httptest.NewServer(handler("busy"))
+	defer busy.Close()
+	other := httptest.NewServer(handler("other"))
+	defer other.Close()
+
+	dir := t.TempDir()
+	r := NewRemote(nil, nil, http.Header{}, 8, FetchConfig{
+		Retries:     1,
+		RetryWait:   time.Millisecond,
+		SourceLimit: 2,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		for _, srv := range []*httptest.Server{busy, other} {
+			out := filepath.Join(dir, fmt.Sprintf("s-t01000-%d-%d", i, srv.Listener.Addr().(*net.TCPAddr).Port))
+			wg.Add(1)
+			go func(url string) {
+				defer wg.Done()
+				require.NoError(t, r.fetch(context.Background(), url, out))
+			}(srv.URL)
+		}
+	}
+	wg.Wait()
+
+	require.Equal(t, 2, maxRunning["busy"])
+	require.Equal(t, 2, maxRunning["other"])
+}
@@ -0,0 +1,81 @@
+package stores
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type abortableRemoveKey struct{}
+
+// WithAbortableRemove returns a context with which Remove stops removing sector
+// files when the context is cancelled. Files which weren't removed yet stay
+// declared in the index, so they are removed the next time. Without it local
+// files are always removed completely.
+func WithAbortableRemove(ctx context.Context) context.Context {
+	return context.WithValue(ctx, abortableRemoveKey{}, true)
+}
+
+// removeContext returns the context used for removing local files
+func removeContext(ctx context.Context) context.Context {
+	if abortable, _ := ctx.Value(abortableRemoveKey{}).(bool); abortable {
+		return ctx
+	}
+	return context.Background()
+}
+
+// removeAll is like os.RemoveAll, but checks for context cancellation between
+// removing files, so that removing large directories can be aborted
+func removeAll(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if fi.IsDir() {
+		if err := removeDirContents(ctx, path); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func removeDirContents(ctx context.Context, dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close() // nolint
+
+	for {
+		names, err := f.Readdirnames(1024)
+		for _, name := range names {
+			if err := removeAll(ctx, filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -1,16 +1,19 @@
 package stores
 
 import (
-	"bytes"
-	"os/exec"
+	"context"
+	"os"
 	"path/filepath"
-	"strings"
+	"syscall"
 
 	"github.com/mitchellh/go-homedir"
 	"golang.org/x/xerrors"
 )
 
-func move(from, to string) error {
+// move moves a sector file or directory, copying it when it's moved across
+// filesystems. Copies stop when ctx is cancelled, and the partial copy is
+// removed, leaving the source in place.
+func move(ctx context.Context, from, to string) error {
 	from, err := homedir.Expand(from)
 	if err != nil {
 		return xerrors.Errorf("move: expanding from: %w", err)
@@ -27,16 +30,39 @@ func move(from, to string) error {
 
 	log.Debugw("move sector data", "from", from, "to", to)
 
-	toDir := filepath.Dir(to)
+	err = os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+
+	var lerr *os.LinkError
+	if !xerrors.As(err, &lerr) || lerr.Err != syscall.EXDEV {
+		return xerrors.Errorf("move: %w", err)
+	}
 
-	// `mv` has decades of experience in moving files quickly; don't pretend we
-	//  can do better
+	// Moving across filesystems means copying all the data, which for large
+	// sectors can take a long time, so make sure that an interrupted move can
+	// be picked up where it stopped
+	return moveCopy(ctx, from, to)
+}
+
+// moveCopy moves by copying, and then removing the source
+func moveCopy(ctx context.Context, from, to string) error {
+	if err := copyResumable(ctx, from, to); err != nil {
+		if isNoSpace(err) || ctx.Err() != nil {
+			// the copy can't be finished in this filesystem, or was aborted,
+			// don't leave the partial copy taking up space
+			if staging, serr := tempFetchDest(to, false); serr == nil {
+				if rerr := os.RemoveAll(staging); rerr != nil {
+					log.Errorw("removing partial move copy", "path", staging, "error", rerr)
+				}
+			}
+		}
+		return xerrors.Errorf("move: %w", err)
+	}
 
-	var errOut bytes.Buffer
-	cmd := exec.Command("/usr/bin/env", "mv", "-t", toDir, from) // nolint
-	cmd.Stderr = &errOut
-	if err := cmd.Run(); err != nil {
-		return xerrors.Errorf("exec mv (stderr: %s): %w", strings.TrimSpace(errOut.String()), err)
+	if err := os.RemoveAll(from); err != nil {
+		return xerrors.Errorf("move: removing source: %w", err)
 	}
 
 	return nil
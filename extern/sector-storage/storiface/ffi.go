@@ -3,6 +3,8 @@ package storiface
 import (
 	"errors"
 
+	"github.com/ipfs/go-cid"
+
 	"github.com/filecoin-project/go-state-types/abi"
 )
 
@@ -15,3 +17,21 @@ func (i UnpaddedByteIndex) Padded() PaddedByteIndex {
 }
 
 type PaddedByteIndex uint64
+
+// ReplicaUpdateOut holds commitments of a sector after its replica was updated
+// with new deal data (snap-deals)
+type ReplicaUpdateOut struct {
+	NewSealed   cid.Cid
+	NewUnsealed cid.Cid
+}
+
+// FinalizeSectorOut holds bytes the sealed replica and cache of a finalized
+// sector use on disk, summed over all copies local to the worker. Sparse
+// files and filesystem overhead make them differ from nominal sizes.
+type FinalizeSectorOut struct {
+	SealedOnDisk int64
+	CacheOnDisk  int64
+}
+
+type ReplicaVanillaProofs [][]byte
+type ReplicaUpdateProof []byte
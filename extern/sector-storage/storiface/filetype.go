@@ -12,11 +12,58 @@ const (
 	FTUnsealed SectorFileType = 1 << iota
 	FTSealed
 	FTCache
+	FTUpdate
+	FTUpdateCache
 
 	FileTypes = iota
 )
 
-var PathTypes = []SectorFileType{FTUnsealed, FTSealed, FTCache}
+type fileTypeInfo struct {
+	fileType SectorFileType
+	name     string
+	field    func(*SectorPaths) *string
+
+	overheadSeal      int // 10x overheads
+	overheadFinalized int
+}
+
+// fileTypes describes all sector file types handled by the storage subsystem,
+// everything else is derived from this list
+var fileTypes = []fileTypeInfo{
+	{FTUnsealed, "unsealed", func(sps *SectorPaths) *string { return &sps.Unsealed }, FSOverheadDen, FSOverheadDen},
+	{FTSealed, "sealed", func(sps *SectorPaths) *string { return &sps.Sealed }, FSOverheadDen, FSOverheadDen},
+	{FTCache, "cache", func(sps *SectorPaths) *string { return &sps.Cache }, 141, 2}, // 11 layers + D(2x ssize) + C + R
+	{FTUpdate, "update", func(sps *SectorPaths) *string { return &sps.Update }, FSOverheadDen, FSOverheadDen},
+	{FTUpdateCache, "update-cache", func(sps *SectorPaths) *string { return &sps.UpdateCache }, 20, 2}, // D(2x ssize) + R
+}
+
+var PathTypes = func() []SectorFileType {
+	out := make([]SectorFileType, len(fileTypes))
+	for i, info := range fileTypes {
+		out[i] = info.fileType
+	}
+	return out
+}()
+
+var FSOverheadSeal = fileTypesMap(func(info fileTypeInfo) int { return info.overheadSeal })
+
+var FsOverheadFinalized = fileTypesMap(func(info fileTypeInfo) int { return info.overheadFinalized })
+
+var fileTypeInfos = func() map[SectorFileType]fileTypeInfo {
+	out := map[SectorFileType]fileTypeInfo{}
+	for _, info := range fileTypes {
+		out[info.fileType] = info
+	}
+	return out
+}()
+
+func fileTypesMap(get func(fileTypeInfo) int) map[SectorFileType]int {
+	out := map[SectorFileType]int{}
+	for _, info := range fileTypes {
+		out[info.fileType] = get(info)
+	}
+	return out
+}
 
 const (
 	FTNone SectorFileType = 0
@@ -24,31 +71,15 @@ const (
 
 const FSOverheadDen = 10
 
-var FSOverheadSeal = map[SectorFileType]int{ // 10x overheads
-	FTUnsealed: FSOverheadDen,
-	FTSealed:   FSOverheadDen,
-	FTCache:    141, // 11 layers + D(2x ssize) + C + R
-}
-
-var FsOverheadFinalized = map[SectorFileType]int{
-	FTUnsealed: FSOverheadDen,
-	FTSealed:   FSOverheadDen,
-	FTCache:    2,
-}
-
 type SectorFileType int
 
 func (t SectorFileType) String() string {
-	switch t {
-	case FTUnsealed:
-		return "unsealed"
-	case FTSealed:
-		return "sealed"
-	case FTCache:
-		return "cache"
-	default:
+	info, ok := fileTypeInfos[t]
+	if !ok {
 		return fmt.Sprintf("<unknown %d>", t)
 	}
+
+	return info.name
 }
 
 func (t SectorFileType) Has(singleType SectorFileType) bool {
@@ -86,9 +117,11 @@ func (t SectorFileType) All() [FileTypes]bool {
 type SectorPaths struct {
 	ID abi.SectorID
 
-	Unsealed string
-	Sealed   string
-	Cache    string
+	Unsealed    string
+	Sealed      string
+	Cache       string
+	Update      string
+	UpdateCache string
 }
 
 func ParseSectorID(baseName string) (abi.SectorID, error) {
@@ -114,25 +147,38 @@ func SectorName(sid abi.SectorID) string {
 }
 
 func PathByType(sps SectorPaths, fileType SectorFileType) string {
-	switch fileType {
-	case FTUnsealed:
-		return sps.Unsealed
-	case FTSealed:
-		return sps.Sealed
-	case FTCache:
-		return sps.Cache
+	info, ok := fileTypeInfos[fileType]
+	if !ok {
+		panic("requested unknown path type")
 	}
 
-	panic("requested unknown path type")
+	return *info.field(&sps)
 }
 
 func SetPathByType(sps *SectorPaths, fileType SectorFileType, p string) {
-	switch fileType {
-	case FTUnsealed:
-		sps.Unsealed = p
-	case FTSealed:
-		sps.Sealed = p
-	case FTCache:
-		sps.Cache = p
+	info, ok := fileTypeInfos[fileType]
+	if !ok {
+		return
+	}
+
+	*info.field(sps) = p
+}
+
+// SectorStorageIDs lists IDs of all storage paths holding each sector file
+// type, for file types with copies in multiple paths
+type SectorStorageIDs map[SectorFileType][]string
+
+// Add adds a storage path holding a file type, paths are only listed once
+func (s SectorStorageIDs) Add(fileType SectorFileType, id string) {
+	for _, have := range s[fileType] {
+		if have == id {
+			return
+		}
 	}
+	s[fileType] = append(s[fileType], id)
+}
+
+// ByType returns all storage paths holding a file type
+func (s SectorStorageIDs) ByType(fileType SectorFileType) []string {
+	return s[fileType]
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/specs-storage/storage"
@@ -19,7 +20,120 @@ import (
 type WorkerInfo struct {
 	Hostname string
 
+	// Raw capacity of the worker, Resources.MemReserved is memory used by
+	// other processes
 	Resources WorkerResources
+
+	// Resources taken by calls accepted by the worker which didn't finish yet,
+	// running or queued. This includes calls the scheduler may not account for,
+	// e.g. ones started before the manager restarted.
+	Reserved ReservedResources
+
+	// Percentage of the most used resource (CPU, RAM or RAM+swap) which is
+	// taken by tasks running on the worker
+	Load float64
+
+	// IDs of local storage paths which can't be accessed
+	OfflinePaths []string
+
+	// Sum of maximum memory estimates of tasks admitted to run on the worker,
+	// and the soft limit the worker keeps it under, 0 when it has no limit
+	MemProjected uint64
+	MemSoftLimit uint64
+
+	// Capabilities declared by the worker, nil for workers which don't
+	// declare capabilities
+	Capabilities WorkerCapabilities
+
+	// Temperatures and throttling of the worker's CPU and GPUs
+	Thermal ThermalInfo
+}
+
+// Throttle states of a device, ThrottleUnknown when the platform doesn't
+// expose it
+const (
+	ThrottleUnknown = "unknown"
+	ThrottleNone    = "none"
+	ThrottleActive  = "throttled"
+)
+
+// DeviceThermal is the thermal state of a CPU or GPU
+type DeviceThermal struct {
+	Name string
+
+	// Temperature of the hottest sensor of the device, 0 when unknown
+	TempC float64
+
+	Throttle string
+	// Reasons the device is throttled for, when the platform reports them
+	Reasons []string
+	// ThrottleEvents counts throttling events since boot, when the platform
+	// reports them
+	ThrottleEvents uint64
+}
+
+type ThermalInfo struct {
+	CPU  DeviceThermal
+	GPUs []DeviceThermal
+}
+
+// WorkerCapability is a feature of a worker, required by some task types on
+// top of the worker accepting them
+type WorkerCapability string
+
+const (
+	CapSnapDeals     WorkerCapability = "snap-deals"
+	CapPartialUnseal WorkerCapability = "partial-unseal"
+	CapWindowPoSt    WorkerCapability = "window-post"
+)
+
+// AllCapabilities lists known worker capabilities
+var AllCapabilities = []WorkerCapability{CapSnapDeals, CapPartialUnseal, CapWindowPoSt}
+
+// TaskCapabilities are the capabilities a worker must declare to be assigned
+// tasks of a type, task types not listed require none
+var TaskCapabilities = map[sealtasks.TaskType]WorkerCapability{
+	sealtasks.TTReplicaUpdate:       CapSnapDeals,
+	sealtasks.TTProveReplicaUpdate1: CapSnapDeals,
+	sealtasks.TTProveReplicaUpdate2: CapSnapDeals,
+
+	sealtasks.TTUnseal: CapPartialUnseal,
+}
+
+type WorkerCapabilities []WorkerCapability
+
+func (c WorkerCapabilities) Has(capability WorkerCapability) bool {
+	for _, have := range c {
+		if have == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Supports returns whether a worker with the capabilities can be assigned tasks
+// of the type. Workers which don't declare capabilities support all tasks,
+// their accepted task types alone decide what they are assigned.
+func (c WorkerCapabilities) Supports(task sealtasks.TaskType) bool {
+	if c == nil {
+		return true
+	}
+
+	need, ok := TaskCapabilities[task]
+	if !ok {
+		return true
+	}
+	return c.Has(need)
+}
+
+// ParseWorkerCapability returns the known capability with the name
+func ParseWorkerCapability(name string) (WorkerCapability, error) {
+	for _, c := range AllCapabilities {
+		if string(c) == name {
+			return c, nil
+		}
+	}
+	return "", xerrors.Errorf("unknown worker capability %q", name)
 }
 
 type WorkerResources struct {
@@ -32,6 +146,33 @@ type WorkerResources struct {
 	GPUs []string
 }
 
+type ReservedResources struct {
+	MemUsedMin uint64
+	MemUsedMax uint64
+	GpuUsed    bool   // nolint
+	CpuUse     uint64 // nolint
+}
+
+// ProofParams describes proof parameters a worker uses for a seal proof type
+type ProofParams struct {
+	ProofType abi.RegisteredSealProof
+
+	// Version is a hash of the names and CIDs of all parameter files used for
+	// the proof type, workers with the same version use the same parameters
+	Version string
+
+	Files []ProofParamFile
+}
+
+type ProofParamFile struct {
+	Name   string
+	CID    string
+	Digest string
+
+	// Present is false when the file is missing from the parameter cache
+	Present bool
+}
+
 type WorkerStats struct {
 	Info    WorkerInfo
 	Enabled bool
@@ -64,6 +205,106 @@ type WorkerJob struct {
 	Hostname string `json:",omitempty"` // optional, set for ret-wait jobs
 }
 
+// UnsealedPurgePolicy selects which unsealed sector copies a worker may remove
+type UnsealedPurgePolicy struct {
+	// Only purge unsealed files which weren't modified for at least MinAge
+	MinAge time.Duration
+
+	// Unsealed copies of pinned sectors are always kept
+	Pinned []abi.SectorID
+}
+
+// LogLine is a log message recorded for a call
+type LogLine struct {
+	Time    time.Time
+	Level   string
+	Message string
+}
+
+// SectorSummary describes which files of a sector are present in local storage
+type SectorSummary struct {
+	Sector abi.SectorID
+	Types  SectorFileType
+}
+
+// SectorListPage is a page of a sector listing
+type SectorListPage struct {
+	Sectors []SectorSummary
+
+	// Next is the cursor for getting the next page, empty on the last page
+	Next string
+}
+
+// EvacuateSelector selects where sectors go when evacuating a worker
+type EvacuateSelector struct {
+	// IDs of local storage paths sectors are moved to, tried in order. Sector
+	// files in all other local paths are moved.
+	Paths []string
+
+	// Pinned sectors are left in place
+	Pinned []abi.SectorID
+}
+
+// RepairSource selects where RepairFile fetches a good copy of a sector file
+// from
+type RepairSource struct {
+	// IDs of storage paths to fetch from, tried in order. When empty, all
+	// other paths holding the file are tried, primary copies first.
+	Paths []string
+}
+
+// EvacProgress reports progress of evacuating a worker
+type EvacProgress struct {
+	// Running is true while sectors are being moved
+	Running bool
+
+	// Sectors is the number of sectors which had files in evacuated paths when
+	// the evacuation (or its last resumption) started, Moved of which were
+	// moved so far
+	Sectors int
+	Moved   int
+
+	// Sectors which weren't moved, Busy ones had tasks in progress
+	Pinned []abi.SectorID
+	Busy   []abi.SectorID
+	Failed []abi.SectorID
+
+	// Remaining sectors weren't reached before the evacuation was stopped, by
+	// cancelling the call, they are moved when it's resumed
+	Remaining []abi.SectorID
+
+	// Done is true when all sectors except pinned ones were moved
+	Done bool
+}
+
+type EvacResult string
+
+const (
+	EvacMoved  EvacResult = "moved"
+	EvacPinned EvacResult = "pinned"
+	EvacBusy   EvacResult = "busy"
+	EvacFailed EvacResult = "failed"
+)
+
+// EvacUpdate is sent by EvacuateUpdates for each sector it's done with, with
+// the progress so far. The last update, which has no Result, carries the
+// summary of the evacuation, and its error if it failed.
+type EvacUpdate struct {
+	Sector abi.SectorID
+	Result EvacResult
+	Error  string
+
+	Progress EvacProgress
+}
+
+// TaskFailureRate is the share of recent calls of a task type which failed on
+// a worker, over the last Calls calls (at most the configured window)
+type TaskFailureRate struct {
+	Calls    int
+	Failures int
+	Rate     float64
+}
+
 type CallID struct {
 	Sector abi.SectorID
 	ID     uuid.UUID
@@ -84,17 +325,27 @@ type WorkerCalls interface {
 	SealCommit1(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, pieces []abi.PieceInfo, cids storage.SectorCids) (CallID, error)
 	SealCommit2(ctx context.Context, sector storage.SectorRef, c1o storage.Commit1Out) (CallID, error)
 	FinalizeSector(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range) (CallID, error)
+	FinalizeSectorTypes(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range, types SectorFileType) (CallID, error)
 	ReleaseUnsealed(ctx context.Context, sector storage.SectorRef, safeToFree []storage.Range) (CallID, error)
 	MoveStorage(ctx context.Context, sector storage.SectorRef, types SectorFileType) (CallID, error)
 	UnsealPiece(context.Context, storage.SectorRef, UnpaddedByteIndex, abi.UnpaddedPieceSize, abi.SealRandomness, cid.Cid) (CallID, error)
 	ReadPiece(context.Context, io.Writer, storage.SectorRef, UnpaddedByteIndex, abi.UnpaddedPieceSize) (CallID, error)
 	Fetch(context.Context, storage.SectorRef, SectorFileType, PathType, AcquireMode) (CallID, error)
+
+	ReplicaUpdate(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (CallID, error)
+	ProveReplicaUpdate1(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid) (CallID, error)
+	ProveReplicaUpdate2(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid, vanillaProofs ReplicaVanillaProofs) (CallID, error)
 }
 
 type ErrorCode int
 
 const (
-	ErrUnknown ErrorCode = iota
+	ErrUnknown        ErrorCode = iota
+	ErrPieceOrder               // the existing pieces of an AddPiece call don't match the pieces added to the sector
+	ErrFFIFatal                 // the proofs backend failed in a way retrying the call won't fix, e.g. corrupt input
+	ErrForeignSector            // the sector belongs to a miner the worker doesn't serve
+	ErrOccupiedSector           // the first piece of a sector was added while the sector has unsealed data
+	ErrMissingStorage           // storage holding sector files of the call was removed from the sector index
 )
 
 const (
@@ -102,12 +353,28 @@ const (
 	ErrTempUnknown ErrorCode = iota + 100
 	ErrTempWorkerRestart
 	ErrTempAllocateSpace
+	ErrTempWorkerUnhealthy
+	ErrTempTooManySectors
+	ErrTempDeadlineInfeasible // the worker doesn't expect to finish the task by its deadline
+	ErrTempSectorPaused       // work on the sector is paused on the worker
+	ErrTempFFI                // the proofs backend failed for a transient reason, e.g. resource contention
+	ErrTempNoGPU              // the worker can't see any GPU to run a GPU task on
 )
 
+// Temporary returns true for error codes which may go away when the call is
+// retried, either on the same worker or on another one
+func (c ErrorCode) Temporary() bool {
+	return c >= ErrTempUnknown
+}
+
 type CallError struct {
 	Code    ErrorCode
-	Message string
-	sub     error
+	Message string // full error string, kept for callers which don't understand other fields
+
+	Retryable bool     // whether re-dispatching the call may succeed
+	Chain     []string // messages of wrapped errors, outermost first
+
+	sub error
 }
 
 func (c *CallError) Error() string {
@@ -127,20 +394,240 @@ func Err(code ErrorCode, sub error) *CallError {
 		Code:    code,
 		Message: sub.Error(),
 
+		Retryable: code.Temporary(),
+		Chain:     errChain(sub),
+
 		sub: sub,
 	}
 }
 
+func errChain(err error) []string {
+	var out []string
+	for ; err != nil; err = errors.Unwrap(err) {
+		out = append(out, err.Error())
+	}
+
+	return out
+}
+
+// IsRetryable returns true when the error carries a CallError marked as retryable
+func IsRetryable(err error) bool {
+	var cerr *CallError
+	return errors.As(err, &cerr) && cerr.Retryable
+}
+
 type WorkerReturn interface {
 	ReturnAddPiece(ctx context.Context, callID CallID, pi abi.PieceInfo, err *CallError) error
 	ReturnSealPreCommit1(ctx context.Context, callID CallID, p1o storage.PreCommit1Out, err *CallError) error
 	ReturnSealPreCommit2(ctx context.Context, callID CallID, sealed storage.SectorCids, err *CallError) error
 	ReturnSealCommit1(ctx context.Context, callID CallID, out storage.Commit1Out, err *CallError) error
 	ReturnSealCommit2(ctx context.Context, callID CallID, proof storage.Proof, err *CallError) error
-	ReturnFinalizeSector(ctx context.Context, callID CallID, err *CallError) error
+	ReturnFinalizeSector(ctx context.Context, callID CallID, out FinalizeSectorOut, err *CallError) error
 	ReturnReleaseUnsealed(ctx context.Context, callID CallID, err *CallError) error
 	ReturnMoveStorage(ctx context.Context, callID CallID, err *CallError) error
 	ReturnUnsealPiece(ctx context.Context, callID CallID, err *CallError) error
 	ReturnReadPiece(ctx context.Context, callID CallID, ok bool, err *CallError) error
 	ReturnFetch(ctx context.Context, callID CallID, err *CallError) error
+	ReturnReplicaUpdate(ctx context.Context, callID CallID, out ReplicaUpdateOut, err *CallError) error
+	ReturnProveReplicaUpdate1(ctx context.Context, callID CallID, proofs ReplicaVanillaProofs, err *CallError) error
+	ReturnProveReplicaUpdate2(ctx context.Context, callID CallID, proof ReplicaUpdateProof, err *CallError) error
+	ReturnGeneratePieceCommP(ctx context.Context, callID CallID, pi abi.PieceInfo, err *CallError) error
+	ReturnVerifyCommit2(ctx context.Context, callID CallID, ok bool, err *CallError) error
+}
+
+// GPUTime is the wall time tasks of a type spent running on a worker with
+// GPUs, for tasks able to use GPUs
+type GPUTime struct {
+	Calls    int
+	Duration time.Duration
+}
+
+// TaskTime is the time tasks of a type spent on a worker. QueueWait is the
+// time from being dispatched to starting to run, of Started tasks, Execution
+// is the time Succeeded tasks ran.
+type TaskTime struct {
+	Started   int
+	QueueWait time.Duration
+
+	Succeeded int
+	Execution time.Duration
+
+	// Phases is the execution time of successful tasks by sub-phase, for
+	// tasks the proofs backend times sub-phases of
+	Phases map[string]time.Duration `json:",omitempty"`
+}
+
+// IOContention is how many piece reads and storage moves a worker has, see
+// sectorstorage.MoveReadPolicy
+type IOContention struct {
+	Policy string
+
+	Reads        int // including reads waiting in the call queue
+	Moves        int // running moves
+	MovesWaiting int // moves held back for reads
+}
+
+// TaskPhase is how long a sub-phase of a task took
+type TaskPhase struct {
+	Phase string
+	Took  time.Duration
+}
+
+// PathLatency is the result of the last latency probe of a storage path
+type PathLatency struct {
+	ID string
+
+	// Write is how long writing and syncing a small file took, Read how long
+	// reading it back took
+	Write time.Duration
+	Read  time.Duration
+
+	Probed time.Time
+	Err    string `json:",omitempty"`
+}
+
+// PendingRemoval is a removal of sector files which failed, and is retried
+// periodically by the worker
+type PendingRemoval struct {
+	Sector abi.SectorID
+	Types  SectorFileType
+
+	Since    time.Time
+	Attempts int
+	LastErr  string
+
+	// Due is when a removal deferred by the unsealed grace period is due,
+	// zero for removals which failed
+	Due time.Time
+}
+
+// ReapedCall is a call the worker cancelled for running longer than its max
+// call age
+type ReapedCall struct {
+	Call CallID
+	Task sealtasks.TaskType
+
+	Started time.Time // when the worker accepted the call
+	Reaped  time.Time
+}
+
+// ScrubFinding is a sector file the background scrubber found to be missing
+// or incomplete
+type ScrubFinding struct {
+	Sector abi.SectorID
+	Type   SectorFileType
+	Path   string `json:",omitempty"` // empty when the file is missing
+
+	Error string
+	Found time.Time
+}
+
+// VerifyUpdate is sent by VerifySectorUpdates as sector files are read. The
+// last update has Done set, and carries the error if verification failed.
+type VerifyUpdate struct {
+	Sector abi.SectorID
+
+	// Verified bytes of the sealed replica and cache files were read so far,
+	// out of Total
+	Verified int64
+	Total    int64
+
+	// ETA is when verification is expected to be done at the read rate so
+	// far, zero until anything was read
+	ETA time.Time
+
+	Done  bool
+	Error string
+}
+
+// AddedPiece is a piece added to a sector by the worker, at the offset it was
+// written to in the unsealed sector file
+type AddedPiece struct {
+	Piece  abi.PieceInfo
+	Offset PaddedByteIndex
+}
+
+// DiagnosticReport is a snapshot of a worker's state for troubleshooting,
+// see LocalWorker.Diagnostics
+type DiagnosticReport struct {
+	Generated time.Time
+
+	Info      WorkerInfo
+	TaskTypes []sealtasks.TaskType
+
+	// Calls which weren't returned to the manager yet
+	Calls []DiagnosticCall
+
+	FailureRates map[sealtasks.TaskType]TaskFailureRate
+	GPUTime      map[sealtasks.TaskType]GPUTime
+	TaskTimes    map[sealtasks.TaskType]TaskTime
+
+	// RecentErrors are warnings and errors logged by recent calls, oldest
+	// first
+	RecentErrors []CallLogLine
+
+	Storage         []DiagnosticStorage
+	PathLatencies   []PathLatency
+	PendingRemovals []PendingRemoval
+
+	// Errors are sections of the report which couldn't be collected
+	Errors []string `json:",omitempty"`
+}
+
+type DiagnosticCall struct {
+	ID   CallID
+	Task sealtasks.TaskType
+
+	// Done is set for calls which are done, but whose results weren't
+	// returned yet
+	Done bool
+}
+
+type CallLogLine struct {
+	Call CallID
+	LogLine
+}
+
+// DiagnosticStorage is the health of a storage path the worker can use,
+// LocalPath is empty for remote paths
+type DiagnosticStorage struct {
+	ID        string
+	LocalPath string `json:",omitempty"`
+
+	Reachable bool
+	Err       string `json:",omitempty"`
+}
+
+// StagedPiece is a piece kept in the staging area of a worker
+type StagedPiece struct {
+	Size   abi.UnpaddedPieceSize
+	Staged time.Time
+}
+
+// StagedSector lists the pieces staged for a sector, in the order they are
+// added to it
+type StagedSector struct {
+	Sector    abi.SectorID
+	ProofType abi.RegisteredSealProof
+	Pieces    []StagedPiece
+}
+
+// StagingInfo describes the piece staging area of a worker
+type StagingInfo struct {
+	Dir string
+
+	Capacity  int64 // of the filesystem the staging area is on
+	Available int64
+	Used      int64 // by staged pieces
+
+	Sectors []StagedSector
+}
+
+// ConcurrencyBoost is a temporary raise of the concurrency limit of a task type
+type ConcurrencyBoost struct {
+	Task       sealtasks.TaskType
+	Limit      int // boosted limit
+	Configured int // limit restored when the boost ends
+	Running    int
+	Until      time.Time
 }
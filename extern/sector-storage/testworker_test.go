@@ -116,6 +116,10 @@ func (t *testWorker) Info(ctx context.Context) (storiface.WorkerInfo, error) {
 	}, nil
 }
 
+func (t *testWorker) Reservations(ctx context.Context) (storiface.ReservedResources, error) {
+	return storiface.ReservedResources{}, nil
+}
+
 func (t *testWorker) Session(context.Context) (uuid.UUID, error) {
 	return t.session, nil
 }
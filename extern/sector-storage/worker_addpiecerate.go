@@ -0,0 +1,68 @@
+package sectorstorage
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
+)
+
+// largest amount of piece data read at once by rate limited AddPiece calls
+const maxAddPieceBurst = 4 << 20
+
+// addPieceLimiter paces reading piece data of all AddPiece calls of the worker
+// so that together they don't go over the throughput limit, see
+// WorkerConfig.AddPieceRateLimit. Nil means no limit.
+type addPieceLimiter struct {
+	lim *rate.Limiter
+}
+
+func newAddPieceLimiter(bytesPerSec int64) *addPieceLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	// one second worth of data at most, so that pieces share the limit fairly
+	burst := bytesPerSec
+	if burst > maxAddPieceBurst {
+		burst = maxAddPieceBurst
+	}
+
+	return &addPieceLimiter{
+		lim: rate.NewLimiter(rate.Limit(bytesPerSec), int(burst)),
+	}
+}
+
+// reader returns r paced by the limiter
+func (a *addPieceLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if a == nil {
+		return r
+	}
+
+	return &limitedReader{
+		ctx: ctx,
+		lim: a.lim,
+		r:   r,
+	}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	lim *rate.Limiter
+	r   io.Reader
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.lim.Burst() {
+		p = p[:lr.lim.Burst()]
+	}
+
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.lim.WaitN(lr.ctx, n); werr != nil {
+			return n, xerrors.Errorf("waiting for AddPiece rate limit: %w", werr)
+		}
+	}
+	return n, err
+}
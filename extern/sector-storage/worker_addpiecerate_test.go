@@ -0,0 +1,50 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddPieceRateLimit(t *testing.T) {
+	ctx := context.Background()
+
+	require.Nil(t, newAddPieceLimiter(0))
+
+	data := bytes.Repeat([]byte{0xa5}, 75<<10)
+
+	var nolim *addPieceLimiter
+	r := nolim.reader(ctx, bytes.NewReader(data))
+	_, ok := r.(*bytes.Reader)
+	require.True(t, ok)
+
+	// two pieces share the limit, the second half second is paced
+	lim := newAddPieceLimiter(100 << 10)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			read, err := ioutil.ReadAll(lim.reader(ctx, bytes.NewReader(data)))
+			require.NoError(t, err)
+			require.Equal(t, data, read)
+		}()
+	}
+	wg.Wait()
+
+	require.True(t, time.Since(start) > 400*time.Millisecond, "took %s", time.Since(start))
+
+	// waits end with the call
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := ioutil.ReadAll(lim.reader(cctx, bytes.NewReader(data)))
+	require.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-statestore"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/xerrors"
@@ -21,6 +22,10 @@ const (
 	CallStarted CallState = iota
 	CallDone
 	// returned -> remove
+
+	// CallResult entries aren't calls, they keep results of successful calls
+	// for re-returning them when the task is dispatched again
+	CallResult
 )
 
 type Call struct {
@@ -55,8 +60,33 @@ func (wt *workerCallTracker) onReturned(ci storiface.CallID) error {
 }
 
 func (wt *workerCallTracker) unfinished() ([]Call, error) {
-	var out []Call
-	return out, wt.st.List(&out)
+	var all []Call
+	if err := wt.st.List(&all); err != nil {
+		return nil, err
+	}
+
+	out := make([]Call, 0, len(all))
+	for _, call := range all {
+		if call.State != CallResult {
+			out = append(out, call)
+		}
+	}
+	return out, nil
+}
+
+// activeSectors returns sectors with calls which weren't returned to the manager yet
+func (wt *workerCallTracker) activeSectors() (map[abi.SectorID]struct{}, error) {
+	calls, err := wt.unfinished()
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[abi.SectorID]struct{}{}
+	for _, call := range calls {
+		out[call.ID.Sector] = struct{}{}
+	}
+
+	return out, nil
 }
 
 // Ideally this would be a tag on the struct field telling cbor-gen to enforce higher max-len
@@ -0,0 +1,88 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// callContext returns a context of the call which can be cancelled with
+// CancelCall. The returned func must be called when the call is done.
+func (l *LocalWorker) callContext(ctx context.Context, ci storiface.CallID) (context.Context, func()) {
+	cctx, cancel := context.WithCancel(ctx)
+	cctx = context.WithValue(cctx, callAbortKey{}, &callAbort{cancel: cancel})
+
+	l.cancelLk.Lock()
+	if l.cancels == nil {
+		l.cancels = map[storiface.CallID]context.CancelFunc{}
+	}
+	l.cancels[ci] = cancel
+	l.cancelLk.Unlock()
+
+	return cctx, func() {
+		l.cancelLk.Lock()
+		delete(l.cancels, ci)
+		l.cancelLk.Unlock()
+
+		cancel()
+	}
+}
+
+// CancelCall cancels the context of a running call. Whether the call stops
+// early depends on the task honoring the context, results (usually a context
+// cancelled error) are returned as usual.
+func (l *LocalWorker) CancelCall(ctx context.Context, ci storiface.CallID) error {
+	l.cancelLk.Lock()
+	cancel, ok := l.cancels[ci]
+	l.cancelLk.Unlock()
+
+	if !ok {
+		return xerrors.Errorf("call %s is not running", ci)
+	}
+
+	cancel()
+	return nil
+}
+
+// callAbort lets the worker cancel a call for a reason, which is then reported
+// as the call error
+type callAbort struct {
+	cancel context.CancelFunc
+
+	lk    sync.Mutex
+	cause error
+}
+
+type callAbortKey struct{}
+
+// abortCall cancels the call running with the context, the first cause is kept
+func abortCall(ctx context.Context, cause error) {
+	ca, ok := ctx.Value(callAbortKey{}).(*callAbort)
+	if !ok {
+		return
+	}
+
+	ca.lk.Lock()
+	if ca.cause == nil {
+		ca.cause = cause
+	}
+	ca.lk.Unlock()
+
+	ca.cancel()
+}
+
+// callAbortCause returns why the call running with the context was aborted,
+// nil when it wasn't
+func callAbortCause(ctx context.Context) error {
+	ca, ok := ctx.Value(callAbortKey{}).(*callAbort)
+	if !ok {
+		return nil
+	}
+
+	ca.lk.Lock()
+	defer ca.lk.Unlock()
+	return ca.cause
+}
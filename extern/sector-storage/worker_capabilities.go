@@ -0,0 +1,30 @@
+package sectorstorage
+
+import (
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// capabilities returns the capabilities the worker declares in its info,
+// the configured ones, or the ones supported by the proofs backend
+func (l *LocalWorker) capabilities() storiface.WorkerCapabilities {
+	if l.declaredCaps != nil {
+		return append(storiface.WorkerCapabilities{}, l.declaredCaps...)
+	}
+
+	caps := storiface.WorkerCapabilities{storiface.CapPartialUnseal}
+	if l.executor == nil {
+		return caps
+	}
+
+	sb, err := l.executor()
+	if err != nil {
+		log.Warnw("checking capabilities of the proofs backend", "error", err)
+		return caps
+	}
+	if _, ok := sb.(ffiwrapper.ReplicaUpdater); ok {
+		caps = append(caps, storiface.CapSnapDeals)
+	}
+
+	return caps
+}
@@ -0,0 +1,38 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestWorkerCapabilities(t *testing.T) {
+	ctx := context.Background()
+
+	// testExec doesn't do replica updates
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	caps := w.capabilities()
+	require.Equal(t, storiface.WorkerCapabilities{storiface.CapPartialUnseal}, caps)
+	require.True(t, caps.Supports(sealtasks.TTUnseal))
+	require.True(t, caps.Supports(sealtasks.TTPreCommit1))
+	require.False(t, caps.Supports(sealtasks.TTReplicaUpdate))
+
+	declared, _, _, cleanup2 := newTestLocalWorker(ctx, t, WorkerConfig{
+		Capabilities: []storiface.WorkerCapability{},
+	}, nil)
+	defer cleanup2()
+
+	caps = declared.capabilities()
+	require.NotNil(t, caps)
+	require.False(t, caps.Supports(sealtasks.TTUnseal))
+
+	// workers which don't declare capabilities are assigned any task they accept
+	var legacy storiface.WorkerCapabilities
+	require.True(t, legacy.Supports(sealtasks.TTReplicaUpdate))
+}
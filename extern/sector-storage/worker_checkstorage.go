@@ -0,0 +1,110 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+)
+
+var ErrStorageUnreachable = errors.New("storage path unreachable")
+
+// StorageCheckFile is the file written and read in each local storage path by
+// CheckStorage. It's not the latency probe file, so that checks don't race
+// with latency probes.
+const StorageCheckFile = ".storage-check"
+
+// how long checking a remote storage path may take
+var remoteCheckTimeout = 30 * time.Second
+
+// storageLister is implemented by indexes which know all storage paths, like
+// the miner API
+type storageLister interface {
+	StorageList(ctx context.Context) (map[stores.ID][]stores.Decl, error)
+}
+
+// CheckStorage probes every storage path the worker can use, and returns
+// whether each is reachable, with nil errors for paths which are. Local paths
+// are checked by writing, syncing and reading back a small file. Remote paths,
+// which only their owners write to, are checked by getting their filesystem
+// stats through their URLs. Remote paths are only checked when the index can
+// list them.
+func (l *LocalWorker) CheckStorage(ctx context.Context) (map[stores.ID]error, error) {
+	out := map[stores.ID]error{}
+
+	if l.localStore != nil {
+		paths, err := l.localStore.Local(ctx)
+		if err != nil {
+			return nil, xerrors.Errorf("getting local storage paths: %w", err)
+		}
+
+		for _, p := range paths {
+			if p.LocalPath == "" {
+				continue
+			}
+
+			out[p.ID] = nil
+			if _, _, err := probePath(filepath.Join(p.LocalPath, StorageCheckFile)); err != nil {
+				out[p.ID] = xerrors.Errorf("%s (%s): %w", p.ID, p.LocalPath, err)
+			}
+		}
+	}
+
+	lister, ok := l.sindex.(storageLister)
+	if !ok {
+		return out, nil
+	}
+
+	all, err := lister.StorageList(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("listing storage paths: %w", err)
+	}
+
+	for id := range all {
+		if _, local := out[id]; local {
+			continue
+		}
+
+		out[id] = l.checkRemoteStorage(ctx, id)
+	}
+
+	return out, nil
+}
+
+func (l *LocalWorker) checkRemoteStorage(ctx context.Context, id stores.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, remoteCheckTimeout)
+	defer cancel()
+
+	if _, err := l.storage.FsStat(ctx, id); err != nil {
+		return xerrors.Errorf("%s (remote): %w", id, err)
+	}
+	return nil
+}
+
+// storageReachable returns an ErrStorageUnreachable error when some storage
+// paths can't be reached
+func (l *LocalWorker) storageReachable(ctx context.Context) error {
+	checked, err := l.CheckStorage(ctx)
+	if err != nil {
+		return err
+	}
+
+	var reasons []string
+	for _, err := range checked {
+		if err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+	if len(reasons) > 0 {
+		sort.Strings(reasons)
+		return xerrors.Errorf("%w: %s", ErrStorageUnreachable, strings.Join(reasons, "; "))
+	}
+
+	return nil
+}
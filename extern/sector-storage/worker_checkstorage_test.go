@@ -0,0 +1,52 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+)
+
+func TestCheckStorage(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	checked, err := w.CheckStorage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[stores.ID]error{p.ID: nil}, checked)
+	require.NoFileExists(t, filepath.Join(p.LocalPath, StorageCheckFile))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(fsutil.FsStat{Capacity: 1 << 30, Available: 1 << 30})
+	}))
+	defer srv.Close()
+
+	require.NoError(t, si.StorageAttach(ctx, stores.StorageInfo{ID: "remote-up", URLs: []string{srv.URL + "/remote"}, Weight: 1, CanStore: true}, fsutil.FsStat{}))
+
+	checked, err = w.CheckStorage(ctx)
+	require.NoError(t, err)
+	require.Len(t, checked, 2)
+	require.NoError(t, checked["remote-up"])
+	require.NoError(t, w.Healthy(ctx))
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	require.NoError(t, si.StorageAttach(ctx, stores.StorageInfo{ID: "remote-down", URLs: []string{down.URL + "/remote"}, Weight: 1, CanStore: true}, fsutil.FsStat{}))
+
+	checked, err = w.CheckStorage(ctx)
+	require.NoError(t, err)
+	require.Len(t, checked, 3)
+	require.NoError(t, checked[p.ID])
+	require.Error(t, checked["remote-down"])
+	require.True(t, xerrors.Is(w.Healthy(ctx), ErrStorageUnreachable))
+}
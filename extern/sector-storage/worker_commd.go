@@ -0,0 +1,81 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+)
+
+var ErrInsufficientPieces = errors.New("not enough piece information to compute the unsealed CID")
+
+// ComputeUnsealedCID computes the unsealed sector commitment (CommD) from the
+// layout of the sector's pieces, e.g. for checking a finalized sector against
+// the CommD on chain. Pieces with a CID don't need the unsealed sector file,
+// commitments of pieces without one are computed from their data in the local
+// unsealed file. When no pieces are given, pieces added to the sector with
+// this worker are used.
+func (l *LocalWorker) ComputeUnsealedCID(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (cid.Cid, error) {
+	if len(pieces) == 0 {
+		for _, ap := range l.pieces.list(sector.ID) {
+			pieces = append(pieces, ap.Piece)
+		}
+	}
+	if len(pieces) == 0 {
+		return cid.Undef, xerrors.Errorf("no pieces given, and none were added to sector %d with this worker: %w", sector.ID, ErrInsufficientPieces)
+	}
+
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	var sb ffiwrapper.Storage
+	var offset abi.PaddedPieceSize
+
+	layout := make([]abi.PieceInfo, len(pieces))
+	for i, p := range pieces {
+		if err := p.Size.Validate(); err != nil {
+			return cid.Undef, xerrors.Errorf("piece %d: invalid size: %w", i, err)
+		}
+
+		// pieces are aligned to their size in the sector
+		_, padding := ffiwrapper.GetRequiredPadding(offset, p.Size)
+		offset += padding
+		if offset+p.Size > abi.PaddedPieceSize(ssize) {
+			return cid.Undef, xerrors.Errorf("piece %d at offset %d doesn't fit in the %d byte sector", i, offset, ssize)
+		}
+
+		if !p.PieceCID.Defined() {
+			if sb == nil {
+				if sb, err = l.sb(); err != nil {
+					return cid.Undef, err
+				}
+			}
+
+			p.PieceCID, err = l.unsealedPieceCID(ctx, sb, sector, offset, p.Size)
+			if err == errNoUnsealedPiece {
+				return cid.Undef, xerrors.Errorf("piece %d has no CID, and sector %d has no local unsealed data: %w", i, sector.ID, ErrInsufficientPieces)
+			}
+			if err != nil {
+				return cid.Undef, xerrors.Errorf("computing commitment of piece %d from unsealed data: %w", i, err)
+			}
+		}
+
+		layout[i] = p
+		offset += p.Size
+	}
+
+	c, err := ffiwrapper.GenerateUnsealedCID(sector.ProofType, layout)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("generating unsealed CID of sector %d: %w", sector.ID, err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,81 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestComputeUnsealedCID(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &addPieceReturns{
+		pieces: make(chan abi.PieceInfo, 1),
+		errs:   make(chan *storiface.CallError, 1),
+	}
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	exec := &addPieceExec{}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	_, err := w.ComputeUnsealedCID(ctx, sector, nil)
+	require.True(t, xerrors.Is(err, ErrInsufficientPieces), err)
+
+	// without unsealed data pieces must have a CID
+	_, err = w.ComputeUnsealedCID(ctx, sector, []abi.PieceInfo{{Size: 128}})
+	require.True(t, xerrors.Is(err, ErrInsufficientPieces), err)
+
+	addPiece := func(existing []abi.UnpaddedPieceSize, size abi.UnpaddedPieceSize, fill byte) abi.PieceInfo {
+		_, err := w.AddPiece(ctx, sector, existing, size, bytes.NewReader(bytes.Repeat([]byte{fill}, int(size))))
+		require.NoError(t, err)
+		require.Nil(t, <-ret.errs)
+		return <-ret.pieces
+	}
+
+	pieces := []abi.PieceInfo{
+		addPiece(nil, 127, 1),
+		addPiece([]abi.UnpaddedPieceSize{127}, 254, 2),
+	}
+
+	expect, err := ffiwrapper.GenerateUnsealedCID(sector.ProofType, pieces)
+	require.NoError(t, err)
+
+	c, err := w.ComputeUnsealedCID(ctx, sector, pieces)
+	require.NoError(t, err)
+	require.Equal(t, expect, c)
+
+	// pieces added with the worker are used when none are given
+	c, err = w.ComputeUnsealedCID(ctx, sector, nil)
+	require.NoError(t, err)
+	require.Equal(t, expect, c)
+
+	// commitments of pieces without a CID are computed from unsealed data
+	c, err = w.ComputeUnsealedCID(ctx, sector, []abi.PieceInfo{pieces[0], {Size: pieces[1].Size, PieceCID: cid.Undef}})
+	require.NoError(t, err)
+	require.Equal(t, expect, c)
+
+	_, err = w.ComputeUnsealedCID(ctx, sector, []abi.PieceInfo{{Size: 100, PieceCID: pieces[0].PieceCID}})
+	require.Error(t, err)
+
+	_, err = w.ComputeUnsealedCID(ctx, sector, append(pieces, abi.PieceInfo{Size: 2048, PieceCID: pieces[0].PieceCID}))
+	require.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+var ErrCPUOnlyUnsupported = errors.New("proofs may use GPUs")
+
+// CheckCPUOnly returns an ErrCPUOnlyUnsupported error when the proofs may use
+// GPUs to run the task for the proof type, as recorded in ResourceTable, so
+// that running it only on CPUs can't be guaranteed
+func CheckCPUOnly(tt sealtasks.TaskType, spt abi.RegisteredSealProof) error {
+	res, ok := ResourceTable[tt][spt]
+	if !ok {
+		return xerrors.Errorf("%s of proof type %d: unknown resource use: %w", tt, spt, ErrCPUOnlyUnsupported)
+	}
+	if res.CanGPU {
+		return xerrors.Errorf("%s of proof type %d: %w", tt, spt, ErrCPUOnlyUnsupported)
+	}
+	return nil
+}
+
+// CheckCPUOnlyCommit1 checks that SealCommit1 of all proof types the worker
+// supports runs only on CPUs, when the worker is configured to run
+// SealCommit1 on CPUs only (see WorkerConfig.CPUOnlyCommit1)
+func (l *LocalWorker) CheckCPUOnlyCommit1(ctx context.Context) error {
+	if !l.cpuOnlyCommit1 {
+		return nil
+	}
+
+	spts, err := l.SupportedProofs(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting supported proofs: %w", err)
+	}
+
+	for _, spt := range spts {
+		if err := CheckCPUOnly(sealtasks.TTCommit1, spt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCommit1CPUOnly returns an error when SealCommit1 must run on CPUs only,
+// but the proofs may use GPUs for it
+func (l *LocalWorker) checkCommit1CPUOnly(spt abi.RegisteredSealProof) error {
+	if !l.cpuOnlyCommit1 {
+		return nil
+	}
+	return CheckCPUOnly(sealtasks.TTCommit1, spt)
+}
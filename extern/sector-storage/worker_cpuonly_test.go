@@ -0,0 +1,47 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+func TestCPUOnlyCommit1(t *testing.T) {
+	ctx := context.Background()
+
+	spt := abi.RegisteredSealProof_StackedDrg2KiBV1
+	require.NoError(t, CheckCPUOnly(sealtasks.TTCommit1, spt))
+	require.True(t, xerrors.Is(CheckCPUOnly(sealtasks.TTCommit2, spt), ErrCPUOnlyUnsupported))
+	require.True(t, xerrors.Is(CheckCPUOnly(sealtasks.TTCommit1, abi.RegisteredSealProof(-1)), ErrCPUOnlyUnsupported))
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		TaskTypes:      []sealtasks.TaskType{sealtasks.TTCommit1},
+		CPUOnlyCommit1: true,
+	}, nil)
+	defer cleanup()
+
+	require.NoError(t, w.CheckCPUOnlyCommit1(ctx))
+	require.NoError(t, w.checkCommit1CPUOnly(spt))
+
+	// proofs which could use GPUs for commit1
+	res := ResourceTable[sealtasks.TTCommit1][spt]
+	defer func() {
+		ResourceTable[sealtasks.TTCommit1][spt] = res
+	}()
+	gpuRes := res
+	gpuRes.CanGPU = true
+	ResourceTable[sealtasks.TTCommit1][spt] = gpuRes
+
+	require.True(t, xerrors.Is(w.checkCommit1CPUOnly(spt), ErrCPUOnlyUnsupported))
+	require.True(t, xerrors.Is(w.CheckCPUOnlyCommit1(ctx), ErrCPUOnlyUnsupported))
+
+	w.cpuOnlyCommit1 = false
+	require.NoError(t, w.checkCommit1CPUOnly(spt))
+	require.NoError(t, w.CheckCPUOnlyCommit1(ctx))
+}
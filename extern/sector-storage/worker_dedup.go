@@ -0,0 +1,246 @@
+package sectorstorage
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// pieceCopy is an unsealed sector file holding only one piece
+type pieceCopy struct {
+	sector  abi.SectorID
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// current returns false when the file was removed or changed since it was
+// recorded
+func (pc pieceCopy) current() bool {
+	st, err := os.Stat(pc.path)
+	return err == nil && st.Size() == pc.size && st.ModTime().Equal(pc.modTime)
+}
+
+// pieceCopies indexes unsealed sector files holding only one piece by the
+// piece CID, so that sectors with the same piece can share the file
+type pieceCopies struct {
+	lk     sync.Mutex
+	copies map[cid.Cid][]pieceCopy
+}
+
+// add records the file holding the piece, returns false when it can't be
+// stat'ed
+func (p *pieceCopies) add(piece cid.Cid, sector abi.SectorID, path string) bool {
+	st, err := os.Stat(path)
+	if err != nil {
+		log.Warnw("recording unsealed piece copy", "sector", sector, "path", path, "error", err)
+		return false
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	if p.copies == nil {
+		p.copies = map[cid.Cid][]pieceCopy{}
+	}
+	p.copies[piece] = append(p.copies[piece], pieceCopy{
+		sector:  sector,
+		path:    path,
+		size:    st.Size(),
+		modTime: st.ModTime(),
+	})
+	return true
+}
+
+// find returns current files holding the piece for sectors other than sector.
+// Copies which were removed or changed are forgotten.
+func (p *pieceCopies) find(piece cid.Cid, sector abi.SectorID) []pieceCopy {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	var kept, out []pieceCopy
+	for _, pc := range p.copies[piece] {
+		if !pc.current() {
+			continue
+		}
+		kept = append(kept, pc)
+		if pc.sector != sector {
+			out = append(out, pc)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(p.copies, piece)
+	} else {
+		p.copies[piece] = kept
+	}
+	return out
+}
+
+// drop forgets files of a sector, e.g. when it's removed. Other sectors
+// sharing a file keep their link to it.
+func (p *pieceCopies) drop(sector abi.SectorID) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	for piece, copies := range p.copies {
+		kept := copies[:0]
+		for _, pc := range copies {
+			if pc.sector != sector {
+				kept = append(kept, pc)
+			}
+		}
+		if len(kept) == 0 {
+			delete(p.copies, piece)
+		} else {
+			p.copies[piece] = kept
+		}
+	}
+}
+
+// dedupPiece makes the unsealed file of a sector holding only the added piece
+// a hard link to a local unsealed file of another sector with the same piece,
+// freeing the space of the just written copy. Only sectors filled by a single
+// piece are deduplicated, as unsealed files are shared as a whole. The piece
+// CID is only known after the data was read, so the piece is written first.
+func (l *LocalWorker) dedupPiece(ctx context.Context, sector storage.SectorRef, epcs []abi.UnpaddedPieceSize, pi abi.PieceInfo) error {
+	if !l.dedupPieces || len(epcs) > 0 {
+		return nil
+	}
+
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return xerrors.Errorf("getting sector size: %w", err)
+	}
+	if pi.Size != abi.PaddedPieceSize(ssize) {
+		return nil
+	}
+
+	paths, err := l.localSectorPaths(ctx, sector.ID, storiface.FTUnsealed)
+	if err != nil {
+		return err
+	}
+
+	for _, dest := range paths {
+		for _, src := range l.pieceCopies.find(pi.PieceCID, sector.ID) {
+			linked, err := linkPieceCopy(src.path, dest)
+			if err != nil {
+				return xerrors.Errorf("linking unsealed piece copy %s to %s: %w", src.path, dest, err)
+			}
+			if linked {
+				log.Infow("deduplicated unsealed piece", "sector", sector.ID, "piece", pi.PieceCID, "shared", src.path)
+				break
+			}
+		}
+
+		l.pieceCopies.add(pi.PieceCID, sector.ID, dest)
+	}
+
+	return nil
+}
+
+// linkPieceCopy replaces dest with a hard link to src. Returns false when src
+// is on another filesystem.
+func linkPieceCopy(src, dest string) (bool, error) {
+	tmp := dest + ".dedup"
+	if err := os.Link(src, tmp); err != nil {
+		var lerr *os.LinkError
+		if xerrors.As(err, &lerr) && isCrossDevice(lerr.Err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		_ = os.Remove(tmp)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// unsharePiece gives local unsealed files of the sector which are shared with
+// other sectors their own copy, before they are changed in place, e.g. when
+// finalization frees unsealed data
+func (l *LocalWorker) unsharePiece(ctx context.Context, sector abi.SectorID) error {
+	if !l.dedupPieces {
+		return nil
+	}
+
+	paths, err := l.localSectorPaths(ctx, sector, storiface.FTUnsealed)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		shared, err := hasOtherLinks(p)
+		if err != nil {
+			return xerrors.Errorf("checking links of %s: %w", p, err)
+		}
+		if !shared {
+			continue
+		}
+
+		tmp := p + ".unshare"
+		if err := copyFileContent(p, tmp); err != nil {
+			_ = os.Remove(tmp)
+			return xerrors.Errorf("copying shared unsealed file %s: %w", p, err)
+		}
+		if err := os.Rename(tmp, p); err != nil {
+			_ = os.Remove(tmp)
+			return xerrors.Errorf("replacing shared unsealed file %s: %w", p, err)
+		}
+		log.Infow("unshared unsealed piece copy", "sector", sector, "path", p)
+	}
+
+	l.pieceCopies.drop(sector)
+	return nil
+}
+
+func isCrossDevice(err error) bool {
+	return xerrors.Is(err, syscall.EXDEV)
+}
+
+// hasOtherLinks returns true when the file has more than one hard link
+func hasOtherLinks(path string) (bool, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, nil
+	}
+	return sys.Nlink > 1, nil
+}
+
+func copyFileContent(from, to string) error {
+	in, err := os.Open(from) // nolint
+	if err != nil {
+		return err
+	}
+	defer in.Close() // nolint
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644) // nolint
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
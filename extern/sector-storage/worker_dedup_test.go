@@ -0,0 +1,114 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// unsealedFileExec writes added pieces to unsealed files in a storage path
+type unsealedFileExec struct {
+	testExec
+
+	p  stores.StoragePath
+	si *stores.Index
+}
+
+func (e *unsealedFileExec) AddPiece(ctx context.Context, sector storage.SectorRef, pieceSizes []abi.UnpaddedPieceSize, newPieceSize abi.UnpaddedPieceSize, pieceData storage.Data) (abi.PieceInfo, error) {
+	data, err := ioutil.ReadAll(pieceData)
+	if err != nil {
+		return abi.PieceInfo{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	c, err := commcid.DataCommitmentV1ToCID(sum[:])
+	if err != nil {
+		return abi.PieceInfo{}, err
+	}
+
+	spath := filepath.Join(e.p.LocalPath, storiface.FTUnsealed.String(), storiface.SectorName(sector.ID))
+	if err := ioutil.WriteFile(spath, data, 0644); err != nil {
+		return abi.PieceInfo{}, err
+	}
+	if err := e.si.StorageDeclareSector(ctx, e.p.ID, sector.ID, storiface.FTUnsealed, true); err != nil {
+		return abi.PieceInfo{}, err
+	}
+
+	return abi.PieceInfo{Size: newPieceSize.Padded(), PieceCID: c}, nil
+}
+
+func TestDedupPieces(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &addPieceReturns{
+		pieces: make(chan abi.PieceInfo, 1),
+		errs:   make(chan *storiface.CallError, 1),
+	}
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{DedupPieces: true}, ret)
+	defer cleanup()
+
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return &unsealedFileExec{p: p, si: si}, nil
+	}
+
+	size := abi.PaddedPieceSize(2048).Unpadded()
+	popular := bytes.Repeat([]byte{1}, int(size))
+
+	add := func(n abi.SectorNumber, data []byte) string {
+		sector := storage.SectorRef{
+			ID:        abi.SectorID{Miner: 1000, Number: n},
+			ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+		}
+		_, err := w.AddPiece(ctx, sector, nil, size, bytes.NewReader(data))
+		require.NoError(t, err)
+		<-ret.pieces
+		require.Nil(t, <-ret.errs)
+		return filepath.Join(p.LocalPath, storiface.FTUnsealed.String(), storiface.SectorName(sector.ID))
+	}
+	same := func(a, b string) bool {
+		as, err := os.Stat(a)
+		require.NoError(t, err)
+		bs, err := os.Stat(b)
+		require.NoError(t, err)
+		return os.SameFile(as, bs)
+	}
+
+	first := add(1, popular)
+	second := add(2, popular)
+	other := add(3, bytes.Repeat([]byte{2}, int(size)))
+
+	require.True(t, same(first, second))
+	require.False(t, same(first, other))
+
+	// removing one sector leaves the shared data to the others
+	require.NoError(t, w.Remove(ctx, abi.SectorID{Miner: 1000, Number: 1}))
+	require.NoFileExists(t, first)
+	data, err := ioutil.ReadFile(second)
+	require.NoError(t, err)
+	require.Equal(t, popular, data)
+
+	fourth := add(4, popular)
+	require.True(t, same(second, fourth))
+
+	// files are unshared before they are changed
+	require.NoError(t, w.unsharePiece(ctx, abi.SectorID{Miner: 1000, Number: 2}))
+	require.False(t, same(second, fourth))
+	data, err = ioutil.ReadFile(second)
+	require.NoError(t, err)
+	require.Equal(t, popular, data)
+}
@@ -0,0 +1,152 @@
+package sectorstorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// number of most recent call warnings and errors included in diagnostics
+const diagnosticErrorLines = 100
+
+// Diagnostics collects the worker configuration and resources, calls in
+// progress, failure rates, recent call errors and storage health into one
+// report, e.g. to attach to a support request. Sections which can't be
+// collected are listed in the report's Errors, instead of failing the whole
+// report. Collecting stops with an error when ctx is cancelled, e.g. when
+// storage checks hang.
+func (l *LocalWorker) Diagnostics(ctx context.Context) (storiface.DiagnosticReport, error) {
+	report := storiface.DiagnosticReport{
+		Generated: time.Now(),
+	}
+
+	sections := []struct {
+		name    string
+		collect func() error
+	}{
+		{"info", func() (err error) {
+			report.Info, err = l.Info(ctx)
+			return err
+		}},
+		{"task types", func() error {
+			tts, err := l.TaskTypes(ctx)
+			for tt := range tts {
+				report.TaskTypes = append(report.TaskTypes, tt)
+			}
+			sort.Slice(report.TaskTypes, func(i, j int) bool {
+				return report.TaskTypes[i] < report.TaskTypes[j]
+			})
+			return err
+		}},
+		{"calls", func() (err error) {
+			report.Calls, err = l.diagnosticCalls()
+			return err
+		}},
+		{"failure rates", func() (err error) {
+			report.FailureRates, err = l.FailureRates(ctx)
+			return err
+		}},
+		{"gpu time", func() (err error) {
+			report.GPUTime, err = l.GPUTimeStats(ctx)
+			return err
+		}},
+		{"task times", func() (err error) {
+			report.TaskTimes, err = l.TaskTimes(ctx)
+			return err
+		}},
+		{"recent errors", func() error {
+			report.RecentErrors = l.callLogs.problems(diagnosticErrorLines)
+			return nil
+		}},
+		{"storage", func() (err error) {
+			report.Storage, err = l.diagnosticStorage(ctx)
+			return err
+		}},
+		{"path latencies", func() (err error) {
+			report.PathLatencies, err = l.StoragePathLatencies(ctx)
+			return err
+		}},
+		{"pending removals", func() (err error) {
+			report.PendingRemovals, err = l.PendingRemovals(ctx)
+			return err
+		}},
+	}
+
+	for _, s := range sections {
+		if err := ctx.Err(); err != nil {
+			return storiface.DiagnosticReport{}, xerrors.Errorf("collecting diagnostics (%s): %w", s.name, err)
+		}
+
+		if err := s.collect(); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", s.name, err))
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return storiface.DiagnosticReport{}, xerrors.Errorf("collecting diagnostics: %w", err)
+	}
+
+	return report, nil
+}
+
+func (l *LocalWorker) diagnosticCalls() ([]storiface.DiagnosticCall, error) {
+	calls, err := l.ct.unfinished()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]storiface.DiagnosticCall, 0, len(calls))
+	for _, call := range calls {
+		out = append(out, storiface.DiagnosticCall{
+			ID:   call.ID,
+			Task: returnTaskType[call.RetType],
+			Done: call.State == CallDone,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID.String() < out[j].ID.String()
+	})
+	return out, nil
+}
+
+func (l *LocalWorker) diagnosticStorage(ctx context.Context) ([]storiface.DiagnosticStorage, error) {
+	checked, err := l.CheckStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	local := map[string]string{}
+	if l.localStore != nil {
+		paths, err := l.localStore.Local(ctx)
+		if err != nil {
+			return nil, xerrors.Errorf("getting local storage paths: %w", err)
+		}
+		for _, p := range paths {
+			local[string(p.ID)] = p.LocalPath
+		}
+	}
+
+	out := make([]storiface.DiagnosticStorage, 0, len(checked))
+	for id, cerr := range checked {
+		ds := storiface.DiagnosticStorage{
+			ID:        string(id),
+			LocalPath: local[string(id)],
+			Reachable: cerr == nil,
+		}
+		if cerr != nil {
+			ds.Err = cerr.Error()
+		}
+		out = append(out, ds)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
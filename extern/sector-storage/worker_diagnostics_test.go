@@ -0,0 +1,53 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestDiagnostics(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		TaskTypes: []sealtasks.TaskType{sealtasks.TTAddPiece, sealtasks.TTFetch},
+	}, nil)
+	defer cleanup()
+
+	ci := storiface.CallID{Sector: abi.SectorID{Miner: 1000, Number: 1}, ID: uuid.New()}
+	require.NoError(t, w.ct.onStart(ci, AddPiece))
+	w.failures.record(sealtasks.TTAddPiece, true)
+	w.callLogs.add(ci, "info", "starting")
+	w.callLogs.add(ci, "error", "it broke")
+
+	report, err := w.Diagnostics(ctx)
+	require.NoError(t, err)
+	require.Empty(t, report.Errors)
+
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTAddPiece, sealtasks.TTFetch}, report.TaskTypes)
+	require.Equal(t, []storiface.DiagnosticCall{{ID: ci, Task: sealtasks.TTAddPiece}}, report.Calls)
+	require.Equal(t, 1, report.FailureRates[sealtasks.TTAddPiece].Failures)
+
+	require.Len(t, report.RecentErrors, 1)
+	require.Equal(t, ci, report.RecentErrors[0].Call)
+	require.Equal(t, "it broke", report.RecentErrors[0].Message)
+
+	require.Equal(t, []storiface.DiagnosticStorage{{ID: string(p.ID), LocalPath: p.LocalPath, Reachable: true}}, report.Storage)
+
+	// the report is serializable
+	_, err = json.Marshal(report)
+	require.NoError(t, err)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = w.Diagnostics(cctx)
+	require.Error(t, err)
+}
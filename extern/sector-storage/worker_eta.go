@@ -0,0 +1,212 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// weight of the last call duration in the moving average of call durations
+const etaDurationWeight = 0.2
+
+type etaKey struct {
+	task      sealtasks.TaskType
+	proofType abi.RegisteredSealProof
+}
+
+type etaCall struct {
+	key   etaKey
+	start time.Time // zero while the call waits in the queue
+
+	// sub-phases the call finished so far, see ffiwrapper.TaskPhases
+	phases func() []storiface.TaskPhase
+}
+
+// callETA estimates when calls will be done, from how long calls of the same
+// task and proof type took on the worker before, and which calls are ahead of
+// them in the call queue
+type callETA struct {
+	lk sync.Mutex
+
+	durations map[etaKey]time.Duration
+	phases    map[etaKey]map[string]time.Duration // moving averages like durations
+	calls     map[storiface.CallID]*etaCall
+}
+
+func newCallETA() *callETA {
+	return &callETA{
+		durations: map[etaKey]time.Duration{},
+		phases:    map[etaKey]map[string]time.Duration{},
+		calls:     map[storiface.CallID]*etaCall{},
+	}
+}
+
+func etaAverage(prev time.Duration, hasPrev bool, took time.Duration) time.Duration {
+	if !hasPrev {
+		return took
+	}
+	return time.Duration(float64(prev)*(1-etaDurationWeight) + float64(took)*etaDurationWeight)
+}
+
+func (e *callETA) queued(ci storiface.CallID, key etaKey) {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	e.calls[ci] = &etaCall{key: key}
+}
+
+// started marks the call running, ctx is the context it runs with
+func (e *callETA) started(ctx context.Context, ci storiface.CallID) {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	if c, ok := e.calls[ci]; ok {
+		c.start = time.Now()
+		c.phases = func() []storiface.TaskPhase {
+			return ffiwrapper.TaskPhases(ctx)
+		}
+	}
+}
+
+// done forgets the call, durations of calls which ran and succeeded are added
+// to the history
+func (e *callETA) done(ci storiface.CallID, ok bool) {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	c, found := e.calls[ci]
+	if !found {
+		return
+	}
+	delete(e.calls, ci)
+
+	if !ok || c.start.IsZero() {
+		return
+	}
+
+	prev, hasPrev := e.durations[c.key]
+	e.durations[c.key] = etaAverage(prev, hasPrev, time.Since(c.start))
+
+	phases := c.phases()
+	if len(phases) > 0 && e.phases[c.key] == nil {
+		e.phases[c.key] = map[string]time.Duration{}
+	}
+	for _, p := range phases {
+		prev, hasPrev := e.phases[c.key][p.Phase]
+		e.phases[c.key][p.Phase] = etaAverage(prev, hasPrev, p.Took)
+	}
+}
+
+// remaining estimates how long a running call has left. Phases it finished
+// tell how far the call got, the rest is expected to take as long as it did
+// for calls before it. Returns false for calls which wait in the queue, have
+// no history, or ran longer than expected.
+func (e *callETA) remaining(ci storiface.CallID) (time.Duration, bool) {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	c, ok := e.calls[ci]
+	if !ok || c.start.IsZero() {
+		return 0, false
+	}
+
+	expected, ok := e.durations[c.key]
+	if !ok {
+		return 0, false
+	}
+	elapsed := time.Since(c.start)
+
+	// time expected for, and actually spent in finished phases
+	var expectedDone, tookDone time.Duration
+	for _, p := range c.phases() {
+		d, ok := e.phases[c.key][p.Phase]
+		if !ok {
+			continue
+		}
+		expectedDone += d
+		tookDone += p.Took
+	}
+
+	// counted from when the last finished phase ended
+	left := expected - expectedDone - (elapsed - tookDone)
+
+	if left <= 0 {
+		return 0, false
+	}
+	return left, true
+}
+
+// estimate returns how long a new call would take to finish, including waiting
+// for calls ahead of it in the queue. The estimate is rough, it assumes calls
+// start in arrival order, and calls without a history take no time. Returns
+// false when there is no history for the call itself.
+func (e *callETA) estimate(key etaKey, limit int) (time.Duration, bool) {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	took, ok := e.durations[key]
+	if !ok {
+		return 0, false
+	}
+
+	if limit <= 0 || len(e.calls) < limit {
+		return took, true
+	}
+
+	// queued calls wait for running calls, and are then spread over the slots
+	var ahead time.Duration
+	for _, c := range e.calls {
+		d := e.durations[c.key]
+		if !c.start.IsZero() {
+			d -= time.Since(c.start)
+			if d < 0 {
+				d = 0
+			}
+		}
+		ahead += d
+	}
+
+	return ahead/time.Duration(limit) + took, true
+}
+
+// CallETA estimates when a running call will be done, from how long calls of
+// the same task and proof type took before, how long the call has been running,
+// and which sub-phases of the task it finished (see ffiwrapper.StartPhase).
+// Returns false when there is no reasonable estimate: the call isn't running,
+// e.g. because it waits in the queue, the worker has no history for its task,
+// or it ran longer than expected.
+func (l *LocalWorker) CallETA(ctx context.Context, ci storiface.CallID) (time.Time, bool) {
+	left, ok := l.eta.remaining(ci)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Now().Add(left), true
+}
+
+// CheckTaskDeadline returns a CallError with the ErrTempDeadlineInfeasible
+// code when a task for the sector started now isn't expected to be done by the
+// deadline, given calls queued on the worker and how long the task took
+// before. Without history for the task there is no estimate, and no error. The
+// error is returned as a value, so that the scheduler gets its code also from
+// remote workers.
+func (l *LocalWorker) CheckTaskDeadline(ctx context.Context, sector storage.SectorRef, task sealtasks.TaskType, deadline time.Time) (*storiface.CallError, error) {
+	eta, ok := l.eta.estimate(etaKey{task: task, proofType: sector.ProofType}, l.queue.limit)
+	if !ok {
+		return nil, nil
+	}
+
+	if done := time.Now().Add(eta); done.After(deadline) {
+		return storiface.Err(storiface.ErrTempDeadlineInfeasible, xerrors.Errorf("%s for sector %d expected to be done at %s, after the deadline %s", task, sector.ID, done.Format(time.RFC3339), deadline.Format(time.RFC3339))), nil
+	}
+
+	return nil, nil
+}
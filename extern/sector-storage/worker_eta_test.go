@@ -0,0 +1,157 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestCallETA(t *testing.T) {
+	pc1 := etaKey{task: sealtasks.TTPreCommit1, proofType: abi.RegisteredSealProof_StackedDrg2KiBV1}
+	pc2 := etaKey{task: sealtasks.TTPreCommit2, proofType: abi.RegisteredSealProof_StackedDrg2KiBV1}
+
+	e := newCallETA()
+
+	_, ok := e.estimate(pc1, 1)
+	require.False(t, ok)
+
+	e.durations[pc1] = time.Hour
+	e.durations[pc2] = 4 * time.Hour
+
+	eta, ok := e.estimate(pc1, 1)
+	require.True(t, ok)
+	require.Equal(t, time.Hour, eta)
+
+	// a running PC2 occupies the only slot
+	running := storiface.CallID{ID: uuid.New()}
+	e.queued(running, pc2)
+	e.started(context.Background(), running)
+
+	eta, _ = e.estimate(pc1, 1)
+	require.InDelta(t, float64(5*time.Hour), float64(eta), float64(time.Minute))
+
+	// with two slots there is room for the call, until another one queues
+	eta, _ = e.estimate(pc1, 2)
+	require.Equal(t, time.Hour, eta)
+
+	queued := storiface.CallID{ID: uuid.New()}
+	e.queued(queued, pc1)
+	eta, _ = e.estimate(pc1, 2)
+	require.InDelta(t, float64(time.Hour*7/2), float64(eta), float64(time.Minute))
+
+	// no limit means no waiting
+	eta, _ = e.estimate(pc1, 0)
+	require.Equal(t, time.Hour, eta)
+
+	// durations of failed, or not started calls aren't recorded
+	e.done(running, false)
+	e.done(queued, true)
+	require.Equal(t, 4*time.Hour, e.durations[pc2])
+	require.Equal(t, time.Hour, e.durations[pc1])
+	require.Empty(t, e.calls)
+}
+
+func TestCallETARemaining(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	pc1 := etaKey{task: sealtasks.TTPreCommit1, proofType: abi.RegisteredSealProof_StackedDrg2KiBV1}
+	ci := storiface.CallID{ID: uuid.New()}
+
+	_, ok := w.CallETA(ctx, ci)
+	require.False(t, ok)
+
+	// queued calls and calls without history have no estimate
+	w.eta.queued(ci, pc1)
+	_, ok = w.CallETA(ctx, ci)
+	require.False(t, ok)
+
+	cctx := ffiwrapper.WithPhaseTimer(ctx)
+	w.eta.started(cctx, ci)
+	_, ok = w.CallETA(ctx, ci)
+	require.False(t, ok)
+
+	w.eta.lk.Lock()
+	w.eta.durations[pc1] = time.Hour
+	w.eta.phases[pc1] = map[string]time.Duration{
+		ffiwrapper.PhaseAcquire: 30 * time.Minute,
+		ffiwrapper.PhaseSDR:     30 * time.Minute,
+	}
+	w.eta.lk.Unlock()
+
+	eta, ok := w.CallETA(ctx, ci)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Hour), eta, time.Minute)
+
+	// finishing acquire quickly leaves the time sdr usually takes
+	ffiwrapper.StartPhase(cctx, ffiwrapper.PhaseAcquire)()
+
+	eta, ok = w.CallETA(ctx, ci)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(30*time.Minute), eta, time.Minute)
+
+	// past the expected time
+	w.eta.lk.Lock()
+	w.eta.calls[ci].start = time.Now().Add(-2 * time.Hour)
+	w.eta.lk.Unlock()
+
+	_, ok = w.CallETA(ctx, ci)
+	require.False(t, ok)
+
+	// phase durations of finished calls are recorded
+	w.eta.done(ci, true)
+	w.eta.lk.Lock()
+	defer w.eta.lk.Unlock()
+	require.Less(t, int64(w.eta.phases[pc1][ffiwrapper.PhaseAcquire]), int64(30*time.Minute))
+	require.Equal(t, 30*time.Minute, w.eta.phases[pc1][ffiwrapper.PhaseSDR])
+}
+
+func TestCheckTaskDeadline(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	check := func(deadline time.Time) *storiface.CallError {
+		cerr, err := w.CheckTaskDeadline(ctx, sector, sealtasks.TTAddPiece, deadline)
+		require.NoError(t, err)
+		return cerr
+	}
+
+	// without history there is no estimate
+	require.Nil(t, check(time.Now()))
+
+	key := etaKey{task: sealtasks.TTAddPiece, proofType: sector.ProofType}
+	w.eta.lk.Lock()
+	w.eta.durations[key] = time.Hour
+	w.eta.lk.Unlock()
+
+	cerr := check(time.Now().Add(time.Minute))
+	require.NotNil(t, cerr)
+	require.Equal(t, storiface.ErrTempDeadlineInfeasible, cerr.Code)
+	require.True(t, cerr.Retryable)
+
+	require.Nil(t, check(time.Now().Add(2*time.Hour)))
+
+	// other tasks don't count
+	cerr, err := w.CheckTaskDeadline(ctx, sector, sealtasks.TTPreCommit1, time.Now())
+	require.NoError(t, err)
+	require.Nil(t, cerr)
+}
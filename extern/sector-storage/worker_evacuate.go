@@ -0,0 +1,360 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// EvacuateFile [path]/evacuate.json
+//
+// Written into local paths which are being evacuated, so that the worker
+// keeps refusing new sectors after restarting, until the evacuation is
+// cancelled.
+const EvacuateFile = "evacuate.json"
+
+type evacuation struct {
+	lk sync.Mutex
+
+	// set while the worker is evacuating, or was evacuated
+	sel      *storiface.EvacuateSelector
+	progress storiface.EvacProgress
+}
+
+func (e *evacuation) active() bool {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	return e.sel != nil
+}
+
+// loadEvacuation restores the evacuation state persisted in local paths
+func (l *LocalWorker) loadEvacuation(ctx context.Context) error {
+	if l.localStore == nil {
+		return nil
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(filepath.Join(p.LocalPath, EvacuateFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return xerrors.Errorf("reading evacuation state: %w", err)
+		}
+
+		var sel storiface.EvacuateSelector
+		if err := json.Unmarshal(b, &sel); err != nil {
+			return xerrors.Errorf("parsing evacuation state in %s: %w", p.LocalPath, err)
+		}
+
+		l.evac.lk.Lock()
+		l.evac.sel = &sel
+		l.evac.lk.Unlock()
+
+		log.Warnw("worker is evacuated, not accepting new tasks", "path", p.LocalPath)
+		return nil
+	}
+
+	return nil
+}
+
+// Evacuate moves all sectors out of local paths not selected by sel into the
+// selected paths, e.g. before decommissioning the machine. From the start of
+// the evacuation the worker refuses new tasks and sectors, also after
+// restarting, until CancelEvacuation is called.
+//
+// Pinned sectors, and sectors with tasks in progress, are left in place.
+// Cancelling ctx stops the evacuation after the sector being moved, which is
+// never left partially moved. Interrupted evacuations are resumed by calling
+// Evacuate again, sectors which were moved already aren't moved again. Calling
+// Evacuate with an empty selector resumes with the selector of the last
+// evacuation.
+func (l *LocalWorker) Evacuate(ctx context.Context, sel storiface.EvacuateSelector) (storiface.EvacProgress, error) {
+	sel, sources, err := l.startEvacuation(ctx, sel)
+	if err != nil {
+		return storiface.EvacProgress{}, err
+	}
+
+	return l.runEvacuation(ctx, sel, sources, func(storiface.EvacUpdate) {})
+}
+
+// EvacuateUpdates is Evacuate, running in the background, which reports on
+// the returned channel each sector it's done with, then the summary of the
+// evacuation, after which the channel is closed. Updates are dropped when ctx
+// is cancelled and the receiver doesn't keep up, the summary can then be
+// retrieved with EvacuationProgress.
+func (l *LocalWorker) EvacuateUpdates(ctx context.Context, sel storiface.EvacuateSelector) (<-chan storiface.EvacUpdate, error) {
+	sel, sources, err := l.startEvacuation(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan storiface.EvacUpdate, 1)
+
+	send := func(u storiface.EvacUpdate) {
+		select {
+		case out <- u:
+		case <-ctx.Done():
+			select {
+			case out <- u:
+			default:
+			}
+		}
+	}
+
+	go func() {
+		defer close(out)
+
+		progress, err := l.runEvacuation(ctx, sel, sources, send)
+		summary := storiface.EvacUpdate{Progress: progress}
+		if err != nil {
+			summary.Error = err.Error()
+		}
+		send(summary)
+	}()
+
+	return out, nil
+}
+
+// startEvacuation checks the selector, and marks the evacuation as running,
+// returning the selector to use and the paths to evacuate
+func (l *LocalWorker) startEvacuation(ctx context.Context, sel storiface.EvacuateSelector) (storiface.EvacuateSelector, []stores.StoragePath, error) {
+	if l.localStore == nil {
+		return sel, nil, xerrors.Errorf("worker doesn't use local storage paths")
+	}
+	if len(sel.Paths) == 0 {
+		// resume the persisted evacuation
+		l.evac.lk.Lock()
+		if l.evac.sel != nil {
+			sel = *l.evac.sel
+		}
+		l.evac.lk.Unlock()
+	}
+	if len(sel.Paths) == 0 {
+		return sel, nil, xerrors.Errorf("no destination paths selected")
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return sel, nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	dest := map[stores.ID]bool{}
+	for _, id := range sel.Paths {
+		dest[stores.ID(id)] = true
+	}
+
+	var sources []stores.StoragePath
+	for _, p := range paths {
+		if !dest[p.ID] {
+			sources = append(sources, p)
+		}
+	}
+	if len(sources)+len(dest) != len(paths) {
+		return sel, nil, xerrors.Errorf("destination paths must be local paths of the worker")
+	}
+
+	l.evac.lk.Lock()
+	if l.evac.progress.Running {
+		l.evac.lk.Unlock()
+		return sel, nil, xerrors.Errorf("evacuation already running")
+	}
+	l.evac.sel = &sel
+	l.evac.progress = storiface.EvacProgress{Running: true}
+	l.evac.lk.Unlock()
+
+	return sel, sources, nil
+}
+
+func (l *LocalWorker) runEvacuation(ctx context.Context, sel storiface.EvacuateSelector, sources []stores.StoragePath, report func(storiface.EvacUpdate)) (storiface.EvacProgress, error) {
+	progress, err := l.evacuate(ctx, sel, sources, report)
+
+	l.evac.lk.Lock()
+	l.evac.progress = progress
+	l.evac.lk.Unlock()
+
+	return progress, err
+}
+
+func (l *LocalWorker) evacuate(ctx context.Context, sel storiface.EvacuateSelector, sources []stores.StoragePath, report func(storiface.EvacUpdate)) (storiface.EvacProgress, error) {
+	var progress storiface.EvacProgress
+
+	b, err := json.Marshal(&sel)
+	if err != nil {
+		return progress, xerrors.Errorf("marshalling evacuation state: %w", err)
+	}
+
+	isSource := map[stores.ID]bool{}
+	for _, p := range sources {
+		isSource[p.ID] = true
+
+		if err := ioutil.WriteFile(filepath.Join(p.LocalPath, EvacuateFile), b, 0644); err != nil {
+			return progress, xerrors.Errorf("persisting evacuation state: %w", err)
+		}
+	}
+
+	files, err := l.localSectorFiles(ctx, allFileTypes())
+	if err != nil {
+		return progress, err
+	}
+
+	types := map[abi.SectorID]storiface.SectorFileType{}
+	for _, f := range files {
+		if isSource[f.Storage] {
+			types[f.Sector] |= f.Type
+		}
+	}
+
+	sectors := make([]abi.SectorID, 0, len(types))
+	for sid := range types {
+		sectors = append(sectors, sid)
+	}
+	sort.Slice(sectors, func(i, j int) bool {
+		return sectorLess(sectors[i], sectors[j])
+	})
+
+	pinned := map[abi.SectorID]bool{}
+	for _, sid := range sel.Pinned {
+		pinned[sid] = true
+	}
+
+	active, err := l.ct.activeSectors()
+	if err != nil {
+		return progress, xerrors.Errorf("getting active sectors: %w", err)
+	}
+
+	// sectors are moved to the end once started, only closing the worker
+	// interrupts them
+	mctx := &wctx{
+		vals:    ctx,
+		closing: l.closing,
+	}
+
+	progress.Running = true
+	progress.Sectors = len(sectors)
+	l.setEvacProgress(progress)
+
+	for i, sid := range sectors {
+		if err := ctx.Err(); err != nil {
+			progress.Running = false
+			progress.Remaining = sectors[i:]
+			log.Warnw("evacuation stopped", "moved", progress.Moved, "remaining", len(progress.Remaining))
+			return progress, xerrors.Errorf("evacuation stopped with %d sectors remaining: %w", len(progress.Remaining), err)
+		}
+
+		u := storiface.EvacUpdate{Sector: sid}
+		switch _, busy := active[sid]; {
+		case pinned[sid]:
+			progress.Pinned = append(progress.Pinned, sid)
+			u.Result = storiface.EvacPinned
+		case busy:
+			progress.Busy = append(progress.Busy, sid)
+			u.Result = storiface.EvacBusy
+		default:
+			if err := l.evacuateSector(mctx, sid, types[sid], sel.Paths); err != nil {
+				log.Errorw("evacuating sector", "sector", sid, "error", err)
+				progress.Failed = append(progress.Failed, sid)
+				u.Result, u.Error = storiface.EvacFailed, err.Error()
+				break
+			}
+			progress.Moved++
+			u.Result = storiface.EvacMoved
+		}
+
+		l.setEvacProgress(progress)
+		u.Progress = copyEvacProgress(progress)
+		report(u)
+	}
+
+	progress.Running = false
+	progress.Done = len(progress.Busy) == 0 && len(progress.Failed) == 0
+	return progress, nil
+}
+
+// evacuateSector moves the sector to the first destination path with enough
+// space
+func (l *LocalWorker) evacuateSector(ctx context.Context, sid abi.SectorID, types storiface.SectorFileType, dests []string) error {
+	var err error
+	for _, dest := range dests {
+		err = l.MoveSectorToPath(ctx, sid, types, stores.ID(dest))
+		var cerr *storiface.CallError
+		if err == nil || !xerrors.As(err, &cerr) || cerr.Code != storiface.ErrTempAllocateSpace {
+			return err
+		}
+	}
+	return err
+}
+
+// copyEvacProgress copies sector lists of the progress, which evacuate keeps
+// appending to
+func copyEvacProgress(p storiface.EvacProgress) storiface.EvacProgress {
+	cp := func(s []abi.SectorID) []abi.SectorID {
+		if s == nil {
+			return nil
+		}
+		return append([]abi.SectorID{}, s...)
+	}
+
+	p.Pinned, p.Busy, p.Failed, p.Remaining = cp(p.Pinned), cp(p.Busy), cp(p.Failed), cp(p.Remaining)
+	return p
+}
+
+func (l *LocalWorker) setEvacProgress(progress storiface.EvacProgress) {
+	l.evac.lk.Lock()
+	defer l.evac.lk.Unlock()
+
+	l.evac.progress = progress
+}
+
+// EvacuationProgress returns progress of the current, or last evacuation
+func (l *LocalWorker) EvacuationProgress(ctx context.Context) (storiface.EvacProgress, error) {
+	l.evac.lk.Lock()
+	defer l.evac.lk.Unlock()
+
+	return l.evac.progress, nil
+}
+
+// CancelEvacuation makes an evacuated worker accept new tasks again. Sectors
+// which were moved stay in their new paths.
+func (l *LocalWorker) CancelEvacuation(ctx context.Context) error {
+	l.evac.lk.Lock()
+	defer l.evac.lk.Unlock()
+
+	if l.evac.progress.Running {
+		return xerrors.Errorf("evacuation running, cancel the Evacuate call first")
+	}
+
+	if l.localStore != nil {
+		paths, err := l.localStore.Local(ctx)
+		if err != nil {
+			return xerrors.Errorf("getting local storage paths: %w", err)
+		}
+
+		for _, p := range paths {
+			if err := os.Remove(filepath.Join(p.LocalPath, EvacuateFile)); err != nil && !os.IsNotExist(err) {
+				return xerrors.Errorf("removing evacuation state: %w", err)
+			}
+		}
+	}
+
+	l.evac.sel = nil
+	l.evac.progress = storiface.EvacProgress{}
+	return nil
+}
@@ -0,0 +1,217 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// openEvacuationDest opens a new storage path in the worker to evacuate to
+func openEvacuationDest(ctx context.Context, t *testing.T, w *LocalWorker) (string, stores.ID) {
+	dir := t.TempDir()
+	destID := stores.ID(uuid.New().String())
+	b, err := json.Marshal(&stores.LocalStorageMeta{
+		ID:       destID,
+		Weight:   1,
+		CanStore: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, stores.MetaFile), b, 0644))
+	require.NoError(t, w.localStore.OpenPath(ctx, dir))
+
+	return dir, destID
+}
+
+func TestEvacuate(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		TaskTypes: []sealtasks.TaskType{sealtasks.TTAddPiece, sealtasks.TTFetch},
+	}, nil)
+	defer cleanup()
+
+	dir, destID := openEvacuationDest(ctx, t, w)
+
+	moved := abi.SectorID{Miner: 1000, Number: 1}
+	pinned := abi.SectorID{Miner: 1000, Number: 2}
+	busy := abi.SectorID{Miner: 1000, Number: 3}
+	for _, sid := range []abi.SectorID{moved, pinned, busy} {
+		writeTestSectorFile(ctx, t, p, si, sid, storiface.FTSealed, 1<<10)
+	}
+	writeTestSectorFile(ctx, t, p, si, moved, storiface.FTUnsealed, 1<<10)
+
+	busyCall := storiface.CallID{Sector: busy, ID: uuid.New()}
+	require.NoError(t, w.ct.onStart(busyCall, SealPreCommit1))
+
+	inDest := func(sid abi.SectorID, ft storiface.SectorFileType) string {
+		return filepath.Join(dir, ft.String(), storiface.SectorName(sid))
+	}
+
+	_, err := w.Evacuate(ctx, storiface.EvacuateSelector{})
+	require.Error(t, err)
+	_, err = w.Evacuate(ctx, storiface.EvacuateSelector{Paths: []string{"missing"}})
+	require.Error(t, err)
+
+	progress, err := w.Evacuate(ctx, storiface.EvacuateSelector{
+		Paths:  []string{string(destID)},
+		Pinned: []abi.SectorID{pinned},
+	})
+	require.NoError(t, err)
+	require.Equal(t, storiface.EvacProgress{
+		Sectors: 3,
+		Moved:   1,
+		Pinned:  []abi.SectorID{pinned},
+		Busy:    []abi.SectorID{busy},
+	}, progress)
+
+	require.FileExists(t, inDest(moved, storiface.FTSealed))
+	require.FileExists(t, inDest(moved, storiface.FTUnsealed))
+	require.NoFileExists(t, inDest(pinned, storiface.FTSealed))
+
+	status, err := w.EvacuationProgress(ctx)
+	require.NoError(t, err)
+	require.Equal(t, progress, status)
+
+	// no new tasks or sectors are accepted, also after restarting
+	w.evac = evacuation{}
+	require.NoError(t, w.loadEvacuation(ctx))
+
+	tts, err := w.TaskTypes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, tts)
+
+	err = w.checkSectorLimit(ctx, abi.SectorID{Miner: 1000, Number: 4})
+	var cerr *storiface.CallError
+	require.True(t, xerrors.As(err, &cerr))
+	require.Equal(t, storiface.ErrTempTooManySectors, cerr.Code)
+	require.NoError(t, w.checkSectorLimit(ctx, pinned))
+
+	// resuming moves sectors which aren't busy anymore
+	require.NoError(t, w.ct.onDone(busyCall, nil))
+	require.NoError(t, w.ct.onReturned(busyCall))
+
+	progress, err = w.Evacuate(ctx, storiface.EvacuateSelector{})
+	require.NoError(t, err)
+	require.Equal(t, storiface.EvacProgress{
+		Sectors: 2,
+		Moved:   1,
+		Pinned:  []abi.SectorID{pinned},
+		Done:    true,
+	}, progress)
+	require.FileExists(t, inDest(busy, storiface.FTSealed))
+
+	require.NoError(t, w.CancelEvacuation(ctx))
+	require.NoFileExists(t, filepath.Join(p.LocalPath, EvacuateFile))
+
+	tts, err = w.TaskTypes(ctx)
+	require.NoError(t, err)
+	require.Len(t, tts, 2)
+	require.NoError(t, w.checkSectorLimit(ctx, abi.SectorID{Miner: 1000, Number: 4}))
+}
+
+func TestEvacuateUpdates(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	_, destID := openEvacuationDest(ctx, t, w)
+
+	moved := abi.SectorID{Miner: 1000, Number: 1}
+	pinned := abi.SectorID{Miner: 1000, Number: 2}
+	for _, sid := range []abi.SectorID{moved, pinned} {
+		writeTestSectorFile(ctx, t, p, si, sid, storiface.FTSealed, 1<<10)
+	}
+
+	_, err := w.EvacuateUpdates(ctx, storiface.EvacuateSelector{Paths: []string{"missing"}})
+	require.Error(t, err)
+
+	updates, err := w.EvacuateUpdates(ctx, storiface.EvacuateSelector{
+		Paths:  []string{string(destID)},
+		Pinned: []abi.SectorID{pinned},
+	})
+	require.NoError(t, err)
+
+	var got []storiface.EvacUpdate
+	for u := range updates {
+		got = append(got, u)
+	}
+
+	require.Len(t, got, 3)
+	require.Equal(t, moved, got[0].Sector)
+	require.Equal(t, storiface.EvacMoved, got[0].Result)
+	require.Equal(t, 1, got[0].Progress.Moved)
+	require.Equal(t, pinned, got[1].Sector)
+	require.Equal(t, storiface.EvacPinned, got[1].Result)
+
+	require.Equal(t, storiface.EvacResult(""), got[2].Result)
+	require.Empty(t, got[2].Error)
+	require.Equal(t, storiface.EvacProgress{
+		Sectors: 2,
+		Moved:   1,
+		Pinned:  []abi.SectorID{pinned},
+		Done:    true,
+	}, got[2].Progress)
+}
+
+func TestEvacuateStopsAfterSector(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	dir, destID := openEvacuationDest(ctx, t, w)
+
+	sectors := []abi.SectorID{{Miner: 1000, Number: 1}, {Miner: 1000, Number: 2}, {Miner: 1000, Number: 3}}
+	for _, sid := range sectors {
+		writeTestSectorFile(ctx, t, p, si, sid, storiface.FTSealed, 1<<10)
+	}
+
+	sel, sources, err := w.startEvacuation(ctx, storiface.EvacuateSelector{Paths: []string{string(destID)}})
+	require.NoError(t, err)
+
+	// cancelled while the first sector is moved
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var reported []abi.SectorID
+	progress, err := w.runEvacuation(cctx, sel, sources, func(u storiface.EvacUpdate) {
+		reported = append(reported, u.Sector)
+		cancel()
+	})
+	require.True(t, xerrors.Is(err, context.Canceled), "%+v", err)
+	require.Equal(t, []abi.SectorID{sectors[0]}, reported)
+	require.Equal(t, storiface.EvacProgress{
+		Sectors:   3,
+		Moved:     1,
+		Remaining: sectors[1:],
+	}, progress)
+
+	require.FileExists(t, filepath.Join(dir, storiface.FTSealed.String(), storiface.SectorName(sectors[0])))
+	require.NoFileExists(t, filepath.Join(dir, storiface.FTSealed.String(), storiface.SectorName(sectors[1])))
+
+	status, err := w.EvacuationProgress(ctx)
+	require.NoError(t, err)
+	require.Equal(t, progress, status)
+
+	// resuming moves the remaining sectors
+	progress, err = w.Evacuate(ctx, storiface.EvacuateSelector{})
+	require.NoError(t, err)
+	require.Equal(t, storiface.EvacProgress{
+		Sectors: 2,
+		Moved:   2,
+		Done:    true,
+	}, progress)
+}
@@ -0,0 +1,96 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// DefaultFailureWindow is the number of recent calls of each task type
+// failure rates are computed over, when not configured
+const DefaultFailureWindow = 50
+
+// failureWindow keeps outcomes of the last calls of each task type
+type failureWindow struct {
+	lk   sync.Mutex
+	size int
+
+	// outcomes are ring buffers, true for failed calls
+	outcomes map[sealtasks.TaskType]*outcomeRing
+}
+
+type outcomeRing struct {
+	failed   []bool
+	next     int
+	failures int
+}
+
+func newFailureWindow(size int) *failureWindow {
+	if size <= 0 {
+		size = DefaultFailureWindow
+	}
+
+	return &failureWindow{
+		size:     size,
+		outcomes: map[sealtasks.TaskType]*outcomeRing{},
+	}
+}
+
+func (fw *failureWindow) record(tt sealtasks.TaskType, failed bool) {
+	fw.lk.Lock()
+	defer fw.lk.Unlock()
+
+	r, ok := fw.outcomes[tt]
+	if !ok {
+		r = &outcomeRing{}
+		fw.outcomes[tt] = r
+	}
+
+	if len(r.failed) < fw.size {
+		r.failed = append(r.failed, failed)
+	} else {
+		if r.failed[r.next] {
+			r.failures--
+		}
+		r.failed[r.next] = failed
+		r.next = (r.next + 1) % fw.size
+	}
+
+	if failed {
+		r.failures++
+	}
+}
+
+func (fw *failureWindow) rates() map[sealtasks.TaskType]storiface.TaskFailureRate {
+	fw.lk.Lock()
+	defer fw.lk.Unlock()
+
+	out := make(map[sealtasks.TaskType]storiface.TaskFailureRate, len(fw.outcomes))
+	for tt, r := range fw.outcomes {
+		out[tt] = storiface.TaskFailureRate{
+			Calls:    len(r.failed),
+			Failures: r.failures,
+			Rate:     float64(r.failures) / float64(len(r.failed)),
+		}
+	}
+	return out
+}
+
+// recordOutcome adds the outcome of a call to failure rates. Calls cancelled by
+// the caller say nothing about the worker's health, and aren't counted.
+func (l *LocalWorker) recordOutcome(ctx context.Context, rt ReturnType, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	l.failures.record(returnTaskType[rt], err != nil)
+}
+
+// FailureRates returns the share of failed calls of each task type, over the
+// last calls of that type (see WorkerConfig.FailureWindow). Task types without
+// any calls yet aren't included.
+func (l *LocalWorker) FailureRates(ctx context.Context) (map[sealtasks.TaskType]storiface.TaskFailureRate, error) {
+	return l.failures.rates(), nil
+}
@@ -0,0 +1,69 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestFailureWindow(t *testing.T) {
+	fw := newFailureWindow(4)
+	require.Empty(t, fw.rates())
+
+	for _, failed := range []bool{true, false, true, false} {
+		fw.record(sealtasks.TTPreCommit1, failed)
+	}
+	fw.record(sealtasks.TTCommit2, false)
+
+	require.Equal(t, map[sealtasks.TaskType]storiface.TaskFailureRate{
+		sealtasks.TTPreCommit1: {Calls: 4, Failures: 2, Rate: 0.5},
+		sealtasks.TTCommit2:    {Calls: 1, Failures: 0, Rate: 0},
+	}, fw.rates())
+
+	// old outcomes leave the window
+	fw.record(sealtasks.TTPreCommit1, false)
+	fw.record(sealtasks.TTPreCommit1, false)
+	require.Equal(t, storiface.TaskFailureRate{Calls: 4, Failures: 1, Rate: 0.25}, fw.rates()[sealtasks.TTPreCommit1])
+
+	fw.record(sealtasks.TTPreCommit1, true)
+	fw.record(sealtasks.TTPreCommit1, true)
+	require.Equal(t, storiface.TaskFailureRate{Calls: 4, Failures: 2, Rate: 0.5}, fw.rates()[sealtasks.TTPreCommit1])
+}
+
+func TestFailureRates(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{FailureWindow: 10}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 1}}
+	call := func(ctx context.Context, err error) {
+		ci := storiface.CallID{Sector: sector.ID}
+		_, _ = w.runCall(ctx, sector, SealCommit2, ci, func(context.Context, storiface.CallID) (interface{}, error) {
+			return nil, err
+		})
+	}
+
+	call(ctx, nil)
+	call(ctx, xerrors.New("fail"))
+	call(ctx, nil)
+
+	// calls cancelled by the caller aren't counted
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	call(cctx, xerrors.New("cancelled"))
+
+	rates, err := w.FailureRates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[sealtasks.TaskType]storiface.TaskFailureRate{
+		sealtasks.TTCommit2: {Calls: 3, Failures: 1, Rate: 1.0 / 3},
+	}, rates)
+}
@@ -0,0 +1,80 @@
+package sectorstorage
+
+import (
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// FFIErrorRule classifies errors of the proofs backend whose message contains
+// Match, ignoring case. Fatal errors are reported as storiface.ErrFFIFatal, so
+// that the call isn't dispatched again, others as storiface.ErrTempFFI, which
+// the manager retries.
+type FFIErrorRule struct {
+	Match string
+	Fatal bool
+}
+
+// DefaultFFIErrorRules classify common errors of the proofs backend. Resource
+// contention is worth a retry, corrupt or mismatching input isn't.
+var DefaultFFIErrorRules = []FFIErrorRule{
+	{Match: "out of memory"},
+	{Match: "cannot allocate memory"},
+	{Match: "resource temporarily unavailable"},
+	{Match: "device or resource busy"},
+	{Match: "CUDA_ERROR"},
+	{Match: "CL_OUT_OF_RESOURCES"},
+
+	{Match: "invalid", Fatal: true},
+	{Match: "mismatch", Fatal: true},
+	{Match: "corrupt", Fatal: true},
+	{Match: "unexpected eof", Fatal: true},
+}
+
+// ParseFFIErrorRule parses a rule given as fatal:<match> or
+// recoverable:<match>
+func ParseFFIErrorRule(s string) (FFIErrorRule, error) {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return FFIErrorRule{}, xerrors.Errorf("invalid FFI error rule %q, expected fatal:<match> or recoverable:<match>", s)
+	}
+
+	switch kv[0] {
+	case "fatal":
+		return FFIErrorRule{Match: kv[1], Fatal: true}, nil
+	case "recoverable":
+		return FFIErrorRule{Match: kv[1]}, nil
+	default:
+		return FFIErrorRule{}, xerrors.Errorf("unknown FFI error class %q in rule %q, expected fatal or recoverable", kv[0], s)
+	}
+}
+
+// classifyError gives errors returned by a task's work a recoverable or fatal
+// error code, with the first rule matching the error. Errors which already
+// carry a CallError, or don't match any rule, are returned as they are.
+func (l *LocalWorker) classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var cerr *storiface.CallError
+	if xerrors.As(err, &cerr) {
+		return err
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, rule := range l.ffiErrorRules {
+		if !strings.Contains(msg, strings.ToLower(rule.Match)) {
+			continue
+		}
+
+		if rule.Fatal {
+			return storiface.Err(storiface.ErrFFIFatal, err)
+		}
+		return storiface.Err(storiface.ErrTempFFI, err)
+	}
+
+	return err
+}
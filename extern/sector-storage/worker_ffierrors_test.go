@@ -0,0 +1,86 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+type failingC2Exec struct {
+	testExec
+
+	err error
+}
+
+func (e *failingC2Exec) SealCommit2(ctx context.Context, sector storage.SectorRef, c1o storage.Commit1Out) (storage.Proof, error) {
+	return nil, e.err
+}
+
+func TestClassifyFFIErrors(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &c2Returns{errs: make(chan *storiface.CallError, 1), proofs: make(chan storage.Proof, 1)}
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		FFIErrorRules: []FFIErrorRule{{Match: "invalid device"}},
+	}, ret)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	commit2 := func(err error) *storiface.CallError {
+		w.executor = func() (ffiwrapper.Storage, error) {
+			return &failingC2Exec{err: err}, nil
+		}
+
+		_, cerr := w.SealCommit2(ctx, sector, storage.Commit1Out("c1o"))
+		require.NoError(t, cerr)
+		<-ret.proofs
+		return <-ret.errs
+	}
+
+	cerr := commit2(xerrors.New("GPU failed: CUDA_ERROR_OUT_OF_MEMORY"))
+	require.Equal(t, storiface.ErrTempFFI, cerr.Code)
+	require.True(t, cerr.Retryable)
+
+	cerr = commit2(xerrors.New("commit phase 2: Invalid vanilla proof"))
+	require.Equal(t, storiface.ErrFFIFatal, cerr.Code)
+	require.False(t, cerr.Retryable)
+
+	// configured rules are checked first
+	cerr = commit2(xerrors.New("invalid device ordinal"))
+	require.Equal(t, storiface.ErrTempFFI, cerr.Code)
+
+	cerr = commit2(xerrors.New("something else"))
+	require.Equal(t, storiface.ErrUnknown, cerr.Code)
+
+	// call errors keep their code
+	cerr = commit2(storiface.Err(storiface.ErrTempAllocateSpace, errors.New("invalid path")))
+	require.Equal(t, storiface.ErrTempAllocateSpace, cerr.Code)
+}
+
+func TestParseFFIErrorRule(t *testing.T) {
+	r, err := ParseFFIErrorRule("fatal:bad input")
+	require.NoError(t, err)
+	require.Equal(t, FFIErrorRule{Match: "bad input", Fatal: true}, r)
+
+	r, err = ParseFFIErrorRule("recoverable:busy")
+	require.NoError(t, err)
+	require.Equal(t, FFIErrorRule{Match: "busy"}, r)
+
+	for _, s := range []string{"busy", "fatal:", "retry:busy"} {
+		_, err := ParseFFIErrorRule(s)
+		require.Error(t, err, s)
+	}
+}
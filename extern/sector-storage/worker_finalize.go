@@ -0,0 +1,110 @@
+package sectorstorage
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// FinalizeCachePolicy controls what FinalizeSector does with the sector cache
+// once it was cleared. Clearing only keeps the cache files needed for proving
+// (p_aux, t_aux and tree-r-last), so these are never dropped.
+type FinalizeCachePolicy int
+
+const (
+	// FinalizeCacheKeep leaves the cleared cache where it is, it's moved to
+	// long-term storage in a separate step
+	FinalizeCacheKeep FinalizeCachePolicy = iota
+
+	// FinalizeCacheMove moves the cleared cache to long-term storage as part
+	// of finalization
+	FinalizeCacheMove
+
+	// FinalizeCacheDrop removes copies of the cleared cache from the worker
+	// when a primary copy is already in long-term storage. The cache is kept
+	// when it has no primary copy in long-term storage.
+	FinalizeCacheDrop
+)
+
+var finalizeCachePolicies = map[string]FinalizeCachePolicy{
+	"keep": FinalizeCacheKeep,
+	"move": FinalizeCacheMove,
+	"drop": FinalizeCacheDrop,
+}
+
+// ParseFinalizeCachePolicy parses a policy name: keep, move or drop
+func ParseFinalizeCachePolicy(s string) (FinalizeCachePolicy, error) {
+	p, ok := finalizeCachePolicies[s]
+	if !ok {
+		return 0, xerrors.Errorf("unknown finalize cache policy %q, expected keep, move or drop", s)
+	}
+	return p, nil
+}
+
+// finalizeCache applies the finalize cache policy to a sector with a cleared
+// cache
+func (l *LocalWorker) finalizeCache(ctx context.Context, sector storage.SectorRef) error {
+	switch l.finalizeCachePolicy {
+	case FinalizeCacheMove:
+		if err := l.storage.MoveStorage(ctx, sector, storiface.FTCache); err != nil {
+			return xerrors.Errorf("moving cache to storage: %w", err)
+		}
+	case FinalizeCacheDrop:
+		found, err := l.sindex.StorageFindSector(ctx, sector.ID, storiface.FTCache, 0, false)
+		if err != nil {
+			return xerrors.Errorf("finding cache copies: %w", err)
+		}
+
+		var stored bool
+		for _, info := range found {
+			if info.Primary && info.CanStore {
+				stored = true
+				break
+			}
+		}
+		if !stored {
+			log.Debugw("not dropping sector cache, it has no primary copy in storage", "sector", sector.ID)
+			return nil
+		}
+
+		if err := l.storage.RemoveCopies(ctx, sector.ID, storiface.FTCache); err != nil {
+			return xerrors.Errorf("removing cache copies: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// finalizedSize measures bytes the sealed replica and cache of the sector use
+// on disk, in all local paths holding them
+func (l *LocalWorker) finalizedSize(ctx context.Context, sector abi.SectorID) (storiface.FinalizeSectorOut, error) {
+	var out storiface.FinalizeSectorOut
+	for _, ft := range []storiface.SectorFileType{storiface.FTSealed, storiface.FTCache} {
+		paths, err := l.localSectorPaths(ctx, sector, ft)
+		if err != nil {
+			return storiface.FinalizeSectorOut{}, err
+		}
+
+		var used int64
+		for _, p := range paths {
+			n, err := diskUsage(p)
+			if err != nil {
+				return storiface.FinalizeSectorOut{}, xerrors.Errorf("measuring disk usage of %s: %w", p, err)
+			}
+			used += n
+		}
+
+		if ft == storiface.FTSealed {
+			out.SealedOnDisk = used
+		} else {
+			out.CacheOnDisk = used
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,178 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// openSealingPath adds a seal-only storage path to the worker
+func openSealingPath(ctx context.Context, t *testing.T, w *LocalWorker) stores.StoragePath {
+	dir := t.TempDir()
+
+	id := stores.ID(uuid.New().String())
+	b, err := json.Marshal(&stores.LocalStorageMeta{
+		ID:      id,
+		Weight:  1,
+		CanSeal: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, stores.MetaFile), b, 0644))
+	require.NoError(t, w.localStore.OpenPath(ctx, dir))
+
+	paths, err := w.localStore.Local(ctx)
+	require.NoError(t, err)
+	for _, p := range paths {
+		if p.ID == id {
+			return p
+		}
+	}
+
+	t.Fatal("sealing path not opened")
+	return stores.StoragePath{}
+}
+
+func TestParseFinalizeCachePolicy(t *testing.T) {
+	for name, policy := range map[string]FinalizeCachePolicy{
+		"keep": FinalizeCacheKeep,
+		"move": FinalizeCacheMove,
+		"drop": FinalizeCacheDrop,
+	} {
+		p, err := ParseFinalizeCachePolicy(name)
+		require.NoError(t, err)
+		require.Equal(t, policy, p)
+	}
+
+	_, err := ParseFinalizeCachePolicy("delete")
+	require.Error(t, err)
+}
+
+func TestFinalizeCachePolicy(t *testing.T) {
+	ctx := context.Background()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	cachePath := func(p stores.StoragePath) string {
+		return filepath.Join(p.LocalPath, storiface.FTCache.String(), storiface.SectorName(sector.ID))
+	}
+
+	finalize := func(t *testing.T, policy FinalizeCachePolicy) (stores.StoragePath, stores.StoragePath, *stores.Index, func()) {
+		ret := &finalizeReturns{errs: make(chan *storiface.CallError, 1)}
+		w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{FinalizeCache: policy}, ret)
+		t.Cleanup(cleanup)
+
+		exec := &partialFinalizeExec{finalized: make(chan storiface.SectorFileType, 1)}
+		w.executor = func() (ffiwrapper.Storage, error) {
+			return exec, nil
+		}
+
+		fast := openSealingPath(ctx, t, w)
+		writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTSealed, 1)
+		writeTestSectorFile(ctx, t, fast, si, sector.ID, storiface.FTCache, 1)
+
+		return p, fast, si, func() {
+			_, err := w.FinalizeSectorTypes(ctx, sector, nil, storiface.FTCache)
+			require.NoError(t, err)
+			require.Nil(t, <-ret.errs)
+			require.Equal(t, storiface.FTCache, <-exec.finalized)
+		}
+	}
+
+	t.Run("keep", func(t *testing.T) {
+		p, fast, _, run := finalize(t, FinalizeCacheKeep)
+		run()
+
+		require.FileExists(t, cachePath(fast))
+		require.NoFileExists(t, cachePath(p))
+	})
+
+	t.Run("move", func(t *testing.T) {
+		p, fast, si, run := finalize(t, FinalizeCacheMove)
+		run()
+
+		require.NoFileExists(t, cachePath(fast))
+		require.FileExists(t, cachePath(p))
+
+		found, err := si.StorageFindSector(ctx, sector.ID, storiface.FTCache, 0, false)
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, p.ID, found[0].ID)
+	})
+
+	t.Run("drop-only-copy", func(t *testing.T) {
+		// the only copy of the cache is needed for proving, so it's kept
+		_, fast, _, run := finalize(t, FinalizeCacheDrop)
+		run()
+
+		require.FileExists(t, cachePath(fast))
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		p, fast, si, run := finalize(t, FinalizeCacheDrop)
+
+		// long-term storage already has the primary copy, the sealing copy is
+		// a leftover
+		require.NoError(t, si.StorageDropSector(ctx, fast.ID, sector.ID, storiface.FTCache))
+		require.NoError(t, si.StorageDeclareSector(ctx, fast.ID, sector.ID, storiface.FTCache, false))
+		writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTCache, 1)
+		run()
+
+		require.NoFileExists(t, cachePath(fast))
+		require.FileExists(t, cachePath(p))
+	})
+}
+
+func TestFinalizeReturnsSize(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &finalizeReturns{
+		errs: make(chan *storiface.CallError, 1),
+		outs: make(chan storiface.FinalizeSectorOut, 1),
+	}
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	exec := &partialFinalizeExec{finalized: make(chan storiface.SectorFileType, 1)}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	// the sealed replica and cache are in different paths
+	fast := openSealingPath(ctx, t, w)
+	sealed := writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTSealed, 64<<10)
+	cache := writeTestSectorFile(ctx, t, fast, si, sector.ID, storiface.FTCache, 16<<10)
+
+	_, err := w.FinalizeSectorTypes(ctx, sector, nil, storiface.FTCache)
+	require.NoError(t, err)
+	out := <-ret.outs
+	require.Nil(t, <-ret.errs)
+	<-exec.finalized
+
+	sealedUsage, err := diskUsage(sealed)
+	require.NoError(t, err)
+	cacheUsage, err := diskUsage(cache)
+	require.NoError(t, err)
+
+	require.Equal(t, storiface.FinalizeSectorOut{SealedOnDisk: sealedUsage, CacheOnDisk: cacheUsage}, out)
+	require.True(t, out.SealedOnDisk >= 64<<10, out.SealedOnDisk)
+	require.True(t, out.CacheOnDisk >= 16<<10, out.CacheOnDisk)
+}
@@ -0,0 +1,225 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+// GPUAdmissionPolicy controls what happens to GPU tasks when no GPU has enough
+// free memory to run them
+type GPUAdmissionPolicy int
+
+const (
+	// GPUAdmissionOff starts GPU tasks without checking GPU memory
+	GPUAdmissionOff GPUAdmissionPolicy = iota
+
+	// GPUAdmissionWait makes GPU tasks wait until enough GPU memory is free
+	GPUAdmissionWait
+
+	// GPUAdmissionReject fails GPU tasks which can't fit in GPU memory
+	GPUAdmissionReject
+)
+
+var ErrNoGPUMemory = errors.New("not enough free GPU memory")
+
+type GPUMemory struct {
+	Device int
+	Total  uint64
+	Free   uint64
+}
+
+// GPUMemoryFunc returns total and free memory of each GPU
+type GPUMemoryFunc func(ctx context.Context) ([]GPUMemory, error)
+
+// GPUMemoryNeeded is the estimated GPU memory used by tasks running proofs on GPUs
+// TODO: measure accurately
+var GPUMemoryNeeded = map[sealtasks.TaskType]map[abi.SectorSize]uint64{
+	sealtasks.TTCommit2: {
+		2 << 10:   1 << 30,
+		8 << 20:   1 << 30,
+		512 << 20: 2 << 30,
+		32 << 30:  10 << 30,
+		64 << 30:  16 << 30,
+	},
+}
+
+func init() {
+	GPUMemoryNeeded[sealtasks.TTProveReplicaUpdate2] = GPUMemoryNeeded[sealtasks.TTCommit2]
+}
+
+var gpuAdmissionPollInterval = 5 * time.Second
+
+type gpuAdmission struct {
+	policy    GPUAdmissionPolicy
+	memory    GPUMemoryFunc
+	useDevice func(dev int) error
+
+	lk       sync.Mutex
+	admitted map[int]uint64 // memory needed by admitted tasks
+}
+
+func newGPUAdmission(policy GPUAdmissionPolicy, memory GPUMemoryFunc) *gpuAdmission {
+	if memory == nil {
+		memory = nvidiaGPUMemory
+	}
+
+	return &gpuAdmission{
+		policy:    policy,
+		memory:    memory,
+		useDevice: setCUDAVisibleDevice,
+		admitted:  map[int]uint64{},
+	}
+}
+
+// setCUDAVisibleDevice makes proofs started next use the given GPU. Proofs run
+// in the worker process, and pick GPUs from the environment when they start.
+func setCUDAVisibleDevice(dev int) error {
+	return os.Setenv("CUDA_VISIBLE_DEVICES", strconv.Itoa(dev))
+}
+
+// admit waits for, or rejects the task based on free GPU memory, and returns a
+// func which must be called after the task is done
+func (g *gpuAdmission) admit(ctx context.Context, tt sealtasks.TaskType, spt abi.RegisteredSealProof) (func(), error) {
+	if g == nil || g.policy == GPUAdmissionOff {
+		return func() {}, nil
+	}
+
+	ssize, err := spt.SectorSize()
+	if err != nil {
+		return func() {}, nil
+	}
+
+	need, ok := GPUMemoryNeeded[tt][ssize]
+	if !ok {
+		return func() {}, nil
+	}
+
+	for {
+		dev, ok, err := g.tryAdmit(ctx, need)
+		if err != nil {
+			// don't block proving on failures to monitor GPUs
+			log.Warnw("checking GPU memory failed, admitting task", "task", tt, "error", err)
+			return func() {}, nil
+		}
+
+		if ok {
+			if err := g.useDevice(dev); err != nil {
+				log.Warnw("selecting GPU for task failed", "task", tt, "device", dev, "error", err)
+			}
+
+			return func() {
+				g.lk.Lock()
+				defer g.lk.Unlock()
+
+				g.admitted[dev] -= need
+			}, nil
+		}
+
+		if g.policy == GPUAdmissionReject {
+			return nil, xerrors.Errorf("%s needs %d bytes of GPU memory: %w", tt, need, ErrNoGPUMemory)
+		}
+
+		log.Debugw("waiting for free GPU memory", "task", tt, "need", need)
+
+		select {
+		case <-time.After(gpuAdmissionPollInterval):
+		case <-ctx.Done():
+			return nil, xerrors.Errorf("waiting for GPU memory: %w", ctx.Err())
+		}
+	}
+}
+
+func (g *gpuAdmission) tryAdmit(ctx context.Context, need uint64) (int, bool, error) {
+	devs, err := g.memory(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	best, bestFree := -1, uint64(0)
+	for _, d := range devs {
+		// memory of admitted tasks is taken from the device total, reported
+		// free memory already excludes memory those tasks allocated. Free
+		// memory is still a limit, as other processes may use the GPU.
+		if g.admitted[d.Device] >= d.Total {
+			continue
+		}
+		free := d.Total - g.admitted[d.Device]
+		if d.Free < free {
+			free = d.Free
+		}
+
+		if free >= need && (best == -1 || free > bestFree) {
+			best, bestFree = d.Device, free
+		}
+	}
+
+	if best == -1 {
+		return 0, false, nil
+	}
+
+	g.admitted[best] += need
+	return best, true, nil
+}
+
+func nvidiaGPUMemory(ctx context.Context) ([]GPUMemory, error) {
+	var out, errOut bytes.Buffer
+	cmd := exec.CommandContext(ctx, "nvidia-smi", "--query-gpu=index,memory.total,memory.free", "--format=csv,noheader,nounits") // nolint
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	if err := cmd.Run(); err != nil {
+		return nil, xerrors.Errorf("exec nvidia-smi (stderr: %s): %w", strings.TrimSpace(errOut.String()), err)
+	}
+
+	return parseNvidiaGPUMemory(out.String())
+}
+
+// parseNvidiaGPUMemory parses 'index, memory.total, memory.free' lines, memory
+// is in MiB
+func parseNvidiaGPUMemory(out string) ([]GPUMemory, error) {
+	var devs []GPUMemory
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return nil, xerrors.Errorf("unexpected nvidia-smi output line: '%s'", line)
+		}
+
+		dev, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil {
+			return nil, xerrors.Errorf("parsing GPU index: %w", err)
+		}
+
+		total, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing GPU total memory: %w", err)
+		}
+
+		free, err := strconv.ParseUint(strings.TrimSpace(fields[2]), 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing GPU free memory: %w", err)
+		}
+
+		devs = append(devs, GPUMemory{Device: dev, Total: total << 20, Free: free << 20})
+	}
+
+	return devs, nil
+}
@@ -0,0 +1,69 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+func TestGPUAdmission(t *testing.T) {
+	ctx := context.Background()
+
+	devs, err := parseNvidiaGPUMemory("0, 12000, 12000\n1, 24000, 24000\n")
+	require.NoError(t, err)
+	require.Equal(t, []GPUMemory{
+		{Device: 0, Total: 12000 << 20, Free: 12000 << 20},
+		{Device: 1, Total: 24000 << 20, Free: 24000 << 20},
+	}, devs)
+
+	mem := func(ctx context.Context) ([]GPUMemory, error) {
+		return devs, nil
+	}
+
+	spt := abi.RegisteredSealProof_StackedDrg32GiBV1
+	g := newGPUAdmission(GPUAdmissionReject, mem)
+
+	var used []int
+	g.useDevice = func(dev int) error {
+		used = append(used, dev)
+		return nil
+	}
+
+	// not GPU tasks
+	_, err = g.admit(ctx, sealtasks.TTPreCommit1, spt)
+	require.NoError(t, err)
+	require.Empty(t, used)
+
+	release1, err := g.admit(ctx, sealtasks.TTCommit2, spt)
+	require.NoError(t, err)
+
+	// the first proof allocating its memory doesn't count twice, the second
+	// GPU can fit two 32G C2s, the first one only one
+	devs[1].Free -= 10 << 30
+
+	release2, err := g.admit(ctx, sealtasks.TTCommit2, spt)
+	require.NoError(t, err)
+	release3, err := g.admit(ctx, sealtasks.TTCommit2, spt)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 1, 0}, used)
+
+	_, err = g.admit(ctx, sealtasks.TTCommit2, spt)
+	require.True(t, xerrors.Is(err, ErrNoGPUMemory))
+
+	release1()
+
+	g.policy = GPUAdmissionWait
+	release4, err := g.admit(ctx, sealtasks.TTCommit2, spt)
+	require.NoError(t, err)
+
+	release2()
+	release3()
+	release4()
+	require.Equal(t, map[int]uint64{0: 0, 1: 0}, g.admitted)
+}
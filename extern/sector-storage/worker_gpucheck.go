@@ -0,0 +1,105 @@
+package sectorstorage
+
+import (
+	"errors"
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// GPUCheckPolicy controls what happens to GPU tasks, tasks which the proofs
+// may run on GPUs as recorded in ResourceTable, when the worker can't see any
+// GPU, e.g. after a driver crash
+type GPUCheckPolicy int
+
+const (
+	// GPUCheckOff starts GPU tasks without checking for GPUs
+	GPUCheckOff GPUCheckPolicy = iota
+
+	// GPUCheckReject fails GPU tasks with a retryable ErrTempNoGPU error
+	// when no GPU is found, so that the manager retries them on other workers
+	GPUCheckReject
+
+	// GPUCheckDisable rejects GPU tasks like GPUCheckReject, and also stops
+	// accepting GPU task types, until they are enabled again with TaskEnable
+	GPUCheckDisable
+)
+
+var gpuCheckPolicies = map[string]GPUCheckPolicy{
+	"off":     GPUCheckOff,
+	"reject":  GPUCheckReject,
+	"disable": GPUCheckDisable,
+}
+
+// ParseGPUCheckPolicy parses a policy name: off, reject or disable
+func ParseGPUCheckPolicy(s string) (GPUCheckPolicy, error) {
+	p, ok := gpuCheckPolicies[s]
+	if !ok {
+		return 0, xerrors.Errorf("unknown GPU check policy %q, expected off, reject or disable", s)
+	}
+	return p, nil
+}
+
+var ErrNoGPU = errors.New("no GPU found")
+
+// checkGPUPresent returns a storiface.ErrTempNoGPU error for GPU tasks when no
+// GPU is found. GPUs are listed again for every task, as they can disappear
+// while the worker runs.
+func (l *LocalWorker) checkGPUPresent(sector storage.SectorRef, rt ReturnType) error {
+	if l.gpuCheck == GPUCheckOff {
+		return nil
+	}
+
+	tt := returnTaskType[rt]
+	if !ResourceTable[tt][sector.ProofType].CanGPU || (tt == sealtasks.TTCommit1 && l.cpuOnlyCommit1) {
+		return nil
+	}
+
+	devs, err := l.gpuDevices()
+	if err == nil && len(devs) > 0 {
+		return nil
+	}
+
+	cause := xerrors.Errorf("not starting %s: %w", tt, ErrNoGPU)
+	if err != nil {
+		cause = xerrors.Errorf("not starting %s: %w (listing GPUs: %s)", tt, ErrNoGPU, err)
+	}
+
+	if l.gpuCheck == GPUCheckDisable {
+		disabled := l.disableGPUTasks()
+		if len(disabled) > 0 {
+			log.Errorw("no GPU found, disabled GPU task types", "tasks", disabled, "error", err)
+		}
+	}
+
+	return storiface.Err(storiface.ErrTempNoGPU, cause)
+}
+
+// disableGPUTasks stops accepting task types which the proofs may run on GPUs
+// for any proof type, returns the task types it disabled
+func (l *LocalWorker) disableGPUTasks() []sealtasks.TaskType {
+	l.taskLk.Lock()
+	defer l.taskLk.Unlock()
+
+	var out []sealtasks.TaskType
+	for tt := range l.acceptTasks {
+		if tt == sealtasks.TTCommit1 && l.cpuOnlyCommit1 {
+			continue
+		}
+		for _, res := range ResourceTable[tt] {
+			if res.CanGPU {
+				delete(l.acceptTasks, tt)
+				out = append(out, tt)
+				break
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
@@ -0,0 +1,95 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestGPUCheck(t *testing.T) {
+	ctx := context.Background()
+
+	gpus := []string{"GeForce RTX 3090"}
+	var listErr error
+
+	ret := &c2Returns{errs: make(chan *storiface.CallError, 1), proofs: make(chan storage.Proof, 1)}
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		TaskTypes:  []sealtasks.TaskType{sealtasks.TTAddPiece, sealtasks.TTPreCommit2, sealtasks.TTCommit2},
+		GPUCheck:   GPUCheckReject,
+		GPUDevices: func() ([]string, error) { return gpus, listErr },
+	}, ret)
+	defer cleanup()
+
+	exec := &c2Exec{}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	var c1os int
+	commit2 := func() *storiface.CallError {
+		// results of calls with the same inputs are reused
+		c1os++
+		_, err := w.SealCommit2(ctx, sector, storage.Commit1Out{byte(c1os)})
+		require.NoError(t, err)
+		<-ret.proofs
+		return <-ret.errs
+	}
+	taskTypes := func() map[sealtasks.TaskType]struct{} {
+		tts, err := w.TaskTypes(ctx)
+		require.NoError(t, err)
+		return tts
+	}
+
+	require.Nil(t, commit2())
+	require.Equal(t, 1, exec.calls)
+
+	// the GPU vanished
+	gpus = nil
+	cerr := commit2()
+	require.NotNil(t, cerr)
+	require.Equal(t, storiface.ErrTempNoGPU, cerr.Code)
+	require.True(t, cerr.Retryable)
+	require.Contains(t, cerr.Message, ErrNoGPU.Error())
+	require.Equal(t, 1, exec.calls)
+	require.Len(t, taskTypes(), 3)
+
+	listErr = xerrors.New("driver crashed")
+	gpus = []string{"GeForce RTX 3090"}
+	cerr = commit2()
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Message, "driver crashed")
+
+	// GPU task types are disabled
+	listErr = nil
+	gpus = nil
+	w.gpuCheck = GPUCheckDisable
+	require.Equal(t, storiface.ErrTempNoGPU, commit2().Code)
+	require.Equal(t, map[sealtasks.TaskType]struct{}{sealtasks.TTAddPiece: {}}, taskTypes())
+
+	require.NoError(t, w.TaskEnable(ctx, sealtasks.TTCommit2))
+	gpus = []string{"GeForce RTX 3090"}
+	require.Nil(t, commit2())
+	require.Equal(t, 2, exec.calls)
+}
+
+func TestParseGPUCheckPolicy(t *testing.T) {
+	p, err := ParseGPUCheckPolicy("disable")
+	require.NoError(t, err)
+	require.Equal(t, GPUCheckDisable, p)
+
+	_, err = ParseGPUCheckPolicy("always")
+	require.Error(t, err)
+}
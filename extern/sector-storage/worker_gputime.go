@@ -0,0 +1,80 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ffi "github.com/filecoin-project/filecoin-ffi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// gpuTime accumulates wall time of tasks which ran on GPUs
+type gpuTime struct {
+	devices func() ([]string, error)
+
+	// whether the worker has GPUs is checked once, on the first GPU task
+	once    sync.Once
+	hasGPUs bool
+
+	lk    sync.Mutex
+	tasks map[sealtasks.TaskType]storiface.GPUTime
+}
+
+func newGPUTime() *gpuTime {
+	return &gpuTime{
+		devices: ffi.GetGPUDevices,
+		tasks:   map[sealtasks.TaskType]storiface.GPUTime{},
+	}
+}
+
+func (g *gpuTime) gpus() bool {
+	g.once.Do(func() {
+		devs, err := g.devices()
+		if err != nil {
+			log.Errorf("getting gpu devices failed: %+v", err)
+		}
+		g.hasGPUs = len(devs) > 0
+	})
+	return g.hasGPUs
+}
+
+// track returns a func which must be called when the task is done running,
+// tasks which can't use GPUs, or running on workers without GPUs aren't
+// counted
+func (g *gpuTime) track(sector storage.SectorRef, rt ReturnType) func() {
+	tt := returnTaskType[rt]
+	if !ResourceTable[tt][sector.ProofType].CanGPU || !g.gpus() {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		took := time.Since(start)
+
+		g.lk.Lock()
+		defer g.lk.Unlock()
+
+		t := g.tasks[tt]
+		t.Calls++
+		t.Duration += took
+		g.tasks[tt] = t
+	}
+}
+
+// GPUTimeStats returns the cumulative wall time tasks of each type spent
+// running on GPUs since the worker started. Only tasks which can use GPUs
+// (see ResourceTable) are counted, and only when the worker has GPUs.
+func (l *LocalWorker) GPUTimeStats(ctx context.Context) (map[sealtasks.TaskType]storiface.GPUTime, error) {
+	l.gpuTime.lk.Lock()
+	defer l.gpuTime.lk.Unlock()
+
+	out := make(map[sealtasks.TaskType]storiface.GPUTime, len(l.gpuTime.tasks))
+	for tt, t := range l.gpuTime.tasks {
+		out[tt] = t
+	}
+	return out, nil
+}
@@ -0,0 +1,54 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestGPUTimeStats(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	run := func(rt ReturnType) {
+		_, err := w.runCall(ctx, sector, rt, storiface.CallID{Sector: sector.ID}, func(context.Context, storiface.CallID) (interface{}, error) {
+			return nil, nil
+		})
+		require.NoError(t, err)
+	}
+
+	// nothing is counted without GPUs
+	w.gpuTime.devices = func() ([]string, error) { return nil, nil }
+	run(SealCommit2)
+
+	stats, err := w.GPUTimeStats(ctx)
+	require.NoError(t, err)
+	require.Empty(t, stats)
+
+	w.gpuTime = newGPUTime()
+	w.gpuTime.devices = func() ([]string, error) { return []string{"GeForce RTX 3090"}, nil }
+	run(SealCommit2)
+	run(SealCommit2)
+
+	// tasks which can't use GPUs aren't counted
+	run(SealCommit1)
+
+	stats, err = w.GPUTimeStats(ctx)
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	require.Equal(t, 2, stats[sealtasks.TTCommit2].Calls)
+	require.Greater(t, int64(stats[sealtasks.TTCommit2].Duration), int64(0))
+}
@@ -0,0 +1,58 @@
+package sectorstorage
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+)
+
+// ParseTaskStorageGroups parses storage groups of task types, given as
+// task=group, e.g. seal/v0/addpiece=nvme
+func ParseTaskStorageGroups(specs []string) (map[sealtasks.TaskType]string, error) {
+	known := map[sealtasks.TaskType]bool{}
+	for _, tt := range returnTaskType {
+		known[tt] = true
+	}
+
+	out := map[sealtasks.TaskType]string{}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, xerrors.Errorf("invalid task storage group %q, expected task=group", spec)
+		}
+
+		tt := sealtasks.TaskType(kv[0])
+		if !known[tt] {
+			return nil, xerrors.Errorf("unknown task type %q in task storage group %q", kv[0], spec)
+		}
+		if g, ok := out[tt]; ok && g != kv[1] {
+			return nil, xerrors.Errorf("task %s has storage groups %q and %q", tt, g, kv[1])
+		}
+		out[tt] = kv[1]
+	}
+
+	return out, nil
+}
+
+// CheckTaskStorageGroups returns an error when a storage group of a task type
+// has no local paths, so that misconfigured workers fail at startup instead of
+// failing all tasks of the type
+func CheckTaskStorageGroups(ls *stores.Local, groups map[sealtasks.TaskType]string) error {
+	have := ls.Groups()
+	for tt, g := range groups {
+		if len(have[g]) == 0 {
+			return xerrors.Errorf("storage group %q of task %s has no local paths", g, tt)
+		}
+	}
+	return nil
+}
+
+// withStorageGroup restricts allocating sector files for a task to paths of
+// the task type's storage group, when it has one
+func (l *LocalWorker) withStorageGroup(ctx context.Context, tt sealtasks.TaskType) context.Context {
+	return stores.WithAllocGroup(ctx, l.storageGroups[tt])
+}
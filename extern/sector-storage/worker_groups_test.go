@@ -0,0 +1,37 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+func TestParseTaskStorageGroups(t *testing.T) {
+	groups, err := ParseTaskStorageGroups([]string{"seal/v0/addpiece=nvme", "seal/v0/fetch=hdd"})
+	require.NoError(t, err)
+	require.Equal(t, map[sealtasks.TaskType]string{
+		sealtasks.TTAddPiece: "nvme",
+		sealtasks.TTFetch:    "hdd",
+	}, groups)
+
+	for _, spec := range []string{"seal/v0/addpiece", "seal/v0/addpiece=", "seal/v0/nope=nvme"} {
+		_, err := ParseTaskStorageGroups([]string{spec})
+		require.Error(t, err, spec)
+	}
+
+	_, err = ParseTaskStorageGroups([]string{"seal/v0/addpiece=nvme", "seal/v0/addpiece=hdd"})
+	require.Error(t, err)
+}
+
+func TestCheckTaskStorageGroups(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	require.NoError(t, CheckTaskStorageGroups(w.localStore, nil))
+	require.Error(t, CheckTaskStorageGroups(w.localStore, map[sealtasks.TaskType]string{sealtasks.TTAddPiece: "nvme"}))
+}
@@ -0,0 +1,57 @@
+package sectorstorage
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// TaskHookInfo describes the task a hook is called for
+type TaskHookInfo struct {
+	Task   sealtasks.TaskType
+	Sector storage.SectorRef
+	Call   storiface.CallID
+
+	// Phases is how long sub-phases of the task took, for post-task hooks of
+	// tasks the proofs backend times sub-phases of
+	Phases []storiface.TaskPhase
+}
+
+// PreTaskFunc is called before a task starts running, when it returns an error
+// the task fails with it without running
+type PreTaskFunc func(ctx context.Context, ti TaskHookInfo) error
+
+// PostTaskFunc is called after a task ran, with its result and error
+type PostTaskFunc func(ctx context.Context, ti TaskHookInfo, res interface{}, err error)
+
+// runHooked runs work between the PreTask and PostTask hooks. Hooks run once
+// the task got through the call queue, right before and after the work, so
+// time spent waiting doesn't count.
+func (l *LocalWorker) runHooked(ctx context.Context, sector storage.SectorRef, rt ReturnType, ci storiface.CallID, work func() (interface{}, error)) (interface{}, error) {
+	ti := TaskHookInfo{
+		Task:   returnTaskType[rt],
+		Sector: sector,
+		Call:   ci,
+	}
+
+	if l.preTask != nil {
+		if err := l.preTask(ctx, ti); err != nil {
+			return nil, xerrors.Errorf("pre-task hook: %w", err)
+		}
+	}
+
+	res, err := work()
+
+	if l.postTask != nil {
+		ti.Phases = ffiwrapper.TaskPhases(ctx)
+		l.postTask(ctx, ti, res, err)
+	}
+
+	return res, err
+}
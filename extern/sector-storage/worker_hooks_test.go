@@ -0,0 +1,83 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestTaskHooks(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &addPieceReturns{
+		pieces: make(chan abi.PieceInfo, 1),
+		errs:   make(chan *storiface.CallError, 1),
+	}
+
+	blocked := abi.SectorID{Miner: 1000, Number: 2}
+
+	var lk sync.Mutex
+	var pre []TaskHookInfo
+	var post []error
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		PreTask: func(ctx context.Context, ti TaskHookInfo) error {
+			lk.Lock()
+			defer lk.Unlock()
+
+			pre = append(pre, ti)
+			if ti.Sector.ID == blocked {
+				return xerrors.New("blocked")
+			}
+			return nil
+		},
+		PostTask: func(ctx context.Context, ti TaskHookInfo, res interface{}, err error) {
+			lk.Lock()
+			defer lk.Unlock()
+
+			require.IsType(t, abi.PieceInfo{}, res)
+			post = append(post, err)
+		},
+	}, ret)
+	defer cleanup()
+
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return &addPieceExec{}, nil
+	}
+
+	addPiece := func(sid abi.SectorID) (storiface.CallID, *storiface.CallError) {
+		sector := storage.SectorRef{ID: sid, ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1}
+		ci, err := w.AddPiece(ctx, sector, nil, 127, bytes.NewReader(make([]byte, 127)))
+		require.NoError(t, err)
+		<-ret.pieces
+		return ci, <-ret.errs
+	}
+
+	ci, cerr := addPiece(abi.SectorID{Miner: 1000, Number: 1})
+	require.Nil(t, cerr)
+
+	_, cerr = addPiece(blocked)
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Message, "blocked")
+
+	lk.Lock()
+	defer lk.Unlock()
+
+	require.Len(t, pre, 2)
+	require.Equal(t, sealtasks.TTAddPiece, pre[0].Task)
+	require.Equal(t, ci, pre[0].Call)
+
+	// the task failed by PreTask didn't run
+	require.Equal(t, []error{nil}, post)
+}
@@ -0,0 +1,272 @@
+package sectorstorage
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// SectorTarManifestName is the name of the manifest, the first entry of a
+// sector tar archive
+const SectorTarManifestName = "manifest.json"
+
+// SectorTarManifest describes the content of a sector tar archive. Files are
+// named like in storage paths, [type]/[sector name] for files, and
+// [type]/[sector name]/[file] for directories (e.g. the cache).
+type SectorTarManifest struct {
+	Sector abi.SectorID
+	Files  []SectorTarFile
+}
+
+type SectorTarFile struct {
+	Name string
+	Size int64
+}
+
+var fileTypeByName = func() map[string]storiface.SectorFileType {
+	out := map[string]storiface.SectorFileType{}
+	for _, fileType := range storiface.PathTypes {
+		out[fileType.String()] = fileType
+	}
+	return out
+}()
+
+// importEntry is where an archived sector file or directory is extracted to
+type importEntry struct {
+	fileType storiface.SectorFileType
+	dir      bool
+}
+
+// importEntries checks the manifest, and returns the sector file types it
+// contains
+func (m *SectorTarManifest) importEntries(sector abi.SectorID) (map[storiface.SectorFileType]importEntry, error) {
+	if m.Sector != sector {
+		return nil, xerrors.Errorf("archive is for sector %d, not %d", m.Sector, sector)
+	}
+	if len(m.Files) == 0 {
+		return nil, xerrors.Errorf("archive manifest lists no files")
+	}
+
+	out := map[storiface.SectorFileType]importEntry{}
+	seen := map[string]bool{}
+	for _, f := range m.Files {
+		if seen[f.Name] {
+			return nil, xerrors.Errorf("file %s listed twice in the manifest", f.Name)
+		}
+		seen[f.Name] = true
+
+		parts := strings.Split(f.Name, "/")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, xerrors.Errorf("unexpected file %s in manifest", f.Name)
+		}
+
+		fileType, ok := fileTypeByName[parts[0]]
+		if !ok {
+			return nil, xerrors.Errorf("unknown sector file type of %s", f.Name)
+		}
+		if parts[1] != storiface.SectorName(sector) {
+			return nil, xerrors.Errorf("file %s isn't named after sector %d", f.Name, sector)
+		}
+
+		dir := len(parts) == 3
+		if dir && (parts[2] == "" || parts[2] == "." || parts[2] == "..") {
+			return nil, xerrors.Errorf("unexpected file %s in manifest", f.Name)
+		}
+		if e, ok := out[fileType]; ok && (e.dir != dir || !dir) {
+			return nil, xerrors.Errorf("conflicting %s entries in manifest", fileType)
+		}
+
+		out[fileType] = importEntry{fileType: fileType, dir: dir}
+	}
+
+	return out, nil
+}
+
+// ImportSectorTar imports all files of a sector from a tar stream, e.g. when
+// migrating sectors between workers. The first entry of the archive must be
+// the manifest (see SectorTarManifest), and all files listed in it must
+// follow, with the listed sizes. Files are allocated in local long-term
+// storage paths, extracted next to their destination, and only moved in
+// place and declared in the index after the whole archive was read, so that
+// incomplete archives leave nothing behind. Sectors with files of the
+// imported types already in storage are refused.
+func (l *LocalWorker) ImportSectorTar(ctx context.Context, sector storage.SectorRef, r io.Reader) error {
+	if err := l.checkNamespace(sector.ID); err != nil {
+		return err
+	}
+	if l.localStore == nil {
+		return xerrors.Errorf("worker doesn't use local storage paths")
+	}
+
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return xerrors.Errorf("reading archive manifest: %w", err)
+	}
+	if hdr.Name != SectorTarManifestName {
+		return xerrors.Errorf("first archive entry is %s, expected the %s", hdr.Name, SectorTarManifestName)
+	}
+
+	var manifest SectorTarManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return xerrors.Errorf("decoding archive manifest: %w", err)
+	}
+
+	entries, err := manifest.importEntries(sector.ID)
+	if err != nil {
+		return xerrors.Errorf("invalid archive manifest: %w", err)
+	}
+
+	var types storiface.SectorFileType
+	for fileType := range entries {
+		types |= fileType
+	}
+
+	// the lock is held until lctx is cancelled
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := l.sindex.StorageLock(lctx, sector.ID, storiface.FTNone, types); err != nil {
+		return xerrors.Errorf("locking sector files: %w", err)
+	}
+
+	for fileType := range entries {
+		found, err := l.sindex.StorageFindSector(ctx, sector.ID, fileType, 0, false)
+		if err != nil {
+			return xerrors.Errorf("finding sector %d (%s): %w", sector.ID, fileType, err)
+		}
+		if len(found) > 0 {
+			return xerrors.Errorf("sector %d already has %s files in storage", sector.ID, fileType)
+		}
+	}
+
+	paths, _, copies, err := l.localStore.AcquireSectorCopies(ctx, sector, storiface.FTNone, types, storiface.PathStorage, storiface.AcquireMove)
+	if err != nil {
+		return xerrors.Errorf("allocating sector files: %w", err)
+	}
+
+	staging := map[storiface.SectorFileType]string{}
+	defer func() {
+		for _, p := range staging {
+			if err := os.RemoveAll(p); err != nil {
+				log.Errorw("removing partially imported sector file", "sector", sector.ID, "path", p, "error", err)
+			}
+		}
+	}()
+
+	for fileType, e := range entries {
+		p := storiface.PathByType(paths, fileType) + ".import"
+		if err := os.RemoveAll(p); err != nil {
+			return xerrors.Errorf("removing previous import leftovers: %w", err)
+		}
+		if e.dir {
+			if err := os.Mkdir(p, 0755); err != nil { // nolint
+				return xerrors.Errorf("creating %s: %w", p, err)
+			}
+		}
+		staging[fileType] = p
+	}
+
+	if err := extractSectorTar(tr, &manifest, staging); err != nil {
+		return xerrors.Errorf("extracting sector %d: %w", sector.ID, err)
+	}
+
+	for fileType, p := range staging {
+		if err := os.Rename(p, storiface.PathByType(paths, fileType)); err != nil {
+			return xerrors.Errorf("moving imported %s in place: %w", fileType, err)
+		}
+		delete(staging, fileType)
+
+		for _, sid := range copies.ByType(fileType) {
+			if err := l.sindex.StorageDeclareSector(ctx, stores.ID(sid), sector.ID, fileType, true); err != nil {
+				return xerrors.Errorf("declare sector %d(t:%s) -> %s: %w", sector.ID, fileType, sid, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// extractSectorTar extracts archived files into the staging paths of their
+// type, checking them against the manifest
+func extractSectorTar(tr *tar.Reader, manifest *SectorTarManifest, staging map[storiface.SectorFileType]string) error {
+	sizes := map[string]int64{}
+	for _, f := range manifest.Files {
+		sizes[f.Name] = f.Size
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return xerrors.Errorf("reading archive: %w", err)
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		name := strings.TrimSuffix(hdr.Name, "/")
+		size, ok := sizes[name]
+		if !ok {
+			return xerrors.Errorf("file %s not listed in the manifest", name)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return xerrors.Errorf("%s isn't a regular file", name)
+		}
+		if hdr.Size != size {
+			return xerrors.Errorf("%s is %d bytes, manifest says %d", name, hdr.Size, size)
+		}
+		delete(sizes, name)
+
+		parts := strings.Split(name, "/")
+		dest := staging[fileTypeByName[parts[0]]]
+		if len(parts) == 3 {
+			dest = filepath.Join(dest, parts[2])
+		}
+
+		if err := extractFile(tr, dest, size); err != nil {
+			return xerrors.Errorf("extracting %s: %w", name, err)
+		}
+	}
+
+	for name := range sizes {
+		return xerrors.Errorf("incomplete archive, %s is missing", name)
+	}
+
+	return nil
+}
+
+func extractFile(r io.Reader, dest string, size int64) error {
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644) // nolint
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, io.LimitReader(r, size))
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if n != size {
+		_ = f.Close()
+		return xerrors.Errorf("got %d of %d bytes", n, size)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,121 @@
+package sectorstorage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func testSectorTar(t *testing.T, manifest SectorTarManifest, files map[string][]byte) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	mb, err := json.Marshal(&manifest)
+	require.NoError(t, err)
+
+	write := func(name string, b []byte) {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(b)
+		require.NoError(t, err)
+	}
+
+	write(SectorTarManifestName, mb)
+	for _, f := range manifest.Files {
+		if b, ok := files[f.Name]; ok {
+			write(f.Name, b)
+		}
+	}
+
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestImportSectorTar(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	name := storiface.SectorName(sector.ID)
+
+	files := map[string][]byte{
+		"sealed/" + name:           bytes.Repeat([]byte{1}, 2048),
+		"cache/" + name + "/t_aux": []byte("t_aux"),
+		"cache/" + name + "/p_aux": []byte("p_aux"),
+	}
+	manifest := SectorTarManifest{Sector: sector.ID}
+	for _, n := range []string{"sealed/" + name, "cache/" + name + "/t_aux", "cache/" + name + "/p_aux"} {
+		manifest.Files = append(manifest.Files, SectorTarFile{Name: n, Size: int64(len(files[n]))})
+	}
+
+	requireImported := func(imported bool) {
+		for _, ft := range []storiface.SectorFileType{storiface.FTSealed, storiface.FTCache} {
+			found, err := si.StorageFindSector(ctx, sector.ID, ft, 0, false)
+			require.NoError(t, err)
+			if imported {
+				require.Len(t, found, 1)
+			} else {
+				require.Empty(t, found)
+			}
+		}
+
+		ents, err := ioutil.ReadDir(filepath.Join(p.LocalPath, storiface.FTCache.String()))
+		require.NoError(t, err)
+		if imported {
+			require.Len(t, ents, 1)
+		} else {
+			require.Empty(t, ents)
+		}
+	}
+
+	// incomplete archives are rejected, and leave nothing behind
+	partial := map[string][]byte{}
+	for n, b := range files {
+		if n != "cache/"+name+"/p_aux" {
+			partial[n] = b
+		}
+	}
+	err := w.ImportSectorTar(ctx, sector, testSectorTar(t, manifest, partial))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "incomplete archive")
+	requireImported(false)
+
+	truncated := testSectorTar(t, manifest, files)
+	truncated.Truncate(truncated.Len() - 2048)
+	require.Error(t, w.ImportSectorTar(ctx, sector, truncated))
+	requireImported(false)
+
+	other := manifest
+	other.Sector = abi.SectorID{Miner: 1000, Number: 2}
+	require.Error(t, w.ImportSectorTar(ctx, sector, testSectorTar(t, other, files)))
+
+	bad := SectorTarManifest{Sector: sector.ID, Files: []SectorTarFile{{Name: "cache/" + name + "/../../x"}}}
+	require.Error(t, w.ImportSectorTar(ctx, sector, testSectorTar(t, bad, nil)))
+
+	require.NoError(t, w.ImportSectorTar(ctx, sector, testSectorTar(t, manifest, files)))
+	requireImported(true)
+
+	for n, b := range files {
+		got, err := ioutil.ReadFile(filepath.Join(p.LocalPath, n))
+		require.NoError(t, err)
+		require.Equal(t, b, got)
+	}
+
+	// sectors which are already in storage aren't imported again
+	require.Error(t, w.ImportSectorTar(ctx, sector, testSectorTar(t, manifest, files)))
+}
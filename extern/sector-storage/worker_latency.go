@@ -0,0 +1,153 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// DefaultPathProbeInterval is how often storage path latency is probed when
+// WorkerConfig.PathProbeInterval isn't set
+const DefaultPathProbeInterval = time.Minute
+
+// PathProbeFile is the file written and read in each local storage path to
+// probe latency
+const PathProbeFile = ".latency-probe"
+
+// size of the probe file, small enough to not interfere with sealing I/O
+const pathProbeSize = 4 << 10
+
+type pathLatencies struct {
+	lk     sync.Mutex
+	probes map[string]storiface.PathLatency
+}
+
+// pathProbeLoop probes latency of local storage paths periodically, until the
+// worker is closed
+func (l *LocalWorker) pathProbeLoop(interval time.Duration) {
+	defer close(l.probeDone)
+
+	if interval < 0 || l.localStore == nil {
+		return
+	}
+	if interval == 0 {
+		interval = DefaultPathProbeInterval
+	}
+
+	ctx := &wctx{
+		vals:    context.Background(),
+		closing: l.closing,
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := l.probePaths(ctx); err != nil {
+			log.Errorf("probing storage path latency: %+v", err)
+		}
+
+		select {
+		case <-t.C:
+		case <-l.closing:
+			return
+		}
+	}
+}
+
+func (l *LocalWorker) probePaths(ctx context.Context) error {
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	probes := map[string]storiface.PathLatency{}
+	for _, p := range paths {
+		if p.LocalPath == "" {
+			continue
+		}
+
+		pl := storiface.PathLatency{ID: string(p.ID), Probed: time.Now()}
+		pl.Write, pl.Read, err = probePath(filepath.Join(p.LocalPath, PathProbeFile))
+		if err != nil {
+			pl.Err = err.Error()
+		}
+		probes[pl.ID] = pl
+	}
+
+	l.latencies.lk.Lock()
+	l.latencies.probes = probes
+	l.latencies.lk.Unlock()
+
+	return nil
+}
+
+// probePath measures how long writing and syncing, and then reading back a
+// small probe file takes. The read may be served from the page cache, write
+// latency is the better indicator of a slow disk.
+func probePath(p string) (write time.Duration, read time.Duration, err error) {
+	defer os.Remove(p) // nolint
+
+	data := bytes.Repeat([]byte{0xa5}, pathProbeSize)
+
+	start := time.Now()
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644) // nolint
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return 0, 0, err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return 0, 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, 0, err
+	}
+	write = time.Since(start)
+
+	start = time.Now()
+	got, err := ioutil.ReadFile(p)
+	if err != nil {
+		return write, 0, err
+	}
+	read = time.Since(start)
+
+	if !bytes.Equal(got, data) {
+		return write, read, xerrors.Errorf("probe file read back doesn't match what was written")
+	}
+
+	return write, read, nil
+}
+
+// StoragePathLatencies returns the last latency probe of each local storage
+// path, slowest (by write latency) first. Paths are probed periodically, see
+// WorkerConfig.PathProbeInterval.
+func (l *LocalWorker) StoragePathLatencies(ctx context.Context) ([]storiface.PathLatency, error) {
+	l.latencies.lk.Lock()
+	out := make([]storiface.PathLatency, 0, len(l.latencies.probes))
+	for _, pl := range l.latencies.probes {
+		out = append(out, pl)
+	}
+	l.latencies.lk.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Write != out[j].Write {
+			return out[i].Write > out[j].Write
+		}
+		return out[i].ID < out[j].ID
+	})
+
+	return out, nil
+}
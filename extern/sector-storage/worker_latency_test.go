@@ -0,0 +1,45 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoragePathLatencies(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{PathProbeInterval: -1}, nil)
+	defer cleanup()
+
+	pls, err := w.StoragePathLatencies(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pls)
+
+	require.NoError(t, w.probePaths(ctx))
+
+	pls, err = w.StoragePathLatencies(ctx)
+	require.NoError(t, err)
+	require.Len(t, pls, 1)
+	require.Equal(t, string(p.ID), pls[0].ID)
+	require.Empty(t, pls[0].Err)
+	require.NotZero(t, pls[0].Write)
+	require.False(t, pls[0].Probed.IsZero())
+
+	// the probe file doesn't stay around
+	require.NoFileExists(t, filepath.Join(p.LocalPath, PathProbeFile))
+
+	// failed probes are reported
+	require.NoError(t, os.Mkdir(filepath.Join(p.LocalPath, PathProbeFile), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(p.LocalPath, PathProbeFile, "x"), nil, 0644))
+	require.NoError(t, w.probePaths(ctx))
+
+	pls, err = w.StoragePathLatencies(ctx)
+	require.NoError(t, err)
+	require.Len(t, pls, 1)
+	require.NotEmpty(t, pls[0].Err)
+}
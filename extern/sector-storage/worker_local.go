@@ -1,12 +1,15 @@
 package sectorstorage
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"reflect"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,16 +26,243 @@ import (
 	storage "github.com/filecoin-project/specs-storage/storage"
 
 	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/fr32"
 	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
 	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
 	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
 )
 
-var pathTypes = []storiface.SectorFileType{storiface.FTUnsealed, storiface.FTSealed, storiface.FTCache}
-
 type WorkerConfig struct {
 	TaskTypes []sealtasks.TaskType
 	NoSwap    bool
+
+	// MaxConcurrentCalls limits how many calls can run on the worker at the
+	// same time, queued calls are started in priority order (see WithPriority
+	// and SetCallPriority).
+	// 0 means no limit.
+	MaxConcurrentCalls int
+
+	// TaskLimits limits how many calls of a task type can run on the worker
+	// at the same time, calls over the limit wait without taking a
+	// MaxConcurrentCalls slot. Limits can be raised for a while with
+	// BoostConcurrency. Task types without a limit aren't limited.
+	TaskLimits map[sealtasks.TaskType]int
+
+	// OfflinePathPolicy decides what happens to calls using storage paths
+	// which went offline, or were removed from the sector index (see
+	// StorageMissingError). With OfflinePathWait calls wait for the path to
+	// come back for up to OfflinePathTimeout (0 = wait forever).
+	OfflinePathPolicy  OfflinePathPolicy
+	OfflinePathTimeout time.Duration
+
+	// GPUAdmission makes GPU tasks check that there is enough free memory on
+	// one of the GPUs before starting, and run on that GPU. GPUMemory reports
+	// total and free GPU memory, defaults to querying nvidia-smi.
+	GPUAdmission GPUAdmissionPolicy
+	GPUMemory    GPUMemoryFunc
+
+	// GPUCheck makes GPU tasks check that the worker sees at least one GPU
+	// when they are dispatched, see GPUCheckPolicy. GPUDevices lists GPUs,
+	// defaults to asking the proofs backend.
+	GPUCheck   GPUCheckPolicy
+	GPUDevices func() ([]string, error)
+
+	// MoveReadPolicy makes MoveStorage calls wait for calls reading piece
+	// data, so that moves don't starve them of disk I/O. With MoveReadCap at
+	// most MaxMovesDuringReads moves (at least 1) run while there are reads.
+	MoveReadPolicy      MoveReadPolicy
+	MaxMovesDuringReads int
+
+	// StagingDir is where StagePiece keeps pieces until CommitStaged adds
+	// them to their sector. Piece staging is disabled when empty.
+	StagingDir string
+
+	// SoftMemoryLimit makes tasks wait until the sum of maximum memory
+	// estimates of running tasks (see ResourceTable), including theirs, is
+	// within the limit. With RejectOverMemoryLimit tasks fail with
+	// ErrMemoryLimit instead of waiting. 0 means no limit.
+	SoftMemoryLimit       uint64
+	RejectOverMemoryLimit bool
+
+	// ParamsManifest is the proof parameters manifest (parameters.json), used
+	// by SupportedProofs to check which parameters are present locally
+	ParamsManifest []byte
+
+	// ParamsCheck makes CheckProofParams check parameter files in the
+	// manifest, see ParamsCheckPolicy. ParamsSectorSize is the sector size
+	// which parameters are checked for, 0 checks all present files.
+	ParamsCheck      ParamsCheckPolicy
+	ParamsSectorSize abi.SectorSize
+
+	// CallIDs generates IDs of calls made to the worker, defaults to uuid.New.
+	// Mostly useful for getting deterministic IDs in tests.
+	CallIDs func() uuid.UUID
+
+	// CleanupParallelism limits how many removals of sector file copies, like
+	// the ones done after unsealing, can run at the same time. 0 means no
+	// limit.
+	CleanupParallelism int
+
+	// AddPieceAlloc decides which local paths AddPiece prefers for allocating
+	// unsealed sector files, by default the store picks the path
+	AddPieceAlloc stores.AllocPolicy
+
+	// AddPieceReplicas is how many local paths AddPiece writes unsealed data
+	// to at once, reading the piece data once. 0 or 1 writes one copy.
+	AddPieceReplicas int
+
+	// ReplicasBestEffort lets AddPiece succeed with fewer than AddPieceReplicas
+	// copies, when some can't be written or there aren't enough paths, as long
+	// as one copy is written. Otherwise AddPiece fails.
+	ReplicasBestEffort bool
+
+	// AddPieceOverwrite makes AddPiece remove unsealed data a sector already
+	// has when adding its first piece (with no existing pieces), instead of
+	// failing with ErrSectorOccupied, e.g. for recovery tooling re-adding the
+	// pieces of sectors
+	AddPieceOverwrite bool
+
+	// AddPieceRateLimit limits how fast all AddPiece calls together read
+	// piece data, in bytes per second, so that ingest doesn't saturate disks
+	// and links shared with other work. Pieces are paced, not rejected. 0
+	// means no limit.
+	AddPieceRateLimit int64
+
+	// PreCommit2TreeThreads is how many threads the proofs build PreCommit2
+	// trees with, instead of one per CPU. The thread pool is process-wide and
+	// shared by PreCommit2 tasks running at the same time, and by other tasks
+	// parallelizing over it, so set it to leave CPUs to other calls the worker
+	// runs next to PreCommit2 (see MaxConcurrentCalls). Must be set before any
+	// task runs. 0 keeps the proofs default.
+	PreCommit2TreeThreads int
+
+	// Commit2ClearCache clears the local cache of sectors right after
+	// SealCommit2, instead of when they are finalized, keeping only the files
+	// needed for proving. This frees sealing storage sooner, but Commit1 can't
+	// be redone for the sector afterwards, e.g. when the commit message fails
+	// and a new seed is drawn.
+	Commit2ClearCache bool
+
+	// CPUOnlyCommit1 asserts SealCommit1 never uses GPUs, so that they stay
+	// free for Commit2 and PoSt. SealCommit1 calls fail with
+	// ErrCPUOnlyUnsupported when the proofs may use GPUs for the proof type.
+	CPUOnlyCommit1 bool
+
+	// TaskStorageGroups restricts allocating sector files for tasks of a type
+	// to local paths of a storage group (see stores.LocalStorageMeta.Groups),
+	// e.g. unsealed files of AddPiece to fast paths
+	TaskStorageGroups map[sealtasks.TaskType]string
+
+	// VerifyAddPiece makes AddPiece read each added piece back from the
+	// unsealed sector file and check its commitment before returning. Off by
+	// default as it doubles the I/O of adding pieces.
+	VerifyAddPiece bool
+
+	// AcquirePreference orders local paths which qualify for a sector file
+	// acquired for a task, by default the store's order is used
+	AcquirePreference stores.PathPreference
+
+	// PreTask and PostTask are optional hooks called before each task starts
+	// running, and after it's done. A PreTask error fails the task.
+	PreTask  PreTaskFunc
+	PostTask PostTaskFunc
+
+	// TaskWebhook is a URL task start, success and failure events are posted
+	// to as JSON (see TaskEvent), none when empty. Events are delivered in
+	// the background, retrying with backoff, tasks never wait for them.
+	TaskWebhook string
+
+	// FFIErrorRules classify errors of task work, mostly coming from the proofs
+	// backend, as recoverable or fatal (see FFIErrorRule). They are checked
+	// before DefaultFFIErrorRules, the first matching rule applies.
+	FFIErrorRules []FFIErrorRule
+
+	// MoveFull decides whether MoveStorage tries other destination paths when
+	// the destination runs out of space, by default the move fails
+	MoveFull stores.MoveFullPolicy
+
+	// FinalizeCache decides what FinalizeSector does with the sector cache
+	// after clearing it, FinalizeCacheKeep by default
+	FinalizeCache FinalizeCachePolicy
+
+	// MaxSectors limits how many sectors the worker can hold, calls which
+	// would bring in more sectors (AddPiece, Fetch) fail with
+	// storiface.ErrTempTooManySectors. 0 means no limit.
+	MaxSectors int
+
+	// ReadPieceBufferSize sets the size of the buffer used when streaming piece
+	// data in ReadPiece, must be between MinReadPieceBufferSize and
+	// MaxReadPieceBufferSize. 0 keeps the default buffering.
+	ReadPieceBufferSize int
+
+	// DebugLogSampleRate makes the worker log only one in every N debug
+	// messages on hot paths, like sector acquisition. 0 or 1 logs everything.
+	DebugLogSampleRate uint64
+
+	// DebugReservations makes the worker check that sector paths it acquires
+	// are released exactly once, logging paths released more than once, and
+	// paths still held when the call which acquired them finished
+	DebugReservations bool
+
+	// SyncWrites makes AddPiece and FinalizeSector fsync the sector files
+	// they wrote, and the directories holding them, before returning, so that
+	// results survive power failures. Off by default as it slows tasks down.
+	SyncWrites bool
+
+	// Capabilities are the capabilities the worker declares to the scheduler
+	// (see storiface.TaskCapabilities). When nil, the worker declares all
+	// capabilities its proofs backend supports.
+	Capabilities []storiface.WorkerCapability
+
+	// DedupPieces makes AddPiece share unsealed files of sectors filled by a
+	// single piece with local sectors holding the same piece, with hard links.
+	// Shared files are copied before finalization changes them.
+	DedupPieces bool
+
+	// ForceRemove makes Remove remove sector files also while calls for the
+	// sector are in progress, by default it fails with ErrSectorBusy
+	ForceRemove bool
+
+	// TempFileAge is how long temp files in local storage paths must stay
+	// unmodified before GCTempFiles removes them, DefaultTempFileAge when 0
+	TempFileAge time.Duration
+
+	// FinalizeRemoveRetries is how many times FinalizeSector retries removing
+	// unsealed data, DefaultFinalizeRemoveRetries when 0, negative doesn't
+	// retry. When all attempts fail the sector is still finalized, and the
+	// removal is retried periodically (see PendingRemovals).
+	FinalizeRemoveRetries int
+
+	// UnsealedGracePeriod is how long FinalizeSector keeps unsealed data which
+	// isn't kept by keepUnsealed before removing it in the background, e.g.
+	// to serve retrievals without unsealing again. 0 removes it right away.
+	UnsealedGracePeriod time.Duration
+
+	// AllowedMiners are the miners whose sectors the worker accepts calls for,
+	// calls for sectors of other miners are rejected with
+	// storiface.ErrForeignSector, so that misrouted calls can't touch storage
+	// of another miner. Empty accepts sectors of all miners.
+	AllowedMiners []abi.ActorID
+
+	// MaxCallAge is how long a call can run, from when the worker accepts it,
+	// before it's cancelled, as a safety net against calls stuck forever. It
+	// applies to all calls, independent of task timeouts. Reaped calls fail
+	// with ErrCallTooOld, see ReapedCalls. 0 means no limit.
+	MaxCallAge time.Duration
+
+	// ScrubInterval enables the background scrubber, which checks sealed
+	// replicas and caches of sectors in local paths, one sector each interval,
+	// while no tasks are running. Bad files are logged, reported by
+	// ScrubFindings and posted to the task webhook. 0 disables scrubbing.
+	ScrubInterval time.Duration
+
+	// PathProbeInterval is how often latency of local storage paths is
+	// probed, DefaultPathProbeInterval when 0. Negative disables probing.
+	PathProbeInterval time.Duration
+
+	// FailureWindow is the number of recent calls of each task type
+	// FailureRates are computed over, DefaultFailureWindow when 0
+	FailureWindow int
 }
 
 // used do provide custom proofs impl (mostly used in testing)
@@ -44,15 +274,123 @@ type LocalWorker struct {
 	sindex     stores.SectorIndex
 	ret        storiface.WorkerReturn
 	executor   ExecutorFunc
+	verifier   ffiwrapper.Verifier
+	callIDs    func() uuid.UUID
 	noSwap     bool
+	acquireLog *logSampler
+	releases   *releaseChecker
+	readBuf    int
+
+	offlinePolicy  OfflinePathPolicy
+	offlineTimeout time.Duration
+
+	maxSectors    int
+	sectorLimitLk sync.Mutex
+
+	paramsManifest   []byte
+	paramsCheck      ParamsCheckPolicy
+	paramsSectorSize abi.SectorSize
+
+	cleanupThrottle chan struct{}
+
+	removeRetries int
+	removals      pendingRemovals
+	unsealedGrace time.Duration
+
+	declaredCaps []storiface.WorkerCapability
+
+	pieces pieceOrders
+	paused pausedSectors
+
+	thermal thermalSampler
+
+	tempFileAge time.Duration
+	forceRemove bool
+
+	dedupPieces bool
+	pieceCopies pieceCopies
+
+	addPieceAlloc       stores.AllocPolicy
+	addPieceReplicas    int
+	addPieceOverwrite   bool
+	addPieceRate        *addPieceLimiter
+	replicasBestEffort  bool
+	storageGroups       map[sealtasks.TaskType]string
+	cpuOnlyCommit1      bool
+	gpuCheck            GPUCheckPolicy
+	gpuDevices          func() ([]string, error)
+	clearCacheAfterC2   bool
+	verifyAddPiece      bool
+	syncWrites          bool
+	moveFull            stores.MoveFullPolicy
+	acquirePreference   stores.PathPreference
+	preTask             PreTaskFunc
+	postTask            PostTaskFunc
+	webhook             *taskWebhook
+	finalizeCachePolicy FinalizeCachePolicy
+	ffiErrorRules       []FFIErrorRule
+	allowedMiners       map[abi.ActorID]struct{}
 
 	ct          *workerCallTracker
 	acceptTasks map[sealtasks.TaskType]struct{}
+	taskLk      sync.Mutex
 	running     sync.WaitGroup
+	queue       *callQueue
+	eta         *callETA
+	failures    *failureWindow
+	gpuTime     *gpuTime
+	taskTimes   *taskTimes
+	gpu         *gpuAdmission
+	mem         *memAdmission
+	moveRead    *moveReadArbiter
+	taskLimits  *taskLimits
+	staging     *pieceStaging
+
+	// recent log lines of calls
+	callLogs callLogs
+
+	// sector listings being paged through by ListSectorsPage
+	sectorListings sectorListings
+
+	evac evacuation
+
+	pathsCache pathsCache
+
+	// last latency probes of local storage paths
+	latencies pathLatencies
+
+	// last result of setting up the executor
+	health executorHealth
+
+	// resources used by running tasks, and by all calls which didn't finish
+	// yet, including queued ones
+	active   activeResources
+	reserved activeResources
+	activeLk sync.Mutex
 
 	session     uuid.UUID
 	testDisable int64
 	closing     chan struct{}
+
+	// closed when the reservation reconcile loop exits
+	reconcileDone chan struct{}
+
+	// closed when the path latency probe loop exits
+	probeDone chan struct{}
+
+	// closed when the pending removals loop exits
+	removalsDone chan struct{}
+
+	reaper *callReaper
+	// closed when the call reaper loop exits
+	reapDone chan struct{}
+
+	scrub *sectorScrubber
+	// closed when the scrub loop exits
+	scrubDone chan struct{}
+
+	cancelLk sync.Mutex
+	cancels  map[storiface.CallID]context.CancelFunc
 }
 
 func newLocalWorker(executor ExecutorFunc, wcfg WorkerConfig, store stores.Store, local *stores.Local, sindex stores.SectorIndex, ret storiface.WorkerReturn, cst *statestore.StateStore) *LocalWorker {
@@ -72,16 +410,119 @@ func newLocalWorker(executor ExecutorFunc, wcfg WorkerConfig, store stores.Store
 		},
 		acceptTasks: acceptTasks,
 		executor:    executor,
+		verifier:    ffiwrapper.ProofVerifier,
+		callIDs:     wcfg.CallIDs,
+		queue:       newCallQueue(wcfg.MaxConcurrentCalls),
+		eta:         newCallETA(),
+		failures:    newFailureWindow(wcfg.FailureWindow),
+		gpuTime:     newGPUTime(),
+		taskTimes:   newTaskTimes(),
+		gpu:         newGPUAdmission(wcfg.GPUAdmission, wcfg.GPUMemory),
+		mem:         newMemAdmission(wcfg.SoftMemoryLimit, wcfg.RejectOverMemoryLimit),
+		moveRead:    newMoveReadArbiter(wcfg.MoveReadPolicy, wcfg.MaxMovesDuringReads),
+		taskLimits:  newTaskLimits(wcfg.TaskLimits),
+		staging:     newPieceStaging(wcfg.StagingDir),
 		noSwap:      wcfg.NoSwap,
-
-		session: uuid.New(),
-		closing: make(chan struct{}),
+		acquireLog:  newLogSampler(wcfg.DebugLogSampleRate),
+		releases:    newReleaseChecker(wcfg.DebugReservations),
+		readBuf:     readPieceBufferSize(wcfg.ReadPieceBufferSize),
+
+		offlinePolicy:  wcfg.OfflinePathPolicy,
+		offlineTimeout: wcfg.OfflinePathTimeout,
+
+		maxSectors:       wcfg.MaxSectors,
+		paramsManifest:   wcfg.ParamsManifest,
+		paramsCheck:      wcfg.ParamsCheck,
+		paramsSectorSize: wcfg.ParamsSectorSize,
+
+		addPieceAlloc:       wcfg.AddPieceAlloc,
+		addPieceReplicas:    wcfg.AddPieceReplicas,
+		addPieceOverwrite:   wcfg.AddPieceOverwrite,
+		addPieceRate:        newAddPieceLimiter(wcfg.AddPieceRateLimit),
+		replicasBestEffort:  wcfg.ReplicasBestEffort,
+		storageGroups:       wcfg.TaskStorageGroups,
+		cpuOnlyCommit1:      wcfg.CPUOnlyCommit1,
+		gpuCheck:            wcfg.GPUCheck,
+		gpuDevices:          wcfg.GPUDevices,
+		clearCacheAfterC2:   wcfg.Commit2ClearCache,
+		verifyAddPiece:      wcfg.VerifyAddPiece,
+		syncWrites:          wcfg.SyncWrites,
+		moveFull:            wcfg.MoveFull,
+		acquirePreference:   wcfg.AcquirePreference,
+		preTask:             wcfg.PreTask,
+		postTask:            wcfg.PostTask,
+		finalizeCachePolicy: wcfg.FinalizeCache,
+		ffiErrorRules:       append(append([]FFIErrorRule{}, wcfg.FFIErrorRules...), DefaultFFIErrorRules...),
+
+		session:       uuid.New(),
+		closing:       make(chan struct{}),
+		reconcileDone: make(chan struct{}),
+		probeDone:     make(chan struct{}),
+		removalsDone:  make(chan struct{}),
+		reaper:        newCallReaper(wcfg.MaxCallAge),
+		reapDone:      make(chan struct{}),
+		scrub:         &sectorScrubber{interval: wcfg.ScrubInterval},
+		scrubDone:     make(chan struct{}),
 	}
 
 	if w.executor == nil {
 		w.executor = w.ffiExec
 	}
 
+	if w.callIDs == nil {
+		w.callIDs = uuid.New
+	}
+
+	if w.gpuDevices == nil {
+		w.gpuDevices = ffi.GetGPUDevices
+	}
+
+	w.declaredCaps = wcfg.Capabilities
+
+	w.forceRemove = wcfg.ForceRemove
+	w.dedupPieces = wcfg.DedupPieces
+
+	w.tempFileAge = wcfg.TempFileAge
+	if w.tempFileAge == 0 {
+		w.tempFileAge = DefaultTempFileAge
+	}
+
+	w.unsealedGrace = wcfg.UnsealedGracePeriod
+
+	if wcfg.TaskWebhook != "" {
+		w.webhook = newTaskWebhook(wcfg.TaskWebhook)
+		w.preTask, w.postTask = w.webhook.hooks(wcfg.PreTask, wcfg.PostTask)
+		go w.webhook.run(w.closing)
+	}
+
+	if len(wcfg.AllowedMiners) > 0 {
+		w.allowedMiners = map[abi.ActorID]struct{}{}
+		for _, m := range wcfg.AllowedMiners {
+			w.allowedMiners[m] = struct{}{}
+		}
+	}
+
+	w.removeRetries = wcfg.FinalizeRemoveRetries
+	if w.removeRetries == 0 {
+		w.removeRetries = DefaultFinalizeRemoveRetries
+	}
+
+	if wcfg.CleanupParallelism > 0 {
+		w.cleanupThrottle = make(chan struct{}, wcfg.CleanupParallelism)
+	}
+
+	if err := w.loadEvacuation(context.TODO()); err != nil {
+		log.Errorf("loading evacuation state: %+v", err)
+	}
+
+	setTreeThreads(wcfg.PreCommit2TreeThreads, wcfg.MaxConcurrentCalls)
+
+	go w.reconcileReservationsLoop()
+	go w.pathProbeLoop(wcfg.PathProbeInterval)
+	go w.pendingRemovalsLoop()
+	go w.reapLoop()
+	go w.scrubLoop()
+
 	unfinished, err := w.ct.unfinished()
 	if err != nil {
 		log.Errorf("reading unfinished tasks: %+v", err)
@@ -105,6 +546,9 @@ func newLocalWorker(executor ExecutorFunc, wcfg WorkerConfig, store stores.Store
 	return w
 }
 
+// NewLocalWorker creates a worker which accesses sector data through store.
+// local, which can be nil when the store doesn't use local storage paths, is
+// used for maintenance of local sector files.
 func NewLocalWorker(wcfg WorkerConfig, store stores.Store, local *stores.Local, sindex stores.SectorIndex, ret storiface.WorkerReturn, cst *statestore.StateStore) *LocalWorker {
 	return newLocalWorker(nil, wcfg, store, local, sindex, ret, cst)
 }
@@ -115,39 +559,153 @@ type localWorkerPathProvider struct {
 }
 
 func (l *localWorkerPathProvider) AcquireSector(ctx context.Context, sector storage.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, sealing storiface.PathType) (storiface.SectorPaths, func(), error) {
-	paths, storageIDs, err := l.w.storage.AcquireSector(ctx, sector, existing, allocate, sealing, l.op)
+	pref := l.w.acquirePreference
+	if id, ok := ctx.Value(replicaPathKey{}).(stores.ID); ok {
+		pref = onlyPath(id)
+	}
+
+	paths, storageIDs, copies, err := l.acquire(stores.WithPathPreference(ctx, pref), sector, existing, allocate, sealing)
 	if err != nil {
+		// offline paths are the likely cause, report them instead of whatever
+		// error the store ran into
+		if oerr := l.w.pathsOnline(ctx); xerrors.Is(oerr, ErrPathOffline) {
+			return storiface.SectorPaths{}, nil, xerrors.Errorf("%w (acquire error: %s)", oerr, err)
+		}
 		return storiface.SectorPaths{}, nil, err
 	}
 
-	releaseStorage, err := l.w.localStore.Reserve(ctx, sector, allocate, storageIDs, storiface.FSOverheadSeal)
-	if err != nil {
-		return storiface.SectorPaths{}, nil, xerrors.Errorf("reserving storage space: %w", err)
+	if err := l.w.checkPathsOnline(ctx, sector.ID, existing|allocate, storageIDs); err != nil {
+		return storiface.SectorPaths{}, nil, err
 	}
 
-	log.Debugf("acquired sector %d (e:%d; a:%d): %v", sector, existing, allocate, paths)
+	stopWatching := l.w.watchPathsOnline(ctx, sector.ID, existing|allocate, storageIDs)
 
-	return paths, func() {
+	releaseStorage := func() {}
+	if rs, ok := l.w.storage.(stores.Reserver); ok {
+		releaseStorage, err = rs.Reserve(ctx, sector, allocate, storageIDs, storiface.FSOverheadSeal)
+		if err != nil {
+			stopWatching()
+			if merr := l.w.missingStorage(ctx, sector.ID, existing|allocate, storageIDs); xerrors.Is(merr, ErrStorageMissing) {
+				return storiface.SectorPaths{}, nil, xerrors.Errorf("reserving storage space: %w", merr)
+			}
+			return storiface.SectorPaths{}, nil, xerrors.Errorf("reserving storage space: %w", err)
+		}
+	}
+
+	l.w.acquireLog.Debugf("acquired sector %d (e:%d; a:%d): %v", sector, existing, allocate, paths)
+	callLog(ctx).recordf("debug", "acquired sector %d (e:%d; a:%d): %v", sector, existing, allocate, paths)
+
+	return paths, l.w.releases.wrap(ctx, sector.ID, existing|allocate, func() {
+		stopWatching()
 		releaseStorage()
 
-		for _, fileType := range pathTypes {
+		// when storage was removed from the index while the files were used,
+		// the task likely failed because of it, which is reported instead of
+		// whatever error the task ran into
+		if err := l.w.missingStorage(ctx, sector.ID, existing|allocate, storageIDs); xerrors.Is(err, ErrStorageMissing) {
+			abortCall(ctx, err)
+		}
+
+		for _, fileType := range storiface.PathTypes {
 			if fileType&allocate == 0 {
 				continue
 			}
 
-			sid := storiface.PathByType(storageIDs, fileType)
-
-			if err := l.w.sindex.StorageDeclareSector(ctx, stores.ID(sid), sector.ID, fileType, l.op == storiface.AcquireMove); err != nil {
-				log.Errorf("declare sector error: %+v", err)
+			for _, sid := range copies.ByType(fileType) {
+				if _, err := l.w.sindex.StorageInfo(ctx, stores.ID(sid)); err != nil && isStorageNotFound(err) {
+					log.Warnw("not declaring sector in storage removed from the index", "sector", sector.ID, "type", fileType, "storage", sid)
+					continue
+				}
+				if err := l.w.sindex.StorageDeclareSector(ctx, stores.ID(sid), sector.ID, fileType, l.op == storiface.AcquireMove); err != nil {
+					log.Errorf("declare sector error: %+v", err)
+				}
 			}
 		}
-	}, nil
+	}), nil
+}
+
+// acquire acquires the sector from the worker store, with IDs of all paths
+// holding each file type when the store reports them
+func (l *localWorkerPathProvider) acquire(ctx context.Context, sector storage.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, sealing storiface.PathType) (storiface.SectorPaths, storiface.SectorPaths, storiface.SectorStorageIDs, error) {
+	if ca, ok := l.w.storage.(stores.CopiesAcquirer); ok {
+		return ca.AcquireSectorCopies(ctx, sector, existing, allocate, sealing, l.op)
+	}
+
+	paths, storageIDs, err := l.w.storage.AcquireSector(ctx, sector, existing, allocate, sealing, l.op)
+	if err != nil {
+		return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, err
+	}
+
+	copies := storiface.SectorStorageIDs{}
+	for _, fileType := range storiface.PathTypes {
+		if sid := storiface.PathByType(storageIDs, fileType); sid != "" {
+			copies.Add(fileType, sid)
+		}
+	}
+
+	return paths, storageIDs, copies, nil
 }
 
 func (l *LocalWorker) ffiExec() (ffiwrapper.Storage, error) {
 	return ffiwrapper.New(&localWorkerPathProvider{w: l})
 }
 
+// WorkerUnhealthyError is returned when the worker can't set up its proofs
+// backend. Unlike task errors it means that no task can run on this worker.
+// It's reported to the manager as storiface.ErrTempWorkerUnhealthy, so the
+// call can be retried elsewhere.
+type WorkerUnhealthyError struct {
+	Err error
+}
+
+func (e *WorkerUnhealthyError) Error() string {
+	return fmt.Sprintf("worker unhealthy: %s", e.Err)
+}
+
+func (e *WorkerUnhealthyError) Unwrap() error {
+	return e.Err
+}
+
+// executors are checked at most this often by Session, which is called on
+// every scheduler heartbeat
+const healthCheckInterval = 30 * time.Second
+
+type executorHealth struct {
+	lk      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (h *executorHealth) set(err error) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	h.checked = time.Now()
+	h.err = err
+}
+
+// get returns the cached health, running check when it's stale
+func (h *executorHealth) get(check func() error) error {
+	h.lk.Lock()
+	if time.Since(h.checked) < healthCheckInterval {
+		defer h.lk.Unlock()
+		return h.err
+	}
+	h.lk.Unlock()
+
+	return check()
+}
+
+func (l *LocalWorker) sb() (ffiwrapper.Storage, error) {
+	sb, err := l.executor()
+	if err != nil {
+		err = storiface.Err(storiface.ErrTempWorkerUnhealthy, &WorkerUnhealthyError{Err: xerrors.Errorf("initializing executor: %w", err)})
+	}
+
+	l.health.set(err)
+	return sb, err
+}
+
 type ReturnType string
 
 const (
@@ -162,8 +720,35 @@ const (
 	UnsealPiece     ReturnType = "UnsealPiece"
 	ReadPiece       ReturnType = "ReadPiece"
 	Fetch           ReturnType = "Fetch"
+
+	ReplicaUpdate       ReturnType = "ReplicaUpdate"
+	ProveReplicaUpdate1 ReturnType = "ProveReplicaUpdate1"
+	ProveReplicaUpdate2 ReturnType = "ProveReplicaUpdate2"
+
+	GeneratePieceCommP ReturnType = "GeneratePieceCommP"
+	VerifyCommit2      ReturnType = "VerifyCommit2"
+
+	// local calls, see localCall
+	ReadPieceToPipe ReturnType = "ReadPieceToPipe"
 )
 
+// task types of async calls, used to account for resources used by running tasks
+var returnTaskType = map[ReturnType]sealtasks.TaskType{
+	AddPiece:            sealtasks.TTAddPiece,
+	SealPreCommit1:      sealtasks.TTPreCommit1,
+	SealPreCommit2:      sealtasks.TTPreCommit2,
+	SealCommit1:         sealtasks.TTCommit1,
+	SealCommit2:         sealtasks.TTCommit2,
+	FinalizeSector:      sealtasks.TTFinalize,
+	UnsealPiece:         sealtasks.TTUnseal,
+	ReadPiece:           sealtasks.TTReadUnsealed,
+	ReadPieceToPipe:     sealtasks.TTReadUnsealed,
+	Fetch:               sealtasks.TTFetch,
+	ReplicaUpdate:       sealtasks.TTReplicaUpdate,
+	ProveReplicaUpdate1: sealtasks.TTProveReplicaUpdate1,
+	ProveReplicaUpdate2: sealtasks.TTProveReplicaUpdate2,
+}
+
 // in: func(WorkerReturn, context.Context, CallID, err string)
 // in: func(WorkerReturn, context.Context, CallID, ret T, err string)
 func rfunc(in interface{}) func(context.Context, storiface.CallID, storiface.WorkerReturn, interface{}, *storiface.CallError) error {
@@ -210,29 +795,107 @@ var returnFunc = map[ReturnType]func(context.Context, storiface.CallID, storifac
 	UnsealPiece:     rfunc(storiface.WorkerReturn.ReturnUnsealPiece),
 	ReadPiece:       rfunc(storiface.WorkerReturn.ReturnReadPiece),
 	Fetch:           rfunc(storiface.WorkerReturn.ReturnFetch),
+
+	ReplicaUpdate:       rfunc(storiface.WorkerReturn.ReturnReplicaUpdate),
+	ProveReplicaUpdate1: rfunc(storiface.WorkerReturn.ReturnProveReplicaUpdate1),
+	ProveReplicaUpdate2: rfunc(storiface.WorkerReturn.ReturnProveReplicaUpdate2),
+
+	GeneratePieceCommP: rfunc(storiface.WorkerReturn.ReturnGeneratePieceCommP),
+	VerifyCommit2:      rfunc(storiface.WorkerReturn.ReturnVerifyCommit2),
 }
 
 func (l *LocalWorker) asyncCall(ctx context.Context, sector storage.SectorRef, rt ReturnType, work func(ctx context.Context, ci storiface.CallID) (interface{}, error)) (storiface.CallID, error) {
+	if err := l.checkCallNamespace(sector.ID, rt); err != nil {
+		return l.rejectCall(ctx, sector, rt, err)
+	}
+	if err := l.checkPaused(sector.ID, rt); err != nil {
+		return l.rejectCall(ctx, sector, rt, err)
+	}
+	if err := l.checkGPUPresent(sector, rt); err != nil {
+		return l.rejectCall(ctx, sector, rt, err)
+	}
+
+	return l.startCall(ctx, sector, rt, true, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		return l.runCall(ctx, sector, rt, ci, work)
+	})
+}
+
+// rejectCall starts a call which fails with err right away, without waiting in
+// the call queue. Unlike errors returned from the call method, the error is
+// returned to the manager with the call result, so its error code is kept also
+// when the worker is remote.
+func (l *LocalWorker) rejectCall(ctx context.Context, sector storage.SectorRef, rt ReturnType, err error) (storiface.CallID, error) {
+	return l.startCall(ctx, sector, rt, true, func(context.Context, storiface.CallID) (interface{}, error) {
+		return nil, err
+	})
+}
+
+// localCall is like asyncCall, but for calls made by local users of the
+// worker. Those aren't tracked in the call tracker, and their results aren't
+// returned to the manager.
+func (l *LocalWorker) localCall(ctx context.Context, sector storage.SectorRef, rt ReturnType, work func(ctx context.Context, ci storiface.CallID) (interface{}, error)) (storiface.CallID, error) {
+	if err := l.checkCallNamespace(sector.ID, rt); err != nil {
+		return storiface.UndefCall, err
+	}
+
+	return l.startCall(ctx, sector, rt, false, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		return l.runCall(ctx, sector, rt, ci, work)
+	})
+}
+
+func (l *LocalWorker) startCall(ctx context.Context, sector storage.SectorRef, rt ReturnType, track bool, run func(ctx context.Context, ci storiface.CallID) (interface{}, error)) (storiface.CallID, error) {
 	ci := storiface.CallID{
 		Sector: sector.ID,
-		ID:     uuid.New(),
+		ID:     l.callIDs(),
+	}
+
+	if track {
+		if err := l.ct.onStart(ci, rt); err != nil {
+			log.Errorf("tracking call (start): %+v", err)
+		}
 	}
 
-	if err := l.ct.onStart(ci, rt); err != nil {
-		log.Errorf("tracking call (start): %+v", err)
+	cl := &callLogger{ci: ci, logs: &l.callLogs}
+	rctx := &wctx{
+		vals:    withCallLogger(ctx, cl),
+		closing: l.closing,
 	}
 
+	// cancelled by CancelCall, results are still returned with rctx
+	cctx, cancel := l.callContext(rctx, ci)
+	untrack := l.reaper.track(cctx, ci, returnTaskType[rt])
+
+	l.queue.register(ci, getPriority(ctx))
+	releaseResources := l.reserveResources(sector, rt)
+
 	l.running.Add(1)
 
 	go func() {
 		defer l.running.Done()
 
-		ctx := &wctx{
-			vals:    ctx,
-			closing: l.closing,
+		ctx := rctx
+
+		cl.recordf("info", "starting %s call for sector %d", rt, sector.ID)
+
+		res, err := run(cctx, ci)
+		untrack()
+		l.releases.checkCall(ci)
+		l.queue.unregister(ci)
+		releaseResources()
+		if cause := callAbortCause(cctx); err != nil && cause != nil {
+			err = xerrors.Errorf("%w (call error: %s)", cause, err)
+		}
+		cancel()
+		if err != nil {
+			cl.Warnf("%s call %s failed: %+v", rt, ci, err)
+		} else {
+			cl.recordf("info", "%s call done", rt)
 		}
 
-		res, err := work(ctx, ci)
+		if !track {
+			l.callLogs.onReturned(ci)
+			return
+		}
 
 		if err != nil {
 			rb, err := json.Marshal(res)
@@ -249,19 +912,150 @@ func (l *LocalWorker) asyncCall(ctx context.Context, sector storage.SectorRef, r
 			if err := l.ct.onReturned(ci); err != nil {
 				log.Errorf("tracking call (done): %+v", err)
 			}
+
+			l.callLogs.onReturned(ci)
 		}
 	}()
 
 	return ci, nil
 }
 
+// runCall runs the call once it gets through the call queue and admission checks
+func (l *LocalWorker) runCall(ctx context.Context, sector storage.SectorRef, rt ReturnType, ci storiface.CallID, work func(ctx context.Context, ci storiface.CallID) (interface{}, error)) (res interface{}, err error) {
+	queued := time.Now()
+
+	// the sector is used again, files it has now may be needed. Removals
+	// deferred by the unsealed grace period are only dropped by calls
+	// writing unsealed data, as e.g. moving the finalized sector
+	// doesn't use it.
+	l.removals.drop(sector.ID, writesUnsealed[rt])
+
+	l.eta.queued(ci, etaKey{task: returnTaskType[rt], proofType: sector.ProofType})
+	defer func() {
+		l.eta.done(ci, err == nil)
+		l.recordOutcome(ctx, rt, err)
+	}()
+
+	// moves waiting for reads don't hold a queue slot, reads waiting for one
+	// hold moves back
+	releaseIO, err := l.moveRead.admit(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseIO()
+
+	// tasks waiting for the limit of their task type don't hold a queue slot,
+	// or GPU and memory admission
+	releaseLimit, err := l.taskLimits.admit(ctx, returnTaskType[rt])
+	if err != nil {
+		return nil, err
+	}
+	defer releaseLimit()
+
+	// GPU tasks waiting for memory don't hold a queue slot
+	releaseGPU, err := l.gpu.admit(ctx, returnTaskType[rt], sector.ProofType)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseGPU()
+
+	// nor do tasks waiting for memory
+	releaseMem, err := l.mem.admit(ctx, returnTaskType[rt], sector.ProofType)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseMem()
+
+	release, err := l.queue.acquire(ctx, ci, getPriority(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	defer l.gpuTime.track(sector, rt)()
+
+	l.taskTimes.started(ctx, returnTaskType[rt], time.Since(queued))
+	started := time.Now()
+	defer func() {
+		if err == nil {
+			l.taskTimes.executed(ctx, returnTaskType[rt], time.Since(started), ffiwrapper.TaskPhases(ctx))
+		}
+	}()
+
+	ctx = l.withStorageGroup(ctx, returnTaskType[rt])
+	ctx = ffiwrapper.WithPhaseTimer(ctx)
+	l.eta.started(ctx, ci)
+
+	return l.trackResources(sector, rt, func() (interface{}, error) {
+		return l.runHooked(ctx, sector, rt, ci, func() (interface{}, error) {
+			res, err := work(ctx, ci)
+			return res, l.classifyError(err)
+		})
+	})
+}
+
+// reserveResources accounts for resources needed by a call from when it's
+// accepted until it's done, the returned func must be called when the call is
+// done
+func (l *LocalWorker) reserveResources(sector storage.SectorRef, rt ReturnType) func() {
+	res, ok := ResourceTable[returnTaskType[rt]][sector.ProofType]
+	if !ok {
+		return func() {}
+	}
+
+	wr := storiface.WorkerResources{CPUs: uint64(runtime.NumCPU())}
+
+	l.activeLk.Lock()
+	l.reserved.add(wr, res)
+	l.activeLk.Unlock()
+
+	return func() {
+		l.activeLk.Lock()
+		l.reserved.free(wr, res)
+		l.activeLk.Unlock()
+	}
+}
+
+func (l *LocalWorker) trackResources(sector storage.SectorRef, rt ReturnType, cb func() (interface{}, error)) (interface{}, error) {
+	res, ok := ResourceTable[returnTaskType[rt]][sector.ProofType]
+	if !ok {
+		return cb()
+	}
+
+	// only CPUs matter for computing used threads
+	wr := storiface.WorkerResources{CPUs: uint64(runtime.NumCPU())}
+
+	l.activeLk.Lock()
+	l.active.add(wr, res)
+	l.activeLk.Unlock()
+
+	defer func() {
+		l.activeLk.Lock()
+		l.active.free(wr, res)
+		l.activeLk.Unlock()
+	}()
+
+	return cb()
+}
+
 func toCallError(err error) *storiface.CallError {
+	if err == nil {
+		return nil
+	}
+
 	var serr *storiface.CallError
-	if err != nil && !xerrors.As(err, &serr) {
-		serr = storiface.Err(storiface.ErrUnknown, err)
+	if !xerrors.As(err, &serr) {
+		return storiface.Err(storiface.ErrUnknown, err)
+	}
+
+	if err == error(serr) {
+		return serr
 	}
 
-	return serr
+	// keep the code of the wrapped call error, but report the whole error chain
+	out := storiface.Err(serr.Code, err)
+	out.Retryable = serr.Retryable
+	return out
 }
 
 // doReturn tries to send the result to manager, returns true if successful
@@ -272,11 +1066,11 @@ func doReturn(ctx context.Context, rt ReturnType, ci storiface.CallID, ret stori
 			break
 		}
 
-		log.Errorf("return error, will retry in 5s: %s: %+v", rt, err)
+		callLog(ctx).Errorf("return error, will retry in 5s: %s: %+v", rt, err)
 		select {
 		case <-time.After(5 * time.Second):
 		case <-ctx.Done():
-			log.Errorf("failed to return results: %s", ctx.Err())
+			callLog(ctx).Errorf("failed to return results: %s", ctx.Err())
 
 			// fine to just return, worker is most likely shutting down, and
 			// we didn't mark the result as returned yet, so we'll try to
@@ -289,7 +1083,7 @@ func doReturn(ctx context.Context, rt ReturnType, ci storiface.CallID, ret stori
 }
 
 func (l *LocalWorker) NewSector(ctx context.Context, sector storage.SectorRef) error {
-	sb, err := l.executor()
+	sb, err := l.sb()
 	if err != nil {
 		return err
 	}
@@ -298,18 +1092,59 @@ func (l *LocalWorker) NewSector(ctx context.Context, sector storage.SectorRef) e
 }
 
 func (l *LocalWorker) AddPiece(ctx context.Context, sector storage.SectorRef, epcs []abi.UnpaddedPieceSize, sz abi.UnpaddedPieceSize, r io.Reader) (storiface.CallID, error) {
-	sb, err := l.executor()
+	return l.sectorLimitCall(ctx, sector, AddPiece, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		sb, err := l.sb()
+		if err != nil {
+			return nil, err
+		}
+
+		return l.addPiece(ctx, sb, sector, epcs, sz, r)
+	})
+}
+
+// addPiece does the work of an AddPiece call
+func (l *LocalWorker) addPiece(ctx context.Context, sb ffiwrapper.Storage, sector storage.SectorRef, epcs []abi.UnpaddedPieceSize, sz abi.UnpaddedPieceSize, r io.Reader) (abi.PieceInfo, error) {
+	r = l.addPieceRate.reader(ctx, r)
+
+	pi, err := l.orderedAddPiece(ctx, sector.ID, epcs, func(ctx context.Context) (abi.PieceInfo, error) {
+		add := func() (abi.PieceInfo, error) {
+			ctx := stores.WithAllocPolicy(ctx, l.addPieceAlloc)
+			if l.addPieceReplicas > 1 {
+				return l.addPieceReplicated(ctx, sb, sector, epcs, sz, r)
+			}
+			return sb.AddPiece(ctx, sector, epcs, sz, r)
+		}
+
+		if len(epcs) == 0 {
+			return l.addFirstPiece(ctx, sector.ID, add)
+		}
+		return add()
+	})
 	if err != nil {
-		return storiface.UndefCall, err
+		return pi, err
 	}
 
-	return l.asyncCall(ctx, sector, AddPiece, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
-		return sb.AddPiece(ctx, sector, epcs, sz, r)
-	})
+	if err := l.dedupPiece(ctx, sector, epcs, pi); err != nil {
+		log.Warnw("deduplicating unsealed piece", "sector", sector.ID, "piece", pi.PieceCID, "error", err)
+	}
+
+	if err := l.syncSectorFiles(ctx, sector.ID, storiface.FTUnsealed); err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("syncing unsealed data: %w", err)
+	}
+
+	if !l.verifyAddPiece {
+		return pi, nil
+	}
+
+	if err := l.checkAddedPiece(ctx, sb, sector, epcs, pi); err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("verifying added piece: %w", err)
+	}
+
+	return pi, nil
 }
 
 func (l *LocalWorker) Fetch(ctx context.Context, sector storage.SectorRef, fileType storiface.SectorFileType, ptype storiface.PathType, am storiface.AcquireMode) (storiface.CallID, error) {
-	return l.asyncCall(ctx, sector, Fetch, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+	return l.sectorLimitCall(ctx, sector, Fetch, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
 		_, done, err := (&localWorkerPathProvider{w: l, op: am}).AcquireSector(ctx, sector, fileType, storiface.FTNone, ptype)
 		if err == nil {
 			done()
@@ -320,20 +1155,27 @@ func (l *LocalWorker) Fetch(ctx context.Context, sector storage.SectorRef, fileT
 }
 
 func (l *LocalWorker) SealPreCommit1(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, pieces []abi.PieceInfo) (storiface.CallID, error) {
-	return l.asyncCall(ctx, sector, SealPreCommit1, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+	inputs := []interface{}{ticket, pieces}
+	return l.reusableCall(ctx, sector, SealPreCommit1, storiface.FTSealed|storiface.FTCache, inputs, decodePreCommit1Out, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
 
 		{
-			// cleanup previous failed attempts if they exist
-			if err := l.storage.Remove(ctx, sector.ID, storiface.FTSealed, true); err != nil {
+			// cleanup previous failed attempts if they exist, removing large
+			// cache dirs can take a while, so this stops when ctx is cancelled
+			rctx := stores.WithAbortableRemove(ctx)
+			if err := l.storage.Remove(rctx, sector.ID, storiface.FTSealed, true); err != nil {
 				return nil, xerrors.Errorf("cleaning up sealed data: %w", err)
 			}
 
-			if err := l.storage.Remove(ctx, sector.ID, storiface.FTCache, true); err != nil {
+			if err := l.storage.Remove(rctx, sector.ID, storiface.FTCache, true); err != nil {
 				return nil, xerrors.Errorf("cleaning up cache data: %w", err)
 			}
+
+			if err := ctx.Err(); err != nil {
+				return nil, xerrors.Errorf("cleaning up previous attempts: %w", err)
+			}
 		}
 
-		sb, err := l.executor()
+		sb, err := l.sb()
 		if err != nil {
 			return nil, err
 		}
@@ -343,128 +1185,556 @@ func (l *LocalWorker) SealPreCommit1(ctx context.Context, sector storage.SectorR
 }
 
 func (l *LocalWorker) SealPreCommit2(ctx context.Context, sector storage.SectorRef, phase1Out storage.PreCommit1Out) (storiface.CallID, error) {
-	sb, err := l.executor()
-	if err != nil {
-		return storiface.UndefCall, err
-	}
-
 	return l.asyncCall(ctx, sector, SealPreCommit2, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
-		return sb.SealPreCommit2(ctx, sector, phase1Out)
+		if cids, ok := l.loadPreCommit2Result(ctx, sector, phase1Out); ok {
+			log.Infow("using persisted PreCommit2 result", "sector", sector.ID)
+			return cids, nil
+		}
+
+		sb, err := l.sb()
+		if err != nil {
+			return nil, err
+		}
+
+		cids, err := sb.SealPreCommit2(ctx, sector, phase1Out)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := l.savePreCommit2Result(ctx, sector, phase1Out, cids); err != nil {
+			log.Warnw("persisting PreCommit2 result", "sector", sector.ID, "error", err)
+		}
+
+		return cids, nil
 	})
 }
 
 func (l *LocalWorker) SealCommit1(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, pieces []abi.PieceInfo, cids storage.SectorCids) (storiface.CallID, error) {
-	sb, err := l.executor()
-	if err != nil {
-		return storiface.UndefCall, err
+	if err := l.checkCommit1CPUOnly(sector.ProofType); err != nil {
+		return l.rejectCall(ctx, sector, SealCommit1, xerrors.Errorf("running commit1 on CPUs only: %w", err))
 	}
 
-	return l.asyncCall(ctx, sector, SealCommit1, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+	inputs := []interface{}{ticket, seed, pieces, cids}
+	return l.reusableCall(ctx, sector, SealCommit1, storiface.FTSealed|storiface.FTCache, inputs, decodeCommit1Out, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		sb, err := l.sb()
+		if err != nil {
+			return nil, err
+		}
+
 		return sb.SealCommit1(ctx, sector, ticket, seed, pieces, cids)
 	})
 }
 
 func (l *LocalWorker) SealCommit2(ctx context.Context, sector storage.SectorRef, phase1Out storage.Commit1Out) (storiface.CallID, error) {
-	sb, err := l.executor()
-	if err != nil {
-		return storiface.UndefCall, err
-	}
+	inputs := []interface{}{phase1Out}
+	return l.reusableCall(ctx, sector, SealCommit2, storiface.FTNone, inputs, decodeProof, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		sb, err := l.sb()
+		if err != nil {
+			return nil, err
+		}
 
-	return l.asyncCall(ctx, sector, SealCommit2, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
-		return sb.SealCommit2(ctx, sector, phase1Out)
+		proof, err := sb.SealCommit2(ctx, sector, phase1Out)
+		if err != nil {
+			return nil, err
+		}
+
+		l.trimCacheAfterCommit2(ctx, sb, sector)
+		return proof, nil
 	})
 }
 
 func (l *LocalWorker) FinalizeSector(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range) (storiface.CallID, error) {
-	sb, err := l.executor()
+	return l.FinalizeSectorTypes(ctx, sector, keepUnsealed, finalizeTypes)
+}
+
+// parts of a sector which are changed by finalization
+const finalizeTypes = storiface.FTUnsealed | storiface.FTCache
+
+// FinalizeSectorTypes works like FinalizeSector, but only finalizes parts of
+// the sector selected by types, which lets callers finalize the sector in
+// stages. FTUnsealed frees unsealed data outside of keepUnsealed ranges, and
+// FTCache clears the sealing cache, which requires the sector to be sealed.
+func (l *LocalWorker) FinalizeSectorTypes(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range, types storiface.SectorFileType) (storiface.CallID, error) {
+	sb, err := l.sb()
 	if err != nil {
 		return storiface.UndefCall, err
 	}
 
+	if types == 0 || types&^finalizeTypes != 0 {
+		return storiface.UndefCall, xerrors.Errorf("can only finalize unsealed and cache data, got file types %d", types)
+	}
+
+	keepUnsealed, err = normalizeKeepUnsealed(sector.ProofType, keepUnsealed)
+	if err != nil {
+		return storiface.UndefCall, xerrors.Errorf("invalid keepUnsealed ranges for sector %d: %w", sector.ID, err)
+	}
+
+	finalize := func(ctx context.Context) error {
+		return sb.FinalizeSector(ctx, sector, keepUnsealed)
+	}
+
+	if types != finalizeTypes {
+		pf, ok := sb.(ffiwrapper.PartialFinalizer)
+		if !ok {
+			return storiface.UndefCall, xerrors.Errorf("proofs backend doesn't support partial finalization")
+		}
+
+		if types&storiface.FTCache != 0 {
+			sealed, err := l.sindex.StorageFindSector(ctx, sector.ID, storiface.FTSealed, 0, false)
+			if err != nil {
+				return storiface.UndefCall, xerrors.Errorf("finding sealed sector: %w", err)
+			}
+			if len(sealed) == 0 {
+				return storiface.UndefCall, xerrors.Errorf("can't clear cache of sector %d, it has no sealed replica", sector.ID)
+			}
+		}
+
+		finalize = func(ctx context.Context) error {
+			if types&storiface.FTUnsealed != 0 {
+				if err := pf.FinalizeUnsealed(ctx, sector, keepUnsealed); err != nil {
+					return err
+				}
+			}
+
+			if types&storiface.FTCache != 0 {
+				return pf.ClearCache(ctx, sector)
+			}
+
+			return nil
+		}
+	}
+
 	return l.asyncCall(ctx, sector, FinalizeSector, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
-		if err := sb.FinalizeSector(ctx, sector, keepUnsealed); err != nil {
+		if types&storiface.FTUnsealed != 0 && len(keepUnsealed) > 0 {
+			if err := l.unsharePiece(ctx, sector.ID); err != nil {
+				return nil, xerrors.Errorf("unsharing unsealed data: %w", err)
+			}
+		}
+
+		if err := finalize(ctx); err != nil {
 			return nil, xerrors.Errorf("finalizing sector: %w", err)
 		}
 
-		if len(keepUnsealed) == 0 {
-			if err := l.storage.Remove(ctx, sector.ID, storiface.FTUnsealed, true); err != nil {
-				return nil, xerrors.Errorf("removing unsealed data: %w", err)
+		if types&storiface.FTUnsealed != 0 && len(keepUnsealed) == 0 {
+			if l.unsealedGrace > 0 {
+				l.scheduleRemoval(sector.ID, storiface.FTUnsealed, l.unsealedGrace)
+			} else {
+				// the sector is finalized already, failing to remove unsealed
+				// data shouldn't fail it
+				l.removeOrDefer(ctx, sector.ID, storiface.FTUnsealed)
 			}
 		}
 
-		return nil, err
+		if err := l.syncSectorFiles(ctx, sector.ID, types|storiface.FTSealed); err != nil {
+			return nil, xerrors.Errorf("syncing finalized sector: %w", err)
+		}
+
+		if types&storiface.FTCache != 0 {
+			if err := l.finalizeCache(ctx, sector); err != nil {
+				return nil, err
+			}
+
+			// sealing tasks can't be redone without the cache
+			if err := l.ct.dropResults(sector.ID); err != nil {
+				log.Warnw("dropping stored task results", "sector", sector.ID, "error", err)
+			}
+		}
+
+		// the sector is finalized already, not knowing its size shouldn't
+		// fail it
+		size, err := l.finalizedSize(ctx, sector.ID)
+		if err != nil {
+			log.Warnw("measuring finalized sector size", "sector", sector.ID, "error", err)
+		}
+
+		return size, nil
 	})
 }
 
+// normalizeKeepUnsealed checks that unsealed ranges to keep are within sector
+// bounds, and returns them sorted by offset, with overlapping ranges merged
+func normalizeKeepUnsealed(spt abi.RegisteredSealProof, keep []storage.Range) ([]storage.Range, error) {
+	if len(keep) == 0 {
+		return keep, nil
+	}
+
+	ssize, err := spt.SectorSize()
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector size: %w", err)
+	}
+	maxSize := uint64(abi.PaddedPieceSize(ssize).Unpadded())
+
+	sorted := make([]storage.Range, len(keep))
+	copy(sorted, keep)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Offset < sorted[j].Offset
+	})
+
+	out := make([]storage.Range, 0, len(sorted))
+	for _, r := range sorted {
+		if r.Size == 0 {
+			return nil, xerrors.Errorf("range at offset %d is empty", r.Offset)
+		}
+
+		end := uint64(r.Offset) + uint64(r.Size)
+		if end < uint64(r.Offset) || end > maxSize {
+			return nil, xerrors.Errorf("range %d+%d exceeds sector size (%d unpadded bytes)", r.Offset, r.Size, maxSize)
+		}
+
+		if n := len(out); n > 0 && r.Offset <= out[n-1].Offset+out[n-1].Size {
+			if last := &out[n-1]; r.Offset+r.Size > last.Offset+last.Size {
+				last.Size = r.Offset + r.Size - last.Offset
+			}
+			continue
+		}
+
+		out = append(out, r)
+	}
+
+	return out, nil
+}
+
 func (l *LocalWorker) ReleaseUnsealed(ctx context.Context, sector storage.SectorRef, safeToFree []storage.Range) (storiface.CallID, error) {
 	return storiface.UndefCall, xerrors.Errorf("implement me")
 }
 
 func (l *LocalWorker) Remove(ctx context.Context, sector abi.SectorID) error {
+	if err := l.checkNamespace(sector); err != nil {
+		return err
+	}
+	if err := l.checkRemovable(ctx, sector); err != nil {
+		return err
+	}
+
 	var err error
 
-	if rerr := l.storage.Remove(ctx, sector, storiface.FTSealed, true); rerr != nil {
-		err = multierror.Append(err, xerrors.Errorf("removing sector (sealed): %w", rerr))
+	// unsealed data goes last
+	order := make([]storiface.SectorFileType, 0, len(storiface.PathTypes))
+	for _, fileType := range storiface.PathTypes {
+		if fileType != storiface.FTUnsealed {
+			order = append(order, fileType)
+		}
 	}
-	if rerr := l.storage.Remove(ctx, sector, storiface.FTCache, true); rerr != nil {
-		err = multierror.Append(err, xerrors.Errorf("removing sector (cache): %w", rerr))
+	order = append(order, storiface.FTUnsealed)
+
+	for _, fileType := range order {
+		if rerr := l.storage.Remove(ctx, sector, fileType, true); rerr != nil {
+			err = multierror.Append(err, xerrors.Errorf("removing sector (%s): %w", fileType, rerr))
+		}
 	}
-	if rerr := l.storage.Remove(ctx, sector, storiface.FTUnsealed, true); rerr != nil {
-		err = multierror.Append(err, xerrors.Errorf("removing sector (unsealed): %w", rerr))
+
+	if rerr := l.ct.dropResults(sector); rerr != nil {
+		err = multierror.Append(err, xerrors.Errorf("dropping stored task results: %w", rerr))
 	}
+	l.pieces.drop(sector)
+	l.pieceCopies.drop(sector)
 
 	return err
 }
 
 func (l *LocalWorker) MoveStorage(ctx context.Context, sector storage.SectorRef, types storiface.SectorFileType) (storiface.CallID, error) {
 	return l.asyncCall(ctx, sector, MoveStorage, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
-		return nil, l.storage.MoveStorage(ctx, sector, types)
+		return nil, l.storage.MoveStorage(stores.WithMoveFullPolicy(ctx, l.moveFull), sector, types)
 	})
 }
 
 func (l *LocalWorker) UnsealPiece(ctx context.Context, sector storage.SectorRef, index storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize, randomness abi.SealRandomness, cid cid.Cid) (storiface.CallID, error) {
-	sb, err := l.executor()
-	if err != nil {
-		return storiface.UndefCall, err
-	}
-
 	return l.asyncCall(ctx, sector, UnsealPiece, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		sb, err := l.sb()
+		if err != nil {
+			return nil, err
+		}
+
 		if err = sb.UnsealPiece(ctx, sector, index, size, randomness, cid); err != nil {
 			return nil, xerrors.Errorf("unsealing sector: %w", err)
 		}
 
-		if err = l.storage.RemoveCopies(ctx, sector.ID, storiface.FTSealed); err != nil {
-			return nil, xerrors.Errorf("removing source data: %w", err)
-		}
+		// removing source data is best-effort, and doesn't delay reporting
+		// the unsealed result, so it can't use the call context which is
+		// cancelled when the call is done
+		cleanupCtx, cancel := context.WithCancel(&wctx{
+			vals:    ctx,
+			closing: l.closing,
+		})
+
+		l.running.Add(1)
+		go func() {
+			defer l.running.Done()
+			defer cancel() // releases the sector lock
+
+			// the manager only holds its lock until the result is reported,
+			// keep other tasks away from the copies while they are removed
+			if err := l.sindex.StorageLock(cleanupCtx, sector.ID, storiface.FTNone, storiface.FTSealed|storiface.FTCache); err != nil {
+				log.Warnf("locking sector %d for removing source data: %+v", sector.ID, err)
+				return
+			}
 
-		if err = l.storage.RemoveCopies(ctx, sector.ID, storiface.FTCache); err != nil {
-			return nil, xerrors.Errorf("removing source data: %w", err)
-		}
+			if err := l.removeCopies(cleanupCtx, sector.ID, storiface.FTSealed, storiface.FTCache); err != nil {
+				log.Warnf("removing source data of sector %d: %+v", sector.ID, err)
+			}
+		}()
 
 		return nil, nil
 	})
 }
 
-func (l *LocalWorker) ReadPiece(ctx context.Context, writer io.Writer, sector storage.SectorRef, index storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize) (storiface.CallID, error) {
-	sb, err := l.executor()
-	if err != nil {
-		return storiface.UndefCall, err
+// removeCopies removes non-primary copies of sector files of each type
+// concurrently, with at most WorkerConfig.CleanupParallelism removals running
+// on the worker at the same time
+func (l *LocalWorker) removeCopies(ctx context.Context, sector abi.SectorID, types ...storiface.SectorFileType) error {
+	var (
+		wg   sync.WaitGroup
+		lk   sync.Mutex
+		merr error
+	)
+
+	for _, ft := range types {
+		wg.Add(1)
+		go func(ft storiface.SectorFileType) {
+			defer wg.Done()
+
+			if l.cleanupThrottle != nil {
+				select {
+				case l.cleanupThrottle <- struct{}{}:
+					defer func() {
+						<-l.cleanupThrottle
+					}()
+				case <-ctx.Done():
+					lk.Lock()
+					merr = multierror.Append(merr, xerrors.Errorf("removing %s copies: %w", ft, ctx.Err()))
+					lk.Unlock()
+					return
+				}
+			}
+
+			if err := l.storage.RemoveCopies(ctx, sector, ft); err != nil {
+				lk.Lock()
+				merr = multierror.Append(merr, xerrors.Errorf("removing %s copies: %w", ft, err))
+				lk.Unlock()
+			}
+		}(ft)
 	}
 
+	wg.Wait()
+
+	return merr
+}
+
+func (l *LocalWorker) ReadPiece(ctx context.Context, writer io.Writer, sector storage.SectorRef, index storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize) (storiface.CallID, error) {
 	return l.asyncCall(ctx, sector, ReadPiece, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		sb, err := l.sb()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.readBuf == 0 {
+			return l.readPiece(ctx, sb, writer, sector, index, size)
+		}
+
+		// note that when the writer implements io.ReaderFrom (e.g. files), data
+		// is passed to it directly, bypassing the buffer
+		bw := bufio.NewWriterSize(writer, l.readBuf)
+		ok, err := l.readPiece(ctx, sb, bw, sector, index, size)
+		if err != nil {
+			return ok, err
+		}
+
+		if err := bw.Flush(); err != nil {
+			return false, xerrors.Errorf("flushing piece data: %w", err)
+		}
+
+		return ok, nil
+	})
+}
+
+// readPiece reads piece data with the sealer, or streams it from the store
+// when it implements stores.UnsealedReader
+func (l *LocalWorker) readPiece(ctx context.Context, sb ffiwrapper.Storage, writer io.Writer, sector storage.SectorRef, index storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize) (bool, error) {
+	ur, ok := l.storage.(stores.UnsealedReader)
+	if !ok {
 		return sb.ReadPiece(ctx, writer, sector, index, size)
+	}
+
+	r, err := ur.ReadUnsealed(ctx, sector, index.Padded(), size.Padded())
+	if err != nil {
+		return false, xerrors.Errorf("reading unsealed data from store: %w", err)
+	}
+	if r == nil {
+		return false, nil
+	}
+	defer r.Close() // nolint
+
+	upr, err := fr32.NewUnpadReader(r, size.Padded())
+	if err != nil {
+		return false, xerrors.Errorf("creating unpadded reader: %w", err)
+	}
+
+	if _, err := io.CopyN(writer, upr, int64(size)); err != nil {
+		return false, xerrors.Errorf("reading unsealed data: %w", err)
+	}
+
+	return true, nil
+}
+
+// ReadPieceToPipe reads piece data from the unsealed sector file into a pipe,
+// for processing the data locally. The pipe is closed when all data was read,
+// or with the error of the call. Closing the returned reader early, or
+// cancelling the call with CancelCall stops reading.
+func (l *LocalWorker) ReadPieceToPipe(ctx context.Context, sector storage.SectorRef, index storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize) (io.ReadCloser, storiface.CallID, error) {
+	sb, err := l.sb()
+	if err != nil {
+		return nil, storiface.UndefCall, err
+	}
+
+	pr, pw := io.Pipe()
+
+	ci, err := l.localCall(ctx, sector, ReadPieceToPipe, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = pw.CloseWithError(ctx.Err())
+			case <-done:
+			}
+		}()
+
+		ok, err := l.readPiece(ctx, sb, pw, sector, index, size)
+		if err == nil && !ok {
+			err = xerrors.Errorf("unsealed piece data not found")
+		}
+
+		_ = pw.CloseWithError(err) // nil closes with io.EOF
+		return ok, err
+	})
+	if err != nil {
+		_ = pr.Close()
+		return nil, storiface.UndefCall, err
+	}
+
+	return pr, ci, nil
+}
+
+// Bounds of WorkerConfig.ReadPieceBufferSize
+const (
+	MinReadPieceBufferSize = 4 << 10
+	MaxReadPieceBufferSize = 16 << 20
+)
+
+func readPieceBufferSize(n int) int {
+	switch {
+	case n == 0:
+		return 0
+	case n < MinReadPieceBufferSize:
+		log.Warnf("read piece buffer size %d too small, using %d", n, MinReadPieceBufferSize)
+		return MinReadPieceBufferSize
+	case n > MaxReadPieceBufferSize:
+		log.Warnf("read piece buffer size %d too large, using %d", n, MaxReadPieceBufferSize)
+		return MaxReadPieceBufferSize
+	default:
+		return n
+	}
+}
+
+func (l *LocalWorker) replicaUpdater() (ffiwrapper.ReplicaUpdater, error) {
+	sb, err := l.sb()
+	if err != nil {
+		return nil, err
+	}
+
+	ru, ok := sb.(ffiwrapper.ReplicaUpdater)
+	if !ok {
+		return nil, xerrors.Errorf("proofs backend doesn't support replica updates")
+	}
+
+	return ru, nil
+}
+
+func (l *LocalWorker) ReplicaUpdate(ctx context.Context, sector storage.SectorRef, pieces []abi.PieceInfo) (storiface.CallID, error) {
+	return l.asyncCall(ctx, sector, ReplicaUpdate, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		ru, err := l.replicaUpdater()
+		if err != nil {
+			return nil, err
+		}
+
+		// cleanup previous failed attempts if they exist
+		if err := l.storage.Remove(ctx, sector.ID, storiface.FTUpdate, true); err != nil {
+			return nil, xerrors.Errorf("cleaning up update data: %w", err)
+		}
+
+		if err := l.storage.Remove(ctx, sector.ID, storiface.FTUpdateCache, true); err != nil {
+			return nil, xerrors.Errorf("cleaning up update cache data: %w", err)
+		}
+
+		return ru.ReplicaUpdate(ctx, sector, pieces)
+	})
+}
+
+func (l *LocalWorker) ProveReplicaUpdate1(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid) (storiface.CallID, error) {
+	return l.asyncCall(ctx, sector, ProveReplicaUpdate1, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		ru, err := l.replicaUpdater()
+		if err != nil {
+			return nil, err
+		}
+
+		return ru.ProveReplicaUpdate1(ctx, sector, sectorKey, newSealed, newUnsealed)
+	})
+}
+
+func (l *LocalWorker) ProveReplicaUpdate2(ctx context.Context, sector storage.SectorRef, sectorKey, newSealed, newUnsealed cid.Cid, vanillaProofs storiface.ReplicaVanillaProofs) (storiface.CallID, error) {
+	return l.asyncCall(ctx, sector, ProveReplicaUpdate2, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		ru, err := l.replicaUpdater()
+		if err != nil {
+			return nil, err
+		}
+
+		return ru.ProveReplicaUpdate2(ctx, sector, sectorKey, newSealed, newUnsealed, vanillaProofs)
 	})
 }
 
 func (l *LocalWorker) TaskTypes(context.Context) (map[sealtasks.TaskType]struct{}, error) {
-	return l.acceptTasks, nil
+	// evacuated workers don't take new tasks
+	if l.evac.active() {
+		return map[sealtasks.TaskType]struct{}{}, nil
+	}
+
+	l.taskLk.Lock()
+	defer l.taskLk.Unlock()
+
+	out := make(map[sealtasks.TaskType]struct{}, len(l.acceptTasks))
+	for taskType := range l.acceptTasks {
+		out[taskType] = struct{}{}
+	}
+
+	return out, nil
+}
+
+func (l *LocalWorker) TaskDisable(ctx context.Context, tt sealtasks.TaskType) error {
+	l.taskLk.Lock()
+	defer l.taskLk.Unlock()
+
+	delete(l.acceptTasks, tt)
+	return nil
+}
+
+func (l *LocalWorker) TaskEnable(ctx context.Context, tt sealtasks.TaskType) error {
+	l.taskLk.Lock()
+	defer l.taskLk.Unlock()
+
+	l.acceptTasks[tt] = struct{}{}
+	return nil
 }
 
 func (l *LocalWorker) Paths(ctx context.Context) ([]stores.StoragePath, error) {
-	return l.localStore.Local(ctx)
+	if l.localStore == nil {
+		return nil, nil
+	}
+
+	return l.pathsCache.get(ctx, l.localStore)
 }
 
-func (l *LocalWorker) Info(context.Context) (storiface.WorkerInfo, error) {
+func (l *LocalWorker) Info(ctx context.Context) (storiface.WorkerInfo, error) {
 	hostname, err := os.Hostname() // TODO: allow overriding from config
 	if err != nil {
 		panic(err)
@@ -490,15 +1760,62 @@ func (l *LocalWorker) Info(context.Context) (storiface.WorkerInfo, error) {
 		memSwap = 0
 	}
 
+	resources := storiface.WorkerResources{
+		MemPhysical: mem.Total,
+		MemSwap:     memSwap,
+		MemReserved: mem.VirtualUsed + mem.Total - mem.Available, // TODO: sub this process
+		CPUs:        uint64(runtime.NumCPU()),
+		GPUs:        gpus,
+	}
+
+	// only count what tasks on this worker use, not other processes
+	taskResources := resources
+	taskResources.MemReserved = 0
+
+	l.activeLk.Lock()
+	load := l.active.utilization(taskResources) * 100
+	l.activeLk.Unlock()
+
+	reserved, err := l.Reservations(ctx)
+	if err != nil {
+		return storiface.WorkerInfo{}, err
+	}
+
+	offline, err := l.offlinePaths(ctx)
+	if err != nil {
+		return storiface.WorkerInfo{}, xerrors.Errorf("checking storage paths: %w", err)
+	}
+
+	var offlineIDs []string
+	for id := range offline {
+		offlineIDs = append(offlineIDs, string(id))
+	}
+	sort.Strings(offlineIDs)
+
 	return storiface.WorkerInfo{
-		Hostname: hostname,
-		Resources: storiface.WorkerResources{
-			MemPhysical: mem.Total,
-			MemSwap:     memSwap,
-			MemReserved: mem.VirtualUsed + mem.Total - mem.Available, // TODO: sub this process
-			CPUs:        uint64(runtime.NumCPU()),
-			GPUs:        gpus,
-		},
+		Hostname:     hostname,
+		Resources:    resources,
+		Reserved:     reserved,
+		Load:         load,
+		OfflinePaths: offlineIDs,
+		MemProjected: l.mem.projected(),
+		MemSoftLimit: l.mem.softLimit(),
+		Capabilities: l.capabilities(),
+		Thermal:      l.thermal.sample(ctx),
+	}, nil
+}
+
+// Reservations doesn't touch hardware or storage, so the scheduler can refresh
+// reservations often without calling Info
+func (l *LocalWorker) Reservations(ctx context.Context) (storiface.ReservedResources, error) {
+	l.activeLk.Lock()
+	defer l.activeLk.Unlock()
+
+	return storiface.ReservedResources{
+		MemUsedMin: l.reserved.memUsedMin,
+		MemUsedMax: l.reserved.memUsedMax,
+		GpuUsed:    l.reserved.gpuUsed,
+		CpuUse:     l.reserved.cpuUse,
 	}, nil
 }
 
@@ -511,12 +1828,44 @@ func (l *LocalWorker) Session(ctx context.Context) (uuid.UUID, error) {
 	case <-l.closing:
 		return ClosedWorkerID, nil
 	default:
-		return l.session, nil
+	}
+
+	// report broken workers through the session, so the scheduler stops
+	// assigning them tasks until the executor can be initialized again
+	if err := l.health.get(func() error {
+		_, err := l.sb()
+		return err
+	}); err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return l.session, nil
+}
+
+// Ping doesn't touch the executor or hardware, so it's cheap enough to be
+// called frequently. Returns an error when the worker is closing.
+func (l *LocalWorker) Ping(ctx context.Context) error {
+	select {
+	case <-l.closing:
+		return xerrors.Errorf("worker is closing")
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
 	}
 }
 
 func (l *LocalWorker) Close() error {
 	close(l.closing)
+	<-l.reconcileDone
+	<-l.probeDone
+	<-l.removalsDone
+	<-l.reapDone
+	<-l.scrubDone
+	if l.webhook != nil {
+		<-l.webhook.done
+	}
+	l.releases.checkOutsideCalls()
 	return nil
 }
 
@@ -0,0 +1,635 @@
+package sectorstorage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-statestore"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func newTestLocalWorker(ctx context.Context, t *testing.T, wcfg WorkerConfig, ret storiface.WorkerReturn) (*LocalWorker, stores.StoragePath, *stores.Index, func()) {
+	st := newTestStorage(t)
+
+	si := stores.NewIndex()
+
+	lstor, err := stores.NewLocal(ctx, st, si, nil)
+	require.NoError(t, err)
+
+	stor := stores.NewRemote(lstor, si, nil, 6000, stores.DefaultFetchConfig())
+
+	w := newLocalWorker(func() (ffiwrapper.Storage, error) {
+		return &testExec{}, nil
+	}, wcfg, stor, lstor, si, ret, statestore.New(dssync.MutexWrap(datastore.NewMapDatastore())))
+
+	paths, err := lstor.Local(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+
+	return w, paths[0], si, func() {
+		require.NoError(t, w.Close())
+		st.cleanup()
+	}
+}
+
+// writeTestSectorFile creates a sector file in the storage path and declares it in the index
+func writeTestSectorFile(ctx context.Context, t *testing.T, p stores.StoragePath, si *stores.Index, sid abi.SectorID, ft storiface.SectorFileType, size int) string {
+	spath := filepath.Join(p.LocalPath, ft.String(), storiface.SectorName(sid))
+	require.NoError(t, ioutil.WriteFile(spath, make([]byte, size), 0644))
+	require.NoError(t, si.StorageDeclareSector(ctx, p.ID, sid, ft, true))
+	return spath
+}
+
+func TestToCallError(t *testing.T) {
+	require.Nil(t, toCallError(nil))
+
+	plain := toCallError(xerrors.Errorf("outer: %w", xerrors.New("inner")))
+	require.Equal(t, storiface.ErrUnknown, plain.Code)
+	require.False(t, plain.Retryable)
+	require.Len(t, plain.Chain, 2)
+	require.Equal(t, "outer: inner", plain.Message)
+
+	temp := storiface.Err(storiface.ErrTempAllocateSpace, xerrors.New("no space"))
+	require.Equal(t, temp, toCallError(temp))
+
+	wrapped := toCallError(xerrors.Errorf("reserving storage space: %w", temp))
+	require.Equal(t, storiface.ErrTempAllocateSpace, wrapped.Code)
+	require.True(t, wrapped.Retryable)
+	require.True(t, storiface.IsRetryable(wrapped))
+	require.Contains(t, wrapped.Message, "reserving storage space")
+}
+
+func TestTaskTypesConcurrentToggle(t *testing.T) {
+	ctx := context.Background()
+
+	w := &LocalWorker{
+		acceptTasks: map[sealtasks.TaskType]struct{}{
+			sealtasks.TTAddPiece: {},
+			sealtasks.TTFetch:    {},
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				tt, err := w.TaskTypes(ctx)
+				require.NoError(t, err)
+
+				// the returned map must be a copy callers can freely modify
+				tt[sealtasks.TTCommit2] = struct{}{}
+				delete(tt, sealtasks.TTFetch)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for j := 0; j < 1000; j++ {
+			require.NoError(t, w.TaskDisable(ctx, sealtasks.TTAddPiece))
+			require.NoError(t, w.TaskEnable(ctx, sealtasks.TTAddPiece))
+		}
+	}()
+
+	wg.Wait()
+
+	tt, err := w.TaskTypes(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[sealtasks.TaskType]struct{}{
+		sealtasks.TTAddPiece: {},
+		sealtasks.TTFetch:    {},
+	}, tt)
+}
+
+type commit2Returns struct {
+	storiface.WorkerReturn
+
+	errs chan *storiface.CallError
+}
+
+func (r *commit2Returns) ReturnSealCommit2(ctx context.Context, callID storiface.CallID, proof storage.Proof, err *storiface.CallError) error {
+	r.errs <- err
+	return nil
+}
+
+func TestExecutorErrorUnhealthy(t *testing.T) {
+	ctx := context.Background()
+
+	var execs int64
+	rets := &commit2Returns{errs: make(chan *storiface.CallError, 1)}
+	w := newLocalWorker(func() (ffiwrapper.Storage, error) {
+		atomic.AddInt64(&execs, 1)
+		return nil, xerrors.New("no gpu")
+	}, WorkerConfig{}, nil, nil, nil, rets, statestore.New(dssync.MutexWrap(datastore.NewMapDatastore())))
+
+	// reported through the return call, the code survives RPC
+	_, err := w.SealCommit2(ctx, storage.SectorRef{}, nil)
+	require.NoError(t, err)
+
+	cerr := <-rets.errs
+	require.Equal(t, storiface.ErrTempWorkerUnhealthy, cerr.Code)
+	require.True(t, cerr.Retryable)
+	require.Contains(t, cerr.Message, "no gpu")
+
+	// session uses the cached result
+	_, err = w.Session(ctx)
+	var uerr *WorkerUnhealthyError
+	require.True(t, xerrors.As(err, &uerr))
+	_, err = w.Session(ctx)
+	require.Error(t, err)
+	require.Equal(t, int64(1), atomic.LoadInt64(&execs))
+
+	require.NoError(t, w.Close())
+
+	ses, err := w.Session(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ClosedWorkerID, ses)
+}
+
+func TestInfoLoad(t *testing.T) {
+	ctx := context.Background()
+
+	w := &LocalWorker{}
+
+	idle, err := w.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, float64(0), idle.Load)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		sector := storage.SectorRef{ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1}
+		_, err := w.trackResources(sector, SealCommit2, func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		require.NoError(t, err)
+	}()
+
+	<-started
+	busy, err := w.Info(ctx)
+	require.NoError(t, err)
+	require.Greater(t, busy.Load, idle.Load)
+
+	close(release)
+	<-done
+
+	w.activeLk.Lock()
+	require.Equal(t, activeResources{}, w.active)
+	w.activeLk.Unlock()
+}
+
+func TestInfoReserved(t *testing.T) {
+	ctx := context.Background()
+
+	rets := &commit2Returns{errs: make(chan *storiface.CallError, 1)}
+	w := newLocalWorker(func() (ffiwrapper.Storage, error) {
+		return nil, xerrors.New("no gpu")
+	}, WorkerConfig{MaxConcurrentCalls: 1}, nil, nil, nil, rets, statestore.New(dssync.MutexWrap(datastore.NewMapDatastore())))
+	defer w.Close() // nolint
+
+	// keep the call waiting in the queue
+	release, err := w.queue.acquire(ctx, storiface.UndefCall, 0)
+	require.NoError(t, err)
+
+	sector := storage.SectorRef{ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1}
+	_, err = w.SealCommit2(ctx, sector, nil)
+	require.NoError(t, err)
+
+	info, err := w.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ResourceTable[sealtasks.TTCommit2][sector.ProofType].MinMemory, info.Reserved.MemUsedMin)
+	require.Equal(t, ResourceTable[sealtasks.TTCommit2][sector.ProofType].MaxMemory, info.Reserved.MemUsedMax)
+	require.Zero(t, info.Load)
+
+	reserved, err := w.Reservations(ctx)
+	require.NoError(t, err)
+	require.Equal(t, info.Reserved, reserved)
+
+	release()
+	require.NotNil(t, <-rets.errs)
+
+	require.Eventually(t, func() bool {
+		info, err := w.Info(ctx)
+		return err == nil && info.Reserved == storiface.ReservedResources{}
+	}, time.Second, 10*time.Millisecond)
+}
+
+type fetchReturns struct {
+	storiface.WorkerReturn
+
+	returned chan storiface.CallID
+}
+
+func (r *fetchReturns) ReturnFetch(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
+	r.returned <- callID
+	return nil
+}
+
+func TestDeterministicCallIDs(t *testing.T) {
+	ctx := context.Background()
+
+	var next byte
+	ids := func() uuid.UUID {
+		next++
+		return uuid.UUID{next}
+	}
+
+	ret := &fetchReturns{returned: make(chan storiface.CallID, 2)}
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{CallIDs: ids}, ret)
+	defer cleanup()
+
+	sector := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 1}}
+	for i := byte(1); i <= 2; i++ {
+		ci, err := w.Fetch(ctx, sector, storiface.FTNone, storiface.PathSealing, storiface.AcquireCopy)
+		require.NoError(t, err)
+
+		expect := storiface.CallID{Sector: sector.ID, ID: uuid.UUID{i}}
+		require.Equal(t, expect, ci)
+		require.Equal(t, expect, <-ret.returned)
+	}
+}
+
+func TestNormalizeKeepUnsealed(t *testing.T) {
+	spt := abi.RegisteredSealProof_StackedDrg2KiBV1
+	max := abi.PaddedPieceSize(2048).Unpadded()
+
+	out, err := normalizeKeepUnsealed(spt, nil)
+	require.NoError(t, err)
+	require.Nil(t, out)
+
+	out, err = normalizeKeepUnsealed(spt, []storage.Range{
+		{Offset: 1016, Size: 508},
+		{Offset: 0, Size: 254},
+		{Offset: 127, Size: 381},
+		{Offset: 1524, Size: max - 1524},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []storage.Range{
+		{Offset: 0, Size: 508},
+		{Offset: 1016, Size: max - 1016},
+	}, out)
+
+	_, err = normalizeKeepUnsealed(spt, []storage.Range{{Offset: 0, Size: max + 1}})
+	require.Error(t, err)
+
+	_, err = normalizeKeepUnsealed(spt, []storage.Range{{Offset: max, Size: 0}})
+	require.Error(t, err)
+
+	_, err = normalizeKeepUnsealed(spt, []storage.Range{{Offset: ^abi.UnpaddedPieceSize(0), Size: 2}})
+	require.Error(t, err)
+}
+
+type partialFinalizeExec struct {
+	testExec
+
+	finalized chan storiface.SectorFileType
+}
+
+func (e *partialFinalizeExec) FinalizeUnsealed(ctx context.Context, sector storage.SectorRef, keepUnsealed []storage.Range) error {
+	e.finalized <- storiface.FTUnsealed
+	return nil
+}
+
+func (e *partialFinalizeExec) ClearCache(ctx context.Context, sector storage.SectorRef) error {
+	e.finalized <- storiface.FTCache
+	return nil
+}
+
+func TestFinalizeSectorTypes(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &finalizeReturns{errs: make(chan *storiface.CallError, 1)}
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	keep := []storage.Range{{Offset: 0, Size: 127}}
+
+	_, err := w.FinalizeSectorTypes(ctx, sector, keep, storiface.FTSealed)
+	require.Error(t, err)
+
+	// testExec doesn't support partial finalization
+	_, err = w.FinalizeSectorTypes(ctx, sector, keep, storiface.FTUnsealed)
+	require.Error(t, err)
+
+	exec := &partialFinalizeExec{finalized: make(chan storiface.SectorFileType, 2)}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	// cache can't be cleared before the sector is sealed
+	_, err = w.FinalizeSectorTypes(ctx, sector, keep, storiface.FTCache)
+	require.Error(t, err)
+
+	_, err = w.FinalizeSectorTypes(ctx, sector, keep, storiface.FTUnsealed)
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+	require.Equal(t, storiface.FTUnsealed, <-exec.finalized)
+
+	writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTSealed, 1)
+
+	_, err = w.FinalizeSectorTypes(ctx, sector, keep, storiface.FTCache)
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+	require.Equal(t, storiface.FTCache, <-exec.finalized)
+}
+
+type finalizeReturns struct {
+	storiface.WorkerReturn
+
+	errs chan *storiface.CallError
+	outs chan storiface.FinalizeSectorOut // optional
+}
+
+func (r *finalizeReturns) ReturnFinalizeSector(ctx context.Context, callID storiface.CallID, out storiface.FinalizeSectorOut, err *storiface.CallError) error {
+	if r.outs != nil {
+		r.outs <- out
+	}
+	r.errs <- err
+	return nil
+}
+
+type readPieceExec struct {
+	testExec
+}
+
+func (e *readPieceExec) ReadPiece(ctx context.Context, writer io.Writer, sector storage.SectorRef, offset storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize) (bool, error) {
+	// write in small chunks, like the unpad reader does
+	for i := 0; i < int(size); i += 127 {
+		if _, err := writer.Write(make([]byte, 127)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+type readPieceReturns struct {
+	storiface.WorkerReturn
+
+	errs chan *storiface.CallError
+}
+
+func (r *readPieceReturns) ReturnReadPiece(ctx context.Context, callID storiface.CallID, ok bool, err *storiface.CallError) error {
+	r.errs <- err
+	return nil
+}
+
+type countingWriter struct {
+	writes, written int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	w.written += len(p)
+	return len(p), nil
+}
+
+func TestReadPieceBufferSize(t *testing.T) {
+	ctx := context.Background()
+
+	require.Equal(t, 0, readPieceBufferSize(0))
+	require.Equal(t, MinReadPieceBufferSize, readPieceBufferSize(1))
+	require.Equal(t, MaxReadPieceBufferSize, readPieceBufferSize(1<<30))
+
+	ret := &readPieceReturns{errs: make(chan *storiface.CallError, 1)}
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		ReadPieceBufferSize: 8 << 10,
+	}, ret)
+	defer cleanup()
+
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return &readPieceExec{}, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	out := &countingWriter{}
+	_, err := w.ReadPiece(ctx, out, sector, 0, 127*128)
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+
+	require.Equal(t, 127*128, out.written)
+	require.Equal(t, 2, out.writes)
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+
+	require.NoError(t, w.Ping(ctx))
+
+	// Ping doesn't depend on the executor
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return nil, xerrors.Errorf("broken")
+	}
+	require.NoError(t, w.Ping(ctx))
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Error(t, w.Ping(cctx))
+
+	cleanup()
+	require.Error(t, w.Ping(ctx))
+}
+
+type unsealExec struct {
+	testExec
+}
+
+func (e *unsealExec) UnsealPiece(ctx context.Context, sector storage.SectorRef, offset storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize, randomness abi.SealRandomness, commd cid.Cid) error {
+	return nil
+}
+
+type unsealReturns struct {
+	storiface.WorkerReturn
+
+	errs chan *storiface.CallError
+}
+
+func (r *unsealReturns) ReturnUnsealPiece(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
+	r.errs <- err
+	return nil
+}
+
+// slowCopiesStore blocks RemoveCopies until released
+type slowCopiesStore struct {
+	*memStore
+
+	started chan storiface.SectorFileType
+	release chan struct{}
+}
+
+func (s *slowCopiesStore) RemoveCopies(ctx context.Context, sid abi.SectorID, types storiface.SectorFileType) error {
+	s.started <- types
+	<-s.release
+	return xerrors.Errorf("removing %s failed", types)
+}
+
+func TestUnsealCleanup(t *testing.T) {
+	ctx := context.Background()
+
+	st := &slowCopiesStore{
+		memStore: newMemStore(t.TempDir()),
+		started:  make(chan storiface.SectorFileType, 2),
+		release:  make(chan struct{}),
+	}
+	ret := &unsealReturns{errs: make(chan *storiface.CallError, 1)}
+	si := stores.NewIndex()
+
+	w := newLocalWorker(func() (ffiwrapper.Storage, error) {
+		return &unsealExec{}, nil
+	}, WorkerConfig{CleanupParallelism: 1}, st, nil, si, ret, statestore.New(dssync.MutexWrap(datastore.NewMapDatastore())))
+	defer w.Close() // nolint
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	// held by the manager until the result is reported
+	mctx, unlock := context.WithCancel(ctx)
+	require.NoError(t, si.StorageLock(mctx, sector.ID, storiface.FTSealed|storiface.FTCache, storiface.FTUnsealed))
+
+	_, err := w.UnsealPiece(ctx, sector, 0, 127, nil, cid.Undef)
+	require.NoError(t, err)
+
+	// the result is reported while cleanup is still running
+	require.Nil(t, <-ret.errs)
+
+	select {
+	case <-st.started:
+		t.Fatal("copies removed without holding the sector lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+
+	first := <-st.started
+	select {
+	case <-st.started:
+		t.Fatal("cleanup parallelism not respected")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the lock is held until cleanup is done
+	tctx, tcancel := context.WithCancel(ctx)
+	locked, err := si.StorageTryLock(tctx, sector.ID, storiface.FTSealed, storiface.FTNone)
+	require.NoError(t, err)
+	require.False(t, locked)
+	tcancel()
+
+	close(st.release)
+	require.Equal(t, storiface.FTSealed|storiface.FTCache, first|<-st.started)
+
+	require.Eventually(t, func() bool {
+		tctx, tcancel := context.WithCancel(ctx)
+		defer tcancel()
+
+		locked, err := si.StorageTryLock(tctx, sector.ID, storiface.FTSealed, storiface.FTNone)
+		return err == nil && locked
+	}, time.Second, 10*time.Millisecond)
+
+	// failing removals are aggregated
+	err = w.removeCopies(ctx, sector.ID, storiface.FTSealed, storiface.FTCache)
+	<-st.started
+	<-st.started
+	require.Error(t, err)
+	require.Len(t, err.(*multierror.Error).Errors, 2)
+}
+
+func TestReadPieceToPipe(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return &readPieceExec{}, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	waitCallDone := func() {
+		require.Eventually(t, func() bool {
+			w.cancelLk.Lock()
+			defer w.cancelLk.Unlock()
+			return len(w.cancels) == 0
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	r, _, err := w.ReadPieceToPipe(ctx, sector, 0, 127*16)
+	require.NoError(t, err)
+
+	// local calls aren't tracked
+	unfinished, err := w.ct.unfinished()
+	require.NoError(t, err)
+	require.Empty(t, unfinished)
+
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Len(t, data, 127*16)
+	require.NoError(t, r.Close())
+	waitCallDone()
+
+	// closing the reader early stops the call
+	r, _, err = w.ReadPieceToPipe(ctx, sector, 0, 127*16)
+	require.NoError(t, err)
+
+	_, err = io.ReadFull(r, make([]byte, 127))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	waitCallDone()
+
+	// so does cancelling the call
+	r, ci, err := w.ReadPieceToPipe(ctx, sector, 0, 127*16)
+	require.NoError(t, err)
+
+	_, err = io.ReadFull(r, make([]byte, 127))
+	require.NoError(t, err)
+	require.NoError(t, w.CancelCall(ctx, ci))
+	waitCallDone()
+
+	_, err = ioutil.ReadAll(r)
+	require.True(t, xerrors.Is(err, context.Canceled))
+
+	require.Error(t, w.CancelCall(ctx, ci))
+}
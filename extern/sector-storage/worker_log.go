@@ -0,0 +1,214 @@
+package sectorstorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// logSampler rate-limits high-frequency debug lines by only letting through
+// one in every `every` messages. Only debug messages go through the sampler,
+// warnings and errors are always logged directly.
+type logSampler struct {
+	every uint64
+	n     uint64
+}
+
+func newLogSampler(every uint64) *logSampler {
+	return &logSampler{every: every}
+}
+
+func (s *logSampler) allow() bool {
+	if s == nil || s.every <= 1 {
+		return true
+	}
+
+	return atomic.AddUint64(&s.n, 1)%s.every == 1
+}
+
+func (s *logSampler) Debugf(template string, args ...interface{}) {
+	if !s.allow() {
+		return
+	}
+
+	log.Debugf(template, args...)
+}
+
+const (
+	// number of most recent log lines kept for each call
+	callLogLines = 256
+
+	// number of returned calls whose logs are kept
+	callLogRetain = 64
+)
+
+// callLogs keeps recent log lines of calls in memory. Logs of running calls
+// are always kept, logs of returned calls are evicted oldest first.
+type callLogs struct {
+	lk       sync.Mutex
+	calls    map[storiface.CallID]*callLogBuf
+	returned []storiface.CallID
+
+	// limits, callLogLines and callLogRetain when 0
+	maxLines, retain int
+}
+
+type callLogBuf struct {
+	lines []storiface.LogLine // ring buffer
+	next  int
+}
+
+func (c *callLogs) add(ci storiface.CallID, level, msg string) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.calls == nil {
+		c.calls = map[storiface.CallID]*callLogBuf{}
+	}
+
+	cl, ok := c.calls[ci]
+	if !ok {
+		cl = &callLogBuf{}
+		c.calls[ci] = cl
+	}
+
+	line := storiface.LogLine{
+		Time:    time.Now(),
+		Level:   level,
+		Message: msg,
+	}
+
+	maxLines := c.maxLines
+	if maxLines <= 0 {
+		maxLines = callLogLines
+	}
+
+	if len(cl.lines) < maxLines {
+		cl.lines = append(cl.lines, line)
+		return
+	}
+
+	cl.lines[cl.next] = line
+	cl.next = (cl.next + 1) % len(cl.lines)
+}
+
+// onReturned marks the call as returned, making its logs evictable
+func (c *callLogs) onReturned(ci storiface.CallID) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if _, ok := c.calls[ci]; !ok {
+		return
+	}
+
+	retain := c.retain
+	if retain <= 0 {
+		retain = callLogRetain
+	}
+
+	c.returned = append(c.returned, ci)
+	for len(c.returned) > retain {
+		delete(c.calls, c.returned[0])
+		c.returned = c.returned[1:]
+	}
+}
+
+func (c *callLogs) get(ci storiface.CallID) ([]storiface.LogLine, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	cl, ok := c.calls[ci]
+	if !ok {
+		return nil, false
+	}
+
+	out := make([]storiface.LogLine, 0, len(cl.lines))
+	out = append(out, cl.lines[cl.next:]...)
+	out = append(out, cl.lines[:cl.next]...)
+	return out, true
+}
+
+// problems returns warnings and errors kept in logs of all calls, oldest
+// first, at most limit of the newest ones
+func (c *callLogs) problems(limit int) []storiface.CallLogLine {
+	c.lk.Lock()
+	var out []storiface.CallLogLine
+	for ci, cl := range c.calls {
+		for _, line := range cl.lines {
+			if line.Level == "warn" || line.Level == "error" {
+				out = append(out, storiface.CallLogLine{Call: ci, LogLine: line})
+			}
+		}
+	}
+	c.lk.Unlock()
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Time.Before(out[j].Time)
+	})
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+// callLogger logs messages to the worker log, and records them in the logs
+// of the call
+type callLogger struct {
+	ci   storiface.CallID
+	logs *callLogs
+}
+
+type callLoggerKey struct{}
+
+func withCallLogger(ctx context.Context, cl *callLogger) context.Context {
+	return context.WithValue(ctx, callLoggerKey{}, cl)
+}
+
+// callLog returns the logger of the call running with the context, messages
+// logged outside of calls only go to the worker log
+func callLog(ctx context.Context) *callLogger {
+	cl, _ := ctx.Value(callLoggerKey{}).(*callLogger)
+	return cl
+}
+
+// recordf only records the message in the call logs, and returns it
+func (cl *callLogger) recordf(level, template string, args ...interface{}) string {
+	msg := fmt.Sprintf(template, args...)
+	if cl != nil {
+		cl.logs.add(cl.ci, level, msg)
+	}
+	return msg
+}
+
+func (cl *callLogger) Debugf(template string, args ...interface{}) {
+	log.Debug(cl.recordf("debug", template, args...))
+}
+
+func (cl *callLogger) Infof(template string, args ...interface{}) {
+	log.Info(cl.recordf("info", template, args...))
+}
+
+func (cl *callLogger) Warnf(template string, args ...interface{}) {
+	log.Warn(cl.recordf("warn", template, args...))
+}
+
+func (cl *callLogger) Errorf(template string, args ...interface{}) {
+	log.Error(cl.recordf("error", template, args...))
+}
+
+// CallLogs returns recent log lines of a call, oldest first
+func (l *LocalWorker) CallLogs(ctx context.Context, ci storiface.CallID) ([]storiface.LogLine, error) {
+	lines, ok := l.callLogs.get(ci)
+	if !ok {
+		return nil, xerrors.Errorf("no logs for call %s", ci)
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,88 @@
+package sectorstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestLogSampler(t *testing.T) {
+	var nilSampler *logSampler
+	require.True(t, nilSampler.allow())
+
+	all := newLogSampler(0)
+	for i := 0; i < 10; i++ {
+		require.True(t, all.allow())
+	}
+
+	s := newLogSampler(4)
+	var allowed int
+	for i := 0; i < 40; i++ {
+		if s.allow() {
+			allowed++
+		}
+	}
+	require.Equal(t, 10, allowed)
+}
+
+func TestCallLogs(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &fetchReturns{returned: make(chan storiface.CallID, 1)}
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	w.callLogs.lk.Lock()
+	w.callLogs.maxLines, w.callLogs.retain = 3, 1
+	w.callLogs.lk.Unlock()
+
+	sector := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 1}}
+	ci, err := w.Fetch(ctx, sector, storiface.FTNone, storiface.PathSealing, storiface.AcquireCopy)
+	require.NoError(t, err)
+	<-ret.returned
+
+	// doReturn marks the call as returned after ReturnFetch is called
+	require.Eventually(t, func() bool {
+		w.callLogs.lk.Lock()
+		defer w.callLogs.lk.Unlock()
+		return len(w.callLogs.returned) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	lines, err := w.CallLogs(ctx, ci)
+	require.NoError(t, err)
+	require.NotEmpty(t, lines)
+	require.Contains(t, lines[0].Message, "starting Fetch call")
+
+	// only the last maxLines lines are kept
+	other := storiface.CallID{Sector: sector.ID, ID: uuid.New()}
+	cl := &callLogger{ci: other, logs: &w.callLogs}
+	for i := 0; i < 5; i++ {
+		cl.Infof("line %d", i)
+	}
+
+	lines, err = w.CallLogs(ctx, other)
+	require.NoError(t, err)
+	require.Len(t, lines, 3)
+	for i, line := range lines {
+		require.Equal(t, fmt.Sprintf("line %d", i+2), line.Message)
+		require.Equal(t, "info", line.Level)
+	}
+
+	// logs of returned calls are evicted, oldest first
+	w.callLogs.onReturned(other)
+
+	_, err = w.CallLogs(ctx, ci)
+	require.Error(t, err)
+
+	_, err = w.CallLogs(ctx, other)
+	require.NoError(t, err)
+}
@@ -0,0 +1,106 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+var ErrMemoryLimit = errors.New("soft memory limit reached")
+
+// memAdmission keeps the projected memory use of tasks running on the worker,
+// the sum of their maximum memory estimates, under a soft limit. Unlike the
+// scheduler, which only knows about tasks it assigned, it accounts for all
+// tasks the worker runs.
+type memAdmission struct {
+	limit  uint64 // 0 = no limit
+	reject bool
+
+	lk       sync.Mutex
+	admitted uint64
+	freed    chan struct{} // closed and replaced when memory is released
+}
+
+func newMemAdmission(limit uint64, reject bool) *memAdmission {
+	return &memAdmission{
+		limit:  limit,
+		reject: reject,
+		freed:  make(chan struct{}),
+	}
+}
+
+// admit waits until the task fits under the limit, or rejects it when the
+// worker rejects tasks over the limit, and returns a func which must be called
+// after the task is done. A task needing more than the limit alone is admitted
+// when no other tasks are, so that it doesn't wait forever.
+func (m *memAdmission) admit(ctx context.Context, tt sealtasks.TaskType, spt abi.RegisteredSealProof) (func(), error) {
+	if m == nil {
+		return func() {}, nil
+	}
+
+	res, ok := ResourceTable[tt][spt]
+	if !ok {
+		return func() {}, nil
+	}
+	need := res.MaxMemory
+
+	for {
+		m.lk.Lock()
+		if m.limit == 0 || m.admitted == 0 || m.admitted+need <= m.limit {
+			if m.limit > 0 && need > m.limit {
+				log.Warnw("task needs more memory than the soft limit, running it alone", "task", tt, "need", need, "limit", m.limit)
+			}
+			m.admitted += need
+			m.lk.Unlock()
+
+			return func() {
+				m.lk.Lock()
+				defer m.lk.Unlock()
+
+				m.admitted -= need
+				close(m.freed)
+				m.freed = make(chan struct{})
+			}, nil
+		}
+
+		admitted, freed := m.admitted, m.freed
+		m.lk.Unlock()
+
+		if m.reject {
+			return nil, xerrors.Errorf("%s needs %d bytes of memory, %d of the %d byte soft limit are in use: %w", tt, need, admitted, m.limit, ErrMemoryLimit)
+		}
+
+		log.Debugw("waiting for memory under the soft limit", "task", tt, "need", need, "admitted", admitted, "limit", m.limit)
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, xerrors.Errorf("waiting for memory: %w", ctx.Err())
+		}
+	}
+}
+
+// projected returns the projected memory use of admitted tasks
+func (m *memAdmission) projected() uint64 {
+	if m == nil {
+		return 0
+	}
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	return m.admitted
+}
+
+func (m *memAdmission) softLimit() uint64 {
+	if m == nil {
+		return 0
+	}
+	return m.limit
+}
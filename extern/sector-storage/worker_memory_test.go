@@ -0,0 +1,79 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+func TestMemAdmission(t *testing.T) {
+	ctx := context.Background()
+
+	spt := abi.RegisteredSealProof_StackedDrg2KiBV1
+	need := ResourceTable[sealtasks.TTPreCommit1][spt].MaxMemory
+
+	m := newMemAdmission(need+need/2, false)
+
+	release, err := m.admit(ctx, sealtasks.TTPreCommit1, spt)
+	require.NoError(t, err)
+	require.Equal(t, need, m.projected())
+
+	admitted := make(chan func())
+	go func() {
+		r, err := m.admit(ctx, sealtasks.TTPreCommit1, spt)
+		require.NoError(t, err)
+		admitted <- r
+	}()
+
+	select {
+	case <-admitted:
+		t.Fatal("task admitted over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	release2 := <-admitted
+	require.Equal(t, need, m.projected())
+
+	// waiting stops with the context
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = m.admit(cctx, sealtasks.TTPreCommit1, spt)
+	require.True(t, xerrors.Is(err, context.DeadlineExceeded))
+
+	release2()
+	require.Zero(t, m.projected())
+
+	// tasks needing more than the limit run alone
+	small := newMemAdmission(need/2, true)
+	release, err = small.admit(ctx, sealtasks.TTPreCommit1, spt)
+	require.NoError(t, err)
+
+	_, err = small.admit(ctx, sealtasks.TTPreCommit1, spt)
+	require.True(t, xerrors.Is(err, ErrMemoryLimit))
+	release()
+}
+
+func TestInfoMemProjected(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{SoftMemoryLimit: 64 << 30}, nil)
+	defer cleanup()
+
+	spt := abi.RegisteredSealProof_StackedDrg2KiBV1
+	release, err := w.mem.admit(ctx, sealtasks.TTPreCommit1, spt)
+	require.NoError(t, err)
+	defer release()
+
+	info, err := w.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ResourceTable[sealtasks.TTPreCommit1][spt].MaxMemory, info.MemProjected)
+	require.Equal(t, uint64(64<<30), info.MemSoftLimit)
+}
@@ -0,0 +1,171 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// MoveReadPolicy controls how MoveStorage calls yield disk I/O to calls
+// reading piece data (ReadPiece, ReadPieceToPipe), so that bursts of moves
+// don't starve reads. Reads count from when the worker accepts them,
+// including time they wait in the call queue. Moves are held back before they
+// start, moves already running aren't interrupted.
+type MoveReadPolicy int
+
+const (
+	// MoveReadOff runs moves next to any number of reads
+	MoveReadOff MoveReadPolicy = iota
+
+	// MoveReadPause holds moves back while there are reads
+	MoveReadPause
+
+	// MoveReadCap limits how many moves run while there are reads, see
+	// WorkerConfig.MaxMovesDuringReads
+	MoveReadCap
+)
+
+var moveReadPolicies = map[string]MoveReadPolicy{
+	"off":   MoveReadOff,
+	"pause": MoveReadPause,
+	"cap":   MoveReadCap,
+}
+
+// ParseMoveReadPolicy parses a policy name: off, pause or cap
+func ParseMoveReadPolicy(s string) (MoveReadPolicy, error) {
+	p, ok := moveReadPolicies[s]
+	if !ok {
+		return 0, xerrors.Errorf("unknown move/read policy %q, expected off, pause or cap", s)
+	}
+	return p, nil
+}
+
+func (p MoveReadPolicy) String() string {
+	for name, mp := range moveReadPolicies {
+		if mp == p {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// moveReadArbiter counts reads and moves, and holds moves back as the policy
+// says
+type moveReadArbiter struct {
+	policy   MoveReadPolicy
+	maxMoves int
+
+	lk      sync.Mutex
+	reads   int
+	moves   int
+	waiting int
+	changed chan struct{} // closed when reads or moves end
+}
+
+func newMoveReadArbiter(policy MoveReadPolicy, maxMoves int) *moveReadArbiter {
+	if maxMoves < 1 {
+		maxMoves = 1
+	}
+
+	return &moveReadArbiter{
+		policy:   policy,
+		maxMoves: maxMoves,
+		changed:  make(chan struct{}),
+	}
+}
+
+// admit registers reads, and waits until moves can start. The returned func
+// must be called when the call is done.
+func (a *moveReadArbiter) admit(ctx context.Context, rt ReturnType) (func(), error) {
+	switch rt {
+	case ReadPiece, ReadPieceToPipe:
+		a.lk.Lock()
+		a.reads++
+		a.lk.Unlock()
+
+		return func() {
+			a.lk.Lock()
+			defer a.lk.Unlock()
+
+			a.reads--
+			a.notify()
+		}, nil
+	case MoveStorage:
+	default:
+		return func() {}, nil
+	}
+
+	a.lk.Lock()
+	for !a.canMove() {
+		a.waiting++
+		changed := a.changed
+		a.lk.Unlock()
+
+		log.Debugw("move waiting for piece reads", "policy", a.policy)
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			a.lk.Lock()
+			a.waiting--
+			a.lk.Unlock()
+			return nil, xerrors.Errorf("waiting for piece reads: %w", ctx.Err())
+		}
+
+		a.lk.Lock()
+		a.waiting--
+	}
+	a.moves++
+	a.lk.Unlock()
+
+	return func() {
+		a.lk.Lock()
+		defer a.lk.Unlock()
+
+		a.moves--
+		a.notify()
+	}, nil
+}
+
+// must be called with lk held
+func (a *moveReadArbiter) canMove() bool {
+	if a.reads == 0 {
+		return true
+	}
+
+	switch a.policy {
+	case MoveReadPause:
+		return false
+	case MoveReadCap:
+		return a.moves < a.maxMoves
+	default:
+		return true
+	}
+}
+
+// must be called with lk held
+func (a *moveReadArbiter) notify() {
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+func (a *moveReadArbiter) state() storiface.IOContention {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	return storiface.IOContention{
+		Policy:       a.policy.String(),
+		Reads:        a.reads,
+		Moves:        a.moves,
+		MovesWaiting: a.waiting,
+	}
+}
+
+// IOContention returns how many piece reads and storage moves the worker has,
+// and how many moves are held back for reads
+func (l *LocalWorker) IOContention(ctx context.Context) (storiface.IOContention, error) {
+	return l.moveRead.state(), nil
+}
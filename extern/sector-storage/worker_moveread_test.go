@@ -0,0 +1,88 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestMoveReadArbiter(t *testing.T) {
+	ctx := context.Background()
+
+	admitted := func(a *moveReadArbiter, rt ReturnType) (chan func(), context.CancelFunc) {
+		ctx, cancel := context.WithCancel(ctx)
+		out := make(chan func(), 1)
+		go func() {
+			release, err := a.admit(ctx, rt)
+			if err != nil {
+				close(out)
+				return
+			}
+			out <- release
+		}()
+		return out, cancel
+	}
+	startNow := func(a *moveReadArbiter, rt ReturnType) func() {
+		release, err := a.admit(ctx, rt)
+		require.NoError(t, err)
+		return release
+	}
+	waiting := func(ch chan func()) {
+		select {
+		case <-ch:
+			t.Fatal("move wasn't held back")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	t.Run("pause", func(t *testing.T) {
+		a := newMoveReadArbiter(MoveReadPause, 0)
+
+		releaseMove := startNow(a, MoveStorage)
+		releaseRead := startNow(a, ReadPiece)
+
+		move, _ := admitted(a, MoveStorage)
+		waiting(move)
+		require.Equal(t, storiface.IOContention{Policy: "pause", Reads: 1, Moves: 1, MovesWaiting: 1}, a.state())
+
+		// cancelled while waiting
+		cancelled, cancel := admitted(a, MoveStorage)
+		waiting(cancelled)
+		cancel()
+		_, ok := <-cancelled
+		require.False(t, ok)
+
+		releaseRead()
+		(<-move)()
+		releaseMove()
+		require.Equal(t, storiface.IOContention{Policy: "pause"}, a.state())
+	})
+
+	t.Run("cap", func(t *testing.T) {
+		a := newMoveReadArbiter(MoveReadCap, 2)
+
+		releaseRead := startNow(a, ReadPieceToPipe)
+		releaseMove := startNow(a, MoveStorage)
+		startNow(a, MoveStorage)
+
+		move, _ := admitted(a, MoveStorage)
+		waiting(move)
+
+		releaseMove()
+		(<-move)()
+		releaseRead()
+	})
+
+	t.Run("off", func(t *testing.T) {
+		a := newMoveReadArbiter(MoveReadOff, 0)
+
+		startNow(a, ReadPiece)
+		startNow(a, MoveStorage)
+		startNow(a, MoveStorage)
+		require.Equal(t, storiface.IOContention{Policy: "off", Reads: 1, Moves: 2}, a.state())
+	})
+}
@@ -0,0 +1,36 @@
+package sectorstorage
+
+import (
+	"errors"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+var ErrForeignMiner = errors.New("sector of a miner the worker doesn't serve")
+
+// checkNamespace returns a storiface.ErrForeignSector error when the worker
+// only serves some miners (see WorkerConfig.AllowedMiners), and the sector
+// belongs to another one, so that misrouted calls can't touch its storage
+func (l *LocalWorker) checkNamespace(sector abi.SectorID) error {
+	if len(l.allowedMiners) == 0 {
+		return nil
+	}
+	if _, ok := l.allowedMiners[sector.Miner]; ok {
+		return nil
+	}
+
+	return storiface.Err(storiface.ErrForeignSector, xerrors.Errorf("sector %d of miner f0%d: %w", sector.Number, sector.Miner, ErrForeignMiner))
+}
+
+// checkCallNamespace is checkNamespace for calls, calls which aren't for a
+// sector are always accepted
+func (l *LocalWorker) checkCallNamespace(sector abi.SectorID, rt ReturnType) error {
+	if rt == GeneratePieceCommP {
+		return nil
+	}
+	return l.checkNamespace(sector)
+}
@@ -0,0 +1,78 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestAllowedMiners(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &c2Returns{errs: make(chan *storiface.CallError, 1), proofs: make(chan storage.Proof, 1)}
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{AllowedMiners: []abi.ActorID{1000}}, ret)
+	defer cleanup()
+
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return &c2Exec{}, nil
+	}
+
+	own := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	foreign := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1001, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	_, err := w.SealCommit2(ctx, own, storage.Commit1Out("c1o"))
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+	<-ret.proofs
+
+	_, err = w.SealCommit2(ctx, foreign, storage.Commit1Out("c1o"))
+	require.NoError(t, err)
+	cerr := <-ret.errs
+	<-ret.proofs
+	require.NotNil(t, cerr)
+	require.Equal(t, storiface.ErrForeignSector, cerr.Code)
+	require.False(t, cerr.Retryable)
+
+	// storage of foreign sectors isn't touched by direct calls either
+	sealed := writeTestSectorFile(ctx, t, p, si, foreign.ID, storiface.FTSealed, 2048)
+	err = w.Remove(ctx, foreign.ID)
+	require.True(t, xerrors.Is(err, ErrForeignMiner), err)
+	require.FileExists(t, sealed)
+
+	_, _, err = w.ReadPieceToPipe(ctx, foreign, 0, 127)
+	require.True(t, xerrors.Is(err, ErrForeignMiner), err)
+}
+
+func TestAllowedMinersPieceCommP(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &commPReturns{
+		ci:     make(chan storiface.CallID, 1),
+		pieces: make(chan abi.PieceInfo, 1),
+		errs:   make(chan *storiface.CallError, 1),
+	}
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{AllowedMiners: []abi.ActorID{1000}}, ret)
+	defer cleanup()
+
+	// piece commitments aren't computed for a sector
+	_, err := w.GeneratePieceCommP(ctx, 127, bytes.NewReader(make([]byte, 127)))
+	require.NoError(t, err)
+	<-ret.ci
+	<-ret.pieces
+	require.Nil(t, <-ret.errs)
+}
@@ -0,0 +1,179 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+var ErrAmbiguousSectorState = errors.New("sector files don't match a sealing step")
+
+// number of SDR layers PreCommit1 writes into the cache, by sector size
+var sdrLayers = map[abi.SectorSize]int{
+	2 << 10:   2,
+	8 << 20:   2,
+	512 << 20: 2,
+	32 << 30:  11,
+	64 << 30:  11,
+}
+
+// NextTasks infers from the files of a sector which tasks can advance it, in
+// pipeline order. Which files exist is looked up in the index, caches are
+// inspected when they are in local paths of the worker:
+//
+//	no files                           AddPiece
+//	unsealed                           AddPiece, PreCommit1; only PreCommit1 when
+//	                                   pieces added through the worker fill the
+//	                                   sector, only AddPiece when they don't
+//	unsealed, cache                    PreCommit2, or PreCommit1 when the cache
+//	                                   doesn't have all SDR layers
+//	sealed, cache without p_aux        PreCommit2, which was interrupted
+//	sealed, cache with SDR layers      Commit1, Finalize, whether the sector was
+//	                                   committed isn't recorded in its files
+//	sealed, finalized cache            nothing
+//	update, update cache               ProveReplicaUpdate1
+//
+// Other combinations, e.g. a sealed replica without cache, return an
+// ErrAmbiguousSectorState error describing the state.
+func (l *LocalWorker) NextTasks(ctx context.Context, sector storage.SectorRef) ([]sealtasks.TaskType, error) {
+	if err := l.checkNamespace(sector.ID); err != nil {
+		return nil, err
+	}
+
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	var present storiface.SectorFileType
+	for _, fileType := range storiface.PathTypes {
+		found, err := l.sindex.StorageFindSector(ctx, sector.ID, fileType, 0, false)
+		if err != nil {
+			return nil, xerrors.Errorf("finding sector %d (%s): %w", sector.ID, fileType, err)
+		}
+		if len(found) > 0 {
+			present |= fileType
+		}
+	}
+
+	ambiguous := func(format string, args ...interface{}) error {
+		return xerrors.Errorf("sector %d (%s): %s: %w", sector.ID, present, xerrors.Errorf(format, args...), ErrAmbiguousSectorState)
+	}
+
+	has := func(types storiface.SectorFileType) bool {
+		return present&types == types
+	}
+
+	switch {
+	case has(storiface.FTUpdate | storiface.FTUpdateCache):
+		if !has(storiface.FTSealed) {
+			return nil, ambiguous("replica update without the sealed replica it updates")
+		}
+		return []sealtasks.TaskType{sealtasks.TTProveReplicaUpdate1}, nil
+	case has(storiface.FTUpdate), has(storiface.FTUpdateCache):
+		return nil, ambiguous("replica update without its cache, or the other way around")
+
+	case has(storiface.FTSealed | storiface.FTCache):
+		cache, err := l.inspectCache(ctx, sector.ID, ssize)
+		if err != nil {
+			return nil, err
+		}
+		if !cache.local {
+			return nil, ambiguous("the cache isn't in local paths of the worker, its contents can't be inspected")
+		}
+		switch {
+		case !cache.pAux:
+			return []sealtasks.TaskType{sealtasks.TTPreCommit2}, nil
+		case cache.layers > 0:
+			return []sealtasks.TaskType{sealtasks.TTCommit1, sealtasks.TTFinalize}, nil
+		default:
+			return nil, nil
+		}
+	case has(storiface.FTSealed):
+		return nil, ambiguous("sealed replica without cache, it can't be proven")
+
+	case has(storiface.FTUnsealed | storiface.FTCache):
+		cache, err := l.inspectCache(ctx, sector.ID, ssize)
+		if err != nil {
+			return nil, err
+		}
+		if !cache.local {
+			return nil, ambiguous("the cache isn't in local paths of the worker, its contents can't be inspected")
+		}
+		if cache.layers < sdrLayers[ssize] {
+			return []sealtasks.TaskType{sealtasks.TTPreCommit1}, nil
+		}
+		return []sealtasks.TaskType{sealtasks.TTPreCommit2}, nil
+	case has(storiface.FTCache):
+		return nil, ambiguous("cache without sealed or unsealed data")
+
+	case has(storiface.FTUnsealed):
+		added := l.pieces.list(sector.ID)
+		if len(added) == 0 {
+			return []sealtasks.TaskType{sealtasks.TTAddPiece, sealtasks.TTPreCommit1}, nil
+		}
+
+		var filled abi.PaddedPieceSize
+		for _, ap := range added {
+			filled += ap.Piece.Size
+		}
+		if filled < abi.PaddedPieceSize(ssize) {
+			return []sealtasks.TaskType{sealtasks.TTAddPiece}, nil
+		}
+		return []sealtasks.TaskType{sealtasks.TTPreCommit1}, nil
+
+	default:
+		return []sealtasks.TaskType{sealtasks.TTAddPiece}, nil
+	}
+}
+
+type cacheContents struct {
+	// local is false when the cache isn't in a local path
+	local bool
+
+	layers int
+	pAux   bool
+}
+
+// inspectCache lists the files of the sector's cache in a local path
+func (l *LocalWorker) inspectCache(ctx context.Context, sector abi.SectorID, ssize abi.SectorSize) (cacheContents, error) {
+	if _, ok := sdrLayers[ssize]; !ok {
+		return cacheContents{}, xerrors.Errorf("unknown cache layout of %s sectors: %w", ssize, ErrAmbiguousSectorState)
+	}
+
+	caches, err := l.localSectorPaths(ctx, sector, storiface.FTCache)
+	if err != nil {
+		return cacheContents{}, err
+	}
+	if len(caches) == 0 {
+		return cacheContents{}, nil
+	}
+
+	ents, err := ioutil.ReadDir(caches[0])
+	if err != nil {
+		return cacheContents{}, xerrors.Errorf("listing cache: %w", err)
+	}
+
+	out := cacheContents{local: true}
+	for _, ent := range ents {
+		name := ent.Name()
+		switch {
+		case name == "p_aux":
+			out.pAux = true
+		case strings.HasPrefix(name, "sc-02-data-layer-") && filepath.Ext(name) == ".dat":
+			out.layers++
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestNextTasks(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sectorRef := func(n abi.SectorNumber) storage.SectorRef {
+		return storage.SectorRef{
+			ID:        abi.SectorID{Miner: 1000, Number: n},
+			ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+		}
+	}
+	next := func(sector storage.SectorRef, expect ...sealtasks.TaskType) {
+		t.Helper()
+
+		tts, err := w.NextTasks(ctx, sector)
+		require.NoError(t, err)
+		require.Equal(t, expect, tts)
+	}
+	ambiguous := func(sector storage.SectorRef) {
+		t.Helper()
+
+		_, err := w.NextTasks(ctx, sector)
+		require.True(t, xerrors.Is(err, ErrAmbiguousSectorState), "%+v", err)
+	}
+
+	cacheFile := func(sector storage.SectorRef, name string) string {
+		return filepath.Join(p.LocalPath, storiface.FTCache.String(), storiface.SectorName(sector.ID), name)
+	}
+	addCache := func(sector storage.SectorRef, files ...string) {
+		require.NoError(t, os.Mkdir(cacheFile(sector, ""), 0755))
+		require.NoError(t, si.StorageDeclareSector(ctx, p.ID, sector.ID, storiface.FTCache, true))
+		for _, f := range files {
+			require.NoError(t, ioutil.WriteFile(cacheFile(sector, f), []byte(f), 0644))
+		}
+	}
+
+	sector := sectorRef(1)
+	next(sector, sealtasks.TTAddPiece)
+
+	writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTUnsealed, 2048)
+	next(sector, sealtasks.TTAddPiece, sealtasks.TTPreCommit1)
+
+	// PreCommit1 was interrupted
+	addCache(sector, "sc-02-data-layer-1.dat")
+	next(sector, sealtasks.TTPreCommit1)
+
+	require.NoError(t, ioutil.WriteFile(cacheFile(sector, "sc-02-data-layer-2.dat"), nil, 0644))
+	next(sector, sealtasks.TTPreCommit2)
+
+	// PreCommit2 was interrupted
+	writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTSealed, 2048)
+	next(sector, sealtasks.TTPreCommit2)
+
+	require.NoError(t, ioutil.WriteFile(cacheFile(sector, "p_aux"), nil, 0644))
+	next(sector, sealtasks.TTCommit1, sealtasks.TTFinalize)
+
+	// finalized
+	for _, f := range []string{"sc-02-data-layer-1.dat", "sc-02-data-layer-2.dat"} {
+		require.NoError(t, os.Remove(cacheFile(sector, f)))
+	}
+	next(sector)
+
+	writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTUpdate, 2048)
+	ambiguous(sector)
+	writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTUpdateCache, 1)
+	next(sector, sealtasks.TTProveReplicaUpdate1)
+
+	sealedOnly := sectorRef(2)
+	writeTestSectorFile(ctx, t, p, si, sealedOnly.ID, storiface.FTSealed, 2048)
+	ambiguous(sealedOnly)
+
+	cacheOnly := sectorRef(3)
+	addCache(cacheOnly, "p_aux")
+	ambiguous(cacheOnly)
+
+	// caches in other paths can't be inspected
+	remote := sectorRef(4)
+	writeTestSectorFile(ctx, t, p, si, remote.ID, storiface.FTSealed, 2048)
+	require.NoError(t, si.StorageAttach(ctx, stores.StorageInfo{ID: "remote", URLs: []string{"http://remote/remote"}, Weight: 1, CanStore: true}, fsutil.FsStat{}))
+	require.NoError(t, si.StorageDeclareSector(ctx, "remote", remote.ID, storiface.FTCache, true))
+	ambiguous(remote)
+}
@@ -0,0 +1,58 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+var ErrSectorOccupied = errors.New("sector already has unsealed data")
+
+// addFirstPiece runs add for the first piece of a sector, which would
+// overwrite unsealed data the sector already has, e.g. after a piece was
+// accidentally added again. Such sectors fail with a storiface.ErrOccupiedSector
+// error, unless WorkerConfig.AddPieceOverwrite is set, then their unsealed
+// data is removed first.
+//
+// When add fails, the unsealed data it wrote is removed, so that retrying the
+// call doesn't find the sector occupied. Data left behind by a worker which
+// crashed while adding the first piece can only be overwritten with
+// AddPieceOverwrite.
+func (l *LocalWorker) addFirstPiece(ctx context.Context, sector abi.SectorID, add func() (abi.PieceInfo, error)) (abi.PieceInfo, error) {
+	found, err := l.sindex.StorageFindSector(ctx, sector, storiface.FTUnsealed, 0, false)
+	if err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("finding unsealed data: %w", err)
+	}
+
+	// cleanups aren't interrupted by the call being cancelled
+	cctx := &wctx{
+		vals:    ctx,
+		closing: l.closing,
+	}
+
+	if len(found) > 0 {
+		if !l.addPieceOverwrite {
+			return abi.PieceInfo{}, storiface.Err(storiface.ErrOccupiedSector, xerrors.Errorf("adding the first piece to sector %d in %d paths: %w", sector, len(found), ErrSectorOccupied))
+		}
+
+		log.Warnw("overwriting unsealed data of sector with its first piece", "sector", sector, "paths", len(found))
+		if err := l.storage.Remove(cctx, sector, storiface.FTUnsealed, true); err != nil {
+			return abi.PieceInfo{}, xerrors.Errorf("removing unsealed data: %w", err)
+		}
+	}
+
+	pi, err := add()
+	if err != nil {
+		if rerr := l.storage.Remove(cctx, sector, storiface.FTUnsealed, true); rerr != nil {
+			log.Warnw("removing unsealed data of failed first piece", "sector", sector, "error", rerr)
+		}
+		return abi.PieceInfo{}, err
+	}
+
+	return pi, nil
+}
@@ -0,0 +1,85 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestAddPieceOccupiedSector(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &addPieceReturns{
+		pieces: make(chan abi.PieceInfo, 1),
+		errs:   make(chan *storiface.CallError, 1),
+	}
+
+	w, _, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	exec := &replicaExec{w: w}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 127)
+	addPiece := func(existing []abi.UnpaddedPieceSize) *storiface.CallError {
+		_, err := w.AddPiece(ctx, sector, existing, abi.UnpaddedPieceSize(len(data)), bytes.NewReader(data))
+		require.NoError(t, err)
+		<-ret.pieces
+		return <-ret.errs
+	}
+	unsealedCopies := func() int {
+		found, err := si.StorageFindSector(ctx, sector.ID, storiface.FTUnsealed, 0, false)
+		require.NoError(t, err)
+		return len(found)
+	}
+
+	require.Nil(t, addPiece(nil))
+	require.Nil(t, addPiece([]abi.UnpaddedPieceSize{abi.UnpaddedPieceSize(len(data))}))
+
+	// adding the first piece again
+	cerr := addPiece(nil)
+	require.NotNil(t, cerr)
+	require.Equal(t, storiface.ErrOccupiedSector, cerr.Code)
+	require.False(t, cerr.Retryable)
+	require.Contains(t, cerr.Message, ErrSectorOccupied.Error())
+	require.Equal(t, 1, unsealedCopies())
+
+	// failed first pieces don't leave the sector occupied
+	other := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 2},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	exec.failIn = storiface.FTUnsealed.String()
+	_, err := w.AddPiece(ctx, other, nil, abi.UnpaddedPieceSize(len(data)), bytes.NewReader(data))
+	require.NoError(t, err)
+	<-ret.pieces
+	require.NotNil(t, <-ret.errs)
+	found, err := si.StorageFindSector(ctx, other.ID, storiface.FTUnsealed, 0, false)
+	require.NoError(t, err)
+	require.Empty(t, found)
+	exec.failIn = ""
+
+	// overwriting replaces the existing data
+	w.addPieceOverwrite = true
+	require.Nil(t, addPiece(nil))
+	require.Equal(t, 1, unsealedCopies())
+
+	added := w.pieces.list(sector.ID)
+	require.Len(t, added, 1)
+	require.Equal(t, storiface.PaddedByteIndex(0), added[0].Offset)
+}
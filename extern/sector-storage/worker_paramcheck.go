@@ -0,0 +1,138 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/minio/blake2b-simd"
+	"golang.org/x/xerrors"
+)
+
+// ParamsCheckPolicy controls how CheckProofParams checks proof parameter
+// files used by task types the worker accepts, so that bad files, e.g. from
+// an interrupted download, fail the worker at startup instead of the first
+// task using them
+type ParamsCheckPolicy int
+
+const (
+	// ParamsCheckOff doesn't check parameter files
+	ParamsCheckOff ParamsCheckPolicy = iota
+
+	// ParamsCheckPresent checks that parameter files exist and aren't empty
+	ParamsCheckPresent
+
+	// ParamsCheckDigest also checks that parameter files match the digest in
+	// the manifest, which reads all of them
+	ParamsCheckDigest
+)
+
+var paramsCheckPolicies = map[string]ParamsCheckPolicy{
+	"off":     ParamsCheckOff,
+	"present": ParamsCheckPresent,
+	"digest":  ParamsCheckDigest,
+}
+
+// ParseParamsCheckPolicy parses a policy name: off, present or digest
+func ParseParamsCheckPolicy(s string) (ParamsCheckPolicy, error) {
+	p, ok := paramsCheckPolicies[s]
+	if !ok {
+		return 0, xerrors.Errorf("unknown params check policy %q, expected off, present or digest", s)
+	}
+	return p, nil
+}
+
+var ErrBadProofParams = errors.New("bad proof parameter files")
+
+// CheckProofParams checks parameter files listed in WorkerConfig.ParamsManifest
+// for the sector size in WorkerConfig.ParamsSectorSize, which task types the
+// worker accepts use, as WorkerConfig.ParamsCheck says. Missing files are
+// reported too. Without a sector size files of all sizes are checked, but
+// missing ones are skipped, SupportedProofs doesn't report their proof types.
+func (l *LocalWorker) CheckProofParams(ctx context.Context) error {
+	if l.paramsCheck == ParamsCheckOff {
+		return nil
+	}
+
+	tts, err := l.TaskTypes(ctx)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := l.paramsFiles()
+	if err != nil {
+		return err
+	}
+
+	dir := paramDir()
+
+	var bad []string
+	for file, info := range manifest {
+		if l.paramsSectorSize != 0 && info.SectorSize != uint64(l.paramsSectorSize) {
+			continue
+		}
+		if !usedByTasks(tts, file) {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, file)
+		st, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err) && l.paramsSectorSize == 0:
+			continue
+		case err != nil:
+			bad = append(bad, err.Error())
+			continue
+		case st.Size() == 0:
+			bad = append(bad, fmt.Sprintf("%s is empty", path))
+			continue
+		}
+
+		if l.paramsCheck != ParamsCheckDigest {
+			continue
+		}
+
+		log.Infow("checking proof parameter file digest", "file", path, "size", st.Size())
+
+		digest, err := paramDigest(path)
+		if err != nil {
+			bad = append(bad, err.Error())
+			continue
+		}
+		if digest != info.Digest {
+			bad = append(bad, fmt.Sprintf("%s has digest %s, expected %s", path, digest, info.Digest))
+		}
+	}
+
+	if len(bad) > 0 {
+		sort.Strings(bad)
+		return xerrors.Errorf("%w: %s", ErrBadProofParams, strings.Join(bad, "; "))
+	}
+	return nil
+}
+
+// paramDigest hashes a parameter file the way go-paramfetch does
+func paramDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() // nolint
+
+	h := blake2b.New512()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", xerrors.Errorf("reading %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
@@ -0,0 +1,97 @@
+package sectorstorage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+func TestCheckProofParams(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	oldDir, set := os.LookupEnv(paramDirEnv)
+	require.NoError(t, os.Setenv(paramDirEnv, dir))
+	defer func() {
+		if set {
+			_ = os.Setenv(paramDirEnv, oldDir)
+		} else {
+			_ = os.Unsetenv(paramDirEnv)
+		}
+	}()
+
+	const (
+		params  = "v28-stacked-proof-of-replication-2k.params"
+		vk      = "v28-stacked-proof-of-replication-2k.vk"
+		params8 = "v28-stacked-proof-of-replication-8m.params"
+	)
+
+	write := func(file, content string) string {
+		p := filepath.Join(dir, file)
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+		digest, err := paramDigest(p)
+		require.NoError(t, err)
+		return digest
+	}
+
+	paramsDigest := write(params, "params")
+	vkDigest := write(vk, "vk")
+
+	manifest := []byte(fmt.Sprintf(`{
+  "%s": {"digest": "%s", "sector_size": 2048},
+  "%s": {"digest": "%s", "sector_size": 2048},
+  "%s": {"digest": "00", "sector_size": 8388608}
+}`, params, paramsDigest, vk, vkDigest, params8))
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		TaskTypes:        []sealtasks.TaskType{sealtasks.TTCommit2},
+		ParamsManifest:   manifest,
+		ParamsCheck:      ParamsCheckDigest,
+		ParamsSectorSize: 2048,
+	}, nil)
+	defer cleanup()
+
+	require.NoError(t, w.CheckProofParams(ctx))
+
+	// truncated download
+	write(params, "par")
+	err := w.CheckProofParams(ctx)
+	require.True(t, xerrors.Is(err, ErrBadProofParams))
+	require.Contains(t, err.Error(), params)
+
+	// only digests catch it
+	w.paramsCheck = ParamsCheckPresent
+	require.NoError(t, w.CheckProofParams(ctx))
+
+	write(params, "")
+	require.True(t, xerrors.Is(w.CheckProofParams(ctx), ErrBadProofParams))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, params)))
+	require.True(t, xerrors.Is(w.CheckProofParams(ctx), ErrBadProofParams))
+
+	// without a sector size, missing files are skipped
+	w.paramsSectorSize = 0
+	require.NoError(t, w.CheckProofParams(ctx))
+
+	write(params8, "bad")
+	w.paramsCheck = ParamsCheckDigest
+	err = w.CheckProofParams(ctx)
+	require.True(t, xerrors.Is(err, ErrBadProofParams))
+	require.Contains(t, err.Error(), params8)
+
+	// params of tasks the worker doesn't accept aren't checked
+	require.NoError(t, w.TaskDisable(ctx, sealtasks.TTCommit2))
+	require.NoError(t, w.CheckProofParams(ctx))
+
+	w.paramsCheck = ParamsCheckOff
+	require.NoError(t, w.TaskEnable(ctx, sealtasks.TTCommit2))
+	require.NoError(t, w.CheckProofParams(ctx))
+}
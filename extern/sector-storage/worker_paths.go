@@ -0,0 +1,303 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// OfflinePathPolicy controls what happens to calls using a storage path which
+// went offline, e.g. because the disk got unmounted
+type OfflinePathPolicy int
+
+const (
+	// OfflinePathFail fails calls using offline paths right away
+	OfflinePathFail OfflinePathPolicy = iota
+
+	// OfflinePathWait makes calls wait for offline paths to come back, up to
+	// WorkerConfig.OfflinePathTimeout
+	OfflinePathWait
+)
+
+var ErrPathOffline = errors.New("storage path offline")
+
+var offlinePathPollInterval = time.Second
+
+// offlinePaths returns local storage paths which can't be accessed, with the
+// reason. A path is considered offline when its metadata file can't be read,
+// which is also the case when the filesystem holding it is unmounted.
+func (l *LocalWorker) offlinePaths(ctx context.Context) (map[stores.ID]error, error) {
+	if l.localStore == nil {
+		return nil, nil
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	out := map[stores.ID]error{}
+	for _, p := range paths {
+		if _, err := os.Stat(filepath.Join(p.LocalPath, stores.MetaFile)); err != nil {
+			out[p.ID] = xerrors.Errorf("%s (%s): %w", p.ID, p.LocalPath, err)
+		}
+	}
+
+	return out, nil
+}
+
+// usedPathsOnline returns an ErrPathOffline error when one of the storage
+// paths used by a call is offline
+func (l *LocalWorker) usedPathsOnline(ctx context.Context, types storiface.SectorFileType, storageIDs storiface.SectorPaths) error {
+	offline, err := l.offlinePaths(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, fileType := range storiface.PathTypes {
+		if fileType&types == 0 {
+			continue
+		}
+
+		if oerr, ok := offline[stores.ID(storiface.PathByType(storageIDs, fileType))]; ok {
+			return xerrors.Errorf("%w: %s", ErrPathOffline, oerr)
+		}
+	}
+
+	return nil
+}
+
+// usedPathsAvailable returns a StorageMissingError when storage used by a call
+// was removed from the sector index, or an ErrPathOffline error when one of
+// its paths is offline
+func (l *LocalWorker) usedPathsAvailable(ctx context.Context, sector abi.SectorID, types storiface.SectorFileType, storageIDs storiface.SectorPaths) error {
+	if err := l.missingStorage(ctx, sector, types, storageIDs); err != nil {
+		return err
+	}
+
+	return l.usedPathsOnline(ctx, types, storageIDs)
+}
+
+func isPathUnavailable(err error) bool {
+	return xerrors.Is(err, ErrPathOffline) || xerrors.Is(err, ErrStorageMissing)
+}
+
+// checkPathsOnline applies the offline path policy to storage paths used by a
+// call. Storage removed from the index is handled like offline paths, so that
+// with OfflinePathWait calls wait for it to be attached again.
+func (l *LocalWorker) checkPathsOnline(ctx context.Context, sector abi.SectorID, types storiface.SectorFileType, storageIDs storiface.SectorPaths) error {
+	var deadline <-chan time.Time
+	if l.offlinePolicy == OfflinePathWait && l.offlineTimeout > 0 {
+		timer := time.NewTimer(l.offlineTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		err := l.usedPathsAvailable(ctx, sector, types, storageIDs)
+		if err == nil || !isPathUnavailable(err) || l.offlinePolicy != OfflinePathWait {
+			return err
+		}
+
+		log.Warnw("storage path offline, waiting for it to come back", "error", err)
+
+		select {
+		case <-time.After(offlinePathPollInterval):
+		case <-deadline:
+			return xerrors.Errorf("timed out waiting: %w", err)
+		case <-ctx.Done():
+			return xerrors.Errorf("waiting for offline storage path (%s): %w", err, ctx.Err())
+		}
+	}
+}
+
+// watchPathsOnline keeps checking storage paths used by a call while it holds
+// the sector files, and aborts the call when one of them goes offline or is
+// removed from the index. With OfflinePathWait the call is only aborted when
+// the path doesn't come back within WorkerConfig.OfflinePathTimeout. The
+// returned func stops watching.
+func (l *LocalWorker) watchPathsOnline(ctx context.Context, sector abi.SectorID, types storiface.SectorFileType, storageIDs storiface.SectorPaths) func() {
+	if l.localStore == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(offlinePathPollInterval)
+		defer ticker.Stop()
+
+		var offlineSince time.Time
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+
+			err := l.usedPathsAvailable(ctx, sector, types, storageIDs)
+			if err == nil {
+				offlineSince = time.Time{}
+				continue
+			}
+			if !isPathUnavailable(err) {
+				log.Warnw("checking storage paths", "error", err)
+				continue
+			}
+
+			if l.offlinePolicy == OfflinePathWait {
+				if offlineSince.IsZero() {
+					offlineSince = time.Now()
+					log.Warnw("storage path unavailable during call, waiting for it to come back", "error", err)
+				}
+
+				if l.offlineTimeout <= 0 || time.Since(offlineSince) < l.offlineTimeout {
+					continue
+				}
+
+				err = xerrors.Errorf("timed out waiting: %w", err)
+			}
+
+			abortCall(ctx, xerrors.Errorf("storage path unavailable during call: %w", err))
+			return
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+		})
+	}
+}
+
+// Healthy returns an error when the worker can't run tasks, or some of its
+// storage paths are offline or unreachable (see CheckStorage)
+func (l *LocalWorker) Healthy(ctx context.Context) error {
+	if _, err := l.sb(); err != nil {
+		return err
+	}
+
+	if err := l.pathsOnline(ctx); err != nil {
+		return err
+	}
+
+	return l.storageReachable(ctx)
+}
+
+// pathsOnline returns an ErrPathOffline error when some local storage paths are
+// offline
+func (l *LocalWorker) pathsOnline(ctx context.Context) error {
+	offline, err := l.offlinePaths(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(offline) > 0 {
+		reasons := make([]string, 0, len(offline))
+		for _, err := range offline {
+			reasons = append(reasons, err.Error())
+		}
+		sort.Strings(reasons)
+
+		return xerrors.Errorf("%w: %s", ErrPathOffline, strings.Join(reasons, "; "))
+	}
+
+	return nil
+}
+
+// how long Paths results are reused, unless paths are opened in the meantime
+var pathsCacheTTL = 5 * time.Second
+
+// pathsCache keeps the local path list returned by Paths, which is polled by
+// the scheduler
+type pathsCache struct {
+	lk sync.Mutex
+
+	paths   []stores.StoragePath
+	gen     uint64
+	expires time.Time
+}
+
+// get returns a copy of the cached path list, refreshing it when it expired or
+// paths were opened since it was cached
+func (c *pathsCache) get(ctx context.Context, ls *stores.Local) ([]stores.StoragePath, error) {
+	gen := ls.PathsGeneration()
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.paths == nil || c.gen != gen || !time.Now().Before(c.expires) {
+		paths, err := ls.Local(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.paths = paths
+		c.gen = gen
+		c.expires = time.Now().Add(pathsCacheTTL)
+	}
+
+	return append([]stores.StoragePath{}, c.paths...), nil
+}
+
+// localSectorPaths returns paths of the sector file in local storage paths
+func (l *LocalWorker) localSectorPaths(ctx context.Context, sector abi.SectorID, fileType storiface.SectorFileType) ([]string, error) {
+	if l.localStore == nil {
+		return nil, nil
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	local := map[string]string{}
+	for _, p := range paths {
+		if p.LocalPath != "" {
+			local[string(p.ID)] = p.LocalPath
+		}
+	}
+
+	found, err := l.sindex.StorageFindSector(ctx, sector, fileType, 0, false)
+	if err != nil {
+		return nil, xerrors.Errorf("finding sector %d (%s): %w", sector, fileType, err)
+	}
+
+	var out []string
+	for _, info := range found {
+		if lp, ok := local[string(info.ID)]; ok {
+			out = append(out, filepath.Join(lp, fileType.String(), storiface.SectorName(sector)))
+		}
+	}
+	return out, nil
+}
+
+// SetPathWeight changes the weight of a local storage path, e.g. to keep new
+// sector files off a failing disk without restarting the worker. Files which
+// were already allocated stay where they are.
+func (l *LocalWorker) SetPathWeight(ctx context.Context, id stores.ID, weight uint64) error {
+	if l.localStore == nil {
+		return xerrors.Errorf("worker doesn't use local storage paths")
+	}
+
+	if err := l.localStore.SetWeight(ctx, id, weight); err != nil {
+		return xerrors.Errorf("setting weight of path %s: %w", id, err)
+	}
+
+	log.Infow("changed storage path weight", "path", id, "weight", weight)
+	return nil
+}
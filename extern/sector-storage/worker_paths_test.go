@@ -0,0 +1,220 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestOfflinePath(t *testing.T) {
+	ctx := context.Background()
+
+	oldInterval := offlinePathPollInterval
+	offlinePathPollInterval = 10 * time.Millisecond
+	defer func() {
+		offlinePathPollInterval = oldInterval
+	}()
+
+	w, p, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	require.NoError(t, w.Healthy(ctx))
+
+	meta := filepath.Join(p.LocalPath, stores.MetaFile)
+	mb, err := ioutil.ReadFile(meta)
+	require.NoError(t, err)
+
+	// simulate the disk getting unmounted
+	require.NoError(t, os.Remove(meta))
+
+	require.True(t, xerrors.Is(w.Healthy(ctx), ErrPathOffline))
+
+	info, err := w.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{string(p.ID)}, info.OfflinePaths)
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	pp := &localWorkerPathProvider{w: w, op: storiface.AcquireMove}
+
+	_, _, err = pp.AcquireSector(ctx, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing)
+	require.True(t, xerrors.Is(err, ErrPathOffline))
+
+	w.offlinePolicy = OfflinePathWait
+	w.offlineTimeout = 20 * time.Millisecond
+
+	_, _, err = pp.AcquireSector(ctx, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing)
+	require.True(t, xerrors.Is(err, ErrPathOffline))
+
+	w.offlineTimeout = 0
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = ioutil.WriteFile(meta, mb, 0644)
+	}()
+
+	_, done, err := pp.AcquireSector(ctx, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing)
+	require.NoError(t, err)
+	done()
+
+	require.NoError(t, w.Healthy(ctx))
+}
+
+func TestOfflinePathDuringCall(t *testing.T) {
+	ctx := context.Background()
+
+	oldInterval := offlinePathPollInterval
+	offlinePathPollInterval = 10 * time.Millisecond
+	defer func() {
+		offlinePathPollInterval = oldInterval
+	}()
+
+	w, p, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	meta := filepath.Join(p.LocalPath, stores.MetaFile)
+	mb, err := ioutil.ReadFile(meta)
+	require.NoError(t, err)
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	pp := &localWorkerPathProvider{w: w, op: storiface.AcquireMove}
+
+	acquire := func() (context.Context, func()) {
+		cctx, cancel := w.callContext(ctx, storiface.CallID{Sector: sector.ID, ID: uuid.New()})
+		_, done, err := pp.AcquireSector(cctx, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing)
+		require.NoError(t, err)
+
+		return cctx, func() {
+			done()
+			cancel()
+		}
+	}
+
+	// the call is aborted when the path goes offline while it holds the sector
+	cctx, done := acquire()
+	require.NoError(t, os.Remove(meta))
+
+	select {
+	case <-cctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("call not aborted")
+	}
+	require.True(t, xerrors.Is(callAbortCause(cctx), ErrPathOffline))
+	done()
+	require.NoError(t, ioutil.WriteFile(meta, mb, 0644))
+
+	// with the wait policy, paths can come back within the timeout
+	w.offlinePolicy = OfflinePathWait
+	w.offlineTimeout = time.Second
+
+	cctx, done = acquire()
+	require.NoError(t, os.Remove(meta))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, ioutil.WriteFile(meta, mb, 0644))
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, cctx.Err())
+	require.NoError(t, callAbortCause(cctx))
+	done()
+}
+
+func TestPathsCache(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	paths, err := w.Paths(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	require.Equal(t, uint64(1), paths[0].Weight)
+
+	// callers get copies
+	paths[0].LocalPath = "changed"
+	paths, err = w.Paths(ctx)
+	require.NoError(t, err)
+	require.Equal(t, p.LocalPath, paths[0].LocalPath)
+
+	// cached paths are used until they expire
+	w.pathsCache.lk.Lock()
+	w.pathsCache.paths = []stores.StoragePath{{ID: "cached"}}
+	w.pathsCache.lk.Unlock()
+
+	paths, err = w.Paths(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []stores.StoragePath{{ID: "cached"}}, paths)
+
+	w.pathsCache.lk.Lock()
+	w.pathsCache.expires = time.Now()
+	w.pathsCache.lk.Unlock()
+
+	paths, err = w.Paths(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	require.Equal(t, p.ID, paths[0].ID)
+
+	// and right away when paths are opened
+	openSealingPath(ctx, t, w)
+
+	paths, err = w.Paths(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 2)
+}
+
+func TestSetPathWeight(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	_, destID := openEvacuationDest(ctx, t, w)
+
+	bestAlloc := func() stores.ID {
+		best, err := si.StorageBestAlloc(ctx, storiface.FTSealed, 2048, storiface.PathStorage)
+		require.NoError(t, err)
+		require.Len(t, best, 2)
+		return best[0].ID
+	}
+
+	require.NoError(t, w.SetPathWeight(ctx, p.ID, 100))
+	require.Equal(t, p.ID, bestAlloc())
+
+	require.NoError(t, w.SetPathWeight(ctx, p.ID, 0))
+	require.Equal(t, destID, bestAlloc())
+
+	paths, err := w.Paths(ctx)
+	require.NoError(t, err)
+	for _, sp := range paths {
+		if sp.ID == p.ID {
+			require.Equal(t, uint64(0), sp.Weight)
+		}
+	}
+
+	// the weight is kept when the path is declared again
+	require.NoError(t, w.localStore.Redeclare(ctx))
+	require.Equal(t, destID, bestAlloc())
+
+	mb, err := ioutil.ReadFile(filepath.Join(p.LocalPath, stores.MetaFile))
+	require.NoError(t, err)
+	require.Contains(t, string(mb), `"Weight": 0`)
+	require.NoFileExists(t, filepath.Join(p.LocalPath, stores.MetaFile+".tmp"))
+
+	require.Error(t, w.SetPathWeight(ctx, "missing", 1))
+}
@@ -0,0 +1,90 @@
+package sectorstorage
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// pausedSectors are sectors which the worker doesn't start new calls for
+type pausedSectors struct {
+	lk      sync.Mutex
+	sectors map[abi.SectorID]struct{}
+}
+
+func (p *pausedSectors) set(sector abi.SectorID, paused bool) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	if !paused {
+		delete(p.sectors, sector)
+		return
+	}
+
+	if p.sectors == nil {
+		p.sectors = map[abi.SectorID]struct{}{}
+	}
+	p.sectors[sector] = struct{}{}
+}
+
+func (p *pausedSectors) paused(sector abi.SectorID) bool {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	_, ok := p.sectors[sector]
+	return ok
+}
+
+func (p *pausedSectors) list() []abi.SectorID {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	out := make([]abi.SectorID, 0, len(p.sectors))
+	for sector := range p.sectors {
+		out = append(out, sector)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Miner != out[j].Miner {
+			return out[i].Miner < out[j].Miner
+		}
+		return out[i].Number < out[j].Number
+	})
+	return out
+}
+
+// PauseSector stops the worker from starting new calls for the sector, e.g.
+// while it's being investigated. Calls dispatched for the sector are rejected
+// with storiface.ErrTempSectorPaused, so that the manager retries them later.
+// Calls already started keep running. Pauses don't survive worker restarts.
+func (l *LocalWorker) PauseSector(ctx context.Context, sector abi.SectorID) error {
+	l.paused.set(sector, true)
+	log.Infow("paused sector", "sector", sector)
+	return nil
+}
+
+// ResumeSector lets the worker start calls for a sector paused by PauseSector
+// again
+func (l *LocalWorker) ResumeSector(ctx context.Context, sector abi.SectorID) error {
+	l.paused.set(sector, false)
+	log.Infow("resumed sector", "sector", sector)
+	return nil
+}
+
+// PausedSectors returns sectors paused by PauseSector
+func (l *LocalWorker) PausedSectors(ctx context.Context) ([]abi.SectorID, error) {
+	return l.paused.list(), nil
+}
+
+// checkPaused returns storiface.ErrTempSectorPaused when the sector is paused
+func (l *LocalWorker) checkPaused(sector abi.SectorID, rt ReturnType) error {
+	if l.paused.paused(sector) {
+		return storiface.Err(storiface.ErrTempSectorPaused, xerrors.Errorf("not starting %s, sector %d is paused", rt, sector))
+	}
+	return nil
+}
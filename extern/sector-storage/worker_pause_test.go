@@ -0,0 +1,53 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestPauseSector(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &sectorLimitReturns{errs: make(chan *storiface.CallError, 1)}
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	paused := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 2}}
+	other := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 1}}
+
+	require.NoError(t, w.PauseSector(ctx, paused.ID))
+	require.NoError(t, w.PauseSector(ctx, abi.SectorID{Miner: 1000, Number: 3}))
+
+	list, err := w.PausedSectors(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []abi.SectorID{paused.ID, {Miner: 1000, Number: 3}}, list)
+
+	_, err = w.Fetch(ctx, paused, storiface.FTNone, storiface.PathSealing, storiface.AcquireCopy)
+	require.NoError(t, err)
+	cerr := <-ret.errs
+	require.NotNil(t, cerr)
+	require.Equal(t, storiface.ErrTempSectorPaused, cerr.Code)
+	require.True(t, cerr.Retryable)
+
+	// other sectors proceed
+	_, err = w.Fetch(ctx, other, storiface.FTNone, storiface.PathSealing, storiface.AcquireCopy)
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+
+	require.NoError(t, w.ResumeSector(ctx, paused.ID))
+
+	_, err = w.Fetch(ctx, paused, storiface.FTNone, storiface.PathSealing, storiface.AcquireCopy)
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+
+	list, err = w.PausedSectors(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []abi.SectorID{{Miner: 1000, Number: 3}}, list)
+}
@@ -0,0 +1,138 @@
+package sectorstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// PreCommit2ResultFile [cache]/pc2-result.json
+const PreCommit2ResultFile = "pc2-result.json"
+
+// preCommit2Result is the result of PreCommit2 persisted in the sector cache,
+// so that a worker asked to redo PreCommit2, e.g. because it restarted before
+// returning the result, doesn't have to recompute it. The proofs backend
+// doesn't expose intermediate PreCommit2 state, so only finished runs are
+// persisted.
+//
+// The result is only valid for the same PreCommit1 output, which covers the
+// ticket, and proof type, and while the sealed replica wasn't changed.
+type preCommit2Result struct {
+	ProofType abi.RegisteredSealProof
+	Phase1Sum []byte
+
+	SealedSize    int64
+	SealedModTime time.Time
+
+	Sealed   cid.Cid
+	Unsealed cid.Cid
+}
+
+// preCommit2Paths returns local paths of the sealed replica and cache of the
+// sector, empty when the sector isn't in local storage
+func (l *LocalWorker) preCommit2Paths(ctx context.Context, sector storage.SectorRef) (storiface.SectorPaths, error) {
+	if l.localStore == nil {
+		return storiface.SectorPaths{}, nil
+	}
+
+	paths, _, err := l.localStore.AcquireSector(ctx, sector, storiface.FTSealed|storiface.FTCache, storiface.FTNone, storiface.PathSealing, storiface.AcquireMove)
+	if err != nil {
+		return storiface.SectorPaths{}, xerrors.Errorf("finding local sector files: %w", err)
+	}
+
+	if paths.Sealed == "" || paths.Cache == "" {
+		return storiface.SectorPaths{}, nil
+	}
+
+	return paths, nil
+}
+
+// loadPreCommit2Result returns the persisted PreCommit2 result, if it's valid
+// for the PreCommit1 output
+func (l *LocalWorker) loadPreCommit2Result(ctx context.Context, sector storage.SectorRef, phase1Out storage.PreCommit1Out) (storage.SectorCids, bool) {
+	paths, err := l.preCommit2Paths(ctx, sector)
+	if err != nil || paths.Cache == "" {
+		return storage.SectorCids{}, false
+	}
+
+	rb, err := ioutil.ReadFile(filepath.Join(paths.Cache, PreCommit2ResultFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnw("reading persisted PreCommit2 result", "sector", sector.ID, "error", err)
+		}
+		return storage.SectorCids{}, false
+	}
+
+	var res preCommit2Result
+	if err := json.Unmarshal(rb, &res); err != nil {
+		log.Warnw("parsing persisted PreCommit2 result", "sector", sector.ID, "error", err)
+		return storage.SectorCids{}, false
+	}
+
+	st, err := os.Stat(paths.Sealed)
+	if err != nil {
+		return storage.SectorCids{}, false
+	}
+
+	sum := sha256.Sum256(phase1Out)
+	switch {
+	case res.ProofType != sector.ProofType:
+		log.Warnw("not using persisted PreCommit2 result, proof type doesn't match", "sector", sector.ID, "expected", sector.ProofType, "persisted", res.ProofType)
+	case string(res.Phase1Sum) != string(sum[:]):
+		log.Warnw("not using persisted PreCommit2 result, PreCommit1 output doesn't match", "sector", sector.ID)
+	case res.SealedSize != st.Size() || !res.SealedModTime.Equal(st.ModTime()):
+		log.Warnw("not using persisted PreCommit2 result, sealed replica was changed", "sector", sector.ID)
+	default:
+		return storage.SectorCids{Sealed: res.Sealed, Unsealed: res.Unsealed}, true
+	}
+
+	return storage.SectorCids{}, false
+}
+
+// savePreCommit2Result persists the result of PreCommit2 in the sector cache
+func (l *LocalWorker) savePreCommit2Result(ctx context.Context, sector storage.SectorRef, phase1Out storage.PreCommit1Out, cids storage.SectorCids) error {
+	paths, err := l.preCommit2Paths(ctx, sector)
+	if err != nil {
+		return err
+	}
+	if paths.Cache == "" {
+		return nil
+	}
+
+	st, err := os.Stat(paths.Sealed)
+	if err != nil {
+		return xerrors.Errorf("stat sealed replica: %w", err)
+	}
+
+	sum := sha256.Sum256(phase1Out)
+	rb, err := json.Marshal(&preCommit2Result{
+		ProofType:     sector.ProofType,
+		Phase1Sum:     sum[:],
+		SealedSize:    st.Size(),
+		SealedModTime: st.ModTime(),
+		Sealed:        cids.Sealed,
+		Unsealed:      cids.Unsealed,
+	})
+	if err != nil {
+		return xerrors.Errorf("marshalling PreCommit2 result: %w", err)
+	}
+
+	file := filepath.Join(paths.Cache, PreCommit2ResultFile)
+	if err := ioutil.WriteFile(file+".tmp", rb, 0644); err != nil {
+		return xerrors.Errorf("writing PreCommit2 result: %w", err)
+	}
+
+	return os.Rename(file+".tmp", file)
+}
@@ -0,0 +1,105 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+type pc2Exec struct {
+	testExec
+
+	calls int
+	cids  storage.SectorCids
+}
+
+func (e *pc2Exec) SealPreCommit2(ctx context.Context, sector storage.SectorRef, pc1o storage.PreCommit1Out) (storage.SectorCids, error) {
+	e.calls++
+	return e.cids, nil
+}
+
+type pc2Returns struct {
+	storiface.WorkerReturn
+
+	errs chan *storiface.CallError
+	cids chan storage.SectorCids
+}
+
+func (r *pc2Returns) ReturnSealPreCommit2(ctx context.Context, callID storiface.CallID, sealed storage.SectorCids, err *storiface.CallError) error {
+	r.errs <- err
+	r.cids <- sealed
+	return nil
+}
+
+func TestPreCommit2Result(t *testing.T) {
+	ctx := context.Background()
+
+	sealedCID, err := commcid.ReplicaCommitmentV1ToCID(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	unsealedCID, err := commcid.DataCommitmentV1ToCID(bytes.Repeat([]byte{2}, 32))
+	require.NoError(t, err)
+
+	ret := &pc2Returns{errs: make(chan *storiface.CallError, 1), cids: make(chan storage.SectorCids, 1)}
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	exec := &pc2Exec{cids: storage.SectorCids{Sealed: sealedCID, Unsealed: unsealedCID}}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	sealedPath := writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTSealed, 2048)
+	cachePath := filepath.Join(p.LocalPath, storiface.FTCache.String(), storiface.SectorName(sector.ID))
+	require.NoError(t, os.Mkdir(cachePath, 0755))
+	require.NoError(t, si.StorageDeclareSector(ctx, p.ID, sector.ID, storiface.FTCache, true))
+
+	pc2 := func(sector storage.SectorRef, pc1o storage.PreCommit1Out) {
+		_, err := w.SealPreCommit2(ctx, sector, pc1o)
+		require.NoError(t, err)
+		require.Nil(t, <-ret.errs)
+		require.Equal(t, exec.cids, <-ret.cids)
+	}
+
+	pc1o := storage.PreCommit1Out(`{"registered_proof":"StackedDrg2KiBV1"}`)
+
+	pc2(sector, pc1o)
+	require.Equal(t, 1, exec.calls)
+	require.FileExists(t, filepath.Join(cachePath, PreCommit2ResultFile))
+
+	// redoing PreCommit2 uses the persisted result
+	pc2(sector, pc1o)
+	require.Equal(t, 1, exec.calls)
+
+	// the result is only used for the same PreCommit1 output and proof type
+	pc2(sector, storage.PreCommit1Out(`{"registered_proof":"StackedDrg2KiBV1","other":true}`))
+	require.Equal(t, 2, exec.calls)
+
+	pc2(storage.SectorRef{ID: sector.ID, ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1_1}, pc1o)
+	require.Equal(t, 3, exec.calls)
+
+	pc2(sector, pc1o)
+	require.Equal(t, 4, exec.calls)
+	pc2(sector, pc1o)
+	require.Equal(t, 4, exec.calls)
+
+	// and while the sealed replica wasn't changed
+	require.NoError(t, ioutil.WriteFile(sealedPath, make([]byte, 1024), 0644))
+	pc2(sector, pc1o)
+	require.Equal(t, 5, exec.calls)
+}
@@ -0,0 +1,124 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// pieceProofTypes are seal proof types used for computing piece commitments,
+// ordered by sector size
+var pieceProofTypes = []abi.RegisteredSealProof{
+	abi.RegisteredSealProof_StackedDrg2KiBV1,
+	abi.RegisteredSealProof_StackedDrg8MiBV1,
+	abi.RegisteredSealProof_StackedDrg512MiBV1,
+	abi.RegisteredSealProof_StackedDrg32GiBV1,
+	abi.RegisteredSealProof_StackedDrg64GiBV1,
+}
+
+// pieceProofType returns the smallest seal proof type fitting a piece
+func pieceProofType(size abi.PaddedPieceSize) (abi.RegisteredSealProof, error) {
+	for _, spt := range pieceProofTypes {
+		ssize, err := spt.SectorSize()
+		if err != nil {
+			return 0, err
+		}
+
+		if abi.PaddedPieceSize(ssize) >= size {
+			return spt, nil
+		}
+	}
+
+	return 0, xerrors.Errorf("piece size %d is larger than the largest sector size", size)
+}
+
+// GeneratePieceCommP computes the piece commitment (CommP) of size bytes of
+// unpadded data read from pieceData, independent of any sector. The result is
+// returned with ReturnGeneratePieceCommP, the computation takes a slot in the
+// worker call queue, same as sealing tasks.
+func (l *LocalWorker) GeneratePieceCommP(ctx context.Context, size abi.UnpaddedPieceSize, pieceData storage.Data) (storiface.CallID, error) {
+	if err := size.Validate(); err != nil {
+		return storiface.UndefCall, xerrors.Errorf("invalid piece size: %w", err)
+	}
+
+	spt, err := pieceProofType(size.Padded())
+	if err != nil {
+		return storiface.UndefCall, err
+	}
+
+	return l.asyncCall(ctx, storage.SectorRef{ProofType: spt}, GeneratePieceCommP, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		c, err := ffiwrapper.GeneratePieceCIDFromFile(spt, pieceData, size)
+		if err != nil {
+			return abi.PieceInfo{}, xerrors.Errorf("generating piece commitment: %w", err)
+		}
+
+		return abi.PieceInfo{
+			Size:     size.Padded(),
+			PieceCID: c,
+		}, nil
+	})
+}
+
+// checkAddedPiece reads a piece added with AddPiece back from the unsealed
+// sector file, and checks that its commitment matches the one AddPiece
+// returned, to catch data corrupted while being written
+func (l *LocalWorker) checkAddedPiece(ctx context.Context, sb ffiwrapper.Storage, sector storage.SectorRef, existingPieceSizes []abi.UnpaddedPieceSize, pi abi.PieceInfo) error {
+	var offset abi.PaddedPieceSize
+	for _, size := range existingPieceSizes {
+		offset += size.Padded()
+	}
+
+	// pieces are aligned to their size in the sector
+	_, padding := ffiwrapper.GetRequiredPadding(offset, pi.Size)
+	offset += padding
+
+	c, err := l.unsealedPieceCID(ctx, sb, sector, offset, pi.Size)
+	if err != nil {
+		return xerrors.Errorf("computing commitment of the written piece: %w", err)
+	}
+
+	if !c.Equals(pi.PieceCID) {
+		return xerrors.Errorf("commitment of the written piece %s doesn't match %s returned by AddPiece", c, pi.PieceCID)
+	}
+
+	return nil
+}
+
+var errNoUnsealedPiece = errors.New("unsealed piece data not found")
+
+// unsealedPieceCID computes the commitment of a piece from its data in the
+// unsealed sector file, at the given offset in the sector
+func (l *LocalWorker) unsealedPieceCID(ctx context.Context, sb ffiwrapper.Storage, sector storage.SectorRef, offset, size abi.PaddedPieceSize) (cid.Cid, error) {
+	readErr := make(chan error, 1)
+
+	pr, pw := io.Pipe()
+	go func() {
+		ok, err := l.readPiece(ctx, sb, pw, sector, storiface.UnpaddedByteIndex(offset.Unpadded()), size.Unpadded())
+		if err == nil && !ok {
+			err = errNoUnsealedPiece
+		}
+		readErr <- err
+
+		_ = pw.CloseWithError(err) // nil closes with io.EOF
+	}()
+
+	c, err := ffiwrapper.GeneratePieceCIDFromFile(sector.ProofType, pr, size.Unpadded())
+	_ = pr.Close()
+	if rerr := <-readErr; rerr == errNoUnsealedPiece {
+		return cid.Undef, rerr
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,167 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+type commPReturns struct {
+	storiface.WorkerReturn
+
+	ci     chan storiface.CallID
+	pieces chan abi.PieceInfo
+	errs   chan *storiface.CallError
+}
+
+func (r *commPReturns) ReturnGeneratePieceCommP(ctx context.Context, callID storiface.CallID, pi abi.PieceInfo, err *storiface.CallError) error {
+	r.ci <- callID
+	r.pieces <- pi
+	r.errs <- err
+	return nil
+}
+
+func TestGeneratePieceCommP(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &commPReturns{
+		ci:     make(chan storiface.CallID, 1),
+		pieces: make(chan abi.PieceInfo, 1),
+		errs:   make(chan *storiface.CallError, 1),
+	}
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	data := make([]byte, 127)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	expect, err := ffiwrapper.GeneratePieceCIDFromFile(abi.RegisteredSealProof_StackedDrg2KiBV1, bytes.NewReader(data), 127)
+	require.NoError(t, err)
+
+	ci, err := w.GeneratePieceCommP(ctx, 127, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, ci, <-ret.ci)
+	require.Equal(t, abi.PieceInfo{Size: 128, PieceCID: expect}, <-ret.pieces)
+	require.Nil(t, <-ret.errs)
+
+	_, err = w.GeneratePieceCommP(ctx, 100, bytes.NewReader(data))
+	require.Error(t, err)
+
+	spt, err := pieceProofType(abi.PaddedPieceSize(32 << 30))
+	require.NoError(t, err)
+	require.Equal(t, abi.RegisteredSealProof_StackedDrg32GiBV1, spt)
+
+	_, err = pieceProofType(abi.PaddedPieceSize(128 << 30))
+	require.Error(t, err)
+}
+
+// addPieceExec keeps the unsealed sector in memory
+type addPieceExec struct {
+	testExec
+
+	unsealed []byte
+	corrupt  bool
+}
+
+func (e *addPieceExec) AddPiece(ctx context.Context, sector storage.SectorRef, pieceSizes []abi.UnpaddedPieceSize, newPieceSize abi.UnpaddedPieceSize, pieceData storage.Data) (abi.PieceInfo, error) {
+	data, err := ioutil.ReadAll(pieceData)
+	if err != nil {
+		return abi.PieceInfo{}, err
+	}
+
+	c, err := ffiwrapper.GeneratePieceCIDFromFile(sector.ProofType, bytes.NewReader(data), newPieceSize)
+	if err != nil {
+		return abi.PieceInfo{}, err
+	}
+
+	if e.corrupt {
+		data[len(data)/2] ^= 0xff
+	}
+
+	var offset abi.PaddedPieceSize
+	for _, size := range pieceSizes {
+		offset += size.Padded()
+	}
+	_, padding := ffiwrapper.GetRequiredPadding(offset, newPieceSize.Padded())
+	offset += padding
+
+	// unpadded bytes at unpadded offsets are enough here
+	start := int(offset.Unpadded())
+	if len(e.unsealed) < start {
+		e.unsealed = append(e.unsealed, make([]byte, start-len(e.unsealed))...)
+	}
+	e.unsealed = append(e.unsealed[:start], data...)
+
+	return abi.PieceInfo{Size: newPieceSize.Padded(), PieceCID: c}, nil
+}
+
+func (e *addPieceExec) ReadPiece(ctx context.Context, writer io.Writer, sector storage.SectorRef, offset storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize) (bool, error) {
+	if int(offset)+int(size) > len(e.unsealed) {
+		return false, nil
+	}
+
+	_, err := writer.Write(e.unsealed[offset : int(offset)+int(size)])
+	return err == nil, err
+}
+
+type addPieceReturns struct {
+	storiface.WorkerReturn
+
+	pieces chan abi.PieceInfo
+	errs   chan *storiface.CallError
+}
+
+func (r *addPieceReturns) ReturnAddPiece(ctx context.Context, callID storiface.CallID, pi abi.PieceInfo, err *storiface.CallError) error {
+	r.pieces <- pi
+	r.errs <- err
+	return nil
+}
+
+func TestVerifyAddPiece(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &addPieceReturns{
+		pieces: make(chan abi.PieceInfo, 1),
+		errs:   make(chan *storiface.CallError, 1),
+	}
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{VerifyAddPiece: true}, ret)
+	defer cleanup()
+
+	exec := &addPieceExec{}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	addPiece := func(existing []abi.UnpaddedPieceSize, size abi.UnpaddedPieceSize, fill byte) *storiface.CallError {
+		_, err := w.AddPiece(ctx, sector, existing, size, bytes.NewReader(bytes.Repeat([]byte{fill}, int(size))))
+		require.NoError(t, err)
+		<-ret.pieces
+		return <-ret.errs
+	}
+
+	// the second piece is padded to be aligned
+	require.Nil(t, addPiece(nil, 127, 1))
+	require.Nil(t, addPiece([]abi.UnpaddedPieceSize{127}, 254, 2))
+
+	exec.corrupt = true
+	require.NotNil(t, addPiece([]abi.UnpaddedPieceSize{127, 127, 254}, 508, 3))
+}
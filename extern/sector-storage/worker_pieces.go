@@ -0,0 +1,172 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// sectorPieceOrder serializes AddPiece calls to one sector, and checks that
+// calls which waited for other calls add their piece after the pieces those
+// calls added
+type sectorPieceOrder struct {
+	lk     chan struct{} // held while a piece is added
+	active int           // calls holding or waiting for lk
+
+	// known is false until a piece was added to the sector, then sizes are
+	// the sizes of all pieces in the sector
+	known bool
+	sizes []abi.UnpaddedPieceSize
+	added []storiface.AddedPiece
+
+	// generation counts pieces added to the sector
+	generation int
+}
+
+type pieceOrders struct {
+	lk      sync.Mutex
+	sectors map[abi.SectorID]*sectorPieceOrder
+}
+
+// lock waits until no other piece is being added to the sector, and returns
+// the generation of the sector's pieces from before waiting
+func (p *pieceOrders) lock(ctx context.Context, sector abi.SectorID) (*sectorPieceOrder, int, func(), error) {
+	p.lk.Lock()
+	if p.sectors == nil {
+		p.sectors = map[abi.SectorID]*sectorPieceOrder{}
+	}
+	so, ok := p.sectors[sector]
+	if !ok {
+		so = &sectorPieceOrder{lk: make(chan struct{}, 1)}
+		p.sectors[sector] = so
+	}
+	so.active++
+	gen := so.generation
+	p.lk.Unlock()
+
+	unref := func() {
+		p.lk.Lock()
+		so.active--
+		if so.active == 0 && !so.known {
+			delete(p.sectors, sector)
+		}
+		p.lk.Unlock()
+	}
+
+	select {
+	case so.lk <- struct{}{}:
+	case <-ctx.Done():
+		unref()
+		return nil, 0, nil, ctx.Err()
+	}
+
+	return so, gen, func() {
+		<-so.lk
+		unref()
+	}, nil
+}
+
+// check returns the offset of a piece added after existing pieces epcs, or
+// an ErrPieceOrder error when pieces were added to the sector since gen, and
+// epcs don't match them. Must be called with the sector locked.
+func (so *sectorPieceOrder) check(sector abi.SectorID, epcs []abi.UnpaddedPieceSize, gen int) (storiface.PaddedByteIndex, error) {
+	var offset abi.UnpaddedPieceSize
+	for _, size := range epcs {
+		offset += size
+	}
+
+	// no pieces were added concurrently, the caller knows best, e.g. when
+	// retrying a piece or after the worker restarted
+	if !so.known || so.generation == gen {
+		return storiface.UnpaddedByteIndex(offset).Padded(), nil
+	}
+
+	if len(epcs) != len(so.sizes) {
+		return 0, storiface.Err(storiface.ErrPieceOrder, xerrors.Errorf("adding piece to sector %d after %d existing pieces, the sector has %d pieces", sector, len(epcs), len(so.sizes)))
+	}
+	for i, size := range epcs {
+		if size != so.sizes[i] {
+			return 0, storiface.Err(storiface.ErrPieceOrder, xerrors.Errorf("existing piece %d of sector %d is %d bytes, not %d", i, sector, so.sizes[i], size))
+		}
+	}
+
+	return storiface.UnpaddedByteIndex(offset).Padded(), nil
+}
+
+// addPiece records a piece added at offset after existing pieces epcs,
+// replacing pieces previously added at or after the offset. Must be called
+// with the sector locked.
+func (so *sectorPieceOrder) addPiece(epcs []abi.UnpaddedPieceSize, pi abi.PieceInfo, offset storiface.PaddedByteIndex) {
+	so.known = true
+	so.sizes = append(append([]abi.UnpaddedPieceSize{}, epcs...), pi.Size.Unpadded())
+
+	added := so.added[:0]
+	for _, ap := range so.added {
+		if ap.Offset < offset {
+			added = append(added, ap)
+		}
+	}
+	so.added = append(added, storiface.AddedPiece{Piece: pi, Offset: offset})
+	so.generation++
+}
+
+// drop forgets pieces of a sector, e.g. when it's removed
+func (p *pieceOrders) drop(sector abi.SectorID) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	if so, ok := p.sectors[sector]; ok && so.active == 0 {
+		delete(p.sectors, sector)
+	}
+}
+
+func (p *pieceOrders) list(sector abi.SectorID) []storiface.AddedPiece {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	so, ok := p.sectors[sector]
+	if !ok {
+		return nil
+	}
+	return append([]storiface.AddedPiece{}, so.added...)
+}
+
+// orderedAddPiece runs add with AddPiece calls to the sector serialized.
+// Concurrent calls are applied one after another, a call whose existing pieces
+// don't include pieces added by the calls it waited for fails with
+// storiface.ErrPieceOrder, instead of overwriting them.
+func (l *LocalWorker) orderedAddPiece(ctx context.Context, sector abi.SectorID, epcs []abi.UnpaddedPieceSize, add func(ctx context.Context) (abi.PieceInfo, error)) (abi.PieceInfo, error) {
+	so, gen, unlock, err := l.pieces.lock(ctx, sector)
+	if err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("waiting for other pieces added to sector %d: %w", sector, err)
+	}
+	defer unlock()
+
+	offset, err := so.check(sector, epcs, gen)
+	if err != nil {
+		return abi.PieceInfo{}, err
+	}
+
+	pi, err := add(ctx)
+	if err != nil {
+		return pi, err
+	}
+
+	l.pieces.lk.Lock()
+	so.addPiece(epcs, pi, offset)
+	l.pieces.lk.Unlock()
+
+	log.Debugw("added piece", "sector", sector, "piece", pi.PieceCID, "offset", offset)
+	return pi, nil
+}
+
+// SectorPieces returns pieces added to a sector since the worker started, in
+// the order they were added, with the offsets they were written at
+func (l *LocalWorker) SectorPieces(ctx context.Context, sector abi.SectorID) ([]storiface.AddedPiece, error) {
+	return l.pieces.list(sector), nil
+}
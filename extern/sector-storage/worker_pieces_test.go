@@ -0,0 +1,111 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestOrderedAddPiece(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	size := abi.PaddedPieceSize(1024).Unpadded()
+	piece := func(n byte) abi.PieceInfo {
+		return abi.PieceInfo{Size: size.Padded(), PieceCID: testPieceCid(t, n)}
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	firstDone := make(chan error)
+	go func() {
+		_, err := w.orderedAddPiece(ctx, sector, nil, func(context.Context) (abi.PieceInfo, error) {
+			close(started)
+			<-release
+			return piece(1), nil
+		})
+		firstDone <- err
+	}()
+	<-started
+
+	// a concurrent add waits for the first one
+	secondDone := make(chan error)
+	var secondRan bool
+	go func() {
+		_, err := w.orderedAddPiece(ctx, sector, nil, func(context.Context) (abi.PieceInfo, error) {
+			secondRan = true
+			return piece(2), nil
+		})
+		secondDone <- err
+	}()
+
+	// other sectors aren't blocked
+	_, err := w.orderedAddPiece(ctx, abi.SectorID{Miner: 1000, Number: 2}, nil, func(context.Context) (abi.PieceInfo, error) {
+		return piece(3), nil
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-secondDone:
+		t.Fatal("second add didn't wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-firstDone)
+
+	// it didn't know about the first piece, so it can't be applied
+	err = <-secondDone
+	var cerr *storiface.CallError
+	require.True(t, xerrors.As(err, &cerr))
+	require.Equal(t, storiface.ErrPieceOrder, cerr.Code)
+	require.False(t, secondRan)
+
+	_, err = w.orderedAddPiece(ctx, sector, []abi.UnpaddedPieceSize{size}, func(context.Context) (abi.PieceInfo, error) {
+		return piece(2), nil
+	})
+	require.NoError(t, err)
+
+	pieces, err := w.SectorPieces(ctx, sector)
+	require.NoError(t, err)
+	require.Equal(t, []storiface.AddedPiece{
+		{Piece: piece(1), Offset: 0},
+		{Piece: piece(2), Offset: 1024},
+	}, pieces)
+
+	// retrying a piece replaces it
+	_, err = w.orderedAddPiece(ctx, sector, []abi.UnpaddedPieceSize{size}, func(context.Context) (abi.PieceInfo, error) {
+		return piece(4), nil
+	})
+	require.NoError(t, err)
+
+	pieces, err = w.SectorPieces(ctx, sector)
+	require.NoError(t, err)
+	require.Equal(t, []storiface.AddedPiece{
+		{Piece: piece(1), Offset: 0},
+		{Piece: piece(4), Offset: 1024},
+	}, pieces)
+
+	require.NoError(t, w.Remove(ctx, sector))
+	pieces, err = w.SectorPieces(ctx, sector)
+	require.NoError(t, err)
+	require.Empty(t, pieces)
+}
+
+func testPieceCid(t *testing.T, fill byte) cid.Cid {
+	c, err := commcid.DataCommitmentV1ToCID(bytes.Repeat([]byte{fill}, 32))
+	require.NoError(t, err)
+	return c
+}
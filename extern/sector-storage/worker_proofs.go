@@ -0,0 +1,213 @@
+package sectorstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// same as in go-paramfetch
+const (
+	paramDirEnv     = "FIL_PROOFS_PARAMETER_CACHE"
+	defaultParamDir = "/var/tmp/filecoin-proof-parameters"
+)
+
+// taskParams maps tasks which need proof parameters to the name of parameter
+// files they use
+var taskParams = map[sealtasks.TaskType]string{
+	sealtasks.TTCommit2:             "stacked-proof-of-replication",
+	sealtasks.TTProveReplicaUpdate2: "empty-sector-update",
+}
+
+type paramFile struct {
+	CID        string `json:"cid"`
+	Digest     string `json:"digest"`
+	SectorSize uint64 `json:"sector_size"`
+}
+
+func paramDir() string {
+	if dir := os.Getenv(paramDirEnv); dir != "" {
+		return dir
+	}
+	return defaultParamDir
+}
+
+// SupportedProofs returns seal proof types the worker can handle with the
+// task types it accepts. Proofs of tasks which need parameters (e.g. Commit2)
+// are only returned if WorkerConfig.ParamsManifest lists parameter files for
+// them, and all of those files are present in the parameter cache.
+func (l *LocalWorker) SupportedProofs(ctx context.Context) ([]abi.RegisteredSealProof, error) {
+	tts, err := l.TaskTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := l.paramsFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := proofCandidates(tts)
+	dir := paramDir()
+
+	var out []abi.RegisteredSealProof
+	for spt := range candidates {
+		ssize, err := spt.SectorSize()
+		if err != nil {
+			return nil, xerrors.Errorf("getting sector size: %w", err)
+		}
+
+		ok := true
+		for tt := range tts {
+			name, needsParams := taskParams[tt]
+			if !needsParams {
+				continue
+			}
+
+			if !paramsPresent(dir, manifest, name, uint64(ssize)) {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			out = append(out, spt)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+
+	return out, nil
+}
+
+// ProofParams reports the proof parameters used by the worker for each seal
+// proof type, with task types the worker accepts, which needs them. Proof
+// types without parameter files in WorkerConfig.ParamsManifest aren't
+// returned.
+func (l *LocalWorker) ProofParams(ctx context.Context) ([]storiface.ProofParams, error) {
+	tts, err := l.TaskTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := l.paramsFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := paramDir()
+
+	var out []storiface.ProofParams
+	for spt := range proofCandidates(tts) {
+		ssize, err := spt.SectorSize()
+		if err != nil {
+			return nil, xerrors.Errorf("getting sector size: %w", err)
+		}
+
+		var files []storiface.ProofParamFile
+		for file, info := range manifest {
+			if info.SectorSize != uint64(ssize) || !usedByTasks(tts, file) {
+				continue
+			}
+
+			_, err := os.Stat(filepath.Join(dir, file))
+			files = append(files, storiface.ProofParamFile{
+				Name:    file,
+				CID:     info.CID,
+				Digest:  info.Digest,
+				Present: err == nil,
+			})
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Name < files[j].Name
+		})
+
+		h := sha256.New()
+		for _, f := range files {
+			_, _ = fmt.Fprintf(h, "%s %s\n", f.Name, f.CID)
+		}
+
+		out = append(out, storiface.ProofParams{
+			ProofType: spt,
+			Version:   hex.EncodeToString(h.Sum(nil)),
+			Files:     files,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ProofType < out[j].ProofType
+	})
+
+	return out, nil
+}
+
+// paramsFiles parses the parameters manifest, it's empty when the worker has
+// no manifest
+func (l *LocalWorker) paramsFiles() (map[string]paramFile, error) {
+	var manifest map[string]paramFile
+	if len(l.paramsManifest) > 0 {
+		if err := json.Unmarshal(l.paramsManifest, &manifest); err != nil {
+			return nil, xerrors.Errorf("parsing parameters manifest: %w", err)
+		}
+	}
+	return manifest, nil
+}
+
+// proofCandidates returns seal proof types with resource table entries for
+// any of the task types
+func proofCandidates(tts map[sealtasks.TaskType]struct{}) map[abi.RegisteredSealProof]struct{} {
+	out := map[abi.RegisteredSealProof]struct{}{}
+	for tt := range tts {
+		for spt := range ResourceTable[tt] {
+			out[spt] = struct{}{}
+		}
+	}
+	return out
+}
+
+// usedByTasks checks if the parameter file is used by any of the task types
+func usedByTasks(tts map[sealtasks.TaskType]struct{}, file string) bool {
+	for tt := range tts {
+		if name, ok := taskParams[tt]; ok && strings.Contains(file, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// paramsPresent checks that the manifest has files with the given name and
+// sector size, and that all of them exist in the parameter dir
+func paramsPresent(dir string, manifest map[string]paramFile, name string, ssize uint64) bool {
+	var found bool
+	for file, info := range manifest {
+		if info.SectorSize != ssize || !strings.Contains(file, name) {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			return false
+		}
+		found = true
+	}
+
+	return found
+}
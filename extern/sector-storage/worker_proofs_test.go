@@ -0,0 +1,140 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestSupportedProofs(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "lotus-params-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint
+
+	oldDir, set := os.LookupEnv(paramDirEnv)
+	require.NoError(t, os.Setenv(paramDirEnv, dir))
+	defer func() {
+		if set {
+			_ = os.Setenv(paramDirEnv, oldDir)
+		} else {
+			_ = os.Unsetenv(paramDirEnv)
+		}
+	}()
+
+	manifest := []byte(`{
+  "v28-stacked-proof-of-replication-2k.params": {"sector_size": 2048},
+  "v28-stacked-proof-of-replication-2k.vk": {"sector_size": 2048},
+  "v28-stacked-proof-of-replication-8m.params": {"sector_size": 8388608}
+}`)
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		TaskTypes:      []sealtasks.TaskType{sealtasks.TTPreCommit1, sealtasks.TTCommit2},
+		ParamsManifest: manifest,
+	}, nil)
+	defer cleanup()
+
+	// no params downloaded
+	proofs, err := w.SupportedProofs(ctx)
+	require.NoError(t, err)
+	require.NotContains(t, proofs, abi.RegisteredSealProof_StackedDrg2KiBV1)
+	require.NotContains(t, proofs, abi.RegisteredSealProof_StackedDrg32GiBV1) // not in the manifest
+
+	for _, f := range []string{"v28-stacked-proof-of-replication-2k.params", "v28-stacked-proof-of-replication-2k.vk"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, f), nil, 0644))
+	}
+
+	proofs, err = w.SupportedProofs(ctx)
+	require.NoError(t, err)
+	require.Contains(t, proofs, abi.RegisteredSealProof_StackedDrg2KiBV1)
+	require.Contains(t, proofs, abi.RegisteredSealProof_StackedDrg2KiBV1_1)
+	require.NotContains(t, proofs, abi.RegisteredSealProof_StackedDrg8MiBV1)
+
+	require.NotContains(t, proofs, abi.RegisteredSealProof_StackedDrg32GiBV1)
+
+	// PreCommit1 doesn't need params
+	require.NoError(t, w.TaskDisable(ctx, sealtasks.TTCommit2))
+	proofs, err = w.SupportedProofs(ctx)
+	require.NoError(t, err)
+	require.Contains(t, proofs, abi.RegisteredSealProof_StackedDrg8MiBV1)
+	require.Contains(t, proofs, abi.RegisteredSealProof_StackedDrg32GiBV1)
+
+	// without a manifest nothing needing params is supported
+	w.paramsManifest = nil
+	require.NoError(t, w.TaskEnable(ctx, sealtasks.TTCommit2))
+	proofs, err = w.SupportedProofs(ctx)
+	require.NoError(t, err)
+	require.NotContains(t, proofs, abi.RegisteredSealProof_StackedDrg2KiBV1)
+}
+
+func TestProofParams(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	oldDir, set := os.LookupEnv(paramDirEnv)
+	require.NoError(t, os.Setenv(paramDirEnv, dir))
+	defer func() {
+		if set {
+			_ = os.Setenv(paramDirEnv, oldDir)
+		} else {
+			_ = os.Unsetenv(paramDirEnv)
+		}
+	}()
+
+	manifest := []byte(`{
+  "v28-stacked-proof-of-replication-2k.params": {"cid": "Qm2kParams", "digest": "aa", "sector_size": 2048},
+  "v28-stacked-proof-of-replication-2k.vk": {"cid": "Qm2kVk", "digest": "bb", "sector_size": 2048},
+  "v28-proof-of-spacetime-fallback-2k.params": {"cid": "Qm2kPoSt", "digest": "cc", "sector_size": 2048}
+}`)
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		TaskTypes:      []sealtasks.TaskType{sealtasks.TTCommit2},
+		ParamsManifest: manifest,
+	}, nil)
+	defer cleanup()
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "v28-stacked-proof-of-replication-2k.params"), nil, 0644))
+
+	params, err := w.ProofParams(ctx)
+	require.NoError(t, err)
+
+	// V1 and V1_1 proofs use the same parameters, other sizes aren't in the manifest
+	require.Len(t, params, 2)
+	require.Equal(t, abi.RegisteredSealProof_StackedDrg2KiBV1, params[0].ProofType)
+	require.Equal(t, abi.RegisteredSealProof_StackedDrg2KiBV1_1, params[1].ProofType)
+	require.Equal(t, params[0].Version, params[1].Version)
+	require.NotEmpty(t, params[0].Version)
+
+	// PoSt params aren't used by Commit2
+	require.Equal(t, []storiface.ProofParamFile{
+		{Name: "v28-stacked-proof-of-replication-2k.params", CID: "Qm2kParams", Digest: "aa", Present: true},
+		{Name: "v28-stacked-proof-of-replication-2k.vk", CID: "Qm2kVk", Digest: "bb", Present: false},
+	}, params[0].Files)
+
+	// a different manifest means different params
+	w.paramsManifest = []byte(`{
+  "v28-stacked-proof-of-replication-2k.params": {"cid": "Qm2kParamsNew", "digest": "dd", "sector_size": 2048},
+  "v28-stacked-proof-of-replication-2k.vk": {"cid": "Qm2kVk", "digest": "bb", "sector_size": 2048}
+}`)
+	updated, err := w.ProofParams(ctx)
+	require.NoError(t, err)
+	require.Len(t, updated, 2)
+	require.NotEqual(t, params[0].Version, updated[0].Version)
+
+	// tasks which don't need params don't report any
+	require.NoError(t, w.TaskDisable(ctx, sealtasks.TTCommit2))
+	require.NoError(t, w.TaskEnable(ctx, sealtasks.TTPreCommit1))
+	params, err = w.ProofParams(ctx)
+	require.NoError(t, err)
+	require.Empty(t, params)
+}
@@ -0,0 +1,198 @@
+package sectorstorage
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// callQueue limits how many calls run on the worker at the same time. When
+// the limit is reached, calls wait for a free slot, and when one frees up the
+// call with highest priority starts first. Calls with equal priority start in
+// the order they arrived.
+//
+// Priorities of registered calls can be changed until they start, this is how
+// the manager sets priorities of calls made over RPC, where context values
+// aren't passed to the worker.
+type callQueue struct {
+	lk sync.Mutex
+
+	limit   int // 0 = unlimited
+	running int
+
+	seq     uint64
+	waiting callHeap
+	calls   map[storiface.CallID]*queuedCall // registered, not yet started
+}
+
+type queuedCall struct {
+	ci       storiface.CallID
+	priority int
+	seq      uint64
+	index    int // in waiting, -1 when not queued
+
+	ready chan struct{}
+}
+
+func newCallQueue(limit int) *callQueue {
+	return &callQueue{
+		limit: limit,
+		calls: map[storiface.CallID]*queuedCall{},
+	}
+}
+
+func (q *callQueue) newCall(ci storiface.CallID, priority int) *queuedCall {
+	c := &queuedCall{
+		ci:       ci,
+		priority: priority,
+		seq:      q.seq,
+		index:    -1,
+		ready:    make(chan struct{}),
+	}
+	q.seq++
+	return c
+}
+
+// register adds a call which will acquire a slot later, so that its priority
+// can be changed with setPriority before it starts. Registered calls keep
+// their place in arrival order from the time they were registered.
+func (q *callQueue) register(ci storiface.CallID, priority int) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	q.calls[ci] = q.newCall(ci, priority)
+}
+
+// unregister forgets a registered call which finished without going through
+// acquire
+func (q *callQueue) unregister(ci storiface.CallID) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	delete(q.calls, ci)
+}
+
+// setPriority changes the priority of a registered call, returns false when
+// the call isn't known or already started
+func (q *callQueue) setPriority(ci storiface.CallID, priority int) bool {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	c, ok := q.calls[ci]
+	if !ok {
+		return false
+	}
+
+	c.priority = priority
+	if c.index >= 0 {
+		heap.Fix(&q.waiting, c.index)
+	}
+	return true
+}
+
+// acquire waits for a free slot, the returned func must be called when the
+// call is done. Registered calls use their registered priority, others use
+// the passed priority.
+func (q *callQueue) acquire(ctx context.Context, ci storiface.CallID, priority int) (func(), error) {
+	q.lk.Lock()
+
+	c, ok := q.calls[ci]
+	if !ok {
+		c = q.newCall(ci, priority)
+	}
+
+	if q.limit <= 0 || (q.running < q.limit && q.waiting.Len() == 0) {
+		q.running++
+		delete(q.calls, ci)
+		q.lk.Unlock()
+		return q.release, nil
+	}
+
+	heap.Push(&q.waiting, c)
+	q.lk.Unlock()
+
+	select {
+	case <-c.ready:
+		return q.release, nil
+	case <-ctx.Done():
+		q.lk.Lock()
+		defer q.lk.Unlock()
+
+		delete(q.calls, ci)
+
+		select {
+		case <-c.ready:
+			// got a slot after ctx was cancelled, pass it on
+			q.running--
+			q.next()
+		default:
+			heap.Remove(&q.waiting, c.index)
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+func (q *callQueue) release() {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	q.running--
+	q.next()
+}
+
+// next starts waiting calls while there are free slots, must be called with lk held
+func (q *callQueue) next() {
+	for q.running < q.limit && q.waiting.Len() > 0 {
+		c := heap.Pop(&q.waiting).(*queuedCall)
+		q.running++
+		delete(q.calls, c.ci)
+		close(c.ready)
+	}
+}
+
+// SetCallPriority changes the priority of a call waiting in the worker call
+// queue. The priority of calls made over RPC can only be set this way, as
+// context values, including the scheduling priority (see WithPriority), aren't
+// passed to the worker. Calls which already started are left alone.
+func (l *LocalWorker) SetCallPriority(ctx context.Context, ci storiface.CallID, priority int) error {
+	if !l.queue.setPriority(ci, priority) {
+		log.Debugw("not setting priority of call which isn't queued", "call", ci, "priority", priority)
+	}
+	return nil
+}
+
+type callHeap []*queuedCall
+
+func (h callHeap) Len() int { return len(h) }
+
+func (h callHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h callHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *callHeap) Push(x interface{}) {
+	c := x.(*queuedCall)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *callHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	c.index = -1
+	old[n-1] = nil
+	*h = old[:n-1]
+	return c
+}
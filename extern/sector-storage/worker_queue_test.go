@@ -0,0 +1,133 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestCallQueuePriority(t *testing.T) {
+	ctx := context.Background()
+
+	q := newCallQueue(1)
+
+	release, err := q.acquire(ctx, storiface.UndefCall, 0)
+	require.NoError(t, err)
+
+	started := make(chan int, 4)
+	enqueue := func(id, prio int) {
+		go func() {
+			rel, err := q.acquire(ctx, storiface.UndefCall, prio)
+			require.NoError(t, err)
+			started <- id
+			rel()
+		}()
+
+		// make sure calls are queued in order
+		require.Eventually(t, func() bool {
+			q.lk.Lock()
+			defer q.lk.Unlock()
+			return q.waiting.Len() == id
+		}, time.Second, time.Millisecond)
+	}
+
+	enqueue(1, 0)
+	enqueue(2, 10)
+	enqueue(3, 0)
+	enqueue(4, 10)
+
+	release()
+
+	var order []int
+	for i := 0; i < 4; i++ {
+		order = append(order, <-started)
+	}
+	require.Equal(t, []int{2, 4, 1, 3}, order)
+}
+
+func TestCallQueueCancel(t *testing.T) {
+	q := newCallQueue(1)
+
+	release, err := q.acquire(context.Background(), storiface.UndefCall, 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := q.acquire(ctx, storiface.UndefCall, 0)
+		errs <- err
+	}()
+
+	require.Eventually(t, func() bool {
+		q.lk.Lock()
+		defer q.lk.Unlock()
+		return q.waiting.Len() == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	require.Equal(t, context.Canceled, <-errs)
+
+	release()
+
+	// the cancelled call doesn't hold a slot
+	release, err = q.acquire(context.Background(), storiface.UndefCall, 0)
+	require.NoError(t, err)
+	release()
+	require.Zero(t, q.running)
+}
+
+func TestCallQueueSetPriority(t *testing.T) {
+	ctx := context.Background()
+
+	q := newCallQueue(1)
+
+	release, err := q.acquire(ctx, storiface.UndefCall, 0)
+	require.NoError(t, err)
+
+	calls := []storiface.CallID{
+		{ID: uuid.New()},
+		{ID: uuid.New()},
+		{ID: uuid.New()},
+	}
+
+	started := make(chan int, len(calls))
+	for i, ci := range calls {
+		q.register(ci, 0)
+
+		go func(i int, ci storiface.CallID) {
+			rel, err := q.acquire(ctx, ci, 0)
+			require.NoError(t, err)
+			started <- i
+			rel()
+		}(i, ci)
+	}
+
+	// priority can be set before the call gets to acquire
+	require.True(t, q.setPriority(calls[2], 10))
+
+	require.Eventually(t, func() bool {
+		q.lk.Lock()
+		defer q.lk.Unlock()
+		return q.waiting.Len() == len(calls)
+	}, time.Second, time.Millisecond)
+
+	// and while it's waiting
+	require.True(t, q.setPriority(calls[1], 5))
+
+	release()
+
+	var order []int
+	for range calls {
+		order = append(order, <-started)
+	}
+	require.Equal(t, []int{2, 1, 0}, order)
+
+	// started calls are forgotten
+	require.False(t, q.setPriority(calls[0], 1))
+	require.Empty(t, q.calls)
+}
@@ -0,0 +1,145 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+var ErrCallTooOld = errors.New("call exceeded the maximum call age")
+
+var (
+	// how often calls are checked against the max call age, at most half the
+	// max age
+	reapInterval = time.Minute
+
+	// how many reaped calls are kept for ReapedCalls
+	maxReapedCalls = 256
+)
+
+type reaperCall struct {
+	ctx     context.Context
+	task    sealtasks.TaskType
+	started time.Time
+	reaped  bool
+}
+
+// callReaper cancels calls which have been running for longer than the max
+// call age, as a safety net against stuck calls holding resources forever.
+// Unlike task timeouts the age covers all tasks, and includes time spent
+// waiting in the call queue.
+type callReaper struct {
+	maxAge time.Duration // 0 = never reap
+
+	lk     sync.Mutex
+	calls  map[storiface.CallID]*reaperCall
+	reaped []storiface.ReapedCall
+}
+
+func newCallReaper(maxAge time.Duration) *callReaper {
+	return &callReaper{
+		maxAge: maxAge,
+		calls:  map[storiface.CallID]*reaperCall{},
+	}
+}
+
+// track starts the age of a call accepted with the call context ctx, the
+// returned func must be called when the call is done
+func (r *callReaper) track(ctx context.Context, ci storiface.CallID, task sealtasks.TaskType) func() {
+	if r == nil || r.maxAge <= 0 {
+		return func() {}
+	}
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	r.calls[ci] = &reaperCall{
+		ctx:     ctx,
+		task:    task,
+		started: time.Now(),
+	}
+
+	return func() {
+		r.lk.Lock()
+		defer r.lk.Unlock()
+
+		delete(r.calls, ci)
+	}
+}
+
+// reap aborts calls older than the max age. Calls are aborted once, calls
+// which don't honor their context keep running until they return.
+func (r *callReaper) reap(now time.Time) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	for ci, c := range r.calls {
+		age := now.Sub(c.started)
+		if c.reaped || age <= r.maxAge {
+			continue
+		}
+
+		c.reaped = true
+		log.Errorw("call running longer than the max call age, cancelling it", "call", ci, "task", c.task, "sector", ci.Sector, "age", age, "maxAge", r.maxAge)
+		abortCall(c.ctx, xerrors.Errorf("%s call running for %s, more than %s: %w", c.task, age.Truncate(time.Second), r.maxAge, ErrCallTooOld))
+
+		r.reaped = append(r.reaped, storiface.ReapedCall{
+			Call:    ci,
+			Task:    c.task,
+			Started: c.started,
+			Reaped:  now,
+		})
+	}
+
+	if over := len(r.reaped) - maxReapedCalls; over > 0 {
+		r.reaped = append([]storiface.ReapedCall{}, r.reaped[over:]...)
+	}
+}
+
+func (r *callReaper) list() []storiface.ReapedCall {
+	if r == nil {
+		return nil
+	}
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	return append([]storiface.ReapedCall{}, r.reaped...)
+}
+
+func (l *LocalWorker) reapLoop() {
+	defer close(l.reapDone)
+
+	if l.reaper.maxAge <= 0 {
+		return
+	}
+
+	interval := reapInterval
+	if half := l.reaper.maxAge / 2; half < interval {
+		interval = half
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			l.reaper.reap(now)
+		case <-l.closing:
+			return
+		}
+	}
+}
+
+// ReapedCalls returns calls recently cancelled for running longer than the
+// max call age (see WorkerConfig.MaxCallAge), oldest first
+func (l *LocalWorker) ReapedCalls(ctx context.Context) ([]storiface.ReapedCall, error) {
+	return l.reaper.list(), nil
+}
@@ -0,0 +1,78 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// stuckC2Exec runs SealCommit2 until its context is cancelled
+type stuckC2Exec struct {
+	testExec
+}
+
+func (e *stuckC2Exec) SealCommit2(ctx context.Context, sector storage.SectorRef, c1o storage.Commit1Out) (storage.Proof, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestReapStaleCalls(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &c2Returns{errs: make(chan *storiface.CallError, 1), proofs: make(chan storage.Proof, 1)}
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{MaxCallAge: 50 * time.Millisecond}, ret)
+	defer cleanup()
+
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return &stuckC2Exec{}, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	reaped, err := w.ReapedCalls(ctx)
+	require.NoError(t, err)
+	require.Empty(t, reaped)
+
+	ci, err := w.SealCommit2(ctx, sector, storage.Commit1Out("c1o"))
+	require.NoError(t, err)
+
+	cerr := <-ret.errs
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Message, ErrCallTooOld.Error())
+	<-ret.proofs
+
+	reaped, err = w.ReapedCalls(ctx)
+	require.NoError(t, err)
+	require.Len(t, reaped, 1)
+	require.Equal(t, ci, reaped[0].Call)
+	require.Equal(t, sealtasks.TTCommit2, reaped[0].Task)
+	require.True(t, reaped[0].Reaped.Sub(reaped[0].Started) > 50*time.Millisecond)
+}
+
+func TestReaperKeepsYoungCalls(t *testing.T) {
+	r := newCallReaper(time.Hour)
+
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := r.track(cctx, storiface.CallID{}, sealtasks.TTCommit2)
+
+	r.reap(time.Now())
+	require.NoError(t, cctx.Err())
+	require.Empty(t, r.list())
+
+	done()
+	r.reap(time.Now().Add(2 * time.Hour))
+	require.Empty(t, r.list())
+}
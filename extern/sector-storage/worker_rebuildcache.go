@@ -0,0 +1,131 @@
+package sectorstorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// RebuildCache regenerates the cache files of a sealed sector which can be
+// computed from its sealed replica, see ffiwrapper.CacheRebuilder, so that a
+// sector whose cache disk was lost doesn't have to be sealed again. Cache
+// directories in local paths which the index doesn't know about, e.g. ones
+// with p_aux restored from a backup, are declared before rebuilding.
+func (l *LocalWorker) RebuildCache(ctx context.Context, sector storage.SectorRef) error {
+	if err := l.checkNamespace(sector.ID); err != nil {
+		return err
+	}
+	if _, err := sector.ProofType.SectorSize(); err != nil {
+		return xerrors.Errorf("proof type %d: %s: %w", sector.ProofType, err, ffiwrapper.ErrCacheNotRebuildable)
+	}
+
+	sb, err := l.sb()
+	if err != nil {
+		return err
+	}
+	cr, ok := sb.(ffiwrapper.CacheRebuilder)
+	if !ok {
+		return xerrors.Errorf("proofs backend: %w", ffiwrapper.ErrCacheRebuildUnsupported)
+	}
+
+	active, err := l.ct.activeSectors()
+	if err != nil {
+		return xerrors.Errorf("getting active sectors: %w", err)
+	}
+	if _, ok := active[sector.ID]; ok {
+		return xerrors.Errorf("sector %d has tasks in progress", sector.ID)
+	}
+
+	sealed, err := l.sindex.StorageFindSector(ctx, sector.ID, storiface.FTSealed, 0, false)
+	if err != nil {
+		return xerrors.Errorf("finding sealed sector: %w", err)
+	}
+	if len(sealed) == 0 {
+		return xerrors.Errorf("sector %d has no sealed replica: %w", sector.ID, storiface.ErrSectorNotFound)
+	}
+
+	// the lock is held until lctx is cancelled
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := l.sindex.StorageLock(lctx, sector.ID, storiface.FTSealed, storiface.FTCache); err != nil {
+		return xerrors.Errorf("locking sector files: %w", err)
+	}
+
+	if err := l.declareLocalCaches(ctx, sector.ID); err != nil {
+		return err
+	}
+
+	caches, err := l.sindex.StorageFindSector(ctx, sector.ID, storiface.FTCache, 0, false)
+	if err != nil {
+		return xerrors.Errorf("finding sector cache: %w", err)
+	}
+	if len(caches) == 0 {
+		return xerrors.Errorf("sector %d has no cache left, p_aux was lost with it: %w", sector.ID, ffiwrapper.ErrCacheNotRebuildable)
+	}
+
+	if err := cr.RebuildCache(ctx, sector); err != nil {
+		return xerrors.Errorf("rebuilding cache of sector %d: %w", sector.ID, err)
+	}
+
+	log.Infow("rebuilt sector cache", "sector", sector.ID)
+	return l.syncSectorFiles(ctx, sector.ID, storiface.FTCache)
+}
+
+// declareLocalCaches declares cache directories of the sector found in local
+// paths which aren't in the index
+func (l *LocalWorker) declareLocalCaches(ctx context.Context, sector abi.SectorID) error {
+	if l.localStore == nil {
+		return nil
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	found, err := l.sindex.StorageFindSector(ctx, sector, storiface.FTCache, 0, false)
+	if err != nil {
+		return xerrors.Errorf("finding sector cache: %w", err)
+	}
+	declared := map[stores.ID]struct{}{}
+	for _, info := range found {
+		declared[info.ID] = struct{}{}
+	}
+
+	for _, p := range paths {
+		if p.LocalPath == "" {
+			continue
+		}
+		if _, ok := declared[p.ID]; ok {
+			continue
+		}
+
+		st, err := os.Stat(filepath.Join(p.LocalPath, storiface.FTCache.String(), storiface.SectorName(sector)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return xerrors.Errorf("checking cache in %s: %w", p.LocalPath, err)
+		}
+		if !st.IsDir() {
+			continue
+		}
+
+		if err := l.sindex.StorageDeclareSector(ctx, p.ID, sector, storiface.FTCache, p.CanStore); err != nil {
+			return xerrors.Errorf("declaring cache in %s: %w", p.ID, err)
+		}
+		log.Infow("declared sector cache found on disk", "sector", sector, "path", p.ID)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// rebuildingExec writes tree-r-last into the cache acquired through the
+// worker's path provider
+type rebuildingExec struct {
+	testExec
+
+	w *LocalWorker
+}
+
+func (e *rebuildingExec) RebuildCache(ctx context.Context, sector storage.SectorRef) error {
+	paths, done, err := (&localWorkerPathProvider{w: e.w}).AcquireSector(ctx, sector, storiface.FTSealed|storiface.FTCache, storiface.FTNone, storiface.PathStorage)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	return ioutil.WriteFile(filepath.Join(paths.Cache, "sc-02-data-tree-r-last.dat"), []byte("tree-r-last"), 0644)
+}
+
+func TestRebuildCache(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	// the test executor can't rebuild caches
+	err := w.RebuildCache(ctx, sector)
+	require.True(t, xerrors.Is(err, ffiwrapper.ErrCacheRebuildUnsupported), "%+v", err)
+
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return &rebuildingExec{w: w}, nil
+	}
+
+	err = w.RebuildCache(ctx, sector)
+	require.True(t, xerrors.Is(err, storiface.ErrSectorNotFound), "%+v", err)
+
+	writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTSealed, 2048)
+
+	// p_aux was lost with the cache
+	err = w.RebuildCache(ctx, sector)
+	require.True(t, xerrors.Is(err, ffiwrapper.ErrCacheNotRebuildable), "%+v", err)
+
+	// a cache restored on disk is declared, and rebuilt
+	cache := filepath.Join(p.LocalPath, storiface.FTCache.String(), storiface.SectorName(sector.ID))
+	require.NoError(t, os.Mkdir(cache, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cache, "p_aux"), []byte("p_aux"), 0644))
+
+	require.NoError(t, w.RebuildCache(ctx, sector))
+
+	found, err := si.StorageFindSector(ctx, sector.ID, storiface.FTCache, 0, false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, p.ID, found[0].ID)
+
+	_, err = os.Stat(filepath.Join(cache, "sc-02-data-tree-r-last.dat"))
+	require.NoError(t, err)
+
+	sector.ProofType = abi.RegisteredSealProof(-1)
+	err = w.RebuildCache(ctx, sector)
+	require.True(t, xerrors.Is(err, ffiwrapper.ErrCacheNotRebuildable), "%+v", err)
+}
@@ -0,0 +1,136 @@
+package sectorstorage
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// releaseChecker detects funcs releasing sector paths acquired through the
+// worker which are called more than once, or never, see
+// WorkerConfig.DebugReservations. Acquisitions made by a call which are still
+// held when the call finishes, or made outside of calls and still held when
+// the worker is closed, are reported as leaked.
+type releaseChecker struct {
+	lk sync.Mutex
+
+	next uint64
+	held map[uint64]*heldAcquisition
+}
+
+type heldAcquisition struct {
+	sector   abi.SectorID
+	types    storiface.SectorFileType
+	call     *storiface.CallID
+	acquired time.Time
+	stack    []byte
+
+	reported bool
+}
+
+func newReleaseChecker(enabled bool) *releaseChecker {
+	if !enabled {
+		return nil
+	}
+
+	return &releaseChecker{
+		held: map[uint64]*heldAcquisition{},
+	}
+}
+
+// wrap returns release, which complains when called again instead of
+// releasing the paths twice
+func (c *releaseChecker) wrap(ctx context.Context, sector abi.SectorID, types storiface.SectorFileType, release func()) func() {
+	if c == nil {
+		return release
+	}
+
+	a := &heldAcquisition{
+		sector:   sector,
+		types:    types,
+		acquired: time.Now(),
+		stack:    debug.Stack(),
+	}
+	if cl := callLog(ctx); cl != nil {
+		ci := cl.ci
+		a.call = &ci
+	}
+
+	c.lk.Lock()
+	c.next++
+	id := c.next
+	c.held[id] = a
+	c.lk.Unlock()
+
+	var once sync.Once
+	return func() {
+		released := false
+		once.Do(func() {
+			c.lk.Lock()
+			delete(c.held, id)
+			c.lk.Unlock()
+
+			release()
+			released = true
+		})
+
+		if !released {
+			log.Errorw("sector paths released more than once", "sector", sector, "types", types, "call", a.call, "stack", string(debug.Stack()))
+		}
+	}
+}
+
+// checkCall reports paths acquired by the call which weren't released, and
+// returns how many there are
+func (c *releaseChecker) checkCall(ci storiface.CallID) int {
+	if c == nil {
+		return 0
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	var leaked int
+	for _, a := range c.held {
+		if a.call != nil && *a.call == ci {
+			c.reportLeak(a)
+			leaked++
+		}
+	}
+	return leaked
+}
+
+// checkOutsideCalls reports paths acquired outside of calls, e.g. by
+// maintenance loops, which weren't released, when the worker is closed
+func (c *releaseChecker) checkOutsideCalls() int {
+	if c == nil {
+		return 0
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	var leaked int
+	for _, a := range c.held {
+		if a.call == nil {
+			c.reportLeak(a)
+			leaked++
+		}
+	}
+	return leaked
+}
+
+// reportLeak logs each leaked acquisition once, must be called with lk held
+func (c *releaseChecker) reportLeak(a *heldAcquisition) {
+	if a.reported {
+		return
+	}
+	a.reported = true
+
+	log.Errorw("sector paths never released", "sector", a.sector, "types", a.types, "call", a.call, "held", time.Since(a.acquired), "acquired at", string(a.stack))
+}
@@ -0,0 +1,56 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestReleaseChecker(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{DebugReservations: true}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	ci := storiface.CallID{Sector: sector.ID, ID: uuid.New()}
+	cctx := withCallLogger(ctx, &callLogger{ci: ci, logs: &w.callLogs})
+
+	pp := &localWorkerPathProvider{w: w}
+	_, done, err := pp.AcquireSector(cctx, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, w.releases.checkCall(ci))
+	require.Equal(t, 0, w.releases.checkOutsideCalls())
+
+	done()
+	require.Equal(t, 0, w.releases.checkCall(ci))
+
+	// released once only
+	var released int
+	again := w.releases.wrap(ctx, sector.ID, storiface.FTSealed, func() { released++ })
+	require.Equal(t, 1, w.releases.checkOutsideCalls())
+	again()
+	again()
+	require.Equal(t, 1, released)
+	require.Equal(t, 0, w.releases.checkOutsideCalls())
+
+	// disabled by default
+	var c *releaseChecker
+	var calls int
+	release := c.wrap(ctx, sector.ID, storiface.FTSealed, func() { calls++ })
+	release()
+	release()
+	require.Equal(t, 2, calls)
+	require.Equal(t, 0, c.checkCall(ci))
+}
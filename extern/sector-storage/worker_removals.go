@@ -0,0 +1,235 @@
+package sectorstorage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// DefaultFinalizeRemoveRetries is how many times FinalizeSector retries
+// removing unsealed data when WorkerConfig.FinalizeRemoveRetries isn't set
+const DefaultFinalizeRemoveRetries = 3
+
+// first delay between removal retries, doubled after each attempt
+var removeRetryBackoff = time.Second
+
+// how often pending removals are retried
+var pendingRemovalInterval = 5 * time.Minute
+
+// calls writing unsealed data, which need the sector's unsealed file even when
+// its removal was deferred by the unsealed grace period
+var writesUnsealed = map[ReturnType]bool{
+	AddPiece:      true,
+	UnsealPiece:   true,
+	ReplicaUpdate: true,
+}
+
+// pendingRemovals are removals of sector files which kept failing. They are
+// only kept in memory, sector files left behind after a restart are cleaned up
+// like any other leftovers (see PurgeUnsealed).
+type pendingRemovals struct {
+	lk      sync.Mutex
+	pending map[abi.SectorID]*storiface.PendingRemoval
+}
+
+func (pr *pendingRemovals) add(sector abi.SectorID, types storiface.SectorFileType, attempts int, err error) {
+	pr.lk.Lock()
+	defer pr.lk.Unlock()
+
+	if pr.pending == nil {
+		pr.pending = map[abi.SectorID]*storiface.PendingRemoval{}
+	}
+
+	p, ok := pr.pending[sector]
+	if !ok {
+		p = &storiface.PendingRemoval{Sector: sector, Since: time.Now()}
+		pr.pending[sector] = p
+	}
+
+	p.Types |= types
+	p.Attempts += attempts
+	p.LastErr = err.Error()
+}
+
+// schedule adds a removal which is due at the given time
+func (pr *pendingRemovals) schedule(sector abi.SectorID, types storiface.SectorFileType, due time.Time) {
+	pr.lk.Lock()
+	defer pr.lk.Unlock()
+
+	if pr.pending == nil {
+		pr.pending = map[abi.SectorID]*storiface.PendingRemoval{}
+	}
+
+	p, ok := pr.pending[sector]
+	if !ok {
+		p = &storiface.PendingRemoval{Sector: sector, Since: time.Now()}
+		pr.pending[sector] = p
+	}
+
+	p.Types |= types
+	if due.After(p.Due) {
+		p.Due = due
+	}
+}
+
+// drop forgets pending removals of a sector, e.g. because a new call uses it.
+// Scheduled removals are only dropped when scheduled is true.
+func (pr *pendingRemovals) drop(sector abi.SectorID, scheduled bool) {
+	pr.lk.Lock()
+	defer pr.lk.Unlock()
+
+	if p, ok := pr.pending[sector]; ok && (scheduled || p.Due.IsZero()) {
+		delete(pr.pending, sector)
+	}
+}
+
+func (pr *pendingRemovals) list() []storiface.PendingRemoval {
+	pr.lk.Lock()
+	defer pr.lk.Unlock()
+
+	out := make([]storiface.PendingRemoval, 0, len(pr.pending))
+	for _, p := range pr.pending {
+		out = append(out, *p)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return sectorLess(out[i].Sector, out[j].Sector)
+	})
+	return out
+}
+
+// removeWithRetry removes sector files, retrying failed removals with backoff,
+// e.g. to get over network filesystem hiccups. Returns the number of attempts
+// made, and the last error when all of them failed.
+func (l *LocalWorker) removeWithRetry(ctx context.Context, sector abi.SectorID, types storiface.SectorFileType) (int, error) {
+	backoff := removeRetryBackoff
+
+	var attempts int
+	for {
+		err := l.storage.Remove(ctx, sector, types, true)
+		attempts++
+		if err == nil {
+			return attempts, nil
+		}
+
+		if attempts > l.removeRetries {
+			return attempts, err
+		}
+
+		log.Warnw("removing sector files failed, retrying", "sector", sector, "types", types, "attempt", attempts, "error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return attempts, xerrors.Errorf("%w (last removal error: %s)", ctx.Err(), err)
+		}
+		backoff *= 2
+	}
+}
+
+// removeOrDefer removes sector files, and when the removal keeps failing
+// schedules it to be retried later, instead of failing the caller
+func (l *LocalWorker) removeOrDefer(ctx context.Context, sector abi.SectorID, types storiface.SectorFileType) {
+	attempts, err := l.removeWithRetry(ctx, sector, types)
+	if err == nil {
+		return
+	}
+
+	log.Errorw("removing sector files failed, will retry later", "sector", sector, "types", types, "attempts", attempts, "error", err)
+	l.removals.add(sector, types, attempts, err)
+}
+
+// scheduleRemoval removes sector files after the delay, e.g. to keep unsealed
+// data around for retrievals for a while after finalizing. Removals of locked
+// sectors wait for pending removals to be retried.
+func (l *LocalWorker) scheduleRemoval(sector abi.SectorID, types storiface.SectorFileType, delay time.Duration) {
+	l.removals.schedule(sector, types, time.Now().Add(delay))
+	log.Infow("scheduled sector file removal", "sector", sector, "types", types, "delay", delay)
+
+	time.AfterFunc(delay, func() {
+		select {
+		case <-l.closing:
+			return
+		default:
+		}
+
+		l.retryRemovals(&wctx{
+			vals:    context.Background(),
+			closing: l.closing,
+		})
+	})
+}
+
+// retryRemovals retries pending removals of sectors which aren't locked, and
+// which are due
+func (l *LocalWorker) retryRemovals(ctx context.Context) {
+	now := time.Now()
+	for _, p := range l.removals.list() {
+		if p.Due.After(now) {
+			continue
+		}
+		if err := l.retryRemoval(ctx, p); err != nil {
+			log.Warnw("retrying sector file removal failed", "sector", p.Sector, "types", p.Types, "error", err)
+			l.removals.add(p.Sector, 0, 1, err)
+		}
+	}
+}
+
+func (l *LocalWorker) retryRemoval(ctx context.Context, p storiface.PendingRemoval) error {
+	// the lock is held until lctx is cancelled
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	locked, err := l.sindex.StorageTryLock(lctx, p.Sector, storiface.FTNone, p.Types)
+	if err != nil {
+		return xerrors.Errorf("locking sector files: %w", err)
+	}
+	if !locked {
+		log.Debugw("not retrying removal, sector is locked", "sector", p.Sector)
+		return nil
+	}
+
+	if err := l.storage.Remove(ctx, p.Sector, p.Types, true); err != nil {
+		return err
+	}
+
+	log.Infow("removed sector files after retrying", "sector", p.Sector, "types", p.Types, "attempts", p.Attempts+1)
+	l.removals.drop(p.Sector, true)
+	return nil
+}
+
+// pendingRemovalsLoop retries pending removals periodically, until the worker
+// is closed
+func (l *LocalWorker) pendingRemovalsLoop() {
+	defer close(l.removalsDone)
+
+	ctx := &wctx{
+		vals:    context.Background(),
+		closing: l.closing,
+	}
+
+	t := time.NewTicker(pendingRemovalInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			l.retryRemovals(ctx)
+		case <-l.closing:
+			return
+		}
+	}
+}
+
+// PendingRemovals returns removals of sector files which failed, and are
+// retried periodically, and removals deferred by the unsealed grace period
+func (l *LocalWorker) PendingRemovals(ctx context.Context) ([]storiface.PendingRemoval, error) {
+	return l.removals.list(), nil
+}
@@ -0,0 +1,154 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+type flakyRemoveStore struct {
+	stores.Store
+
+	failures int64
+	attempts int64
+}
+
+func (s *flakyRemoveStore) Remove(ctx context.Context, sid abi.SectorID, types storiface.SectorFileType, force bool) error {
+	atomic.AddInt64(&s.attempts, 1)
+	if atomic.AddInt64(&s.failures, -1) >= 0 {
+		return xerrors.New("stale NFS file handle")
+	}
+	return s.Store.Remove(ctx, sid, types, force)
+}
+
+func TestRemoveOrDefer(t *testing.T) {
+	ctx := context.Background()
+
+	backoff := removeRetryBackoff
+	removeRetryBackoff = time.Millisecond
+	defer func() {
+		removeRetryBackoff = backoff
+	}()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{FinalizeRemoveRetries: 2}, nil)
+	defer cleanup()
+
+	store := &flakyRemoveStore{Store: w.storage}
+	w.storage = store
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	unsealed := writeTestSectorFile(ctx, t, p, si, sector, storiface.FTUnsealed, 1<<10)
+
+	// transient failures are retried
+	store.failures = 2
+	w.removeOrDefer(ctx, sector, storiface.FTUnsealed)
+	require.Equal(t, int64(3), store.attempts)
+	require.NoFileExists(t, unsealed)
+	require.Empty(t, w.removals.list())
+
+	// persistent failures are retried later
+	unsealed = writeTestSectorFile(ctx, t, p, si, sector, storiface.FTUnsealed, 1<<10)
+	store.failures, store.attempts = 4, 0
+	w.removeOrDefer(ctx, sector, storiface.FTUnsealed)
+	require.Equal(t, int64(3), store.attempts)
+	require.FileExists(t, unsealed)
+
+	pending, err := w.PendingRemovals(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, sector, pending[0].Sector)
+	require.Equal(t, storiface.FTUnsealed, pending[0].Types)
+	require.Equal(t, 3, pending[0].Attempts)
+	require.Contains(t, pending[0].LastErr, "stale NFS file handle")
+
+	w.retryRemovals(ctx)
+	pending, err = w.PendingRemovals(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, 4, pending[0].Attempts)
+
+	// locks of the last attempt are released asynchronously
+	require.Eventually(t, func() bool {
+		w.retryRemovals(ctx)
+		return len(w.removals.list()) == 0
+	}, time.Second, 10*time.Millisecond)
+	require.NoFileExists(t, unsealed)
+
+	// removals are dropped when the sector is used again
+	w.removals.add(sector, storiface.FTUnsealed, 1, xerrors.New("failed"))
+	_, err = w.runCall(ctx, storage.SectorRef{ID: sector}, Fetch, storiface.CallID{Sector: sector}, func(context.Context, storiface.CallID) (interface{}, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, w.removals.list())
+}
+
+func TestUnsealedGracePeriod(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &finalizeReturns{errs: make(chan *storiface.CallError, 1)}
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{UnsealedGracePeriod: 200 * time.Millisecond}, ret)
+	defer cleanup()
+
+	exec := &partialFinalizeExec{finalized: make(chan storiface.SectorFileType, 2)}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	unsealed := writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTUnsealed, 1<<10)
+
+	_, err := w.FinalizeSectorTypes(ctx, sector, nil, storiface.FTUnsealed)
+	require.NoError(t, err)
+	require.Nil(t, <-ret.errs)
+	require.Equal(t, storiface.FTUnsealed, <-exec.finalized)
+
+	// kept until the grace period is over
+	require.FileExists(t, unsealed)
+	pending, err := w.PendingRemovals(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, storiface.FTUnsealed, pending[0].Types)
+	require.False(t, pending[0].Due.IsZero())
+
+	w.retryRemovals(ctx)
+	require.FileExists(t, unsealed)
+
+	// calls not writing unsealed data don't need it
+	_, err = w.runCall(ctx, sector, Fetch, storiface.CallID{Sector: sector.ID}, func(context.Context, storiface.CallID) (interface{}, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, w.removals.list(), 1)
+
+	require.Eventually(t, func() bool {
+		return len(w.removals.list()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoFileExists(t, unsealed)
+
+	// calls writing unsealed data drop the removal
+	unsealed = writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTUnsealed, 1<<10)
+	w.scheduleRemoval(sector.ID, storiface.FTUnsealed, 100*time.Millisecond)
+	_, err = w.runCall(ctx, sector, AddPiece, storiface.CallID{Sector: sector.ID}, func(context.Context, storiface.CallID) (interface{}, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, w.removals.list())
+
+	time.Sleep(200 * time.Millisecond)
+	require.FileExists(t, unsealed)
+}
@@ -0,0 +1,46 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// ErrSectorBusy is returned by Remove when the sector has calls in progress
+var ErrSectorBusy = errors.New("sector has calls in progress")
+
+type forceRemoveKey struct{}
+
+// WithForceRemove returns a context with which Remove removes sector files
+// also while calls for the sector are in progress
+func WithForceRemove(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceRemoveKey{}, true)
+}
+
+// checkRemovable returns ErrSectorBusy when calls for the sector are in
+// progress, unless removal is forced by the config or the context
+func (l *LocalWorker) checkRemovable(ctx context.Context, sector abi.SectorID) error {
+	if force, _ := ctx.Value(forceRemoveKey{}).(bool); force || l.forceRemove {
+		return nil
+	}
+
+	calls, err := l.ct.unfinished()
+	if err != nil {
+		return xerrors.Errorf("getting unfinished calls: %w", err)
+	}
+
+	var active []ReturnType
+	for _, call := range calls {
+		if call.ID.Sector == sector && call.State == CallStarted {
+			active = append(active, call.RetType)
+		}
+	}
+	if len(active) > 0 {
+		return xerrors.Errorf("removing sector %d with calls %v in progress: %w", sector, active, ErrSectorBusy)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+type blockingC2Exec struct {
+	testExec
+
+	started, release chan struct{}
+}
+
+func (e *blockingC2Exec) SealCommit2(ctx context.Context, sector storage.SectorRef, c1o storage.Commit1Out) (storage.Proof, error) {
+	close(e.started)
+	<-e.release
+	return storage.Proof("proof"), nil
+}
+
+func TestRemoveBusySector(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &c2Returns{errs: make(chan *storiface.CallError, 1), proofs: make(chan storage.Proof, 1)}
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	exec := &blockingC2Exec{started: make(chan struct{}), release: make(chan struct{})}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	sealed := writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTSealed, 2048)
+
+	_, err := w.SealCommit2(ctx, sector, storage.Commit1Out("c1o"))
+	require.NoError(t, err)
+	<-exec.started
+
+	// files of the running task stay in place
+	err = w.Remove(ctx, sector.ID)
+	require.True(t, xerrors.Is(err, ErrSectorBusy), err)
+	require.FileExists(t, sealed)
+
+	// other sectors can be removed
+	other := abi.SectorID{Miner: 1000, Number: 2}
+	otherSealed := writeTestSectorFile(ctx, t, p, si, other, storiface.FTSealed, 2048)
+	require.NoError(t, w.Remove(ctx, other))
+	require.NoFileExists(t, otherSealed)
+
+	close(exec.release)
+	require.Nil(t, <-ret.errs)
+	<-ret.proofs
+
+	// once the call returned the sector can be removed
+	require.NoError(t, w.Remove(ctx, sector.ID))
+	require.NoFileExists(t, sealed)
+}
+
+func TestForceRemoveBusySector(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	sealed := writeTestSectorFile(ctx, t, p, si, sector, storiface.FTSealed, 2048)
+	require.NoError(t, w.ct.onStart(storiface.CallID{Sector: sector}, SealPreCommit2))
+
+	require.True(t, xerrors.Is(w.Remove(ctx, sector), ErrSectorBusy))
+	require.NoError(t, w.Remove(WithForceRemove(ctx), sector))
+	require.NoFileExists(t, sealed)
+}
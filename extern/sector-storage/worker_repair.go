@@ -0,0 +1,198 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+var ErrNoHealthySource = errors.New("no healthy copy to repair from")
+
+// RepairFile replaces local copies of a sector file, e.g. a corrupted sealed
+// replica, with a copy fetched from another storage path selected by src, and
+// checks the fetched copy. Fetched copies which don't pass the check are
+// removed, and the next source is tried. Fails with ErrNoHealthySource when
+// no other path holds the file, or no copy fetched passes the check. Sectors
+// with tasks running on this worker are refused.
+func (l *LocalWorker) RepairFile(ctx context.Context, sector storage.SectorRef, fileType storiface.SectorFileType, src storiface.RepairSource) error {
+	if err := l.checkNamespace(sector.ID); err != nil {
+		return err
+	}
+	if bits.OnesCount(uint(fileType)) != 1 {
+		return xerrors.Errorf("repair expects one file type, got %s", fileType)
+	}
+	if l.localStore == nil {
+		return xerrors.Errorf("worker doesn't use local storage paths")
+	}
+
+	fetcher, ok := l.storage.(stores.SourceFetcher)
+	if !ok {
+		return xerrors.Errorf("store can't fetch sector files from chosen paths")
+	}
+
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	active, err := l.ct.activeSectors()
+	if err != nil {
+		return xerrors.Errorf("getting active sectors: %w", err)
+	}
+	if _, ok := active[sector.ID]; ok {
+		return xerrors.Errorf("sector %d has tasks in progress", sector.ID)
+	}
+
+	// the lock is held until lctx is cancelled
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := l.sindex.StorageLock(lctx, sector.ID, storiface.FTNone, fileType); err != nil {
+		return xerrors.Errorf("locking sector files: %w", err)
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	local := map[stores.ID]stores.StoragePath{}
+	for _, p := range paths {
+		if p.LocalPath != "" {
+			local[p.ID] = p
+		}
+	}
+
+	found, err := l.sindex.StorageFindSector(ctx, sector.ID, fileType, 0, false)
+	if err != nil {
+		return xerrors.Errorf("finding sector %d (%s): %w", sector.ID, fileType, err)
+	}
+
+	// the repaired copy replaces the local ones, in the path of the first
+	var localCopies []stores.ID
+	var primary bool
+	pathType := storiface.PathSealing
+	for _, info := range found {
+		p, ok := local[info.ID]
+		if !ok {
+			continue
+		}
+		if len(localCopies) == 0 && p.CanStore {
+			pathType = storiface.PathStorage
+		}
+		localCopies = append(localCopies, info.ID)
+		primary = primary || info.Primary
+	}
+
+	sources := repairSources(found, local, src)
+	if len(sources) == 0 {
+		return xerrors.Errorf("repairing sector %d (%s): %w", sector.ID, fileType, ErrNoHealthySource)
+	}
+
+	if err := l.localStore.Remove(ctx, sector.ID, fileType, true); err != nil {
+		return xerrors.Errorf("removing local copies: %w", err)
+	}
+
+	fctx := stores.WithAllocPolicy(ctx, stores.AllocPolicy{Paths: localCopies})
+
+	var failed []string
+	for _, source := range sources {
+		dest, storageID, err := fetcher.FetchFrom(fctx, sector, fileType, pathType, source, primary)
+		if err != nil {
+			failed = append(failed, xerrors.Errorf("fetching from %s: %w", source, err).Error())
+			continue
+		}
+
+		if err := checkSectorFile(dest, fileType, ssize); err != nil {
+			failed = append(failed, xerrors.Errorf("copy from %s: %w", source, err).Error())
+			if err := l.localStore.Remove(ctx, sector.ID, fileType, true); err != nil {
+				return xerrors.Errorf("removing bad copy fetched from %s: %w", source, err)
+			}
+			continue
+		}
+
+		log.Infow("repaired sector file", "sector", sector.ID, "type", fileType, "source", source, "storage", storageID)
+		return l.syncSectorFiles(ctx, sector.ID, fileType)
+	}
+
+	return xerrors.Errorf("repairing sector %d (%s): %w: %s", sector.ID, fileType, ErrNoHealthySource, strings.Join(failed, "; "))
+}
+
+// repairSources returns non-local paths holding the file, in the order they
+// are tried
+func repairSources(found []stores.SectorStorageInfo, local map[stores.ID]stores.StoragePath, src storiface.RepairSource) []stores.ID {
+	holding := map[stores.ID]bool{}
+	for _, info := range found {
+		if _, ok := local[info.ID]; !ok {
+			holding[info.ID] = true
+		}
+	}
+
+	var out []stores.ID
+	if len(src.Paths) > 0 {
+		for _, id := range src.Paths {
+			if holding[stores.ID(id)] {
+				out = append(out, stores.ID(id))
+				delete(holding, stores.ID(id))
+			}
+		}
+		return out
+	}
+
+	others := append([]stores.SectorStorageInfo{}, found...)
+	sort.SliceStable(others, func(i, j int) bool {
+		return others[i].Primary && !others[j].Primary
+	})
+	for _, info := range others {
+		if holding[info.ID] {
+			out = append(out, info.ID)
+			delete(holding, info.ID)
+		}
+	}
+	return out
+}
+
+// checkSectorFile checks that a sector file is complete: sealed replicas must
+// have the sector size, caches must hold the files needed for proving
+func checkSectorFile(path string, fileType storiface.SectorFileType, ssize abi.SectorSize) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	switch fileType {
+	case storiface.FTSealed:
+		if st.Size() != int64(ssize) {
+			return xerrors.Errorf("sealed file is %d bytes, expected %d", st.Size(), ssize)
+		}
+	case storiface.FTCache:
+		need := map[string]int64{
+			filepath.Join(path, "t_aux"): 0,
+			filepath.Join(path, "p_aux"): 0,
+		}
+		addCachePathsForSectorSize(need, path, ssize)
+
+		for p := range need {
+			if _, err := os.Stat(p); err != nil {
+				return xerrors.Errorf("cache file missing: %w", err)
+			}
+		}
+	default:
+		if st.Size() == 0 {
+			return xerrors.Errorf("%s file is empty", fileType)
+		}
+	}
+
+	return nil
+}
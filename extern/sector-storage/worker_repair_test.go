@@ -0,0 +1,77 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestRepairFile(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	bad := writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTSealed, 100)
+
+	// only the bad local copy exists
+	err := w.RepairFile(ctx, sector, storiface.FTSealed, storiface.RepairSource{})
+	require.True(t, xerrors.Is(err, ErrNoHealthySource), err)
+	require.FileExists(t, bad)
+
+	var remote *stores.Local
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		(&stores.FetchHandler{Local: remote}).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	st := newTestStorage(t)
+	defer st.cleanup()
+
+	remote, err = stores.NewLocal(ctx, st, si, []string{srv.URL + "/remote"})
+	require.NoError(t, err)
+
+	rpaths, err := remote.Local(ctx)
+	require.NoError(t, err)
+	require.Len(t, rpaths, 1)
+
+	// a copy which is as bad as the local one
+	writeTestSectorFile(ctx, t, rpaths[0], si, sector.ID, storiface.FTSealed, 200)
+
+	err = w.RepairFile(ctx, sector, storiface.FTSealed, storiface.RepairSource{})
+	require.True(t, xerrors.Is(err, ErrNoHealthySource), err)
+	require.NoFileExists(t, bad)
+
+	good := writeTestSectorFile(ctx, t, rpaths[0], si, sector.ID, storiface.FTSealed, 2048)
+	require.NoError(t, ioutil.WriteFile(good, []byte{1, 2, 3}, 0644))
+	require.NoError(t, os.Truncate(good, 2048))
+
+	require.NoError(t, w.RepairFile(ctx, sector, storiface.FTSealed, storiface.RepairSource{Paths: []string{string(rpaths[0].ID)}}))
+
+	got, err := ioutil.ReadFile(filepath.Join(p.LocalPath, storiface.FTSealed.String(), storiface.SectorName(sector.ID)))
+	require.NoError(t, err)
+	require.Len(t, got, 2048)
+	require.Equal(t, []byte{1, 2, 3}, got[:3])
+
+	found, err := si.StorageFindSector(ctx, sector.ID, storiface.FTSealed, 0, false)
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+}
@@ -0,0 +1,195 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+var ErrTooFewReplicas = errors.New("not enough unsealed replicas")
+
+type replicaPathKey struct{}
+
+// withReplicaPath pins sector files acquired through the worker's path
+// provider to one local path
+func withReplicaPath(ctx context.Context, id stores.ID) context.Context {
+	return context.WithValue(ctx, replicaPathKey{}, id)
+}
+
+// onlyPath is a path preference using only the given path
+func onlyPath(id stores.ID) stores.PathPreference {
+	return func(ctx context.Context, sector abi.SectorID, fileType storiface.SectorFileType, existing bool, candidates []stores.PathCandidate) []stores.PathCandidate {
+		for _, c := range candidates {
+			if c.ID == id {
+				return []stores.PathCandidate{c}
+			}
+		}
+		return nil
+	}
+}
+
+// replicaPaths returns local paths to write unsealed replicas of the sector to:
+// paths holding its unsealed file when pieces were added already, otherwise
+// paths the unsealed file may be allocated in
+func (l *LocalWorker) replicaPaths(ctx context.Context, sector storage.SectorRef, epcs []abi.UnpaddedPieceSize) ([]stores.ID, error) {
+	if len(epcs) == 0 {
+		return l.localStore.AllocCandidates(ctx, sector, storiface.FTUnsealed, storiface.PathSealing)
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	local := map[stores.ID]bool{}
+	for _, p := range paths {
+		local[p.ID] = p.LocalPath != ""
+	}
+
+	found, err := l.sindex.StorageFindSector(ctx, sector.ID, storiface.FTUnsealed, 0, false)
+	if err != nil {
+		return nil, xerrors.Errorf("finding unsealed sector: %w", err)
+	}
+
+	var out []stores.ID
+	for _, info := range found {
+		if local[info.ID] {
+			out = append(out, info.ID)
+		}
+	}
+	return out, nil
+}
+
+// addPieceReplicated writes the piece to unsealed files in multiple local
+// paths at once, reading the piece data once. Unless the worker accepts fewer
+// replicas (see WorkerConfig.ReplicasBestEffort), AddPiece fails when any
+// replica can't be written. Replicas which failed are removed otherwise.
+func (l *LocalWorker) addPieceReplicated(ctx context.Context, sb ffiwrapper.Storage, sector storage.SectorRef, epcs []abi.UnpaddedPieceSize, sz abi.UnpaddedPieceSize, r io.Reader) (abi.PieceInfo, error) {
+	if l.localStore == nil {
+		return abi.PieceInfo{}, xerrors.Errorf("writing unsealed replicas requires local storage paths")
+	}
+
+	ids, err := l.replicaPaths(ctx, sector, epcs)
+	if err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("finding paths for unsealed replicas: %w", err)
+	}
+	if len(ids) > l.addPieceReplicas {
+		ids = ids[:l.addPieceReplicas]
+	}
+	if len(ids) == 0 || (len(ids) < l.addPieceReplicas && !l.replicasBestEffort) {
+		return abi.PieceInfo{}, xerrors.Errorf("sector %d can have %d of %d unsealed replicas: %w", sector.ID, len(ids), l.addPieceReplicas, ErrTooFewReplicas)
+	}
+
+	rw := &replicaWriter{
+		pipes:  make([]*io.PipeWriter, len(ids)),
+		failed: make([]error, len(ids)),
+		strict: !l.replicasBestEffort,
+	}
+
+	pis := make([]abi.PieceInfo, len(ids))
+	errs := make([]error, len(ids))
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		pr, pw := io.Pipe()
+		rw.pipes[i] = pw
+
+		wg.Add(1)
+		go func(i int, id stores.ID, pr *io.PipeReader) {
+			defer wg.Done()
+
+			pis[i], errs[i] = sb.AddPiece(withReplicaPath(ctx, id), sector, epcs, sz, pr)
+
+			// stop writes to replicas which are done or failed
+			if errs[i] != nil {
+				_ = pr.CloseWithError(errs[i])
+			} else {
+				_ = pr.Close()
+			}
+		}(i, id, pr)
+	}
+
+	_, err = io.Copy(rw, io.LimitReader(r, int64(sz)))
+	for _, pw := range rw.pipes {
+		_ = pw.CloseWithError(err)
+	}
+	wg.Wait()
+
+	if err != nil && rw.strict {
+		return abi.PieceInfo{}, xerrors.Errorf("writing unsealed replicas of sector %d: %w", sector.ID, err)
+	}
+
+	var pi abi.PieceInfo
+	var written int
+	for i, id := range ids {
+		if errs[i] == nil && written > 0 && pis[i] != pi {
+			errs[i] = xerrors.Errorf("piece %s differs from the one in other replicas, %s", pis[i].PieceCID, pi.PieceCID)
+		}
+		if errs[i] != nil {
+			if rw.strict {
+				return abi.PieceInfo{}, xerrors.Errorf("writing unsealed replica in %s: %w", id, errs[i])
+			}
+			continue
+		}
+
+		pi = pis[i]
+		written++
+	}
+
+	if written == 0 {
+		return abi.PieceInfo{}, xerrors.Errorf("writing unsealed replicas of sector %d: %w", sector.ID, errs[0])
+	}
+
+	for i, id := range ids {
+		if errs[i] == nil {
+			continue
+		}
+
+		log.Warnw("unsealed replica failed, continuing with fewer copies", "sector", sector.ID, "storage", id, "error", errs[i])
+		if err := l.localStore.RemoveFrom(ctx, sector.ID, storiface.FTUnsealed, id); err != nil {
+			log.Errorw("removing failed unsealed replica", "sector", sector.ID, "storage", id, "error", err)
+		}
+	}
+
+	return pi, nil
+}
+
+// replicaWriter writes piece data to AddPiece calls of all replicas. Replicas
+// which fail are skipped, unless strict is set, in which case writes fail.
+type replicaWriter struct {
+	pipes  []*io.PipeWriter
+	failed []error
+	strict bool
+}
+
+func (w *replicaWriter) Write(p []byte) (int, error) {
+	var live int
+	for i, pw := range w.pipes {
+		if w.failed[i] != nil {
+			continue
+		}
+
+		if _, err := pw.Write(p); err != nil {
+			w.failed[i] = err
+			if w.strict {
+				return 0, xerrors.Errorf("writing replica %d: %w", i, err)
+			}
+			continue
+		}
+		live++
+	}
+
+	if live == 0 {
+		return 0, xerrors.Errorf("all replicas failed: %w", w.failed[0])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,127 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// replicaExec appends piece data to the unsealed file acquired through the
+// worker's path provider, failing in the path with failIn in its name
+type replicaExec struct {
+	testExec
+
+	w      *LocalWorker
+	failIn string
+}
+
+func (e *replicaExec) AddPiece(ctx context.Context, sector storage.SectorRef, epcs []abi.UnpaddedPieceSize, sz abi.UnpaddedPieceSize, data storage.Data) (abi.PieceInfo, error) {
+	existing, allocate := storiface.FTUnsealed, storiface.FTNone
+	if len(epcs) == 0 {
+		existing, allocate = allocate, existing
+	}
+
+	paths, done, err := (&localWorkerPathProvider{w: e.w}).AcquireSector(ctx, sector, existing, allocate, storiface.PathSealing)
+	if err != nil {
+		return abi.PieceInfo{}, err
+	}
+	defer done()
+
+	f, err := os.OpenFile(paths.Unsealed, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return abi.PieceInfo{}, err
+	}
+	defer f.Close() // nolint
+
+	if e.failIn != "" && strings.Contains(paths.Unsealed, e.failIn) {
+		// fail after reading some of the data
+		if _, err := io.CopyN(f, data, 1); err != nil {
+			return abi.PieceInfo{}, err
+		}
+		return abi.PieceInfo{}, xerrors.New("disk failure")
+	}
+
+	if _, err := io.Copy(f, data); err != nil {
+		return abi.PieceInfo{}, err
+	}
+	return abi.PieceInfo{Size: sz.Padded(), PieceCID: cid.Undef}, nil
+}
+
+func TestAddPieceReplicated(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{AddPieceReplicas: 2}, nil)
+	defer cleanup()
+
+	second := openSealingPath(ctx, t, w)
+	exec := &replicaExec{w: w}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 127)
+	pi, err := w.addPieceReplicated(ctx, exec, sector, nil, abi.UnpaddedPieceSize(len(data)), bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, abi.UnpaddedPieceSize(len(data)).Padded(), pi.Size)
+
+	// both copies hold the data, and are declared
+	for _, sp := range []stores.StoragePath{p, second} {
+		got, err := ioutil.ReadFile(filepath.Join(sp.LocalPath, storiface.FTUnsealed.String(), storiface.SectorName(sector.ID)))
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+	}
+	found, err := si.StorageFindSector(ctx, sector.ID, storiface.FTUnsealed, 0, false)
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+
+	// existing copies get the next piece
+	epcs := []abi.UnpaddedPieceSize{abi.UnpaddedPieceSize(len(data))}
+	_, err = w.addPieceReplicated(ctx, exec, sector, epcs, abi.UnpaddedPieceSize(len(data)), bytes.NewReader(data))
+	require.NoError(t, err)
+	for _, sp := range []stores.StoragePath{p, second} {
+		got, err := ioutil.ReadFile(filepath.Join(sp.LocalPath, storiface.FTUnsealed.String(), storiface.SectorName(sector.ID)))
+		require.NoError(t, err)
+		require.Len(t, got, 2*len(data))
+	}
+
+	// a failing replica fails the piece
+	exec.failIn = second.LocalPath
+	failing := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 2}, ProofType: sector.ProofType}
+	_, err = w.addPieceReplicated(ctx, exec, failing, nil, abi.UnpaddedPieceSize(len(data)), bytes.NewReader(data))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "disk failure")
+
+	// unless fewer replicas are fine
+	w.replicasBestEffort = true
+	pi, err = w.addPieceReplicated(ctx, exec, failing, nil, abi.UnpaddedPieceSize(len(data)), bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, abi.UnpaddedPieceSize(len(data)).Padded(), pi.Size)
+
+	found, err = si.StorageFindSector(ctx, failing.ID, storiface.FTUnsealed, 0, false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, p.ID, found[0].ID)
+	require.NoFileExists(t, filepath.Join(second.LocalPath, storiface.FTUnsealed.String(), storiface.SectorName(failing.ID)))
+
+	// not enough paths
+	w.addPieceReplicas, w.replicasBestEffort = 3, false
+	_, err = w.addPieceReplicated(ctx, exec, storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 3}, ProofType: sector.ProofType}, nil, 1, bytes.NewReader(data))
+	require.True(t, xerrors.Is(err, ErrTooFewReplicas), err)
+}
@@ -0,0 +1,88 @@
+package sectorstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+var reservationReconcileInterval = 10 * time.Minute
+
+// reconcileReservationsLoop reconciles reservations when the worker starts,
+// which releases reservations left behind by a previous run, and then
+// periodically until the worker is closed
+func (l *LocalWorker) reconcileReservationsLoop() {
+	defer close(l.reconcileDone)
+
+	ctx := &wctx{
+		vals:    context.Background(),
+		closing: l.closing,
+	}
+
+	t := time.NewTicker(reservationReconcileInterval)
+	defer t.Stop()
+
+	for {
+		freed, err := l.ReconcileReservations(ctx)
+		if err != nil {
+			log.Errorf("reconciling storage reservations: %+v", err)
+		} else if freed > 0 {
+			log.Warnf("released %d bytes of orphaned storage reservations", freed)
+		}
+
+		select {
+		case <-t.C:
+		case <-l.closing:
+			return
+		}
+	}
+}
+
+// runningSectors returns sectors with calls running on the worker
+func (l *LocalWorker) runningSectors() map[abi.SectorID]struct{} {
+	l.cancelLk.Lock()
+	defer l.cancelLk.Unlock()
+
+	out := map[abi.SectorID]struct{}{}
+	for ci := range l.cancels {
+		out[ci.Sector] = struct{}{}
+	}
+
+	return out
+}
+
+// ReconcileReservations releases storage space reservations held in local
+// paths for sectors which have no calls running on this worker, and returns
+// how many bytes were released. Reservations of running calls are never
+// touched.
+func (l *LocalWorker) ReconcileReservations(ctx context.Context) (int64, error) {
+	if l.localStore == nil {
+		return 0, nil
+	}
+
+	// reservations are taken by running calls, so listing them before the
+	// calls means that every listed reservation which belongs to a running
+	// call is seen with its call
+	reservations := l.localStore.Reservations()
+	running := l.runningSectors()
+
+	var freed int64
+	for _, res := range reservations {
+		if err := ctx.Err(); err != nil {
+			return freed, err
+		}
+
+		if _, ok := running[res.Sector]; ok {
+			continue
+		}
+
+		size := l.localStore.ReleaseReservation(res)
+		if size > 0 {
+			log.Warnw("released orphaned storage reservation", "sector", res.Sector, "type", res.Type, "storage", res.Storage, "size", size)
+		}
+		freed += size
+	}
+
+	return freed, nil
+}
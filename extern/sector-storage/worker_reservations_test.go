@@ -0,0 +1,98 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-statestore"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestReconcileReservations(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	pp := &localWorkerPathProvider{w: w, op: storiface.AcquireMove}
+
+	leaked := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	running := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 2},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	// reservation of a task which never released it
+	_, _, err := pp.AcquireSector(ctx, leaked, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing)
+	require.NoError(t, err)
+
+	// reservation of a task still running
+	_, done := w.callContext(ctx, storiface.CallID{Sector: running.ID, ID: uuid.New()})
+	defer done()
+	_, release, err := pp.AcquireSector(ctx, running, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing)
+	require.NoError(t, err)
+
+	freed, err := w.ReconcileReservations(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2048), freed)
+
+	freed, err = w.ReconcileReservations(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), freed)
+
+	require.Len(t, w.localStore.Reservations(), 1)
+	release()
+	require.Empty(t, w.localStore.Reservations())
+}
+
+func TestReconcileReservationsAfterRestart(t *testing.T) {
+	ctx := context.Background()
+
+	st := newTestStorage(t)
+	defer st.cleanup()
+
+	si := stores.NewIndex()
+	lstor, err := stores.NewLocal(ctx, st, si, nil)
+	require.NoError(t, err)
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	// the worker crashes while a task holds a reservation
+	_, ids, err := lstor.AcquireSector(ctx, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing, storiface.AcquireMove)
+	require.NoError(t, err)
+	_, err = lstor.Reserve(ctx, sector, storiface.FTUnsealed, ids, storiface.FSOverheadSeal)
+	require.NoError(t, err)
+
+	si = stores.NewIndex()
+	lstor, err = stores.NewLocal(ctx, st, si, nil)
+	require.NoError(t, err)
+	require.Len(t, lstor.Reservations(), 1)
+
+	w := newLocalWorker(func() (ffiwrapper.Storage, error) {
+		return &testExec{}, nil
+	}, WorkerConfig{}, stores.NewRemote(lstor, si, nil, 6000, stores.DefaultFetchConfig()), lstor, si, nil, statestore.New(dssync.MutexWrap(datastore.NewMapDatastore())))
+
+	// released by the reconcile loop when the worker starts
+	require.Eventually(t, func() bool {
+		return len(lstor.Reservations()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, w.Close())
+}
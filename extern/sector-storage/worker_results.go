@@ -0,0 +1,219 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// namespace of IDs of call tracker entries keeping task results
+var resultNamespace = uuid.MustParse("5e3d2f5c-8f0e-4a43-9d7b-3f1a2c6c9b11")
+
+// reusableResultTypes are tasks which keep their results, see reusableCall.
+// PreCommit2 persists its result with the sector cache instead, as it also
+// depends on the sealed replica.
+var reusableResultTypes = []ReturnType{SealPreCommit1, SealCommit1, SealCommit2}
+
+// storedResult is the result of a successful call, for the inputs with the
+// given hash
+type storedResult struct {
+	Inputs []byte
+	Result json.RawMessage
+}
+
+// resultCallID is the ID of the call tracker entry keeping the last result of
+// a task for a sector, there is only one per sector and task
+func resultCallID(sector abi.SectorID, rt ReturnType) storiface.CallID {
+	return storiface.CallID{
+		Sector: sector,
+		ID:     uuid.NewSHA1(resultNamespace, []byte(rt)),
+	}
+}
+
+func resultInputsKey(sector storage.SectorRef, rt ReturnType, inputs []interface{}) ([]byte, error) {
+	b, err := json.Marshal(struct {
+		Sector storage.SectorRef
+		Task   ReturnType
+		Inputs []interface{}
+	}{sector, rt, inputs})
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+func (wt *workerCallTracker) storeResult(sector abi.SectorID, rt ReturnType, inputs []byte, res interface{}) error {
+	rb, err := json.Marshal(res)
+	if err != nil {
+		return xerrors.Errorf("marshaling result: %w", err)
+	}
+
+	sb, err := json.Marshal(&storedResult{Inputs: inputs, Result: rb})
+	if err != nil {
+		return xerrors.Errorf("marshaling stored result: %w", err)
+	}
+
+	ci := resultCallID(sector, rt)
+	has, err := wt.st.Has(ci)
+	if err != nil {
+		return err
+	}
+
+	if !has {
+		return wt.st.Begin(ci, &Call{
+			ID:      ci,
+			RetType: rt,
+			State:   CallResult,
+			Result:  &ManyBytes{sb},
+		})
+	}
+
+	return wt.st.Get(ci).Mutate(func(cs *Call) error {
+		cs.Result = &ManyBytes{sb}
+		return nil
+	})
+}
+
+// storedResult returns the stored result of the task when it was computed for
+// the same inputs
+func (wt *workerCallTracker) storedResult(sector abi.SectorID, rt ReturnType, inputs []byte) (json.RawMessage, bool, error) {
+	ci := resultCallID(sector, rt)
+	has, err := wt.st.Has(ci)
+	if err != nil || !has {
+		return nil, false, err
+	}
+
+	var call Call
+	if err := wt.st.Get(ci).Get(&call); err != nil {
+		return nil, false, err
+	}
+	if call.Result == nil {
+		return nil, false, nil
+	}
+
+	var sr storedResult
+	if err := json.Unmarshal(call.Result.b, &sr); err != nil {
+		return nil, false, xerrors.Errorf("unmarshaling stored result: %w", err)
+	}
+
+	if !bytes.Equal(sr.Inputs, inputs) {
+		return nil, false, nil
+	}
+
+	return sr.Result, true, nil
+}
+
+// dropResults forgets stored results of a sector, e.g. when its files are
+// removed
+func (wt *workerCallTracker) dropResults(sector abi.SectorID) error {
+	for _, rt := range reusableResultTypes {
+		ci := resultCallID(sector, rt)
+		has, err := wt.st.Has(ci)
+		if err != nil {
+			return err
+		}
+		if !has {
+			continue
+		}
+
+		if err := wt.st.Get(ci).End(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reusableCall is like asyncCall for tasks with results depending only on
+// their inputs, and on sector files of the needed types which they produced
+// or used. Successful results are stored in the call tracker, and when the
+// task is dispatched again with the same inputs, e.g. because the result was
+// lost on the way to the manager, the stored result is returned right away
+// instead of computing it again. Stored results are dropped when sector files
+// are removed.
+func (l *LocalWorker) reusableCall(ctx context.Context, sector storage.SectorRef, rt ReturnType, needed storiface.SectorFileType, inputs []interface{}, decode func(json.RawMessage) (interface{}, error), work func(ctx context.Context, ci storiface.CallID) (interface{}, error)) (storiface.CallID, error) {
+	key, err := resultInputsKey(sector, rt, inputs)
+	if err != nil {
+		log.Warnw("hashing task inputs, not reusing results", "sector", sector.ID, "task", rt, "error", err)
+		return l.asyncCall(ctx, sector, rt, work)
+	}
+
+	if res, ok := l.reusableResult(ctx, sector, rt, needed, key, decode); ok {
+		log.Infow("returning stored result of task dispatched again", "sector", sector.ID, "task", rt)
+		return l.startCall(ctx, sector, rt, true, func(context.Context, storiface.CallID) (interface{}, error) {
+			return res, nil
+		})
+	}
+
+	return l.asyncCall(ctx, sector, rt, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		res, err := work(ctx, ci)
+		if err != nil {
+			return res, err
+		}
+
+		if err := l.ct.storeResult(sector.ID, rt, key, res); err != nil {
+			log.Warnw("storing task result", "sector", sector.ID, "task", rt, "error", err)
+		}
+		return res, nil
+	})
+}
+
+func (l *LocalWorker) reusableResult(ctx context.Context, sector storage.SectorRef, rt ReturnType, needed storiface.SectorFileType, key []byte, decode func(json.RawMessage) (interface{}, error)) (interface{}, bool) {
+	rb, ok, err := l.ct.storedResult(sector.ID, rt, key)
+	if err != nil {
+		log.Warnw("reading stored task result", "sector", sector.ID, "task", rt, "error", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	for _, fileType := range storiface.PathTypes {
+		if fileType&needed == 0 {
+			continue
+		}
+
+		si, err := l.sindex.StorageFindSector(ctx, sector.ID, fileType, 0, false)
+		if err != nil || len(si) == 0 {
+			log.Infow("not reusing stored task result, sector file missing", "sector", sector.ID, "task", rt, "file", fileType, "error", err)
+			return nil, false
+		}
+	}
+
+	res, err := decode(rb)
+	if err != nil {
+		log.Warnw("decoding stored task result", "sector", sector.ID, "task", rt, "error", err)
+		return nil, false
+	}
+
+	return res, true
+}
+
+func decodePreCommit1Out(b json.RawMessage) (interface{}, error) {
+	var out storage.PreCommit1Out
+	err := json.Unmarshal(b, &out)
+	return out, err
+}
+
+func decodeCommit1Out(b json.RawMessage) (interface{}, error) {
+	var out storage.Commit1Out
+	err := json.Unmarshal(b, &out)
+	return out, err
+}
+
+func decodeProof(b json.RawMessage) (interface{}, error) {
+	var out storage.Proof
+	err := json.Unmarshal(b, &out)
+	return out, err
+}
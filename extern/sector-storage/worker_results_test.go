@@ -0,0 +1,88 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+type c2Exec struct {
+	testExec
+
+	calls int
+}
+
+func (e *c2Exec) SealCommit2(ctx context.Context, sector storage.SectorRef, c1o storage.Commit1Out) (storage.Proof, error) {
+	e.calls++
+	return append(storage.Proof{byte(e.calls)}, c1o...), nil
+}
+
+type c2Returns struct {
+	storiface.WorkerReturn
+
+	errs   chan *storiface.CallError
+	proofs chan storage.Proof
+}
+
+func (r *c2Returns) ReturnSealCommit2(ctx context.Context, callID storiface.CallID, proof storage.Proof, err *storiface.CallError) error {
+	r.errs <- err
+	r.proofs <- proof
+	return nil
+}
+
+func TestStoredResults(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &c2Returns{errs: make(chan *storiface.CallError, 1), proofs: make(chan storage.Proof, 1)}
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	exec := &c2Exec{}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	c2 := func(c1o storage.Commit1Out) storage.Proof {
+		_, err := w.SealCommit2(ctx, sector, c1o)
+		require.NoError(t, err)
+		require.Nil(t, <-ret.errs)
+		return <-ret.proofs
+	}
+
+	first := c2(storage.Commit1Out("c1o"))
+	require.Equal(t, 1, exec.calls)
+
+	// dispatching the task again returns the stored result
+	require.Equal(t, first, c2(storage.Commit1Out("c1o")))
+	require.Equal(t, 1, exec.calls)
+
+	// stored results don't count as unfinished calls
+	unfinished, err := w.ct.unfinished()
+	require.NoError(t, err)
+	require.Empty(t, unfinished)
+
+	// other inputs are computed
+	other := c2(storage.Commit1Out("other"))
+	require.Equal(t, 2, exec.calls)
+	require.NotEqual(t, first, other)
+
+	require.Equal(t, other, c2(storage.Commit1Out("other")))
+	require.Equal(t, 2, exec.calls)
+
+	// removing the sector forgets its results
+	require.NoError(t, w.Remove(ctx, sector.ID))
+	c2(storage.Commit1Out("other"))
+	require.Equal(t, 3, exec.calls)
+}
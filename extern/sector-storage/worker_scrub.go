@@ -0,0 +1,281 @@
+package sectorstorage
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// how many findings are kept for ScrubFindings
+var maxScrubFindings = 256
+
+// sectorScrubber keeps what the background scrubber found, see
+// WorkerConfig.ScrubInterval
+type sectorScrubber struct {
+	interval time.Duration // 0 = scrubbing disabled
+
+	lk       sync.Mutex
+	findings []storiface.ScrubFinding
+}
+
+func (s *sectorScrubber) add(found []storiface.ScrubFinding) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.findings = append(s.findings, found...)
+	if over := len(s.findings) - maxScrubFindings; over > 0 {
+		s.findings = append([]storiface.ScrubFinding{}, s.findings[over:]...)
+	}
+}
+
+func (s *sectorScrubber) list() []storiface.ScrubFinding {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return append([]storiface.ScrubFinding{}, s.findings...)
+}
+
+// VerifySector checks the sealed replica and cache of the sector in local
+// paths of the worker, like the background scrubber does. Files are checked
+// for their size and for the cache files needed for proving; their contents
+// aren't read, see VerifySectorUpdates for that.
+func (l *LocalWorker) VerifySector(ctx context.Context, sector storage.SectorRef) error {
+	ssize, err := l.startVerify(ctx, sector)
+	if err != nil {
+		return err
+	}
+
+	// the lock is held until lctx is cancelled
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := l.sindex.StorageLock(lctx, sector.ID, storiface.FTSealed|storiface.FTCache, storiface.FTNone); err != nil {
+		return xerrors.Errorf("locking sector files: %w", err)
+	}
+
+	return l.checkLocalSector(ctx, sector.ID, ssize)
+}
+
+// startVerify checks that the sector can be verified by the worker, returning
+// its size
+func (l *LocalWorker) startVerify(ctx context.Context, sector storage.SectorRef) (abi.SectorSize, error) {
+	if err := l.checkNamespace(sector.ID); err != nil {
+		return 0, err
+	}
+
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return 0, xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	sealed, err := l.localSectorPaths(ctx, sector.ID, storiface.FTSealed)
+	if err != nil {
+		return 0, err
+	}
+	if len(sealed) == 0 {
+		return 0, xerrors.Errorf("sector %d has no sealed replica in local paths", sector.ID)
+	}
+
+	return ssize, nil
+}
+
+// checkLocalSector is verifyLocalSector, returning an error for the first bad
+// file found
+func (l *LocalWorker) checkLocalSector(ctx context.Context, sector abi.SectorID, ssize abi.SectorSize) error {
+	found, err := l.verifyLocalSector(ctx, sector, ssize)
+	if err != nil {
+		return err
+	}
+	if len(found) > 0 {
+		return xerrors.Errorf("sector %d has %d bad files, first %s in %s: %s", sector, len(found), found[0].Type, found[0].Path, found[0].Error)
+	}
+	return nil
+}
+
+// ScrubFindings returns recent problems found by the background scrubber,
+// oldest first
+func (l *LocalWorker) ScrubFindings(ctx context.Context) ([]storiface.ScrubFinding, error) {
+	return l.scrub.list(), nil
+}
+
+// verifyLocalSector checks sealed and cache files of the sector in local paths,
+// the caller must hold a read lock on them
+func (l *LocalWorker) verifyLocalSector(ctx context.Context, sector abi.SectorID, ssize abi.SectorSize) ([]storiface.ScrubFinding, error) {
+	var out []storiface.ScrubFinding
+	for _, fileType := range []storiface.SectorFileType{storiface.FTSealed, storiface.FTCache} {
+		paths, err := l.localSectorPaths(ctx, sector, fileType)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(paths) == 0 {
+			// the file can be in a path of another worker
+			found, err := l.sindex.StorageFindSector(ctx, sector, fileType, 0, false)
+			if err != nil {
+				return nil, xerrors.Errorf("finding sector %d (%s): %w", sector, fileType, err)
+			}
+			if len(found) == 0 {
+				out = append(out, storiface.ScrubFinding{
+					Sector: sector,
+					Type:   fileType,
+					Error:  "not found in any storage path",
+					Found:  time.Now(),
+				})
+			}
+			continue
+		}
+
+		for _, p := range paths {
+			if err := checkSectorFile(p, fileType, ssize); err != nil {
+				out = append(out, storiface.ScrubFinding{
+					Sector: sector,
+					Type:   fileType,
+					Path:   p,
+					Error:  err.Error(),
+					Found:  time.Now(),
+				})
+			}
+		}
+	}
+	return out, nil
+}
+
+// scrubLoop walks sectors with sealed replicas in local paths, checking one
+// sector each scrub interval. Sectors are skipped while they are paused or have
+// calls in progress, and the scrubber waits while tasks are running on the
+// worker, so that it doesn't compete with them for disk bandwidth.
+func (l *LocalWorker) scrubLoop() {
+	defer close(l.scrubDone)
+
+	if l.scrub.interval <= 0 {
+		return
+	}
+
+	ctx := &wctx{
+		vals:    context.Background(),
+		closing: l.closing,
+	}
+
+	for {
+		files, err := l.localSectorFiles(ctx, storiface.FTSealed)
+		if err != nil {
+			log.Errorw("listing sectors to scrub", "error", err)
+		}
+
+		if len(files) == 0 && !l.scrubWait() {
+			return
+		}
+
+		for _, f := range files {
+			if !l.scrubWait() {
+				return
+			}
+			l.scrubSector(ctx, f)
+		}
+	}
+}
+
+// scrubWait waits for the scrub interval, and until no tasks are running.
+// Returns false when the worker is closing.
+func (l *LocalWorker) scrubWait() bool {
+	for {
+		select {
+		case <-time.After(l.scrub.interval):
+		case <-l.closing:
+			return false
+		}
+
+		if !l.tasksRunning() {
+			return true
+		}
+	}
+}
+
+func (l *LocalWorker) scrubSector(ctx context.Context, f localSectorFile) {
+	if l.paused.paused(f.Sector) {
+		return
+	}
+
+	active, err := l.ct.activeSectors()
+	if err != nil {
+		log.Errorw("getting active sectors", "error", err)
+		return
+	}
+	if _, ok := active[f.Sector]; ok {
+		return
+	}
+
+	// the lock is held until lctx is cancelled
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	locked, err := l.sindex.StorageTryLock(lctx, f.Sector, storiface.FTSealed|storiface.FTCache, storiface.FTNone)
+	if err != nil {
+		log.Errorw("locking sector files to scrub", "sector", f.Sector, "error", err)
+		return
+	}
+	if !locked {
+		// being written, checked in the next pass
+		return
+	}
+
+	st, err := os.Stat(f.Path)
+	if err != nil {
+		// removed since it was listed
+		log.Debugw("scrubbing sector", "sector", f.Sector, "error", err)
+		return
+	}
+
+	var found []storiface.ScrubFinding
+	// the scrubber finds sectors in local paths without their proof type, the
+	// size of the sealed replica is the sector size
+	if ssize := abi.SectorSize(st.Size()); sdrLayers[ssize] > 0 {
+		found, err = l.verifyLocalSector(ctx, f.Sector, ssize)
+		if err != nil {
+			log.Errorw("scrubbing sector", "sector", f.Sector, "error", err)
+			return
+		}
+	} else {
+		found = []storiface.ScrubFinding{{
+			Sector: f.Sector,
+			Type:   storiface.FTSealed,
+			Path:   f.Path,
+			Error:  "sealed file size doesn't match a sector size",
+			Found:  time.Now(),
+		}}
+	}
+
+	recordScrubbedSector(ctx, len(found) == 0)
+	if len(found) == 0 {
+		return
+	}
+
+	l.scrub.add(found)
+	for _, sf := range found {
+		log.Errorw("scrubber found a bad sector file", "sector", sf.Sector, "type", sf.Type, "path", sf.Path, "error", sf.Error)
+		if l.webhook != nil {
+			l.webhook.emit(TaskEvent{
+				Event:  TaskEventScrubFailure,
+				Time:   sf.Found,
+				Sector: sf.Sector,
+				Error:  sf.Error,
+			})
+		}
+	}
+}
+
+// tasksRunning is true while tasks use resources of the worker
+func (l *LocalWorker) tasksRunning() bool {
+	l.activeLk.Lock()
+	defer l.activeLk.Unlock()
+
+	return l.active.cpuUse > 0 || l.active.gpuUsed || l.active.memUsedMin > 0
+}
@@ -0,0 +1,88 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestScrubber(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{ScrubInterval: 5 * time.Millisecond}, nil)
+	defer cleanup()
+
+	sectorRef := func(n abi.SectorNumber) storage.SectorRef {
+		return storage.SectorRef{
+			ID:        abi.SectorID{Miner: 1000, Number: n},
+			ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+		}
+	}
+	addCache := func(sector storage.SectorRef, files ...string) {
+		dir := filepath.Join(p.LocalPath, storiface.FTCache.String(), storiface.SectorName(sector.ID))
+		require.NoError(t, os.Mkdir(dir, 0755))
+		require.NoError(t, si.StorageDeclareSector(ctx, p.ID, sector.ID, storiface.FTCache, true))
+		for _, f := range files {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644))
+		}
+	}
+	badSectors := func() map[abi.SectorID]bool {
+		found, err := w.ScrubFindings(ctx)
+		require.NoError(t, err)
+
+		out := map[abi.SectorID]bool{}
+		for _, sf := range found {
+			out[sf.Sector] = true
+		}
+		return out
+	}
+
+	// hold the scrubber back as if a task was running
+	w.activeLk.Lock()
+	w.active.cpuUse = 1
+	w.activeLk.Unlock()
+
+	good := sectorRef(1)
+	writeTestSectorFile(ctx, t, p, si, good.ID, storiface.FTSealed, 2048)
+	addCache(good, "t_aux", "p_aux", "sc-02-data-tree-r-last.dat")
+	require.NoError(t, w.VerifySector(ctx, good))
+
+	incomplete := sectorRef(2)
+	writeTestSectorFile(ctx, t, p, si, incomplete.ID, storiface.FTSealed, 2048)
+	addCache(incomplete, "t_aux")
+	require.Error(t, w.VerifySector(ctx, incomplete))
+
+	truncated := sectorRef(3)
+	writeTestSectorFile(ctx, t, p, si, truncated.ID, storiface.FTSealed, 1000)
+	addCache(truncated, "t_aux", "p_aux", "sc-02-data-tree-r-last.dat")
+
+	paused := sectorRef(4)
+	writeTestSectorFile(ctx, t, p, si, paused.ID, storiface.FTSealed, 2048)
+	require.NoError(t, w.PauseSector(ctx, paused.ID))
+
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, badSectors())
+
+	w.activeLk.Lock()
+	w.active.cpuUse = 0
+	w.activeLk.Unlock()
+
+	require.Eventually(t, func() bool {
+		bad := badSectors()
+		return bad[incomplete.ID] && bad[truncated.ID]
+	}, 5*time.Second, 10*time.Millisecond)
+
+	bad := badSectors()
+	require.False(t, bad[good.ID])
+	require.False(t, bad[paused.ID])
+}
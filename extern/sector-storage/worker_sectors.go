@@ -0,0 +1,563 @@
+package sectorstorage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+type localSectorFile struct {
+	Sector  abi.SectorID
+	Type    storiface.SectorFileType
+	Storage stores.ID
+	Path    string
+	ModTime time.Time
+}
+
+// localSectorFiles lists sector files of the given types present in local storage paths
+func (l *LocalWorker) localSectorFiles(ctx context.Context, types storiface.SectorFileType) ([]localSectorFile, error) {
+	if l.localStore == nil {
+		return nil, nil
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	var out []localSectorFile
+	for _, p := range paths {
+		for _, fileType := range storiface.PathTypes {
+			if fileType&types == 0 {
+				continue
+			}
+
+			dir := filepath.Join(p.LocalPath, fileType.String())
+			ents, err := ioutil.ReadDir(dir)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, xerrors.Errorf("listing %s: %w", dir, err)
+			}
+
+			for _, ent := range ents {
+				if ent.Name() == stores.FetchTempSubdir {
+					continue
+				}
+
+				sid, err := storiface.ParseSectorID(ent.Name())
+				if err != nil {
+					log.Warnf("skipping unknown file %s: %s", filepath.Join(dir, ent.Name()), err)
+					continue
+				}
+
+				out = append(out, localSectorFile{
+					Sector:  sid,
+					Type:    fileType,
+					Storage: p.ID,
+					Path:    filepath.Join(dir, ent.Name()),
+					ModTime: ent.ModTime(),
+				})
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func allFileTypes() storiface.SectorFileType {
+	var out storiface.SectorFileType
+	for _, fileType := range storiface.PathTypes {
+		out |= fileType
+	}
+	return out
+}
+
+// diskUsage returns bytes used on disk by a file, or all files in a directory
+func diskUsage(path string) (int64, error) {
+	var used int64
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		si, err := fsutil.FileSize(p)
+		if err != nil {
+			return err
+		}
+
+		used += si.OnDisk
+		return nil
+	})
+
+	return used, err
+}
+
+// sectorFinalized returns true when a primary sealed copy of the sector is
+// declared in long-term storage
+func (l *LocalWorker) sectorFinalized(ctx context.Context, sector abi.SectorID) (bool, error) {
+	si, err := l.sindex.StorageFindSector(ctx, sector, storiface.FTSealed, 0, false)
+	if err != nil {
+		return false, xerrors.Errorf("finding sealed sector %d: %w", sector, err)
+	}
+
+	for _, info := range si {
+		if info.Primary && info.CanStore {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// PurgeUnsealed removes local unsealed copies of finalized sectors matching the
+// policy, and returns how many bytes were freed. Sectors with calls in progress
+// on this worker, or with unsealed data locked in the index (e.g. being read)
+// are never touched.
+func (l *LocalWorker) PurgeUnsealed(ctx context.Context, policy storiface.UnsealedPurgePolicy) (int64, error) {
+	files, err := l.localSectorFiles(ctx, storiface.FTUnsealed)
+	if err != nil {
+		return 0, err
+	}
+
+	active, err := l.ct.activeSectors()
+	if err != nil {
+		return 0, xerrors.Errorf("getting active sectors: %w", err)
+	}
+
+	skip := map[abi.SectorID]struct{}{}
+	for _, sid := range policy.Pinned {
+		skip[sid] = struct{}{}
+	}
+	for sid := range active {
+		skip[sid] = struct{}{}
+	}
+
+	bySector := map[abi.SectorID][]localSectorFile{}
+	for _, f := range files {
+		if _, ok := skip[f.Sector]; ok {
+			continue
+		}
+
+		if time.Since(f.ModTime) < policy.MinAge {
+			skip[f.Sector] = struct{}{}
+			delete(bySector, f.Sector)
+			continue
+		}
+
+		bySector[f.Sector] = append(bySector[f.Sector], f)
+	}
+
+	var freed int64
+	for sid, sfiles := range bySector {
+		finalized, err := l.sectorFinalized(ctx, sid)
+		if err != nil {
+			return freed, err
+		}
+		if !finalized {
+			continue
+		}
+
+		n, err := l.purgeSectorUnsealed(ctx, sid, sfiles)
+		if err != nil {
+			return freed, xerrors.Errorf("purging unsealed data of sector %d: %w", sid, err)
+		}
+
+		freed += n
+	}
+
+	return freed, nil
+}
+
+func (l *LocalWorker) purgeSectorUnsealed(ctx context.Context, sid abi.SectorID, files []localSectorFile) (int64, error) {
+	// the lock is held until lctx is cancelled
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	locked, err := l.sindex.StorageTryLock(lctx, sid, storiface.FTNone, storiface.FTUnsealed)
+	if err != nil {
+		return 0, xerrors.Errorf("locking unsealed data: %w", err)
+	}
+	if !locked {
+		log.Debugf("not purging unsealed data of sector %d, sector is locked", sid)
+		return 0, nil
+	}
+
+	var used int64
+	for _, f := range files {
+		n, err := diskUsage(f.Path)
+		if err != nil {
+			log.Warnf("getting disk usage of %s: %+v", f.Path, err)
+			continue
+		}
+
+		used += n
+	}
+
+	if err := l.localStore.Remove(ctx, sid, storiface.FTUnsealed, true); err != nil {
+		return 0, xerrors.Errorf("removing unsealed data: %w", err)
+	}
+
+	return used, nil
+}
+
+// MoveSectorToPath moves local sector files of the given types into the
+// specified local storage path, e.g. to get them off a failing disk. Sectors
+// with tasks running on this worker are not moved, and the call waits for
+// other users of the files (e.g. piece readers) to release them.
+func (l *LocalWorker) MoveSectorToPath(ctx context.Context, sector abi.SectorID, types storiface.SectorFileType, dest stores.ID) error {
+	if err := l.checkNamespace(sector); err != nil {
+		return err
+	}
+	if l.localStore == nil {
+		return xerrors.Errorf("worker doesn't use local storage paths")
+	}
+
+	active, err := l.ct.activeSectors()
+	if err != nil {
+		return xerrors.Errorf("getting active sectors: %w", err)
+	}
+
+	if _, ok := active[sector]; ok {
+		return xerrors.Errorf("sector %d has tasks in progress", sector)
+	}
+
+	// the lock is held until lctx is cancelled
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := l.sindex.StorageLock(lctx, sector, storiface.FTNone, types); err != nil {
+		return xerrors.Errorf("locking sector files: %w", err)
+	}
+
+	if err := l.localStore.MoveSector(ctx, sector, types, dest); err != nil {
+		return xerrors.Errorf("moving sector %d: %w", sector, err)
+	}
+
+	return nil
+}
+
+// ResetToUnsealed removes the sealed replica and cache of a sector, keeping its
+// unsealed data, so that the sector can be sealed again (e.g. after a fault
+// was detected) without fetching the pieces again. Files are removed from all
+// paths they are declared in. Sectors with tasks running on this worker, and
+// sectors without unsealed data are refused.
+func (l *LocalWorker) ResetToUnsealed(ctx context.Context, sector abi.SectorID) error {
+	if err := l.checkNamespace(sector); err != nil {
+		return err
+	}
+	active, err := l.ct.activeSectors()
+	if err != nil {
+		return xerrors.Errorf("getting active sectors: %w", err)
+	}
+
+	if _, ok := active[sector]; ok {
+		return xerrors.Errorf("sector %d has tasks in progress", sector)
+	}
+
+	// the lock is held until lctx is cancelled, unsealed data is read-locked
+	// so that it can't be removed in the meantime
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := l.sindex.StorageLock(lctx, sector, storiface.FTUnsealed, storiface.FTSealed|storiface.FTCache); err != nil {
+		return xerrors.Errorf("locking sector files: %w", err)
+	}
+
+	unsealed, err := l.sindex.StorageFindSector(ctx, sector, storiface.FTUnsealed, 0, false)
+	if err != nil {
+		return xerrors.Errorf("finding unsealed sector %d: %w", sector, err)
+	}
+	if len(unsealed) == 0 {
+		return xerrors.Errorf("sector %d has no unsealed data to seal from", sector)
+	}
+
+	for _, fileType := range []storiface.SectorFileType{storiface.FTSealed, storiface.FTCache} {
+		if err := l.storage.Remove(ctx, sector, fileType, true); err != nil {
+			return xerrors.Errorf("removing sector %d (%s): %w", sector, fileType, err)
+		}
+	}
+
+	if err := l.ct.dropResults(sector); err != nil {
+		return xerrors.Errorf("dropping stored task results: %w", err)
+	}
+
+	return nil
+}
+
+// ListSectors returns all sectors with files in local storage, ordered by
+// sector ID
+func (l *LocalWorker) ListSectors(ctx context.Context) ([]storiface.SectorSummary, error) {
+	files, err := l.localSectorFiles(ctx, allFileTypes())
+	if err != nil {
+		return nil, err
+	}
+
+	types := map[abi.SectorID]storiface.SectorFileType{}
+	for _, f := range files {
+		types[f.Sector] |= f.Type
+	}
+
+	out := make([]storiface.SectorSummary, 0, len(types))
+	for sid, ft := range types {
+		out = append(out, storiface.SectorSummary{
+			Sector: sid,
+			Types:  ft,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return sectorLess(out[i].Sector, out[j].Sector)
+	})
+
+	return out, nil
+}
+
+// how long sector listings are kept for getting further pages
+const sectorListingTTL = 10 * time.Minute
+
+// sectorListings keeps snapshots of sector listings which are being paged
+// through, so that pages are consistent with each other, and local storage is
+// only listed once
+type sectorListings struct {
+	lk       sync.Mutex
+	listings map[uuid.UUID]*sectorListing
+}
+
+type sectorListing struct {
+	sectors []storiface.SectorSummary
+	expires time.Time
+}
+
+// ListSectorsPage returns up to limit (0 = no limit) sectors with files in
+// local storage, ordered by sector ID. The first page is returned for an empty
+// cursor, further pages are returned for the Next cursor of the previous page.
+// All pages come from a snapshot taken when the first page was requested, and
+// cursors expire when unused for a while.
+func (l *LocalWorker) ListSectorsPage(ctx context.Context, cursor string, limit int) (storiface.SectorListPage, error) {
+	var id uuid.UUID
+	var offset int
+	var listing *sectorListing
+
+	if cursor == "" {
+		sectors, err := l.ListSectors(ctx)
+		if err != nil {
+			return storiface.SectorListPage{}, err
+		}
+
+		id = uuid.New()
+		listing = &sectorListing{sectors: sectors}
+	} else {
+		var err error
+		id, offset, err = parseListCursor(cursor)
+		if err != nil {
+			return storiface.SectorListPage{}, err
+		}
+	}
+
+	return l.sectorListings.page(id, listing, offset, limit)
+}
+
+// page returns a page of the listing with the given id, listing is nil when
+// getting pages of an existing listing
+func (sl *sectorListings) page(id uuid.UUID, listing *sectorListing, offset int, limit int) (storiface.SectorListPage, error) {
+	sl.lk.Lock()
+	defer sl.lk.Unlock()
+
+	now := time.Now()
+	for lid, l := range sl.listings {
+		if now.After(l.expires) {
+			delete(sl.listings, lid)
+		}
+	}
+
+	if listing == nil {
+		listing = sl.listings[id]
+		if listing == nil {
+			return storiface.SectorListPage{}, xerrors.Errorf("sector listing %s not found, it may have expired", id)
+		}
+	}
+
+	if offset > len(listing.sectors) {
+		return storiface.SectorListPage{}, xerrors.Errorf("cursor offset %d past the end of the listing", offset)
+	}
+
+	end := len(listing.sectors)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	out := storiface.SectorListPage{
+		Sectors: listing.sectors[offset:end],
+	}
+
+	if end == len(listing.sectors) {
+		delete(sl.listings, id)
+		return out, nil
+	}
+
+	if sl.listings == nil {
+		sl.listings = map[uuid.UUID]*sectorListing{}
+	}
+	listing.expires = now.Add(sectorListingTTL)
+	sl.listings[id] = listing
+
+	out.Next = fmt.Sprintf("%s/%d", id, end)
+	return out, nil
+}
+
+func parseListCursor(cursor string) (uuid.UUID, int, error) {
+	parts := strings.SplitN(cursor, "/", 2)
+	if len(parts) != 2 {
+		return uuid.UUID{}, 0, xerrors.Errorf("malformed sector listing cursor '%s'", cursor)
+	}
+
+	id, err := uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.UUID{}, 0, xerrors.Errorf("parsing sector listing cursor id: %w", err)
+	}
+
+	offset, err := strconv.Atoi(parts[1])
+	if err != nil || offset < 0 {
+		return uuid.UUID{}, 0, xerrors.Errorf("malformed sector listing cursor offset '%s'", parts[1])
+	}
+
+	return id, offset, nil
+}
+
+func sectorLess(a, b abi.SectorID) bool {
+	if a.Miner != b.Miner {
+		return a.Miner < b.Miner
+	}
+	return a.Number < b.Number
+}
+
+// RedeclareSectors declares all sector files found in local storage paths in
+// the sector index, e.g. after the index was lost or rebuilt. Files are
+// declared as primary in paths which can store sectors, same as when the path
+// is opened. Files already declared aren't declared again, so it's safe to
+// call repeatedly. Returns the number of declarations made.
+func (l *LocalWorker) RedeclareSectors(ctx context.Context) (int, error) {
+	if l.localStore == nil {
+		return 0, nil
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return 0, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	canStore := map[stores.ID]bool{}
+	for _, p := range paths {
+		canStore[p.ID] = p.CanStore
+	}
+
+	files, err := l.localSectorFiles(ctx, allFileTypes())
+	if err != nil {
+		return 0, err
+	}
+
+	var declared int
+	for _, f := range files {
+		primary := canStore[f.Storage]
+
+		found, err := l.sindex.StorageFindSector(ctx, f.Sector, f.Type, 0, false)
+		if err != nil {
+			return declared, xerrors.Errorf("finding sector %d (%s): %w", f.Sector, f.Type, err)
+		}
+
+		var known bool
+		for _, info := range found {
+			if info.ID == f.Storage && (info.Primary || !primary) {
+				known = true
+				break
+			}
+		}
+		if known {
+			continue
+		}
+
+		if err := l.sindex.StorageDeclareSector(ctx, f.Storage, f.Sector, f.Type, primary); err != nil {
+			return declared, xerrors.Errorf("declare sector %d(t:%s) -> %s: %w", f.Sector, f.Type, f.Storage, err)
+		}
+
+		declared++
+	}
+
+	return declared, nil
+}
+
+// sectorLimitCall starts an async call which may bring a new sector to the
+// worker, checking that the sector limit isn't exceeded first. When it is, or
+// the worker is evacuated, the call is rejected with
+// storiface.ErrTempTooManySectors, so that the manager can retry it on another
+// worker.
+func (l *LocalWorker) sectorLimitCall(ctx context.Context, sector storage.SectorRef, rt ReturnType, work func(ctx context.Context, ci storiface.CallID) (interface{}, error)) (storiface.CallID, error) {
+	if l.maxSectors <= 0 && !l.evac.active() {
+		return l.asyncCall(ctx, sector, rt, work)
+	}
+
+	// held until the call is tracked, so that concurrent calls for new
+	// sectors count each other
+	l.sectorLimitLk.Lock()
+	defer l.sectorLimitLk.Unlock()
+
+	if err := l.checkSectorLimit(ctx, sector.ID); err != nil {
+		var cerr *storiface.CallError
+		if xerrors.As(err, &cerr) && cerr.Code == storiface.ErrTempTooManySectors {
+			return l.rejectCall(ctx, sector, rt, err)
+		}
+		return storiface.UndefCall, err
+	}
+
+	return l.asyncCall(ctx, sector, rt, work)
+}
+
+func (l *LocalWorker) checkSectorLimit(ctx context.Context, sector abi.SectorID) error {
+	held, err := l.ListSectors(ctx)
+	if err != nil {
+		return xerrors.Errorf("listing sectors: %w", err)
+	}
+
+	// sectors with calls in progress may not have files yet
+	sectors, err := l.ct.activeSectors()
+	if err != nil {
+		return xerrors.Errorf("getting active sectors: %w", err)
+	}
+
+	for _, s := range held {
+		sectors[s.Sector] = struct{}{}
+	}
+
+	if _, ok := sectors[sector]; ok {
+		return nil
+	}
+
+	if l.evac.active() {
+		return storiface.Err(storiface.ErrTempTooManySectors, xerrors.Errorf("worker is evacuated, not accepting new sectors"))
+	}
+
+	if l.maxSectors > 0 && len(sectors) >= l.maxSectors {
+		return storiface.Err(storiface.ErrTempTooManySectors, xerrors.Errorf("worker already holds the maximum of %d sectors", l.maxSectors))
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestPurgeUnsealed(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	finalized := abi.SectorID{Miner: 1000, Number: 1}
+	pinned := abi.SectorID{Miner: 1000, Number: 2}
+	sealing := abi.SectorID{Miner: 1000, Number: 3}
+	reading := abi.SectorID{Miner: 1000, Number: 4}
+
+	for _, sid := range []abi.SectorID{finalized, pinned, reading} {
+		writeTestSectorFile(ctx, t, p, si, sid, storiface.FTSealed, 1)
+	}
+
+	var unsealed []string
+	for _, sid := range []abi.SectorID{finalized, pinned, sealing, reading} {
+		unsealed = append(unsealed, writeTestSectorFile(ctx, t, p, si, sid, storiface.FTUnsealed, 16<<10))
+	}
+
+	// hold a read lock as ReadPiece would
+	rctx, rcancel := context.WithCancel(ctx)
+	defer rcancel()
+	require.NoError(t, si.StorageLock(rctx, reading, storiface.FTUnsealed, storiface.FTNone))
+
+	freed, err := w.PurgeUnsealed(ctx, storiface.UnsealedPurgePolicy{MinAge: time.Hour})
+	require.NoError(t, err)
+	require.Zero(t, freed)
+
+	freed, err = w.PurgeUnsealed(ctx, storiface.UnsealedPurgePolicy{Pinned: []abi.SectorID{pinned}})
+	require.NoError(t, err)
+	require.True(t, freed >= 16<<10)
+
+	_, err = os.Stat(unsealed[0])
+	require.True(t, os.IsNotExist(err))
+
+	for _, spath := range unsealed[1:] {
+		_, err := os.Stat(spath)
+		require.NoError(t, err)
+	}
+
+	found, err := si.StorageFindSector(ctx, finalized, storiface.FTUnsealed, 0, false)
+	require.NoError(t, err)
+	require.Empty(t, found)
+}
+
+func TestMoveSectorToPath(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "sector-storage-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint
+
+	b, err := json.Marshal(&stores.LocalStorageMeta{
+		ID:       stores.ID(uuid.New().String()),
+		Weight:   1,
+		CanStore: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, stores.MetaFile), b, 0644))
+	require.NoError(t, w.localStore.OpenPath(ctx, dir))
+
+	paths, err := w.localStore.Local(ctx)
+	require.NoError(t, err)
+	require.Len(t, paths, 2)
+
+	var dest stores.StoragePath
+	for _, sp := range paths {
+		if sp.ID != p.ID {
+			dest = sp
+		}
+	}
+
+	sid := abi.SectorID{Miner: 1000, Number: 1}
+	spath := writeTestSectorFile(ctx, t, p, si, sid, storiface.FTSealed, 1<<10)
+
+	require.NoError(t, w.MoveSectorToPath(ctx, sid, storiface.FTSealed, dest.ID))
+
+	_, err = os.Stat(spath)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dest.LocalPath, storiface.FTSealed.String(), storiface.SectorName(sid)))
+	require.NoError(t, err)
+
+	found, err := si.StorageFindSector(ctx, sid, storiface.FTSealed, 0, false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, dest.ID, found[0].ID)
+
+	// the source stays declared when the move fails
+	sealedDir := filepath.Join(p.LocalPath, storiface.FTSealed.String())
+	require.NoError(t, os.RemoveAll(sealedDir))
+	require.Error(t, w.MoveSectorToPath(ctx, sid, storiface.FTSealed, p.ID))
+	require.NoError(t, os.Mkdir(sealedDir, 0755))
+
+	found, err = si.StorageFindSector(ctx, sid, storiface.FTSealed, 0, false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, dest.ID, found[0].ID)
+
+	// missing files are reported
+	err = w.MoveSectorToPath(ctx, sid, storiface.FTSealed|storiface.FTCache, p.ID)
+	require.True(t, xerrors.Is(err, storiface.ErrSectorNotFound))
+
+	// moves wait for locked files to be released
+	lctx, lcancel := context.WithCancel(ctx)
+	defer lcancel()
+	require.NoError(t, si.StorageLock(lctx, sid, storiface.FTSealed, storiface.FTNone))
+
+	tctx, tcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer tcancel()
+	require.Error(t, w.MoveSectorToPath(tctx, sid, storiface.FTSealed, p.ID))
+}
+
+func TestListSectors(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	s1 := abi.SectorID{Miner: 1000, Number: 1}
+	s2 := abi.SectorID{Miner: 1000, Number: 2}
+	s3 := abi.SectorID{Miner: 1001, Number: 0}
+
+	writeTestSectorFile(ctx, t, p, si, s3, storiface.FTUnsealed, 1)
+	writeTestSectorFile(ctx, t, p, si, s1, storiface.FTSealed, 1)
+	writeTestSectorFile(ctx, t, p, si, s2, storiface.FTSealed, 1)
+	writeTestSectorFile(ctx, t, p, si, s1, storiface.FTUnsealed, 1)
+
+	all, err := w.ListSectors(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []storiface.SectorSummary{
+		{Sector: s1, Types: storiface.FTSealed | storiface.FTUnsealed},
+		{Sector: s2, Types: storiface.FTSealed},
+		{Sector: s3, Types: storiface.FTUnsealed},
+	}, all)
+
+	first, err := w.ListSectorsPage(ctx, "", 2)
+	require.NoError(t, err)
+	require.Equal(t, all[:2], first.Sectors)
+	require.NotEmpty(t, first.Next)
+
+	// pages come from the snapshot taken for the first page
+	s0 := abi.SectorID{Miner: 1000, Number: 0}
+	writeTestSectorFile(ctx, t, p, si, s0, storiface.FTSealed, 1)
+
+	last, err := w.ListSectorsPage(ctx, first.Next, 2)
+	require.NoError(t, err)
+	require.Equal(t, all[2:], last.Sectors)
+	require.Empty(t, last.Next)
+
+	// the listing is dropped after the last page
+	_, err = w.ListSectorsPage(ctx, first.Next, 2)
+	require.Error(t, err)
+
+	_, err = w.ListSectorsPage(ctx, "bad-cursor", 2)
+	require.Error(t, err)
+
+	whole, err := w.ListSectorsPage(ctx, "", 0)
+	require.NoError(t, err)
+	require.Len(t, whole.Sectors, 4)
+	require.Empty(t, whole.Next)
+}
+
+func TestRedeclareSectors(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	s1 := abi.SectorID{Miner: 1000, Number: 1}
+	s2 := abi.SectorID{Miner: 1000, Number: 2}
+
+	writeTestSectorFile(ctx, t, p, si, s1, storiface.FTSealed, 1)
+
+	// files not in the index, as if the index was lost
+	for _, ft := range []storiface.SectorFileType{storiface.FTSealed, storiface.FTUnsealed} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(p.LocalPath, ft.String(), storiface.SectorName(s2)), []byte{1}, 0644))
+	}
+
+	n, err := w.RedeclareSectors(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	found, err := si.StorageFindSector(ctx, s2, storiface.FTUnsealed, 0, false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, p.ID, found[0].ID)
+	require.True(t, found[0].Primary)
+
+	n, err = w.RedeclareSectors(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+type sectorLimitReturns struct {
+	storiface.WorkerReturn
+
+	errs chan *storiface.CallError
+}
+
+func (r *sectorLimitReturns) ReturnFetch(ctx context.Context, callID storiface.CallID, err *storiface.CallError) error {
+	r.errs <- err
+	return nil
+}
+
+func TestMaxSectors(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &sectorLimitReturns{errs: make(chan *storiface.CallError, 1)}
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{MaxSectors: 2}, ret)
+	defer cleanup()
+
+	held := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 1}}
+	running := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 2}}
+	added := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 3}}
+
+	writeTestSectorFile(ctx, t, p, si, held.ID, storiface.FTUnsealed, 1)
+
+	// a call for a sector which has no files yet
+	require.NoError(t, w.ct.onStart(storiface.CallID{Sector: running.ID, ID: uuid.New()}, AddPiece))
+
+	// rejected with the call result, so that the manager can retry elsewhere
+	_, err := w.Fetch(ctx, added, storiface.FTNone, storiface.PathSealing, storiface.AcquireCopy)
+	require.NoError(t, err)
+	cerr := <-ret.errs
+	require.NotNil(t, cerr)
+	require.Equal(t, storiface.ErrTempTooManySectors, cerr.Code)
+	require.True(t, cerr.Retryable)
+
+	// sectors the worker already has are fine
+	for _, sector := range []storage.SectorRef{held, running} {
+		_, err = w.Fetch(ctx, sector, storiface.FTNone, storiface.PathSealing, storiface.AcquireCopy)
+		require.NoError(t, err)
+		require.Nil(t, <-ret.errs)
+	}
+}
+
+func TestResetToUnsealed(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	noUnsealed := abi.SectorID{Miner: 1000, Number: 2}
+
+	var sealed []string
+	for _, sid := range []abi.SectorID{sector, noUnsealed} {
+		sealed = append(sealed,
+			writeTestSectorFile(ctx, t, p, si, sid, storiface.FTSealed, 1<<10),
+			writeTestSectorFile(ctx, t, p, si, sid, storiface.FTCache, 1<<10))
+	}
+	unsealed := writeTestSectorFile(ctx, t, p, si, sector, storiface.FTUnsealed, 1<<10)
+
+	require.Error(t, w.ResetToUnsealed(ctx, noUnsealed))
+	for _, f := range sealed {
+		require.FileExists(t, f)
+	}
+
+	call := storiface.CallID{Sector: sector, ID: uuid.New()}
+	require.NoError(t, w.ct.onStart(call, SealPreCommit1))
+	require.Error(t, w.ResetToUnsealed(ctx, sector))
+	require.NoError(t, w.ct.onDone(call, nil))
+	require.NoError(t, w.ct.onReturned(call))
+
+	require.NoError(t, w.ResetToUnsealed(ctx, sector))
+	require.NoFileExists(t, sealed[0])
+	require.NoFileExists(t, sealed[1])
+	require.FileExists(t, sealed[2])
+	require.FileExists(t, unsealed)
+
+	for _, ft := range []storiface.SectorFileType{storiface.FTSealed, storiface.FTCache} {
+		found, err := si.StorageFindSector(ctx, sector, ft, 0, false)
+		require.NoError(t, err)
+		require.Empty(t, found)
+	}
+	found, err := si.StorageFindSector(ctx, sector, storiface.FTUnsealed, 0, false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+}
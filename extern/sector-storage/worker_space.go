@@ -0,0 +1,73 @@
+package sectorstorage
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// sector files which exist, at their full sealing size, once a task completes
+var taskFileTypes = map[sealtasks.TaskType]storiface.SectorFileType{
+	sealtasks.TTAddPiece:   storiface.FTUnsealed,
+	sealtasks.TTPreCommit1: storiface.FTUnsealed | storiface.FTSealed | storiface.FTCache,
+	sealtasks.TTPreCommit2: storiface.FTUnsealed | storiface.FTSealed | storiface.FTCache,
+	sealtasks.TTCommit1:    storiface.FTUnsealed | storiface.FTSealed | storiface.FTCache,
+	sealtasks.TTCommit2:    storiface.FTUnsealed | storiface.FTSealed | storiface.FTCache,
+
+	sealtasks.TTReplicaUpdate:       storiface.FTUnsealed | storiface.FTSealed | storiface.FTCache | storiface.FTUpdate | storiface.FTUpdateCache,
+	sealtasks.TTProveReplicaUpdate1: storiface.FTUnsealed | storiface.FTSealed | storiface.FTCache | storiface.FTUpdate | storiface.FTUpdateCache,
+	sealtasks.TTProveReplicaUpdate2: storiface.FTUnsealed | storiface.FTSealed | storiface.FTCache | storiface.FTUpdate | storiface.FTUpdateCache,
+}
+
+// RemainingSpaceNeeded estimates how many more bytes of local storage the
+// sector needs until upToTask completes, based on the sealing overhead of each
+// file type (storiface.FSOverheadSeal) and the sector files already present in
+// local storage paths.
+func (l *LocalWorker) RemainingSpaceNeeded(ctx context.Context, sector storage.SectorRef, upToTask sealtasks.TaskType) (int64, error) {
+	types, ok := taskFileTypes[upToTask]
+	if !ok {
+		return 0, xerrors.Errorf("no space estimate for task %s", upToTask)
+	}
+
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return 0, xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	files, err := l.localSectorFiles(ctx, types)
+	if err != nil {
+		return 0, err
+	}
+
+	present := map[storiface.SectorFileType]int64{}
+	for _, f := range files {
+		if f.Sector != sector.ID {
+			continue
+		}
+
+		used, err := diskUsage(f.Path)
+		if err != nil {
+			return 0, xerrors.Errorf("getting disk usage of %s: %w", f.Path, err)
+		}
+		present[f.Type] += used
+	}
+
+	var need int64
+	for _, fileType := range storiface.PathTypes {
+		if !types.Has(fileType) {
+			continue
+		}
+
+		total := int64(storiface.FSOverheadSeal[fileType]) * int64(ssize) / storiface.FSOverheadDen
+		if total > present[fileType] {
+			need += total - present[fileType]
+		}
+	}
+
+	return need, nil
+}
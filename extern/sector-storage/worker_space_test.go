@@ -0,0 +1,52 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestRemainingSpaceNeeded(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	ssize := int64(2048)
+	sealed := ssize
+	cache := int64(storiface.FSOverheadSeal[storiface.FTCache]) * ssize / storiface.FSOverheadDen
+
+	need, err := w.RemainingSpaceNeeded(ctx, sector, sealtasks.TTAddPiece)
+	require.NoError(t, err)
+	require.Equal(t, ssize, need)
+
+	need, err = w.RemainingSpaceNeeded(ctx, sector, sealtasks.TTPreCommit2)
+	require.NoError(t, err)
+	require.Equal(t, ssize+sealed+cache, need)
+
+	// unsealed data is already there, files of other sectors don't count
+	writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTUnsealed, int(ssize))
+	writeTestSectorFile(ctx, t, p, si, abi.SectorID{Miner: 1000, Number: 2}, storiface.FTSealed, int(ssize))
+
+	need, err = w.RemainingSpaceNeeded(ctx, sector, sealtasks.TTAddPiece)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), need)
+
+	need, err = w.RemainingSpaceNeeded(ctx, sector, sealtasks.TTPreCommit2)
+	require.NoError(t, err)
+	require.Equal(t, sealed+cache, need)
+
+	_, err = w.RemainingSpaceNeeded(ctx, sector, sealtasks.TTFetch)
+	require.Error(t, err)
+}
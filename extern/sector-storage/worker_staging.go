@@ -0,0 +1,407 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+	"github.com/filecoin-project/lotus/extern/storage-sealing/lib/nullreader"
+)
+
+var ErrStagingDisabled = errors.New("worker has no piece staging area")
+
+// name of the file listing the staged pieces of a sector, in the sector's
+// staging directory
+const stagedPiecesFile = "staged.json"
+
+// pieceStaging keeps pieces in a directory until they are added to their
+// sector, one subdirectory per sector, see WorkerConfig.StagingDir. What is
+// staged is read from disk, so staged pieces survive restarts.
+type pieceStaging struct {
+	dir string
+
+	lk sync.Mutex
+	// sectors being staged to or committed, those can't be changed by other
+	// calls
+	busy map[abi.SectorID]string
+}
+
+type stagedSector struct {
+	ProofType abi.RegisteredSealProof
+	Pieces    []storiface.StagedPiece
+}
+
+func newPieceStaging(dir string) *pieceStaging {
+	if dir == "" {
+		return nil
+	}
+
+	return &pieceStaging{
+		dir:  dir,
+		busy: map[abi.SectorID]string{},
+	}
+}
+
+func (ps *pieceStaging) sectorDir(sector abi.SectorID) string {
+	return filepath.Join(ps.dir, storiface.SectorName(sector))
+}
+
+func (ps *pieceStaging) pieceFile(sector abi.SectorID, i int) string {
+	return filepath.Join(ps.sectorDir(sector), strconv.Itoa(i)+".piece")
+}
+
+// begin marks the sector busy, the returned func must be called when done
+func (ps *pieceStaging) begin(sector abi.SectorID, op string) (func(), error) {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+
+	if busy, ok := ps.busy[sector]; ok {
+		return nil, xerrors.Errorf("sector %d is busy %s staged pieces", sector, busy)
+	}
+	ps.busy[sector] = op
+
+	return func() {
+		ps.lk.Lock()
+		defer ps.lk.Unlock()
+
+		delete(ps.busy, sector)
+	}, nil
+}
+
+// staged reads the staged pieces of the sector, nil when it has none
+func (ps *pieceStaging) staged(sector abi.SectorID) (*stagedSector, error) {
+	b, err := ioutil.ReadFile(filepath.Join(ps.sectorDir(sector), stagedPiecesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("reading staged pieces: %w", err)
+	}
+
+	var ss stagedSector
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return nil, xerrors.Errorf("decoding staged pieces: %w", err)
+	}
+	return &ss, nil
+}
+
+func (ps *pieceStaging) write(sector abi.SectorID, ss *stagedSector) error {
+	b, err := json.MarshalIndent(ss, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("marshaling staged pieces: %w", err)
+	}
+
+	p := filepath.Join(ps.sectorDir(sector), stagedPiecesFile)
+	if err := ioutil.WriteFile(p+".tmp", b, 0644); err != nil {
+		return xerrors.Errorf("writing staged pieces: %w", err)
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// StagePiece keeps piece data in the staging area of the worker, to be added
+// to the sector by CommitStaged with the pieces staged before it. Pieces are
+// written and synced before StagePiece returns, and the staged pieces of a
+// sector must fit in it, including alignment padding. Staging area files are
+// only removed by CommitStaged and RemoveStaged.
+func (l *LocalWorker) StagePiece(ctx context.Context, sector storage.SectorRef, size abi.UnpaddedPieceSize, data storage.Data) error {
+	if err := l.checkNamespace(sector.ID); err != nil {
+		return err
+	}
+	if l.staging == nil {
+		return ErrStagingDisabled
+	}
+	if err := size.Validate(); err != nil {
+		return xerrors.Errorf("invalid piece size: %w", err)
+	}
+
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	done, err := l.staging.begin(sector.ID, "staging")
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ss, err := l.staging.staged(sector.ID)
+	if err != nil {
+		return err
+	}
+	if ss == nil {
+		ss = &stagedSector{ProofType: sector.ProofType}
+	}
+	if ss.ProofType != sector.ProofType {
+		return xerrors.Errorf("sector %d has pieces staged with proof type %d, not %d", sector.ID, ss.ProofType, sector.ProofType)
+	}
+
+	// pieces are aligned to their padded size in the sector
+	var filled abi.PaddedPieceSize
+	for _, sp := range append(append([]storiface.StagedPiece{}, ss.Pieces...), storiface.StagedPiece{Size: size}) {
+		psz := sp.Size.Padded()
+		if rem := filled % psz; rem != 0 {
+			filled += psz - rem
+		}
+		filled += psz
+	}
+	if filled > abi.PaddedPieceSize(ssize) {
+		return xerrors.Errorf("staged pieces would take %d bytes of the %d byte sector", filled, ssize)
+	}
+
+	if err := os.MkdirAll(l.staging.sectorDir(sector.ID), 0755); err != nil { // nolint
+		return xerrors.Errorf("creating staging directory: %w", err)
+	}
+
+	p := l.staging.pieceFile(sector.ID, len(ss.Pieces))
+	if err := writeStagedPiece(p, size, data); err != nil {
+		return xerrors.Errorf("staging piece: %w", err)
+	}
+
+	ss.Pieces = append(ss.Pieces, storiface.StagedPiece{Size: size, Staged: time.Now()})
+	if err := l.staging.write(sector.ID, ss); err != nil {
+		return err
+	}
+
+	log.Infow("staged piece", "sector", sector.ID, "size", size, "pieces", len(ss.Pieces))
+	return nil
+}
+
+func writeStagedPiece(p string, size abi.UnpaddedPieceSize, data io.Reader) error {
+	f, err := os.OpenFile(p+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644) // nolint
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(f, data, int64(size)); err != nil {
+		_ = f.Close()
+		return xerrors.Errorf("reading piece data: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return xerrors.Errorf("syncing piece data: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+// CommitStaged adds the staged pieces of the sector to it with AddPiece, in
+// the order they were staged, and removes them from the staging area. The first
+// piece is added like the first piece of AddPiece, see ErrSectorOccupied. When
+// adding a piece fails, the pieces added before it are removed again, leaving
+// the sector without unsealed data, and all pieces stay staged.
+//
+// Staged pieces are aligned to their size in the sector, pieces of zeros are
+// added before them as needed. Unlike AddPiece, the piece infos, including the
+// padding pieces, are returned to the caller, not through the worker return
+// API.
+func (l *LocalWorker) CommitStaged(ctx context.Context, sector storage.SectorRef) ([]abi.PieceInfo, error) {
+	if err := l.checkNamespace(sector.ID); err != nil {
+		return nil, err
+	}
+	if l.staging == nil {
+		return nil, ErrStagingDisabled
+	}
+	if err := l.checkPaused(sector.ID, AddPiece); err != nil {
+		return nil, err
+	}
+
+	done, err := l.staging.begin(sector.ID, "committing")
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	ss, err := l.staging.staged(sector.ID)
+	if err != nil {
+		return nil, err
+	}
+	if ss == nil || len(ss.Pieces) == 0 {
+		return nil, xerrors.Errorf("sector %d has no staged pieces", sector.ID)
+	}
+	if ss.ProofType != sector.ProofType {
+		return nil, xerrors.Errorf("sector %d has pieces staged with proof type %d, not %d", sector.ID, ss.ProofType, sector.ProofType)
+	}
+
+	sb, err := l.sb()
+	if err != nil {
+		return nil, err
+	}
+
+	ci := storiface.CallID{Sector: sector.ID, ID: l.callIDs()}
+	res, err := l.runCall(ctx, sector, AddPiece, ci, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		var pieces []abi.PieceInfo
+		var epcs []abi.UnpaddedPieceSize
+		var offset abi.PaddedPieceSize
+
+		fail := func(err error) (interface{}, error) {
+			if len(pieces) > 0 {
+				// the first piece cleans up after itself
+				l.removeCommittedPieces(ctx, sector.ID)
+			}
+			return nil, err
+		}
+
+		for i, sp := range ss.Pieces {
+			// pieces are aligned to their size in the sector, like the
+			// sealing FSM does, with pieces of zeros filling the gap
+			pads, _ := ffiwrapper.GetRequiredPadding(offset, sp.Size.Padded())
+			for _, pad := range pads {
+				pi, err := l.addPiece(ctx, sb, sector, epcs, pad.Unpadded(), io.LimitReader(nullreader.Reader{}, int64(pad.Unpadded())))
+				if err != nil {
+					return fail(xerrors.Errorf("adding padding before staged piece %d of %d: %w", i+1, len(ss.Pieces), err))
+				}
+
+				pieces = append(pieces, pi)
+				epcs = append(epcs, pad.Unpadded())
+				offset += pad
+			}
+
+			pi, err := l.addStagedPiece(ctx, sb, sector, epcs, sp.Size, l.staging.pieceFile(sector.ID, i))
+			if err != nil {
+				return fail(xerrors.Errorf("adding staged piece %d of %d: %w", i+1, len(ss.Pieces), err))
+			}
+
+			pieces = append(pieces, pi)
+			epcs = append(epcs, sp.Size)
+			offset += sp.Size.Padded()
+		}
+		return pieces, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.RemoveAll(l.staging.sectorDir(sector.ID)); err != nil {
+		log.Errorw("removing committed staged pieces", "sector", sector.ID, "error", err)
+	}
+
+	pieces := res.([]abi.PieceInfo)
+	log.Infow("committed staged pieces", "sector", sector.ID, "pieces", len(pieces))
+	return pieces, nil
+}
+
+func (l *LocalWorker) addStagedPiece(ctx context.Context, sb ffiwrapper.Storage, sector storage.SectorRef, epcs []abi.UnpaddedPieceSize, size abi.UnpaddedPieceSize, path string) (abi.PieceInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("opening staged piece: %w", err)
+	}
+	defer f.Close() // nolint
+
+	return l.addPiece(ctx, sb, sector, epcs, size, f)
+}
+
+// removeCommittedPieces removes unsealed data of a sector CommitStaged failed
+// to add all pieces to
+func (l *LocalWorker) removeCommittedPieces(ctx context.Context, sector abi.SectorID) {
+	// not interrupted by the call being cancelled
+	cctx := &wctx{
+		vals:    ctx,
+		closing: l.closing,
+	}
+
+	if err := l.storage.Remove(cctx, sector, storiface.FTUnsealed, true); err != nil {
+		log.Errorw("removing unsealed data of failed staged pieces commit", "sector", sector, "error", err)
+	}
+	l.pieces.drop(sector)
+	l.pieceCopies.drop(sector)
+}
+
+// RemoveStaged removes the staged pieces of a sector
+func (l *LocalWorker) RemoveStaged(ctx context.Context, sector abi.SectorID) error {
+	if l.staging == nil {
+		return ErrStagingDisabled
+	}
+
+	done, err := l.staging.begin(sector, "removing")
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if err := os.RemoveAll(l.staging.sectorDir(sector)); err != nil {
+		return xerrors.Errorf("removing staged pieces: %w", err)
+	}
+	return nil
+}
+
+// StagingInfo returns the staged pieces of all sectors, and the space left in
+// the staging area
+func (l *LocalWorker) StagingInfo(ctx context.Context) (storiface.StagingInfo, error) {
+	if l.staging == nil {
+		return storiface.StagingInfo{}, ErrStagingDisabled
+	}
+
+	if err := os.MkdirAll(l.staging.dir, 0755); err != nil { // nolint
+		return storiface.StagingInfo{}, xerrors.Errorf("creating staging area: %w", err)
+	}
+
+	st, err := fsutil.Statfs(l.staging.dir)
+	if err != nil {
+		return storiface.StagingInfo{}, xerrors.Errorf("getting staging area capacity: %w", err)
+	}
+
+	out := storiface.StagingInfo{
+		Dir:       l.staging.dir,
+		Capacity:  st.Capacity,
+		Available: st.Available,
+	}
+
+	ents, err := ioutil.ReadDir(l.staging.dir)
+	if err != nil {
+		return storiface.StagingInfo{}, xerrors.Errorf("listing staging area: %w", err)
+	}
+
+	for _, ent := range ents {
+		sector, err := storiface.ParseSectorID(ent.Name())
+		if err != nil {
+			continue
+		}
+
+		ss, err := l.staging.staged(sector)
+		if err != nil {
+			return storiface.StagingInfo{}, xerrors.Errorf("sector %d: %w", sector, err)
+		}
+		if ss == nil {
+			continue
+		}
+
+		for _, sp := range ss.Pieces {
+			out.Used += int64(sp.Size)
+		}
+		out.Sectors = append(out.Sectors, storiface.StagedSector{
+			Sector:    sector,
+			ProofType: ss.ProofType,
+			Pieces:    ss.Pieces,
+		})
+	}
+
+	sort.Slice(out.Sectors, func(i, j int) bool {
+		a, b := out.Sectors[i].Sector, out.Sectors[j].Sector
+		if a.Miner != b.Miner {
+			return a.Miner < b.Miner
+		}
+		return a.Number < b.Number
+	})
+
+	return out, nil
+}
@@ -0,0 +1,189 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+)
+
+type stagingExec struct {
+	addPieceExec
+
+	calls  int
+	failAt int
+
+	// existing piece sizes passed with each call
+	existing [][]abi.UnpaddedPieceSize
+}
+
+func (e *stagingExec) AddPiece(ctx context.Context, sector storage.SectorRef, pieceSizes []abi.UnpaddedPieceSize, newPieceSize abi.UnpaddedPieceSize, pieceData storage.Data) (abi.PieceInfo, error) {
+	e.calls++
+	e.existing = append(e.existing, append([]abi.UnpaddedPieceSize{}, pieceSizes...))
+	if e.calls == e.failAt {
+		return abi.PieceInfo{}, xerrors.New("add piece failed")
+	}
+	return e.addPieceExec.AddPiece(ctx, sector, pieceSizes, newPieceSize, pieceData)
+}
+
+func TestStagePieces(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "lotus-staging-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{StagingDir: dir}, nil)
+	defer cleanup()
+
+	exec := &stagingExec{}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	var all []byte
+	for i := 0; i < 3; i++ {
+		data := bytes.Repeat([]byte{byte(i + 1)}, 127)
+		require.NoError(t, w.StagePiece(ctx, sector, 127, bytes.NewReader(data)))
+		all = append(all, data...)
+	}
+
+	// doesn't fit after the staged pieces
+	require.Error(t, w.StagePiece(ctx, sector, 2032, bytes.NewReader(make([]byte, 2032))))
+
+	info, err := w.StagingInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3*127), info.Used)
+	require.Len(t, info.Sectors, 1)
+	require.Equal(t, sector.ID, info.Sectors[0].Sector)
+	require.Len(t, info.Sectors[0].Pieces, 3)
+
+	// staged pieces are found by a new worker
+	w2, _, _, cleanup2 := newTestLocalWorker(ctx, t, WorkerConfig{StagingDir: dir}, nil)
+	info2, err := w2.StagingInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, info.Sectors, info2.Sectors)
+	cleanup2()
+
+	// failing the second piece keeps all pieces staged
+	exec.failAt = 2
+	_, err = w.CommitStaged(ctx, sector)
+	require.Error(t, err)
+	require.Equal(t, 2, exec.calls)
+
+	info, err = w.StagingInfo(ctx)
+	require.NoError(t, err)
+	require.Len(t, info.Sectors, 1)
+	require.Len(t, info.Sectors[0].Pieces, 3)
+
+	exec.calls, exec.failAt = 0, 0
+	exec.unsealed = nil
+	pieces, err := w.CommitStaged(ctx, sector)
+	require.NoError(t, err)
+	require.Len(t, pieces, 3)
+	require.Equal(t, 3, exec.calls)
+	require.Equal(t, all, exec.unsealed)
+
+	info, err = w.StagingInfo(ctx)
+	require.NoError(t, err)
+	require.Empty(t, info.Sectors)
+	require.Zero(t, info.Used)
+
+	_, err = w.CommitStaged(ctx, sector)
+	require.Error(t, err)
+
+	// removing staged pieces
+	other := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 2},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	require.NoError(t, w.StagePiece(ctx, other, 127, bytes.NewReader(make([]byte, 127))))
+	require.NoError(t, w.RemoveStaged(ctx, other.ID))
+
+	info, err = w.StagingInfo(ctx)
+	require.NoError(t, err)
+	require.Empty(t, info.Sectors)
+}
+
+func TestCommitStagedPadding(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "lotus-staging-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{StagingDir: dir}, nil)
+	defer cleanup()
+
+	exec := &stagingExec{}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	small := bytes.Repeat([]byte{1}, 127)
+	large := bytes.Repeat([]byte{2}, 1016)
+	require.NoError(t, w.StagePiece(ctx, sector, 127, bytes.NewReader(small)))
+	require.NoError(t, w.StagePiece(ctx, sector, 1016, bytes.NewReader(large)))
+
+	pieces, err := w.CommitStaged(ctx, sector)
+	require.NoError(t, err)
+
+	// the large piece goes at offset 1024, after padding of 128+256+512
+	var sizes []abi.PaddedPieceSize
+	for _, pi := range pieces {
+		sizes = append(sizes, pi.Size)
+	}
+	require.Equal(t, []abi.PaddedPieceSize{128, 128, 256, 512, 1024}, sizes)
+	require.Equal(t, []abi.UnpaddedPieceSize{127, 127, 254, 508}, exec.existing[len(exec.existing)-1])
+
+	zero, err := ffiwrapper.GeneratePieceCIDFromFile(sector.ProofType, bytes.NewReader(make([]byte, 254)), 254)
+	require.NoError(t, err)
+	require.Equal(t, zero, pieces[2].PieceCID)
+
+	require.Equal(t, small, exec.unsealed[:127])
+	require.Equal(t, make([]byte, 1016-127), exec.unsealed[127:1016])
+	require.Equal(t, large, exec.unsealed[1016:])
+
+	var total abi.PaddedPieceSize
+	for _, s := range sizes {
+		total += s
+	}
+	require.Equal(t, abi.PaddedPieceSize(2048), total)
+}
+
+func TestStagingDisabled(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	err := w.StagePiece(ctx, sector, 127, bytes.NewReader(make([]byte, 127)))
+	require.True(t, xerrors.Is(err, ErrStagingDisabled))
+
+	_, err = w.CommitStaged(ctx, sector)
+	require.True(t, xerrors.Is(err, ErrStagingDisabled))
+}
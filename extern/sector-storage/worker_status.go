@@ -0,0 +1,88 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// ListCalls returns calls the worker tracks which weren't returned to the
+// manager yet, running ones and ones whose results are being returned
+func (l *LocalWorker) ListCalls(ctx context.Context) ([]storiface.DiagnosticCall, error) {
+	return l.diagnosticCalls()
+}
+
+// StatusHandler serves a read-only JSON view of the worker, for inspecting it
+// with e.g. curl without the RPC stack:
+//
+//	GET /info     Info
+//	GET /calls    ListCalls
+//	GET /paths    Paths
+//	GET /healthy  Healthy, responds with 503 and the error when unhealthy
+//
+// It doesn't authenticate requests, so it should only be reachable by
+// operators.
+func (l *LocalWorker) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", statusEndpoint(func(ctx context.Context) (interface{}, error) {
+		return l.Info(ctx)
+	}))
+	mux.HandleFunc("/calls", statusEndpoint(func(ctx context.Context) (interface{}, error) {
+		return l.ListCalls(ctx)
+	}))
+	mux.HandleFunc("/paths", statusEndpoint(func(ctx context.Context) (interface{}, error) {
+		return l.Paths(ctx)
+	}))
+	mux.HandleFunc("/healthy", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		status := struct {
+			Healthy bool
+			Error   string `json:",omitempty"`
+		}{Healthy: true}
+		code := http.StatusOK
+		if err := l.Healthy(r.Context()); err != nil {
+			status.Healthy, status.Error = false, err.Error()
+			code = http.StatusServiceUnavailable
+		}
+
+		writeStatus(w, code, status)
+	})
+
+	return mux
+}
+
+func statusEndpoint(get func(ctx context.Context) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		res, err := get(r.Context())
+		if err != nil {
+			log.Warnw("serving worker status", "path", r.URL.Path, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeStatus(w, http.StatusOK, res)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(append(b, '\n'))
+}
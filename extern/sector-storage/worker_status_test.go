@@ -0,0 +1,68 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestStatusHandler(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	srv := httptest.NewServer(w.StatusHandler())
+	defer srv.Close()
+
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint
+
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		return resp.StatusCode
+	}
+
+	var info storiface.WorkerInfo
+	require.Equal(t, http.StatusOK, get("/info", &info))
+	require.NotEmpty(t, info.Hostname)
+
+	var paths []stores.StoragePath
+	require.Equal(t, http.StatusOK, get("/paths", &paths))
+	require.Len(t, paths, 1)
+	require.Equal(t, p.ID, paths[0].ID)
+
+	var calls []storiface.DiagnosticCall
+	require.Equal(t, http.StatusOK, get("/calls", &calls))
+	require.Empty(t, calls)
+
+	var health struct {
+		Healthy bool
+		Error   string
+	}
+	require.Equal(t, http.StatusOK, get("/healthy", &health))
+	require.True(t, health.Healthy)
+
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return nil, xerrors.New("no proofs backend")
+	}
+	require.Equal(t, http.StatusServiceUnavailable, get("/healthy", &health))
+	require.False(t, health.Healthy)
+	require.Contains(t, health.Error, "no proofs backend")
+
+	resp, err := http.Post(srv.URL+"/info", "application/json", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,87 @@
+package sectorstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+var ErrStorageMissing = errors.New("storage removed from the sector index")
+
+// StorageMissingError is returned when storage holding sector files used by a
+// call isn't in the sector index anymore, e.g. because the operator detached
+// it while the call was running. It's reported to the manager as
+// storiface.ErrMissingStorage.
+type StorageMissingError struct {
+	ID       stores.ID
+	Sector   abi.SectorID
+	FileType storiface.SectorFileType
+}
+
+func (e *StorageMissingError) Error() string {
+	return fmt.Sprintf("%s: storage %s holding %s of sector %d", ErrStorageMissing, e.ID, e.FileType, e.Sector)
+}
+
+func (e *StorageMissingError) Unwrap() error {
+	return ErrStorageMissing
+}
+
+// missingStorage returns a StorageMissingError when a local storage path
+// holding sector files used by a call was removed from the sector index. Other
+// storage isn't checked, stores which don't keep files in local paths may use
+// storage IDs the index never knew.
+func (l *LocalWorker) missingStorage(ctx context.Context, sector abi.SectorID, types storiface.SectorFileType, storageIDs storiface.SectorPaths) error {
+	if l.localStore == nil {
+		return nil
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	local := map[stores.ID]struct{}{}
+	for _, p := range paths {
+		local[p.ID] = struct{}{}
+	}
+
+	for _, fileType := range storiface.PathTypes {
+		if fileType&types == 0 {
+			continue
+		}
+
+		id := stores.ID(storiface.PathByType(storageIDs, fileType))
+		if _, ok := local[id]; !ok {
+			continue
+		}
+
+		_, err := l.sindex.StorageInfo(ctx, id)
+		if err == nil {
+			continue
+		}
+		if !isStorageNotFound(err) {
+			return xerrors.Errorf("getting storage info of %s: %w", id, err)
+		}
+
+		return storiface.Err(storiface.ErrMissingStorage, &StorageMissingError{
+			ID:       id,
+			Sector:   sector,
+			FileType: fileType,
+		})
+	}
+
+	return nil
+}
+
+// errors returned by a remote sector index only keep their message
+func isStorageNotFound(err error) bool {
+	return xerrors.Is(err, stores.ErrStorageNotFound) || strings.Contains(err.Error(), stores.ErrStorageNotFound.Error())
+}
@@ -0,0 +1,105 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// detachingIndex reports detached storage as unknown, like an index the
+// storage was removed from
+type detachingIndex struct {
+	stores.SectorIndex
+
+	lk       sync.Mutex
+	detached map[stores.ID]bool
+}
+
+func (i *detachingIndex) detach(id stores.ID) {
+	i.lk.Lock()
+	defer i.lk.Unlock()
+
+	i.detached[id] = true
+}
+
+func (i *detachingIndex) StorageInfo(ctx context.Context, id stores.ID) (stores.StorageInfo, error) {
+	i.lk.Lock()
+	detached := i.detached[id]
+	i.lk.Unlock()
+
+	if detached {
+		// errors of remote indexes only keep their message
+		return stores.StorageInfo{}, xerrors.New(stores.ErrStorageNotFound.Error())
+	}
+	return i.SectorIndex.StorageInfo(ctx, id)
+}
+
+func TestStorageMissingDuringCall(t *testing.T) {
+	ctx := context.Background()
+
+	oldInterval := offlinePathPollInterval
+	offlinePathPollInterval = 10 * time.Millisecond
+	defer func() {
+		offlinePathPollInterval = oldInterval
+	}()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	idx := &detachingIndex{SectorIndex: si, detached: map[stores.ID]bool{}}
+	w.sindex = idx
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	pp := &localWorkerPathProvider{w: w, op: storiface.AcquireMove}
+
+	cctx, cancel := w.callContext(ctx, storiface.CallID{Sector: sector.ID, ID: uuid.New()})
+	defer cancel()
+
+	_, done, err := pp.AcquireSector(cctx, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing)
+	require.NoError(t, err)
+	require.Len(t, w.localStore.Reservations(), 1)
+
+	idx.detach(p.ID)
+
+	select {
+	case <-cctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("call not aborted")
+	}
+
+	cause := callAbortCause(cctx)
+	require.True(t, xerrors.Is(cause, ErrStorageMissing))
+
+	var merr *StorageMissingError
+	require.True(t, xerrors.As(cause, &merr))
+	require.Equal(t, p.ID, merr.ID)
+	require.Equal(t, storiface.FTUnsealed, merr.FileType)
+	require.Equal(t, storiface.ErrMissingStorage, toCallError(cause).Code)
+
+	done()
+	require.Empty(t, w.localStore.Reservations())
+
+	// not declared in the missing storage
+	found, err := si.StorageFindSector(ctx, sector.ID, storiface.FTUnsealed, 0, false)
+	require.NoError(t, err)
+	require.Empty(t, found)
+
+	// calls don't start with missing storage
+	_, _, err = pp.AcquireSector(ctx, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathSealing)
+	require.True(t, xerrors.Is(err, ErrStorageMissing))
+	require.Empty(t, w.localStore.Reservations())
+}
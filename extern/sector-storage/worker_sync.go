@@ -0,0 +1,74 @@
+package sectorstorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// syncSectorFiles flushes sector files of the given types kept in local storage
+// paths to disk, together with the directories holding them, when the worker
+// is configured with SyncWrites
+func (l *LocalWorker) syncSectorFiles(ctx context.Context, sector abi.SectorID, types storiface.SectorFileType) error {
+	if !l.syncWrites {
+		return nil
+	}
+
+	for _, fileType := range storiface.PathTypes {
+		if fileType&types == 0 {
+			continue
+		}
+
+		paths, err := l.localSectorPaths(ctx, sector, fileType)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range paths {
+			if err := syncTree(p); err != nil {
+				return xerrors.Errorf("syncing %s: %w", p, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// syncTree fsyncs a file, or a directory with everything in it, and the
+// directory containing it, so that the entry itself is durable too
+func syncTree(p string) error {
+	err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		return syncFile(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	return syncFile(filepath.Dir(p))
+}
+
+func syncFile(p string) error {
+	f, err := os.Open(p) // nolint
+	if err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,41 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestSyncSectorFiles(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{SyncWrites: true}, nil)
+	defer cleanup()
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	writeTestSectorFile(ctx, t, p, si, sector, storiface.FTUnsealed, 1<<10)
+
+	cache := filepath.Join(p.LocalPath, storiface.FTCache.String(), storiface.SectorName(sector))
+	require.NoError(t, os.Mkdir(cache, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cache, "p_aux"), []byte("p_aux"), 0644))
+	require.NoError(t, si.StorageDeclareSector(ctx, p.ID, sector, storiface.FTCache, true))
+
+	// types without files are skipped
+	require.NoError(t, w.syncSectorFiles(ctx, sector, storiface.FTUnsealed|storiface.FTSealed|storiface.FTCache))
+
+	// files declared in local paths, but missing, can't be synced
+	missing := abi.SectorID{Miner: 1000, Number: 2}
+	require.NoError(t, si.StorageDeclareSector(ctx, p.ID, missing, storiface.FTSealed, true))
+	require.Error(t, w.syncSectorFiles(ctx, missing, storiface.FTSealed))
+
+	w.syncWrites = false
+	require.NoError(t, w.syncSectorFiles(ctx, missing, storiface.FTSealed))
+}
@@ -0,0 +1,199 @@
+package sectorstorage
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// ParseTaskLimits parses concurrency limits of task types, given as
+// task=limit, e.g. seal/v0/precommit/1=4
+func ParseTaskLimits(specs []string) (map[sealtasks.TaskType]int, error) {
+	known := map[sealtasks.TaskType]bool{}
+	for _, tt := range returnTaskType {
+		known[tt] = true
+	}
+
+	out := map[sealtasks.TaskType]int{}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 {
+			return nil, xerrors.Errorf("invalid task limit %q, expected task=limit", spec)
+		}
+
+		tt := sealtasks.TaskType(kv[0])
+		if !known[tt] {
+			return nil, xerrors.Errorf("unknown task type %q in task limit %q", kv[0], spec)
+		}
+		limit, err := strconv.Atoi(kv[1])
+		if err != nil || limit < 1 {
+			return nil, xerrors.Errorf("invalid limit in task limit %q, expected a positive number", spec)
+		}
+		if l, ok := out[tt]; ok && l != limit {
+			return nil, xerrors.Errorf("task %s has limits %d and %d", tt, l, limit)
+		}
+		out[tt] = limit
+	}
+
+	return out, nil
+}
+
+// taskLimits limits how many calls of each task type run at once, see
+// WorkerConfig.TaskLimits. Boosts raise the limit of a task type for a while.
+type taskLimits struct {
+	lk      sync.Mutex
+	limits  map[sealtasks.TaskType]int
+	boosts  map[sealtasks.TaskType]*taskBoost
+	running map[sealtasks.TaskType]int
+	changed chan struct{} // closed when calls end or limits change
+}
+
+type taskBoost struct {
+	limit int
+	until time.Time
+	timer *time.Timer
+}
+
+func newTaskLimits(limits map[sealtasks.TaskType]int) *taskLimits {
+	tl := &taskLimits{
+		limits:  map[sealtasks.TaskType]int{},
+		boosts:  map[sealtasks.TaskType]*taskBoost{},
+		running: map[sealtasks.TaskType]int{},
+		changed: make(chan struct{}),
+	}
+	for tt, limit := range limits {
+		if limit > 0 {
+			tl.limits[tt] = limit
+		}
+	}
+	return tl
+}
+
+// must be called with lk held, 0 means no limit
+func (tl *taskLimits) limit(tt sealtasks.TaskType) int {
+	if b, ok := tl.boosts[tt]; ok {
+		return b.limit
+	}
+	return tl.limits[tt]
+}
+
+// must be called with lk held
+func (tl *taskLimits) notify() {
+	close(tl.changed)
+	tl.changed = make(chan struct{})
+}
+
+// admit waits until the call fits under the limit of its task type. The
+// returned func must be called when the call is done.
+func (tl *taskLimits) admit(ctx context.Context, tt sealtasks.TaskType) (func(), error) {
+	tl.lk.Lock()
+	for {
+		limit := tl.limit(tt)
+		if limit == 0 || tl.running[tt] < limit {
+			break
+		}
+
+		changed := tl.changed
+		tl.lk.Unlock()
+
+		log.Debugw("waiting for task type limit", "task", tt, "limit", limit)
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, xerrors.Errorf("waiting for %s limit: %w", tt, ctx.Err())
+		}
+
+		tl.lk.Lock()
+	}
+	tl.running[tt]++
+	tl.lk.Unlock()
+
+	return func() {
+		tl.lk.Lock()
+		defer tl.lk.Unlock()
+
+		tl.running[tt]--
+		tl.notify()
+	}, nil
+}
+
+// BoostConcurrency raises the concurrency limit of a task type above the one
+// configured in WorkerConfig.TaskLimits for the duration, e.g. to catch up
+// with a backlog. The configured limit is restored when the boost ends, calls
+// running over it then keep running. Boosting a task type again replaces its
+// boost.
+func (l *LocalWorker) BoostConcurrency(ctx context.Context, tt sealtasks.TaskType, limit int, d time.Duration) error {
+	if d <= 0 {
+		return xerrors.Errorf("boost duration must be positive, got %s", d)
+	}
+
+	tl := l.taskLimits
+	tl.lk.Lock()
+	defer tl.lk.Unlock()
+
+	configured, ok := tl.limits[tt]
+	if !ok {
+		return xerrors.Errorf("task type %s has no concurrency limit to boost", tt)
+	}
+	if limit <= configured {
+		return xerrors.Errorf("boosted limit %d of %s isn't above its configured limit %d", limit, tt, configured)
+	}
+
+	if prev, ok := tl.boosts[tt]; ok {
+		prev.timer.Stop()
+	}
+
+	b := &taskBoost{
+		limit: limit,
+		until: time.Now().Add(d),
+	}
+	b.timer = time.AfterFunc(d, func() {
+		tl.lk.Lock()
+		defer tl.lk.Unlock()
+
+		if tl.boosts[tt] != b {
+			return
+		}
+		delete(tl.boosts, tt)
+		tl.notify()
+
+		log.Infow("concurrency boost ended", "task", tt, "limit", tl.limits[tt])
+	})
+	tl.boosts[tt] = b
+	tl.notify()
+
+	log.Infow("boosted task concurrency", "task", tt, "limit", limit, "configured", configured, "until", b.until)
+	return nil
+}
+
+// ConcurrencyBoosts returns the active concurrency boosts of task types
+func (l *LocalWorker) ConcurrencyBoosts(ctx context.Context) ([]storiface.ConcurrencyBoost, error) {
+	tl := l.taskLimits
+	tl.lk.Lock()
+	defer tl.lk.Unlock()
+
+	out := make([]storiface.ConcurrencyBoost, 0, len(tl.boosts))
+	for tt, b := range tl.boosts {
+		out = append(out, storiface.ConcurrencyBoost{
+			Task:       tt,
+			Limit:      b.limit,
+			Configured: tl.limits[tt],
+			Running:    tl.running[tt],
+			Until:      b.until,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Task < out[j].Task
+	})
+	return out, nil
+}
@@ -0,0 +1,100 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+)
+
+func TestParseTaskLimits(t *testing.T) {
+	limits, err := ParseTaskLimits([]string{"seal/v0/precommit/1=4", "seal/v0/commit/2=1", "seal/v0/commit/2=1"})
+	require.NoError(t, err)
+	require.Equal(t, map[sealtasks.TaskType]int{
+		sealtasks.TTPreCommit1: 4,
+		sealtasks.TTCommit2:    1,
+	}, limits)
+
+	for _, spec := range []string{"seal/v0/precommit/1", "seal/v0/nope=1", "seal/v0/precommit/1=0", "seal/v0/precommit/1=x"} {
+		_, err := ParseTaskLimits([]string{spec})
+		require.Error(t, err, spec)
+	}
+
+	_, err = ParseTaskLimits([]string{"seal/v0/commit/2=1", "seal/v0/commit/2=2"})
+	require.Error(t, err)
+}
+
+func TestBoostConcurrency(t *testing.T) {
+	ctx := context.Background()
+
+	w := &LocalWorker{taskLimits: newTaskLimits(map[sealtasks.TaskType]int{sealtasks.TTPreCommit1: 1})}
+	tl := w.taskLimits
+
+	done, err := tl.admit(ctx, sealtasks.TTPreCommit1)
+	require.NoError(t, err)
+
+	// other task types aren't limited
+	done2, err := tl.admit(ctx, sealtasks.TTCommit2)
+	require.NoError(t, err)
+	done2()
+
+	wctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = tl.admit(wctx, sealtasks.TTPreCommit1)
+	cancel()
+	require.Error(t, err)
+
+	require.Error(t, w.BoostConcurrency(ctx, sealtasks.TTCommit2, 2, time.Minute))
+	require.Error(t, w.BoostConcurrency(ctx, sealtasks.TTPreCommit1, 1, time.Minute))
+	require.Error(t, w.BoostConcurrency(ctx, sealtasks.TTPreCommit1, 2, 0))
+
+	// a waiting call is admitted once the limit is boosted
+	admitted := make(chan func())
+	go func() {
+		d, err := tl.admit(ctx, sealtasks.TTPreCommit1)
+		require.NoError(t, err)
+		admitted <- d
+	}()
+
+	select {
+	case <-admitted:
+		t.Fatal("admitted over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, w.BoostConcurrency(ctx, sealtasks.TTPreCommit1, 2, 200*time.Millisecond))
+	var boosted func()
+	select {
+	case boosted = <-admitted:
+	case <-time.After(time.Second):
+		t.Fatal("not admitted after boost")
+	}
+
+	boosts, err := w.ConcurrencyBoosts(ctx)
+	require.NoError(t, err)
+	require.Len(t, boosts, 1)
+	require.Equal(t, sealtasks.TTPreCommit1, boosts[0].Task)
+	require.Equal(t, 2, boosts[0].Limit)
+	require.Equal(t, 1, boosts[0].Configured)
+	require.Equal(t, 2, boosts[0].Running)
+
+	// the configured limit is back when the boost ends
+	require.Eventually(t, func() bool {
+		boosts, err := w.ConcurrencyBoosts(ctx)
+		require.NoError(t, err)
+		return len(boosts) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	done()
+	wctx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = tl.admit(wctx, sealtasks.TTPreCommit1)
+	cancel()
+	require.Error(t, err)
+
+	boosted()
+	done, err = tl.admit(ctx, sealtasks.TTPreCommit1)
+	require.NoError(t, err)
+	done()
+}
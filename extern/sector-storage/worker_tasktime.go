@@ -0,0 +1,78 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// taskTimes accumulates how long tasks waited on the worker before running,
+// and how long they ran
+type taskTimes struct {
+	lk    sync.Mutex
+	tasks map[sealtasks.TaskType]storiface.TaskTime
+}
+
+func newTaskTimes() *taskTimes {
+	return &taskTimes{
+		tasks: map[sealtasks.TaskType]storiface.TaskTime{},
+	}
+}
+
+// started records the time a task waited for admission and in the call queue
+func (t *taskTimes) started(ctx context.Context, tt sealtasks.TaskType, wait time.Duration) {
+	recordTaskDuration(ctx, TaskQueueWait, tt, wait)
+
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	tm := t.tasks[tt]
+	tm.Started++
+	tm.QueueWait += wait
+	t.tasks[tt] = tm
+}
+
+// executed records the time a successful task ran, and its sub-phases took.
+// Failed tasks often fail early, and would make the hardware look faster than
+// it is.
+func (t *taskTimes) executed(ctx context.Context, tt sealtasks.TaskType, took time.Duration, phases []storiface.TaskPhase) {
+	recordTaskDuration(ctx, TaskExecution, tt, took)
+
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	tm := t.tasks[tt]
+	tm.Succeeded++
+	tm.Execution += took
+	if len(phases) > 0 && tm.Phases == nil {
+		tm.Phases = map[string]time.Duration{}
+	}
+	for _, p := range phases {
+		tm.Phases[p.Phase] += p.Took
+	}
+	t.tasks[tt] = tm
+}
+
+// TaskTimes returns the cumulative time tasks of each type waited on the worker
+// before running, which is high on under-provisioned workers, and the time they
+// ran, which is high on slow hardware, since the worker started
+func (l *LocalWorker) TaskTimes(ctx context.Context) (map[sealtasks.TaskType]storiface.TaskTime, error) {
+	l.taskTimes.lk.Lock()
+	defer l.taskTimes.lk.Unlock()
+
+	out := make(map[sealtasks.TaskType]storiface.TaskTime, len(l.taskTimes.tasks))
+	for tt, t := range l.taskTimes.tasks {
+		if t.Phases != nil {
+			phases := make(map[string]time.Duration, len(t.Phases))
+			for p, d := range t.Phases {
+				phases[p] = d
+			}
+			t.Phases = phases
+		}
+		out[tt] = t
+	}
+	return out, nil
+}
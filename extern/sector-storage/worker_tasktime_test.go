@@ -0,0 +1,95 @@
+package sectorstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestTaskTimes(t *testing.T) {
+	ctx := context.Background()
+
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{MaxConcurrentCalls: 1}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	run := func(rt ReturnType, work func() error) error {
+		_, err := w.runCall(ctx, sector, rt, storiface.CallID{Sector: sector.ID, ID: uuid.New()}, func(context.Context, storiface.CallID) (interface{}, error) {
+			return nil, work()
+		})
+		return err
+	}
+
+	unblock := make(chan struct{})
+	running := make(chan struct{})
+	firstDone := make(chan error, 1)
+	go func() {
+		firstDone <- run(SealPreCommit1, func() error {
+			close(running)
+			<-unblock
+			return nil
+		})
+	}()
+	<-running
+
+	// waits in the queue for the first call
+	secondDone := make(chan error, 1)
+	go func() {
+		secondDone <- run(SealPreCommit1, func() error { return nil })
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+	require.NoError(t, <-firstDone)
+	require.NoError(t, <-secondDone)
+
+	// failed calls only count as started
+	require.Error(t, run(SealPreCommit2, func() error { return xerrors.New("failed") }))
+
+	// sub-phases timed by the proofs backend
+	var phases []storiface.TaskPhase
+	_, err := w.runCall(ctx, sector, SealCommit2, storiface.CallID{Sector: sector.ID, ID: uuid.New()}, func(ctx context.Context, _ storiface.CallID) (interface{}, error) {
+		end := ffiwrapper.StartPhase(ctx, ffiwrapper.PhaseSNARK)
+		time.Sleep(10 * time.Millisecond)
+		end()
+		phases = ffiwrapper.TaskPhases(ctx)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, phases, 1)
+	require.Equal(t, ffiwrapper.PhaseSNARK, phases[0].Phase)
+
+	times, err := w.TaskTimes(ctx)
+	require.NoError(t, err)
+	require.Len(t, times, 3)
+
+	c2 := times[sealtasks.TTCommit2]
+	require.GreaterOrEqual(t, int64(c2.Phases[ffiwrapper.PhaseSNARK]), int64(10*time.Millisecond))
+	require.LessOrEqual(t, int64(c2.Phases[ffiwrapper.PhaseSNARK]), int64(c2.Execution))
+
+	pc1 := times[sealtasks.TTPreCommit1]
+	require.Equal(t, 2, pc1.Started)
+	require.Equal(t, 2, pc1.Succeeded)
+	require.GreaterOrEqual(t, int64(pc1.QueueWait), int64(50*time.Millisecond))
+	require.GreaterOrEqual(t, int64(pc1.Execution), int64(50*time.Millisecond))
+
+	pc2 := times[sealtasks.TTPreCommit2]
+	require.Equal(t, 1, pc2.Started)
+	require.Equal(t, 0, pc2.Succeeded)
+	require.Zero(t, pc2.Execution)
+	require.Nil(t, pc1.Phases)
+}
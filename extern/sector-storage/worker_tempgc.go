@@ -0,0 +1,234 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// DefaultTempFileAge is how long temp files stay untouched before
+// GCTempFiles considers them stale
+const DefaultTempFileAge = 24 * time.Hour
+
+// tempFile is a temp artifact in a local storage path, sector is set for
+// artifacts of a sector file
+type tempFile struct {
+	path string
+
+	sector   abi.SectorID
+	fileType storiface.SectorFileType
+	ofSector bool
+}
+
+// GCTempFiles removes temp files left in local storage paths by interrupted
+// fetches, moves and imports, and by tasks writing files atomically, which
+// weren't modified for the configured age (see WorkerConfig.TempFileAge).
+// Temp files of sectors with calls in progress, or with locked files, are
+// never removed. Returns the bytes freed on disk.
+func (l *LocalWorker) GCTempFiles(ctx context.Context) (int64, error) {
+	if l.localStore == nil {
+		return 0, nil
+	}
+
+	paths, err := l.localStore.Local(ctx)
+	if err != nil {
+		return 0, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	active, err := l.ct.activeSectors()
+	if err != nil {
+		return 0, xerrors.Errorf("getting active sectors: %w", err)
+	}
+
+	var candidates []tempFile
+	for _, p := range paths {
+		if p.LocalPath == "" {
+			continue
+		}
+
+		found, err := listTempFiles(p.LocalPath)
+		if err != nil {
+			return 0, xerrors.Errorf("listing temp files in %s: %w", p.ID, err)
+		}
+		candidates = append(candidates, found...)
+	}
+
+	var freed int64
+	for _, tf := range candidates {
+		if err := ctx.Err(); err != nil {
+			return freed, err
+		}
+
+		if tf.ofSector {
+			if _, ok := active[tf.sector]; ok {
+				continue
+			}
+		}
+
+		n, err := l.gcTempFile(ctx, tf)
+		if err != nil {
+			return freed, xerrors.Errorf("removing temp file %s: %w", tf.path, err)
+		}
+		freed += n
+	}
+
+	return freed, nil
+}
+
+// gcTempFile removes the temp file when it's stale, and its sector files
+// aren't locked. Returns bytes freed.
+func (l *LocalWorker) gcTempFile(ctx context.Context, tf tempFile) (int64, error) {
+	modified, err := lastModified(tf.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if time.Since(modified) < l.tempFileAge {
+		return 0, nil
+	}
+
+	if tf.ofSector {
+		// the lock is held until lctx is cancelled
+		lctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		locked, err := l.sindex.StorageTryLock(lctx, tf.sector, storiface.FTNone, tf.fileType)
+		if err != nil {
+			return 0, xerrors.Errorf("locking sector %d (%s): %w", tf.sector, tf.fileType, err)
+		}
+		if !locked {
+			return 0, nil
+		}
+	}
+
+	used, err := diskUsage(tf.path)
+	if err != nil {
+		return 0, xerrors.Errorf("getting disk usage: %w", err)
+	}
+
+	if err := os.RemoveAll(tf.path); err != nil {
+		return 0, err
+	}
+
+	log.Infow("removed stale temp file", "path", tf.path, "sector", tf.sector, "freed", used)
+	return used, nil
+}
+
+// suffixes of temp sector files staged next to the sector file, by imports,
+// and by linking and unlinking deduplicated unsealed files
+var sectorTempSuffixes = []string{".import", ".dedup", ".unshare"}
+
+// listTempFiles lists temp artifacts in a storage path: fetch and move
+// staging copies, staged sector files, and .tmp files of atomic writes
+func listTempFiles(root string) ([]tempFile, error) {
+	var out []tempFile
+
+	ents, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, ent := range ents {
+		if !ent.IsDir() && strings.HasSuffix(ent.Name(), ".tmp") {
+			out = append(out, tempFile{path: filepath.Join(root, ent.Name())})
+		}
+	}
+
+	for _, fileType := range storiface.PathTypes {
+		dir := filepath.Join(root, fileType.String())
+
+		staging, err := readDirIfExists(filepath.Join(dir, stores.FetchTempSubdir))
+		if err != nil {
+			return nil, err
+		}
+		for _, ent := range staging {
+			out = appendSectorTemp(out, filepath.Join(dir, stores.FetchTempSubdir, ent.Name()), ent.Name(), fileType)
+		}
+
+		ents, err := readDirIfExists(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, ent := range ents {
+			name := ent.Name()
+			if suffix := sectorTempSuffix(name); suffix != "" {
+				out = appendSectorTemp(out, filepath.Join(dir, name), strings.TrimSuffix(name, suffix), fileType)
+				continue
+			}
+
+			if ent.IsDir() && name != stores.FetchTempSubdir {
+				files, err := ioutil.ReadDir(filepath.Join(dir, name))
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range files {
+					if strings.HasSuffix(f.Name(), ".tmp") {
+						out = appendSectorTemp(out, filepath.Join(dir, name, f.Name()), name, fileType)
+					}
+				}
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func sectorTempSuffix(name string) string {
+	for _, suffix := range sectorTempSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+func appendSectorTemp(out []tempFile, path, sectorName string, fileType storiface.SectorFileType) []tempFile {
+	sid, err := storiface.ParseSectorID(sectorName)
+	if err != nil {
+		log.Warnf("skipping temp file %s of unknown sector: %s", path, err)
+		return out
+	}
+
+	return append(out, tempFile{
+		path:     path,
+		sector:   sid,
+		fileType: fileType,
+		ofSector: true,
+	})
+}
+
+func readDirIfExists(dir string) ([]os.FileInfo, error) {
+	ents, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return ents, err
+}
+
+// lastModified returns the latest modification time of a file, or of any
+// file in a directory
+func lastModified(path string) (time.Time, error) {
+	var latest time.Time
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+
+	return latest, err
+}
@@ -0,0 +1,73 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestGCTempFiles(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{TempFileAge: time.Hour}, nil)
+	defer cleanup()
+
+	stale := time.Now().Add(-2 * time.Hour)
+	write := func(old bool, parts ...string) string {
+		path := filepath.Join(append([]string{p.LocalPath}, parts...)...)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, make([]byte, 4096), 0644))
+		if old {
+			require.NoError(t, os.Chtimes(path, stale, stale))
+		}
+		return path
+	}
+	name := func(n abi.SectorNumber) string {
+		return storiface.SectorName(abi.SectorID{Miner: 1000, Number: n})
+	}
+
+	removed := []string{
+		write(true, storiface.FTUnsealed.String(), stores.FetchTempSubdir, name(1)),
+		write(true, storiface.FTSealed.String(), name(2)+".import"),
+		write(true, storiface.FTCache.String(), name(3), "pc2-result.json.tmp"),
+		write(true, "sectorstore.json.tmp"),
+	}
+	kept := []string{
+		// too recent
+		write(false, storiface.FTUnsealed.String(), stores.FetchTempSubdir, name(4)),
+		// sector with a call in progress
+		write(true, storiface.FTSealed.String(), stores.FetchTempSubdir, name(5)),
+		// locked sector
+		write(true, storiface.FTSealed.String(), name(6)+".import"),
+		// not a temp file
+		write(true, storiface.FTCache.String(), name(3), "p_aux"),
+	}
+
+	require.NoError(t, w.ct.onStart(storiface.CallID{Sector: abi.SectorID{Miner: 1000, Number: 5}, ID: uuid.New()}, SealPreCommit1))
+
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	require.NoError(t, si.StorageLock(lctx, abi.SectorID{Miner: 1000, Number: 6}, storiface.FTSealed, storiface.FTNone))
+
+	freed, err := w.GCTempFiles(ctx)
+	require.NoError(t, err)
+	require.Greater(t, freed, int64(0))
+
+	for _, path := range removed {
+		require.NoFileExists(t, path)
+	}
+	for _, path := range kept {
+		require.FileExists(t, path)
+	}
+}
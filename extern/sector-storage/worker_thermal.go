@@ -0,0 +1,48 @@
+package sectorstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// how long Info reuses the sampled thermal state, reading it may run external
+// tools
+var thermalCacheTTL = 10 * time.Second
+
+// thermalSampler samples the thermal state of the worker's hardware, see
+// readThermal for what's read on each platform
+type thermalSampler struct {
+	lk   sync.Mutex
+	at   time.Time
+	last storiface.ThermalInfo
+
+	// CPU throttle events at the previous sample
+	cpuEvents  uint64
+	cpuSampled bool
+}
+
+func (ts *thermalSampler) sample(ctx context.Context) storiface.ThermalInfo {
+	ts.lk.Lock()
+	defer ts.lk.Unlock()
+
+	if !ts.at.IsZero() && time.Since(ts.at) < thermalCacheTTL {
+		return ts.last
+	}
+
+	info := readThermal(ctx)
+
+	// CPU throttle counters only tell whether the CPU was throttled since the
+	// previous sample
+	if info.CPU.Throttle == storiface.ThrottleNone {
+		if ts.cpuSampled && info.CPU.ThrottleEvents > ts.cpuEvents {
+			info.CPU.Throttle = storiface.ThrottleActive
+		}
+		ts.cpuEvents, ts.cpuSampled = info.CPU.ThrottleEvents, true
+	}
+
+	ts.at, ts.last = time.Now(), info
+	return info
+}
@@ -0,0 +1,215 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+var nvidiaSmiTimeout = 5 * time.Second
+
+// readThermal reads CPU temperatures and throttle counters from sysfs, NVIDIA
+// GPU state with nvidia-smi, and temperatures of other GPUs from sysfs
+func readThermal(ctx context.Context) storiface.ThermalInfo {
+	return storiface.ThermalInfo{
+		CPU:  readCPUThermal("/sys"),
+		GPUs: readGPUThermal(ctx, "/sys"),
+	}
+}
+
+// names of thermal zones and hwmon sensors measuring CPU temperature
+var cpuSensors = map[string]bool{
+	"x86_pkg_temp": true,
+	"coretemp":     true,
+	"k10temp":      true,
+	"zenpower":     true,
+	"cpu_thermal":  true,
+	"cpu-thermal":  true,
+	"soc_thermal":  true,
+}
+
+func readCPUThermal(root string) storiface.DeviceThermal {
+	dt := storiface.DeviceThermal{Name: "cpu", Throttle: storiface.ThrottleUnknown}
+
+	zones, _ := filepath.Glob(filepath.Join(root, "class/thermal/thermal_zone*"))
+	for _, zone := range zones {
+		if !cpuSensors[readSysString(filepath.Join(zone, "type"))] {
+			continue
+		}
+		if t, ok := readMilliC(filepath.Join(zone, "temp")); ok && t > dt.TempC {
+			dt.TempC = t
+		}
+	}
+
+	mons, _ := filepath.Glob(filepath.Join(root, "class/hwmon/hwmon*"))
+	for _, mon := range mons {
+		if !cpuSensors[readSysString(filepath.Join(mon, "name"))] {
+			continue
+		}
+		if t := maxHwmonTemp(mon); t > dt.TempC {
+			dt.TempC = t
+		}
+	}
+
+	// package counters are repeated for each CPU of the package
+	packages := map[string]bool{}
+	cpus, _ := filepath.Glob(filepath.Join(root, "devices/system/cpu/cpu[0-9]*"))
+	for _, cpu := range cpus {
+		if n, ok := readSysUint(filepath.Join(cpu, "thermal_throttle/core_throttle_count")); ok {
+			dt.ThrottleEvents += n
+			dt.Throttle = storiface.ThrottleNone
+		}
+
+		pkg := readSysString(filepath.Join(cpu, "topology/physical_package_id"))
+		if packages[pkg] {
+			continue
+		}
+		if n, ok := readSysUint(filepath.Join(cpu, "thermal_throttle/package_throttle_count")); ok {
+			packages[pkg] = true
+			dt.ThrottleEvents += n
+			dt.Throttle = storiface.ThrottleNone
+		}
+	}
+
+	return dt
+}
+
+func readGPUThermal(ctx context.Context, root string) []storiface.DeviceThermal {
+	if smi, err := exec.LookPath("nvidia-smi"); err == nil {
+		ctx, cancel := context.WithTimeout(ctx, nvidiaSmiTimeout)
+		defer cancel()
+
+		out, err := exec.CommandContext(ctx, smi, "--query-gpu=name,temperature.gpu,clocks_throttle_reasons.active", "--format=csv,noheader,nounits").Output() // nolint
+		if err == nil {
+			gpus, err := parseNvidiaSmi(out)
+			if err == nil {
+				return gpus
+			}
+			log.Warnf("parsing nvidia-smi output: %+v", err)
+		} else {
+			log.Debugf("running nvidia-smi: %s", err)
+		}
+	}
+
+	return readDRMThermal(root)
+}
+
+// NVIDIA clock throttle reasons which slow the GPU down, reported bits not
+// listed, like the GPU being idle, aren't throttling
+var nvidiaThrottleReasons = []struct {
+	bit    uint64
+	reason string
+}{
+	{0x4, "sw_power_cap"},
+	{0x8, "hw_slowdown"},
+	{0x20, "sw_thermal_slowdown"},
+	{0x40, "hw_thermal_slowdown"},
+	{0x80, "hw_power_brake_slowdown"},
+}
+
+// parseNvidiaSmi parses nvidia-smi output with the name, temperature and
+// active clock throttle reasons of each GPU
+func parseNvidiaSmi(out []byte) ([]storiface.DeviceThermal, error) {
+	r := csv.NewReader(bytes.NewReader(out))
+	r.TrimLeadingSpace = true
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var gpus []storiface.DeviceThermal
+	for _, rec := range records {
+		if len(rec) != 3 {
+			return nil, xerrors.Errorf("expected 3 fields, got %d: %q", len(rec), rec)
+		}
+
+		dt := storiface.DeviceThermal{Name: rec[0], Throttle: storiface.ThrottleUnknown}
+
+		// unsupported fields are reported as e.g. [N/A]
+		if t, err := strconv.ParseFloat(rec[1], 64); err == nil {
+			dt.TempC = t
+		}
+
+		if mask, err := strconv.ParseUint(strings.TrimPrefix(rec[2], "0x"), 16, 64); err == nil {
+			dt.Throttle = storiface.ThrottleNone
+			for _, tr := range nvidiaThrottleReasons {
+				if mask&tr.bit != 0 {
+					dt.Throttle = storiface.ThrottleActive
+					dt.Reasons = append(dt.Reasons, tr.reason)
+				}
+			}
+		}
+
+		gpus = append(gpus, dt)
+	}
+
+	return gpus, nil
+}
+
+// readDRMThermal reads temperatures of GPUs exposing hwmon sensors, like
+// amdgpu does
+func readDRMThermal(root string) []storiface.DeviceThermal {
+	var gpus []storiface.DeviceThermal
+
+	cards, _ := filepath.Glob(filepath.Join(root, "class/drm/card[0-9]*"))
+	for _, card := range cards {
+		if strings.Contains(filepath.Base(card), "-") { // connectors, e.g. card0-DP-1
+			continue
+		}
+
+		mons, _ := filepath.Glob(filepath.Join(card, "device/hwmon/hwmon*"))
+		for _, mon := range mons {
+			gpus = append(gpus, storiface.DeviceThermal{
+				Name:     readSysString(filepath.Join(mon, "name")) + " " + filepath.Base(card),
+				TempC:    maxHwmonTemp(mon),
+				Throttle: storiface.ThrottleUnknown,
+			})
+		}
+	}
+
+	return gpus
+}
+
+func maxHwmonTemp(mon string) float64 {
+	var max float64
+	inputs, _ := filepath.Glob(filepath.Join(mon, "temp*_input"))
+	for _, in := range inputs {
+		if t, ok := readMilliC(in); ok && t > max {
+			max = t
+		}
+	}
+	return max
+}
+
+func readSysString(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func readSysUint(path string) (uint64, bool) {
+	n, err := strconv.ParseUint(readSysString(path), 10, 64)
+	return n, err == nil
+}
+
+// readMilliC reads a temperature in millidegrees Celsius
+func readMilliC(path string) (float64, bool) {
+	n, err := strconv.ParseInt(readSysString(path), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return float64(n) / 1000, true
+}
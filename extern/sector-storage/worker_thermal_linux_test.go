@@ -0,0 +1,73 @@
+package sectorstorage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func writeSysFile(t *testing.T, root, path, content string) {
+	p := filepath.Join(root, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(t, ioutil.WriteFile(p, []byte(content+"\n"), 0644))
+}
+
+func TestReadCPUThermal(t *testing.T) {
+	root := t.TempDir()
+
+	dt := readCPUThermal(root)
+	require.Equal(t, storiface.ThrottleUnknown, dt.Throttle)
+	require.Zero(t, dt.TempC)
+
+	writeSysFile(t, root, "class/thermal/thermal_zone0/type", "acpitz")
+	writeSysFile(t, root, "class/thermal/thermal_zone0/temp", "99000")
+	writeSysFile(t, root, "class/thermal/thermal_zone1/type", "x86_pkg_temp")
+	writeSysFile(t, root, "class/thermal/thermal_zone1/temp", "71000")
+	writeSysFile(t, root, "class/hwmon/hwmon0/name", "coretemp")
+	writeSysFile(t, root, "class/hwmon/hwmon0/temp1_input", "72500")
+	writeSysFile(t, root, "class/hwmon/hwmon0/temp2_input", "64000")
+
+	for cpu, pkg := range map[string]string{"cpu0": "0", "cpu1": "0", "cpu2": "1"} {
+		writeSysFile(t, root, "devices/system/cpu/"+cpu+"/topology/physical_package_id", pkg)
+		writeSysFile(t, root, "devices/system/cpu/"+cpu+"/thermal_throttle/core_throttle_count", "1")
+		writeSysFile(t, root, "devices/system/cpu/"+cpu+"/thermal_throttle/package_throttle_count", "10")
+	}
+
+	dt = readCPUThermal(root)
+	require.Equal(t, "cpu", dt.Name)
+	require.Equal(t, 72.5, dt.TempC)
+	require.Equal(t, storiface.ThrottleNone, dt.Throttle)
+	// package counters are counted once per package
+	require.Equal(t, uint64(3+20), dt.ThrottleEvents)
+}
+
+func TestParseNvidiaSmi(t *testing.T) {
+	gpus, err := parseNvidiaSmi([]byte("NVIDIA GeForce RTX 3090, 65, 0x0000000000000001\nTesla T4, 91, 0x0000000000000060\nTesla K80, [N/A], [Not Supported]\n"))
+	require.NoError(t, err)
+	require.Equal(t, []storiface.DeviceThermal{
+		{Name: "NVIDIA GeForce RTX 3090", TempC: 65, Throttle: storiface.ThrottleNone},
+		{Name: "Tesla T4", TempC: 91, Throttle: storiface.ThrottleActive, Reasons: []string{"sw_thermal_slowdown", "hw_thermal_slowdown"}},
+		{Name: "Tesla K80", Throttle: storiface.ThrottleUnknown},
+	}, gpus)
+
+	_, err = parseNvidiaSmi([]byte("only, two\n"))
+	require.Error(t, err)
+}
+
+func TestReadDRMThermal(t *testing.T) {
+	root := t.TempDir()
+
+	writeSysFile(t, root, "class/drm/card0/device/hwmon/hwmon3/name", "amdgpu")
+	writeSysFile(t, root, "class/drm/card0/device/hwmon/hwmon3/temp1_input", "58000")
+	writeSysFile(t, root, "class/drm/card0/device/hwmon/hwmon3/temp2_input", "80000")
+	writeSysFile(t, root, "class/drm/card0-DP-1/status", "connected")
+
+	require.Equal(t, []storiface.DeviceThermal{
+		{Name: "amdgpu card0", TempC: 80, Throttle: storiface.ThrottleUnknown},
+	}, readDRMThermal(root))
+}
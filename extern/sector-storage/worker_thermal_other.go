@@ -0,0 +1,15 @@
+// +build !linux
+
+package sectorstorage
+
+import (
+	"context"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func readThermal(ctx context.Context) storiface.ThermalInfo {
+	return storiface.ThermalInfo{
+		CPU: storiface.DeviceThermal{Name: "cpu", Throttle: storiface.ThrottleUnknown},
+	}
+}
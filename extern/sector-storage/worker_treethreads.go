@@ -0,0 +1,52 @@
+package sectorstorage
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+
+	"golang.org/x/xerrors"
+)
+
+// The proofs build the trees of PreCommit2 in their global thread pool, which
+// is shared by the whole worker process and sized from this variable, all CPUs
+// by default. Other tasks parallelize over the same pool. It's created when
+// first used, so the size must be set before any task runs, and applies for
+// the lifetime of the process.
+const treeThreadsEnv = "RAYON_NUM_THREADS"
+
+// CheckTreeThreads validates WorkerConfig.PreCommit2TreeThreads, which must be
+// between 0 (proofs default) and the number of CPUs
+func CheckTreeThreads(threads int) error {
+	if threads < 0 || threads > runtime.NumCPU() {
+		return xerrors.Errorf("PreCommit2 tree threads must be between 0 and %d (number of CPUs), got %d", runtime.NumCPU(), threads)
+	}
+	return nil
+}
+
+// setTreeThreads sizes the thread pool PreCommit2 builds trees in. All
+// PreCommit2 tasks running at the same time share the pool, so running more
+// of them doesn't add threads, but other tasks running next to them compete
+// for the remaining CPUs. Warns when the pool, together with other calls the
+// worker may run, is likely to oversubscribe the CPUs.
+func setTreeThreads(threads, maxCalls int) {
+	if threads == 0 {
+		return
+	}
+	if err := CheckTreeThreads(threads); err != nil {
+		log.Warnf("%s, using the proofs default", err)
+		return
+	}
+
+	if cur := os.Getenv(treeThreadsEnv); cur != "" && cur != strconv.Itoa(threads) {
+		log.Warnw("overriding tree building threads set in the environment", "env", treeThreadsEnv, "was", cur, "threads", threads)
+	}
+	if err := os.Setenv(treeThreadsEnv, strconv.Itoa(threads)); err != nil {
+		log.Errorw("setting PreCommit2 tree threads", "threads", threads, "error", err)
+		return
+	}
+
+	if maxCalls != 1 && threads == runtime.NumCPU() {
+		log.Warnw("PreCommit2 tree building uses all CPUs, other calls running next to it will oversubscribe them; consider fewer tree threads, or limiting concurrent calls", "threads", threads, "maxConcurrentCalls", maxCalls)
+	}
+}
@@ -0,0 +1,39 @@
+package sectorstorage
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTreeThreads(t *testing.T) {
+	prev, wasSet := os.LookupEnv(treeThreadsEnv)
+	defer func() {
+		if wasSet {
+			_ = os.Setenv(treeThreadsEnv, prev)
+		} else {
+			_ = os.Unsetenv(treeThreadsEnv)
+		}
+	}()
+
+	require.NoError(t, CheckTreeThreads(0))
+	require.NoError(t, CheckTreeThreads(runtime.NumCPU()))
+	require.Error(t, CheckTreeThreads(-1))
+	require.Error(t, CheckTreeThreads(runtime.NumCPU()+1))
+
+	require.NoError(t, os.Unsetenv(treeThreadsEnv))
+
+	// the proofs default is kept
+	setTreeThreads(0, 0)
+	_, set := os.LookupEnv(treeThreadsEnv)
+	require.False(t, set)
+
+	setTreeThreads(runtime.NumCPU()+1, 0)
+	_, set = os.LookupEnv(treeThreadsEnv)
+	require.False(t, set)
+
+	setTreeThreads(1, 0)
+	require.Equal(t, "1", os.Getenv(treeThreadsEnv))
+}
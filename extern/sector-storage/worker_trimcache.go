@@ -0,0 +1,71 @@
+package sectorstorage
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// trimCacheAfterCommit2 clears the local cache of a sector once Commit2 made
+// its proof, keeping only the files needed for proving (p_aux, t_aux and
+// tree-r-last), see WorkerConfig.Commit2ClearCache. Caches in other
+// workers' paths are never fetched for that, nor are caches which are locked,
+// e.g. by a Commit1 redone for the sector. Failures don't fail Commit2, the
+// cache is cleared again by FinalizeSector.
+func (l *LocalWorker) trimCacheAfterCommit2(ctx context.Context, sb ffiwrapper.Storage, sector storage.SectorRef) {
+	if !l.clearCacheAfterC2 {
+		return
+	}
+
+	if err := l.trimCache(ctx, sb, sector); err != nil {
+		log.Warnw("clearing cache after commit2", "sector", sector.ID, "error", err)
+	}
+}
+
+func (l *LocalWorker) trimCache(ctx context.Context, sb ffiwrapper.Storage, sector storage.SectorRef) error {
+	pf, ok := sb.(ffiwrapper.PartialFinalizer)
+	if !ok {
+		return xerrors.Errorf("proofs backend can't clear caches separately")
+	}
+
+	caches, err := l.localSectorPaths(ctx, sector.ID, storiface.FTCache)
+	if err != nil {
+		return err
+	}
+	if len(caches) == 0 {
+		return nil
+	}
+
+	sealed, err := l.sindex.StorageFindSector(ctx, sector.ID, storiface.FTSealed, 0, false)
+	if err != nil {
+		return xerrors.Errorf("finding sealed sector: %w", err)
+	}
+	if len(sealed) == 0 {
+		return xerrors.Errorf("sector has no sealed replica")
+	}
+
+	// the lock is held until lctx is cancelled
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	locked, err := l.sindex.StorageTryLock(lctx, sector.ID, storiface.FTSealed, storiface.FTCache)
+	if err != nil {
+		return xerrors.Errorf("locking cache: %w", err)
+	}
+	if !locked {
+		log.Infow("not clearing cache after commit2, the cache is in use", "sector", sector.ID)
+		return nil
+	}
+
+	if err := pf.ClearCache(ctx, sector); err != nil {
+		return xerrors.Errorf("clearing cache: %w", err)
+	}
+
+	log.Infow("cleared cache after commit2", "sector", sector.ID)
+	return l.syncSectorFiles(ctx, sector.ID, storiface.FTCache)
+}
@@ -0,0 +1,83 @@
+package sectorstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+type clearingC2Exec struct {
+	partialFinalizeExec
+}
+
+func (e *clearingC2Exec) SealCommit2(ctx context.Context, sector storage.SectorRef, c1o storage.Commit1Out) (storage.Proof, error) {
+	return storage.Proof("proof of " + string(c1o)), nil
+}
+
+func TestCommit2ClearCache(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &c2Returns{errs: make(chan *storiface.CallError, 1), proofs: make(chan storage.Proof, 1)}
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{Commit2ClearCache: true}, ret)
+	defer cleanup()
+
+	exec := &clearingC2Exec{partialFinalizeExec{finalized: make(chan storiface.SectorFileType, 1)}}
+	w.executor = func() (ffiwrapper.Storage, error) {
+		return exec, nil
+	}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+	c2 := func(c1o string) bool {
+		_, err := w.SealCommit2(ctx, sector, storage.Commit1Out(c1o))
+		require.NoError(t, err)
+		require.Nil(t, <-ret.errs)
+		require.Equal(t, storage.Proof("proof of "+c1o), <-ret.proofs)
+
+		select {
+		case ft := <-exec.finalized:
+			require.Equal(t, storiface.FTCache, ft)
+			return true
+		default:
+			return false
+		}
+	}
+
+	// the cache isn't local
+	require.False(t, c2("no cache"))
+
+	writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTCache, 1)
+
+	// nor without a sealed replica
+	require.False(t, c2("not sealed"))
+
+	writeTestSectorFile(ctx, t, p, si, sector.ID, storiface.FTSealed, 1)
+
+	// the cache is in use
+	lctx, unlock := context.WithCancel(ctx)
+	require.NoError(t, si.StorageLock(lctx, sector.ID, storiface.FTCache, storiface.FTNone))
+	require.False(t, c2("locked"))
+	unlock()
+
+	// the lock is released asynchronously, stored results of earlier attempts
+	// would be returned for the same inputs
+	var attempt int
+	require.Eventually(t, func() bool {
+		attempt++
+		return c2(fmt.Sprintf("c1o %d", attempt))
+	}, time.Second, 10*time.Millisecond)
+
+	w.clearCacheAfterC2 = false
+	require.False(t, c2("disabled"))
+}
@@ -0,0 +1,41 @@
+package sectorstorage
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	proof2 "github.com/filecoin-project/specs-actors/v2/actors/runtime/proof"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// VerifyCommit2 checks an existing seal proof of the sector (the output of
+// SealCommit2) without regenerating it, e.g. for periodically re-verifying a
+// sample of proofs to detect hardware faults. Returns false for invalid
+// proofs, errors are only returned when the proof couldn't be verified at all.
+// The result is returned with ReturnVerifyCommit2.
+func (l *LocalWorker) VerifyCommit2(ctx context.Context, sector storage.SectorRef, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, cids storage.SectorCids, proof storage.Proof) (storiface.CallID, error) {
+	return l.asyncCall(ctx, sector, VerifyCommit2, func(ctx context.Context, ci storiface.CallID) (interface{}, error) {
+		ok, err := l.verifier.VerifySeal(proof2.SealVerifyInfo{
+			SealProof:             sector.ProofType,
+			SectorID:              sector.ID,
+			Randomness:            ticket,
+			InteractiveRandomness: seed,
+			Proof:                 proof,
+			SealedCID:             cids.Sealed,
+			UnsealedCID:           cids.Unsealed,
+		})
+		if err != nil {
+			return false, xerrors.Errorf("verifying seal proof of sector %d: %w", sector.ID, err)
+		}
+
+		if !ok {
+			log.Warnw("seal proof verification failed", "sector", sector.ID)
+		}
+
+		return ok, nil
+	})
+}
@@ -0,0 +1,73 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	proof2 "github.com/filecoin-project/specs-actors/v2/actors/runtime/proof"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+type testVerifier struct {
+	ffiwrapper.Verifier
+
+	valid []byte
+}
+
+func (v *testVerifier) VerifySeal(info proof2.SealVerifyInfo) (bool, error) {
+	if len(info.Proof) == 0 {
+		return false, xerrors.Errorf("empty proof")
+	}
+	return bytes.Equal(info.Proof, v.valid), nil
+}
+
+type verifyReturns struct {
+	storiface.WorkerReturn
+
+	ok   chan bool
+	errs chan *storiface.CallError
+}
+
+func (r *verifyReturns) ReturnVerifyCommit2(ctx context.Context, callID storiface.CallID, ok bool, err *storiface.CallError) error {
+	r.ok <- ok
+	r.errs <- err
+	return nil
+}
+
+func TestVerifyCommit2(t *testing.T) {
+	ctx := context.Background()
+
+	ret := &verifyReturns{ok: make(chan bool, 1), errs: make(chan *storiface.CallError, 1)}
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, ret)
+	defer cleanup()
+
+	w.verifier = &testVerifier{valid: []byte("valid")}
+
+	sector := storage.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	_, err := w.VerifyCommit2(ctx, sector, nil, nil, storage.SectorCids{}, []byte("valid"))
+	require.NoError(t, err)
+	require.True(t, <-ret.ok)
+	require.Nil(t, <-ret.errs)
+
+	_, err = w.VerifyCommit2(ctx, sector, nil, nil, storage.SectorCids{}, []byte("invalid"))
+	require.NoError(t, err)
+	require.False(t, <-ret.ok)
+	require.Nil(t, <-ret.errs)
+
+	_, err = w.VerifyCommit2(ctx, sector, nil, nil, storage.SectorCids{}, nil)
+	require.NoError(t, err)
+	require.False(t, <-ret.ok)
+	require.NotNil(t, <-ret.errs)
+}
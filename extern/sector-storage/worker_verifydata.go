@@ -0,0 +1,161 @@
+package sectorstorage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// how often VerifySectorUpdates reports progress while reading sector files
+var verifyUpdateInterval = time.Second
+
+const verifyReadBuf = 1 << 20
+
+// VerifySectorUpdates is VerifySector, running in the background, which also
+// reads all data of the sealed replica and cache files in local paths, so that
+// unreadable data is found before proving needs it. Progress is reported on the
+// returned channel as files are read, then the result, after which the channel
+// is closed. Progress updates are dropped when the receiver doesn't keep up,
+// the result is dropped only when ctx is cancelled and the receiver doesn't
+// keep up.
+func (l *LocalWorker) VerifySectorUpdates(ctx context.Context, sector storage.SectorRef) (<-chan storiface.VerifyUpdate, error) {
+	ssize, err := l.startVerify(ctx, sector)
+	if err != nil {
+		return nil, err
+	}
+
+	// the lock is held until lctx is cancelled, when verification is done
+	lctx, cancel := context.WithCancel(ctx)
+
+	if err := l.sindex.StorageLock(lctx, sector.ID, storiface.FTSealed|storiface.FTCache, storiface.FTNone); err != nil {
+		cancel()
+		return nil, xerrors.Errorf("locking sector files: %w", err)
+	}
+
+	out := make(chan storiface.VerifyUpdate, 1)
+
+	report := func(u storiface.VerifyUpdate) {
+		select {
+		case out <- u:
+		default:
+		}
+	}
+
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		result, err := l.verifySectorData(lctx, sector.ID, ssize, report)
+		result.Done = true
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		select {
+		case out <- result:
+		case <-ctx.Done():
+			select {
+			case out <- result:
+			default:
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// verifySectorData checks the sector files like VerifySector does, then reads
+// them, the caller must hold a read lock on them
+func (l *LocalWorker) verifySectorData(ctx context.Context, sector abi.SectorID, ssize abi.SectorSize, report func(storiface.VerifyUpdate)) (storiface.VerifyUpdate, error) {
+	progress := storiface.VerifyUpdate{Sector: sector}
+
+	if err := l.checkLocalSector(ctx, sector, ssize); err != nil {
+		return progress, err
+	}
+
+	var files []string
+	for _, fileType := range []storiface.SectorFileType{storiface.FTSealed, storiface.FTCache} {
+		paths, err := l.localSectorPaths(ctx, sector, fileType)
+		if err != nil {
+			return progress, err
+		}
+
+		for _, p := range paths {
+			err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() {
+					files = append(files, path)
+					progress.Total += info.Size()
+				}
+				return nil
+			})
+			if err != nil {
+				return progress, xerrors.Errorf("listing sector files in %s: %w", p, err)
+			}
+		}
+	}
+
+	start := time.Now()
+	lastReport := start
+	buf := make([]byte, verifyReadBuf)
+
+	for _, path := range files {
+		err := readSectorFile(ctx, path, buf, func(n int) {
+			progress.Verified += int64(n)
+
+			now := time.Now()
+			if now.Sub(lastReport) < verifyUpdateInterval {
+				return
+			}
+			lastReport = now
+
+			elapsed := now.Sub(start)
+			progress.ETA = now.Add(time.Duration(float64(elapsed) * float64(progress.Total-progress.Verified) / float64(progress.Verified)))
+			report(progress)
+		})
+		if err != nil {
+			return progress, xerrors.Errorf("reading %s: %w", path, err)
+		}
+	}
+
+	progress.ETA = time.Now()
+	return progress, nil
+}
+
+// readSectorFile reads the whole file, calling read with the number of bytes
+// after each read
+func readSectorFile(ctx context.Context, path string, buf []byte, read func(int)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := f.Read(buf)
+		if n > 0 {
+			read(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
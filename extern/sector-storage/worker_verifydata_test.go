@@ -0,0 +1,100 @@
+package sectorstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestVerifySectorUpdates(t *testing.T) {
+	ctx := context.Background()
+
+	w, p, si, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{}, nil)
+	defer cleanup()
+
+	interval := verifyUpdateInterval
+	verifyUpdateInterval = 0
+	defer func() {
+		verifyUpdateInterval = interval
+	}()
+
+	sectorRef := func(n abi.SectorNumber) storage.SectorRef {
+		return storage.SectorRef{
+			ID:        abi.SectorID{Miner: 1000, Number: n},
+			ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1,
+		}
+	}
+	addCache := func(sector storage.SectorRef, files ...string) int64 {
+		dir := filepath.Join(p.LocalPath, storiface.FTCache.String(), storiface.SectorName(sector.ID))
+		require.NoError(t, os.Mkdir(dir, 0755))
+		require.NoError(t, si.StorageDeclareSector(ctx, p.ID, sector.ID, storiface.FTCache, true))
+
+		var size int64
+		for _, f := range files {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644))
+			size += int64(len(f))
+		}
+		return size
+	}
+	verify := func(sector storage.SectorRef) []storiface.VerifyUpdate {
+		updates, err := w.VerifySectorUpdates(ctx, sector)
+		require.NoError(t, err)
+
+		var out []storiface.VerifyUpdate
+		for u := range updates {
+			out = append(out, u)
+		}
+		require.NotEmpty(t, out)
+		return out
+	}
+
+	good := sectorRef(1)
+	writeTestSectorFile(ctx, t, p, si, good.ID, storiface.FTSealed, 2048)
+	total := 2048 + addCache(good, "t_aux", "p_aux", "sc-02-data-tree-r-last.dat")
+
+	updates := verify(good)
+	for _, u := range updates[:len(updates)-1] {
+		require.False(t, u.Done)
+		require.Equal(t, total, u.Total)
+		require.True(t, u.Verified > 0 && u.Verified <= total)
+		require.False(t, u.ETA.IsZero())
+	}
+
+	result := updates[len(updates)-1]
+	require.True(t, result.Done)
+	require.Empty(t, result.Error)
+	require.Equal(t, good.ID, result.Sector)
+	require.Equal(t, total, result.Verified)
+	require.Equal(t, total, result.Total)
+
+	// the lock is released when verification is done
+	require.Eventually(t, func() bool {
+		tctx, tcancel := context.WithCancel(ctx)
+		defer tcancel()
+
+		locked, err := si.StorageTryLock(tctx, good.ID, storiface.FTNone, storiface.FTSealed|storiface.FTCache)
+		return err == nil && locked
+	}, time.Second, 10*time.Millisecond)
+
+	incomplete := sectorRef(2)
+	writeTestSectorFile(ctx, t, p, si, incomplete.ID, storiface.FTSealed, 2048)
+	addCache(incomplete, "t_aux")
+
+	updates = verify(incomplete)
+	require.Len(t, updates, 1)
+	require.True(t, updates[0].Done)
+	require.Contains(t, updates[0].Error, "cache file missing")
+
+	_, err := w.VerifySectorUpdates(ctx, sectorRef(3))
+	require.Error(t, err)
+}
@@ -0,0 +1,196 @@
+package sectorstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+// Task lifecycle events posted to the task webhook
+const (
+	TaskEventStart   = "start"
+	TaskEventSuccess = "success"
+	TaskEventFailure = "failure"
+
+	// posted by the background scrubber for bad sector files, without a task
+	TaskEventScrubFailure = "scrub-failure"
+)
+
+// TaskEvent is the JSON payload posted to the task webhook
+type TaskEvent struct {
+	Event string
+	Time  time.Time
+
+	Task      sealtasks.TaskType
+	Sector    abi.SectorID
+	ProofType abi.RegisteredSealProof
+	Call      storiface.CallID
+
+	Result interface{}           `json:",omitempty"`
+	Error  string                `json:",omitempty"`
+	Phases []storiface.TaskPhase `json:",omitempty"` // sub-phase timings of finished tasks
+}
+
+var (
+	// events waiting for delivery, events emitted when the queue is full
+	// are dropped
+	webhookQueueSize = 1024
+
+	webhookAttempts   = 5
+	webhookBackoff    = time.Second // doubled after each failed attempt
+	webhookMaxBackoff = time.Minute
+	webhookTimeout    = 10 * time.Second
+)
+
+// taskWebhook delivers task events to an HTTP endpoint in the background, in
+// the order they were emitted
+type taskWebhook struct {
+	url    string
+	client *http.Client
+
+	queue chan TaskEvent
+	done  chan struct{}
+}
+
+func newTaskWebhook(url string) *taskWebhook {
+	return &taskWebhook{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan TaskEvent, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// hooks returns task hooks emitting events, running pre and post first
+func (wh *taskWebhook) hooks(pre PreTaskFunc, post PostTaskFunc) (PreTaskFunc, PostTaskFunc) {
+	return func(ctx context.Context, ti TaskHookInfo) error {
+			if pre != nil {
+				if err := pre(ctx, ti); err != nil {
+					return err
+				}
+			}
+
+			wh.emit(newTaskEvent(TaskEventStart, ti))
+			return nil
+		}, func(ctx context.Context, ti TaskHookInfo, res interface{}, err error) {
+			if post != nil {
+				post(ctx, ti, res, err)
+			}
+
+			ev := newTaskEvent(TaskEventSuccess, ti)
+			if err != nil {
+				ev.Event, ev.Error = TaskEventFailure, err.Error()
+			} else {
+				ev.Result = res
+			}
+			wh.emit(ev)
+		}
+}
+
+func newTaskEvent(event string, ti TaskHookInfo) TaskEvent {
+	return TaskEvent{
+		Event:     event,
+		Time:      time.Now(),
+		Task:      ti.Task,
+		Sector:    ti.Sector.ID,
+		ProofType: ti.Sector.ProofType,
+		Call:      ti.Call,
+		Phases:    ti.Phases,
+	}
+}
+
+// emit queues the event for delivery, it never blocks
+func (wh *taskWebhook) emit(ev TaskEvent) {
+	select {
+	case wh.queue <- ev:
+	default:
+		log.Warnw("task webhook queue full, dropping event", "event", ev.Event, "task", ev.Task, "call", ev.Call)
+	}
+}
+
+// run delivers queued events until closing is closed, events not delivered by
+// then are dropped
+func (wh *taskWebhook) run(closing <-chan struct{}) {
+	defer close(wh.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-closing
+		cancel()
+	}()
+
+	for {
+		select {
+		case ev := <-wh.queue:
+			if err := wh.deliver(ctx, ev); err != nil {
+				log.Errorw("delivering task event failed, dropping it", "event", ev.Event, "task", ev.Task, "call", ev.Call, "error", err)
+			}
+		case <-closing:
+			if n := len(wh.queue); n > 0 {
+				log.Warnw("worker closing, dropping undelivered task events", "events", n)
+			}
+			return
+		}
+	}
+}
+
+// deliver posts the event, retrying failed attempts with backoff
+func (wh *taskWebhook) deliver(ctx context.Context, ev TaskEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return xerrors.Errorf("marshaling event: %w", err)
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err = wh.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= webhookAttempts {
+			return xerrors.Errorf("after %d attempts: %w", attempt, err)
+		}
+
+		log.Debugw("delivering task event failed, retrying", "event", ev.Event, "call", ev.Call, "attempt", attempt, "error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return xerrors.Errorf("%w (last delivery error: %s)", ctx.Err(), err)
+		}
+
+		backoff *= 2
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+func (wh *taskWebhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return xerrors.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
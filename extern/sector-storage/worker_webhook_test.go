@@ -0,0 +1,118 @@
+package sectorstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	"github.com/filecoin-project/lotus/extern/sector-storage/storiface"
+)
+
+func TestTaskWebhook(t *testing.T) {
+	ctx := context.Background()
+
+	backoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() {
+		webhookBackoff = backoff
+	}()
+
+	var lk sync.Mutex
+	var events []TaskEvent
+	var requests int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		defer lk.Unlock()
+
+		// every other delivery fails the first time
+		requests++
+		if requests%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var ev TaskEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&ev))
+		events = append(events, ev)
+	}))
+	defer srv.Close()
+
+	var posted int
+	w, _, _, cleanup := newTestLocalWorker(ctx, t, WorkerConfig{
+		TaskWebhook: srv.URL,
+		PostTask: func(ctx context.Context, ti TaskHookInfo, res interface{}, err error) {
+			posted++
+		},
+	}, nil)
+	defer cleanup()
+
+	sector := storage.SectorRef{ID: abi.SectorID{Miner: 1000, Number: 1}, ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1}
+	ok := storiface.CallID{Sector: sector.ID, ID: uuid.New()}
+	failed := storiface.CallID{Sector: sector.ID, ID: uuid.New()}
+
+	_, err := w.runCall(ctx, sector, AddPiece, ok, func(context.Context, storiface.CallID) (interface{}, error) {
+		return abi.PieceInfo{Size: 128}, nil
+	})
+	require.NoError(t, err)
+
+	_, err = w.runCall(ctx, sector, Fetch, failed, func(context.Context, storiface.CallID) (interface{}, error) {
+		return nil, xerrors.New("fetch failed")
+	})
+	require.Error(t, err)
+
+	// configured hooks still run
+	require.Equal(t, 2, posted)
+
+	require.Eventually(t, func() bool {
+		lk.Lock()
+		defer lk.Unlock()
+		return len(events) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lk.Lock()
+	defer lk.Unlock()
+
+	require.Equal(t, TaskEventStart, events[0].Event)
+	require.Equal(t, sealtasks.TTAddPiece, events[0].Task)
+	require.Equal(t, ok, events[0].Call)
+	require.Equal(t, sector.ProofType, events[0].ProofType)
+
+	require.Equal(t, TaskEventSuccess, events[1].Event)
+	require.Equal(t, float64(128), events[1].Result.(map[string]interface{})["Size"])
+
+	require.Equal(t, TaskEventStart, events[2].Event)
+	require.Equal(t, sealtasks.TTFetch, events[2].Task)
+	require.Equal(t, TaskEventFailure, events[3].Event)
+	require.Equal(t, failed, events[3].Call)
+	require.Contains(t, events[3].Error, "fetch failed")
+}
+
+func TestTaskWebhookNeverBlocks(t *testing.T) {
+	queue := webhookQueueSize
+	webhookQueueSize = 1
+	defer func() {
+		webhookQueueSize = queue
+	}()
+
+	// not running, so nothing is delivered
+	wh := newTaskWebhook("http://127.0.0.1:1")
+	pre, _ := wh.hooks(nil, nil)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pre(context.Background(), TaskHookInfo{Task: sealtasks.TTFetch}))
+	}
+	require.Len(t, wh.queue, 1)
+}
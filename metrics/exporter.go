@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/http"
+
+	logging "github.com/ipfs/go-log/v2"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/xerrors"
+
+	"contrib.go.opencensus.io/exporter/prometheus"
+)
+
+var log = logging.Logger("metrics")
+
+// Exporter returns a handler serving registered OpenCensus views in the
+// Prometheus format, under the lotus namespace. It must be created once per
+// process, as the exporter registers itself with the default registry.
+func Exporter() (http.Handler, error) {
+	// Prometheus globals are exposed as interfaces, but the prometheus
+	// OpenCensus exporter expects a concrete *Registry. The concrete type of
+	// the globals are actually *Registry, so we downcast them, staying
+	// defensive in case things change under the hood.
+	registry, ok := promclient.DefaultRegisterer.(*promclient.Registry)
+	if !ok {
+		log.Warnf("failed to export default prometheus registry; some metrics will be unavailable; unexpected type: %T", promclient.DefaultRegisterer)
+	}
+	exporter, err := prometheus.NewExporter(prometheus.Options{
+		Registry:  registry,
+		Namespace: "lotus",
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("creating the prometheus stats exporter: %w", err)
+	}
+
+	return exporter, nil
+}
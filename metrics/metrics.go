@@ -9,6 +9,9 @@ import (
 	"go.opencensus.io/tag"
 
 	rpcmetrics "github.com/filecoin-project/go-jsonrpc/metrics"
+
+	sectorstorage "github.com/filecoin-project/lotus/extern/sector-storage"
+	"github.com/filecoin-project/lotus/extern/sector-storage/stores"
 )
 
 // Distribution
@@ -159,7 +162,7 @@ var (
 )
 
 // DefaultViews is an array of OpenCensus views for metric gathering purposes
-var DefaultViews = append([]*view.View{
+var DefaultViews = append(append(append([]*view.View{
 	InfoView,
 	ChainNodeHeightView,
 	ChainNodeHeightExpectedView,
@@ -184,7 +187,9 @@ var DefaultViews = append([]*view.View{
 	VMFlushCopyCountView,
 	VMFlushCopyDurationView,
 },
-	rpcmetrics.DefaultViews...)
+	rpcmetrics.DefaultViews...),
+	stores.DefaultViews...),
+	sectorstorage.DefaultViews...)
 
 // SinceInMilliseconds returns the duration of time since the provide time as a float64.
 func SinceInMilliseconds(startTime time.Time) float64 {
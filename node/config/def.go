@@ -181,6 +181,9 @@ func DefaultStorageMiner() *StorageMiner {
 			// Default to 10 - tcp should still be able to figure this out, and
 			// it's the ratio between 10gbit / 1gbit
 			ParallelFetchLimit: 10,
+
+			FetchChunkSize: 1 << 30,
+			FetchRetries:   5,
 		},
 
 		Dealmaking: DealmakingConfig{